@@ -0,0 +1,111 @@
+package viamrtsp
+
+/*
+#cgo pkg-config: libavcodec libavutil
+#include <libavcodec/avcodec.h>
+#include <libavutil/mem.h>
+#include <stdlib.h>
+#include <string.h>
+*/
+import "C"
+
+import (
+	"math"
+	"unsafe"
+
+	"github.com/pion/mediadevices/pkg/wave"
+	"github.com/pkg/errors"
+)
+
+// aacDecoder decodes AAC access units into 16 bit PCM audio.
+type aacDecoder struct {
+	codecCtx *C.AVCodecContext
+	frame    *C.AVFrame
+}
+
+// newAACDecoder creates an AAC decoder from the stream's AudioSpecificConfig.
+func newAACDecoder(config []byte) (*aacDecoder, error) {
+	codec := C.avcodec_find_decoder(C.AV_CODEC_ID_AAC)
+	if codec == nil {
+		return nil, errors.New("avcodec_find_decoder() failed")
+	}
+
+	d := &aacDecoder{codecCtx: C.avcodec_alloc_context3(codec)}
+	if d.codecCtx == nil {
+		return nil, errors.New("avcodec_alloc_context3() failed")
+	}
+
+	if len(config) > 0 {
+		// extradata must be allocated by libav & padded, it is freed by avcodec_free_context
+		d.codecCtx.extradata = (*C.uint8_t)(C.av_mallocz(C.size_t(len(config) + C.AV_INPUT_BUFFER_PADDING_SIZE)))
+		if d.codecCtx.extradata == nil {
+			d.close()
+			return nil, errors.New("av_mallocz() failed")
+		}
+		C.memcpy(unsafe.Pointer(d.codecCtx.extradata), unsafe.Pointer(&config[0]), C.size_t(len(config)))
+		d.codecCtx.extradata_size = C.int(len(config))
+	}
+
+	if res := C.avcodec_open2(d.codecCtx, codec, nil); res < 0 {
+		d.close()
+		return nil, errors.Errorf("avcodec_open2() failed: %s", avError(res))
+	}
+
+	d.frame = C.av_frame_alloc()
+	if d.frame == nil {
+		d.close()
+		return nil, errors.New("av_frame_alloc() failed")
+	}
+	return d, nil
+}
+
+// decode decodes an access unit, returning the audio chunks it contained.
+func (d *aacDecoder) decode(au []byte) ([]wave.Audio, error) {
+	if len(au) == 0 {
+		return nil, nil
+	}
+	// the input buffer must be padded as the decoder may read past the end of the packet
+	data := C.CBytes(append(au, make([]byte, C.AV_INPUT_BUFFER_PADDING_SIZE)...))
+	defer C.free(data)
+
+	var avPacket C.AVPacket
+	avPacket.data = (*C.uint8_t)(data)
+	avPacket.size = C.int(len(au))
+	if res := C.avcodec_send_packet(d.codecCtx, &avPacket); res < 0 {
+		return nil, errors.Errorf("avcodec_send_packet() err: %s", avError(res))
+	}
+
+	var chunks []wave.Audio
+	for C.avcodec_receive_frame(d.codecCtx, d.frame) >= 0 {
+		chunk, err := d.frameToWave()
+		if err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// frameToWave converts the planar float samples the AAC decoder outputs to interleaved 16 bit samples.
+func (d *aacDecoder) frameToWave() (wave.Audio, error) {
+	if d.frame.format != C.AV_SAMPLE_FMT_FLTP {
+		return nil, errors.Errorf("unsupported AAC sample format %d", d.frame.format)
+	}
+	channels := int(d.frame.ch_layout.nb_channels)
+	samples := int(d.frame.nb_samples)
+	chunk := wave.NewInt16Interleaved(wave.ChunkInfo{Len: samples, Channels: channels, SamplingRate: int(d.frame.sample_rate)})
+	planes := unsafe.Slice(d.frame.extended_data, channels)
+	for ch, plane := range planes {
+		for i, v := range unsafe.Slice((*float32)(unsafe.Pointer(plane)), samples) {
+			chunk.Data[i*channels+ch] = int16(math.Max(-1, math.Min(1, float64(v))) * math.MaxInt16)
+		}
+	}
+	return chunk, nil
+}
+
+func (d *aacDecoder) close() {
+	if d.frame != nil {
+		C.av_frame_free(&d.frame)
+	}
+	C.avcodec_free_context(&d.codecCtx)
+}
@@ -0,0 +1,273 @@
+package viamrtsp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmpeg4audio"
+	"github.com/bluenviron/mediacommon/pkg/codecs/g711"
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/mediadevices/pkg/wave"
+	"github.com/pion/rtp"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/audioinput"
+	"go.viam.com/rdk/gostream"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+)
+
+// audioSubscriberBufferSize is the number of audio chunks buffered per subscriber before chunks are dropped.
+const audioSubscriberBufferSize = 64
+
+// ModelAudio serves the audio track of an RTSP camera as an audio input.
+var ModelAudio = family.WithModel("rtsp-audio")
+
+func init() {
+	resource.RegisterComponent(audioinput.API, ModelAudio, resource.Registration[audioinput.AudioInput, *AudioConfig]{
+		Constructor: newRTSPAudio,
+	})
+}
+
+// AudioConfig are the config attributes for the audio input model.
+type AudioConfig struct {
+	// Camera is the name of the RTSP camera whose audio track is served. The camera must have `audio` enabled.
+	Camera string `json:"camera"`
+}
+
+// Validate checks to see if the attributes of the model are valid.
+func (conf *AudioConfig) Validate(path string) ([]string, error) {
+	if conf.Camera == "" {
+		return nil, fmt.Errorf("camera is required for component at path '%s'", path)
+	}
+	return []string{conf.Camera}, nil
+}
+
+// cameras holds the RTSP cameras of this module by name so that audio inputs can share their connection.
+var cameras = struct {
+	mu     sync.Mutex
+	byName map[string]*rtspCamera
+}{byName: map[string]*rtspCamera{}}
+
+func registerCamera(rc *rtspCamera) {
+	cameras.mu.Lock()
+	defer cameras.mu.Unlock()
+	cameras.byName[rc.Name().Name] = rc
+}
+
+func unregisterCamera(rc *rtspCamera) {
+	cameras.mu.Lock()
+	defer cameras.mu.Unlock()
+	if cameras.byName[rc.Name().Name] == rc {
+		delete(cameras.byName, rc.Name().Name)
+	}
+}
+
+func lookupCamera(name string) (*rtspCamera, bool) {
+	cameras.mu.Lock()
+	defer cameras.mu.Unlock()
+	rc, ok := cameras.byName[name]
+	return rc, ok
+}
+
+// rtspAudio serves the decoded audio track of an rtspCamera.
+type rtspAudio struct {
+	resource.AlwaysRebuild
+	resource.Named
+	gostream.AudioSource
+
+	cam         *rtspCamera
+	unsubscribe func()
+}
+
+func newRTSPAudio(ctx context.Context, _ resource.Dependencies, conf resource.Config, _ logging.Logger) (audioinput.AudioInput, error) {
+	newConf, err := resource.NativeConfig[*AudioConfig](conf)
+	if err != nil {
+		return nil, err
+	}
+	cam, ok := lookupCamera(newConf.Camera)
+	if !ok {
+		return nil, errors.Errorf("camera '%s' is not an RTSP camera of this module", newConf.Camera)
+	}
+	props, err := cam.audioProperties()
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, unsubscribe := cam.subscribeAudio()
+	reader := gostream.AudioReaderFunc(func(ctx context.Context) (wave.Audio, func(), error) {
+		select {
+		case <-ctx.Done():
+			return nil, func() {}, ctx.Err()
+		case chunk := <-chunks:
+			return chunk, func() {}, nil
+		}
+	})
+	return &rtspAudio{
+		Named:       conf.ResourceName().AsNamed(),
+		AudioSource: gostream.NewAudioSource(reader, props),
+		cam:         cam,
+		unsubscribe: unsubscribe,
+	}, nil
+}
+
+// MediaProperties returns the properties of the audio track.
+func (ra *rtspAudio) MediaProperties(_ context.Context) (prop.Audio, error) {
+	return ra.cam.audioProperties()
+}
+
+// DoCommand is not supported by the audio input.
+func (ra *rtspAudio) DoCommand(_ context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
+	return nil, resource.ErrDoUnimplemented
+}
+
+// Close stops receiving audio from the camera.
+func (ra *rtspAudio) Close(ctx context.Context) error {
+	ra.unsubscribe()
+	return ra.AudioSource.Close(ctx)
+}
+
+// initAudio sets up the client to receive & decode the first supported audio track, if there is one.
+func (rc *rtspCamera) initAudio(session *description.Session) error {
+	var g711Format *format.G711
+	var aacFormat *format.MPEG4Audio
+	if media := session.FindFormat(&g711Format); media != nil {
+		return rc.initG711(session, media, g711Format)
+	}
+	if media := session.FindFormat(&aacFormat); media != nil {
+		return rc.initAAC(session, media, aacFormat)
+	}
+	rc.logger.Warn("audio is enabled but the stream has no G.711 or AAC audio track")
+	return nil
+}
+
+func (rc *rtspCamera) initG711(session *description.Session, media *description.Media, f *format.G711) error {
+	rtpDec, err := f.CreateDecoder()
+	if err != nil {
+		return errors.Wrap(err, "creating G.711 RTP decoder")
+	}
+	if _, err := rc.client.Setup(session.BaseURL, media, 0, 0); err != nil {
+		return errors.Wrapf(err, "when calling RTSP Setup on %s for G.711", session.BaseURL.CloneWithoutCredentials())
+	}
+	channels := f.ChannelCount
+	if channels == 0 {
+		channels = 1
+	}
+	rc.setAudioProperties(prop.Audio{ChannelCount: channels, SampleRate: f.SampleRate, IsInterleaved: true})
+
+	rc.onPacketRTP(media, f, func(pkt *rtp.Packet) {
+		samples, err := rtpDec.Decode(pkt)
+		if err != nil {
+			rc.logger.Debugf("error decoding G.711 rtp packet err: %s", err)
+			return
+		}
+		var lpcm []byte
+		if f.MULaw {
+			lpcm = g711.DecodeMulaw(samples)
+		} else {
+			lpcm = g711.DecodeAlaw(samples)
+		}
+		rc.publishAudio(lpcmToWave(lpcm, channels, f.SampleRate))
+	})
+	return nil
+}
+
+func (rc *rtspCamera) initAAC(session *description.Session, media *description.Media, f *format.MPEG4Audio) error {
+	config := f.GetConfig()
+	if config == nil {
+		return errors.New("AAC track has no AudioSpecificConfig")
+	}
+	configBytes, err := config.Marshal()
+	if err != nil {
+		return errors.Wrap(err, "marshaling AAC AudioSpecificConfig")
+	}
+	rtpDec, err := f.CreateDecoder()
+	if err != nil {
+		return errors.Wrap(err, "creating AAC RTP decoder")
+	}
+	rc.audioDecoder, err = newAACDecoder(configBytes)
+	if err != nil {
+		return errors.Wrap(err, "creating AAC decoder")
+	}
+	if _, err := rc.client.Setup(session.BaseURL, media, 0, 0); err != nil {
+		return errors.Wrapf(err, "when calling RTSP Setup on %s for AAC", session.BaseURL.CloneWithoutCredentials())
+	}
+	rc.setAudioProperties(prop.Audio{ChannelCount: config.ChannelCount, SampleRate: config.SampleRate, IsInterleaved: true})
+
+	audioDecoder := rc.audioDecoder
+	rc.onPacketRTP(media, f, func(pkt *rtp.Packet) {
+		aus, err := rtpDec.Decode(pkt)
+		if err != nil {
+			if !errors.Is(err, rtpmpeg4audio.ErrMorePacketsNeeded) {
+				rc.logger.Debugf("error decoding AAC rtp packet err: %s", err)
+			}
+			return
+		}
+		for _, au := range aus {
+			chunks, err := audioDecoder.decode(au)
+			if err != nil {
+				rc.logger.Debugf("error decoding AAC access unit err: %s", err)
+			}
+			for _, chunk := range chunks {
+				rc.publishAudio(chunk)
+			}
+		}
+	})
+	return nil
+}
+
+// lpcmToWave converts big endian 16 bit LPCM samples to an audio chunk.
+func lpcmToWave(lpcm []byte, channels, sampleRate int) wave.Audio {
+	chunk := wave.NewInt16Interleaved(wave.ChunkInfo{Len: len(lpcm) / 2 / channels, Channels: channels, SamplingRate: sampleRate})
+	for i := range chunk.Data {
+		chunk.Data[i] = int16(uint16(lpcm[i*2])<<8 | uint16(lpcm[i*2+1]))
+	}
+	return chunk
+}
+
+func (rc *rtspCamera) setAudioProperties(props prop.Audio) {
+	rc.audioMu.Lock()
+	defer rc.audioMu.Unlock()
+	rc.audioProps = &props
+}
+
+func (rc *rtspCamera) audioProperties() (prop.Audio, error) {
+	rc.audioMu.Lock()
+	defer rc.audioMu.Unlock()
+	if rc.audioProps == nil {
+		return prop.Audio{}, errors.Errorf("camera '%s' has no audio track, make sure `audio` is enabled", rc.Name().Name)
+	}
+	return *rc.audioProps, nil
+}
+
+// subscribeAudio returns a channel which receives every decoded audio chunk, and a function to unsubscribe.
+// Chunks are dropped if the subscriber does not keep up.
+func (rc *rtspCamera) subscribeAudio() (<-chan wave.Audio, func()) {
+	ch := make(chan wave.Audio, audioSubscriberBufferSize)
+	rc.audioMu.Lock()
+	defer rc.audioMu.Unlock()
+	if rc.audioSubs == nil {
+		rc.audioSubs = map[chan wave.Audio]struct{}{}
+	}
+	rc.audioSubs[ch] = struct{}{}
+	rc.resumeStream()
+	return ch, func() {
+		rc.audioMu.Lock()
+		defer rc.audioMu.Unlock()
+		delete(rc.audioSubs, ch)
+	}
+}
+
+func (rc *rtspCamera) publishAudio(chunk wave.Audio) {
+	rc.audioMu.Lock()
+	defer rc.audioMu.Unlock()
+	for ch := range rc.audioSubs {
+		select {
+		case ch <- chunk:
+		default:
+			rc.metrics.subscriberDrops.Add(1)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"github.com/pion/mediadevices/pkg/wave"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/test"
+)
+
+func TestAudioConfig(t *testing.T) {
+	deps, err := (&AudioConfig{Camera: "cam"}).Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, deps, test.ShouldResemble, []string{"cam"})
+
+	_, err = (&AudioConfig{}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestLPCMToWave(t *testing.T) {
+	chunk := lpcmToWave([]byte{0x01, 0x02, 0xff, 0xfe, 0x00, 0x10, 0x80, 0x00}, 2, 8000)
+	i16, ok := chunk.(*wave.Int16Interleaved)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, i16.ChunkInfo(), test.ShouldResemble, wave.ChunkInfo{Len: 2, Channels: 2, SamplingRate: 8000})
+	test.That(t, i16.Data, test.ShouldResemble, []int16{0x0102, -2, 0x0010, -32768})
+}
+
+func TestAudioSubscriptions(t *testing.T) {
+	rc := &rtspCamera{Named: camera.Named("cam").AsNamed()}
+	_, err := rc.audioProperties()
+	test.That(t, err, test.ShouldNotBeNil)
+
+	registerCamera(rc)
+	found, ok := lookupCamera("cam")
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, found, test.ShouldEqual, rc)
+	unregisterCamera(rc)
+	_, ok = lookupCamera("cam")
+	test.That(t, ok, test.ShouldBeFalse)
+
+	chunks, unsubscribe := rc.subscribeAudio()
+	chunk := lpcmToWave([]byte{0, 1}, 1, 8000)
+	rc.publishAudio(chunk)
+	test.That(t, <-chunks, test.ShouldEqual, chunk)
+
+	// slow subscribers drop chunks rather than block the stream
+	for i := 0; i < audioSubscriberBufferSize+1; i++ {
+		rc.publishAudio(chunk)
+	}
+	test.That(t, len(chunks), test.ShouldEqual, audioSubscriberBufferSize)
+
+	unsubscribe()
+	test.That(t, rc.audioSubs, test.ShouldBeEmpty)
+}
@@ -0,0 +1,112 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+	"github.com/pkg/errors"
+)
+
+// authError is returned when the camera rejects the configured credentials, or requires credentials
+// which are not configured, so that it can be told apart from network errors.
+type authError struct {
+	method base.Method
+	user   string
+	// attempted is the scheme of the rejected Authorization header, empty if none was sent.
+	attempted string
+	// offered are the schemes of the camera's WWW-Authenticate headers.
+	offered []string
+}
+
+// Error implements the error interface.
+func (e *authError) Error() string {
+	offered := "no authentication scheme"
+	if len(e.offered) > 0 {
+		offered = strings.Join(e.offered, ", ")
+	}
+	switch {
+	case e.user == "":
+		return fmt.Sprintf("camera requires authentication for %s (offers %s) but no username is configured", e.method, offered)
+	case e.attempted == "":
+		return fmt.Sprintf("camera rejected %s for user '%s' without offering a supported authentication scheme (offers %s)",
+			e.method, e.user, offered)
+	default:
+		return fmt.Sprintf("camera rejected %s credentials for user '%s' on %s (offers %s), check the username & password",
+			e.attempted, e.user, e.method, offered)
+	}
+}
+
+// Is reports whether target is ErrUnauthorized, so that callers can branch on auth failures.
+func (e *authError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+// authTracker records the authentication exchanged with the camera through the client's
+// OnRequest & OnResponse callbacks, which is used to explain why a request was rejected.
+type authTracker struct {
+	mu        sync.Mutex
+	method    base.Method
+	attempted string
+	offered   []string
+}
+
+func (a *authTracker) onRequest(req *base.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.method = req.Method
+	a.attempted = ""
+	if v := req.Header["Authorization"]; len(v) > 0 {
+		a.attempted = authScheme(v[0])
+	}
+}
+
+func (a *authTracker) onResponse(res *base.Response) {
+	if res.StatusCode != base.StatusUnauthorized {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.offered = nil
+	for _, v := range res.Header["WWW-Authenticate"] {
+		a.offered = append(a.offered, authScheme(v))
+	}
+}
+
+// classify returns an *authError if err was caused by the camera rejecting the request's credentials,
+// and marks network errors as such, with ErrConnectTimeout as the cause of timeouts, so that users can tell
+// which one to fix.
+func (a *authTracker) classify(err error, u *base.URL) error {
+	var status liberrors.ErrClientBadStatusCode
+	var setup liberrors.ErrClientAuthSetup
+	if (errors.As(err, &status) && status.Code == base.StatusUnauthorized) || errors.As(err, &setup) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		authErr := &authError{method: a.method, attempted: a.attempted, offered: a.offered}
+		if u.User != nil {
+			authErr.user = u.User.Username()
+		}
+		return authErr
+	}
+	var timedOut liberrors.ErrClientRequestTimedOut
+	if errors.As(err, &timedOut) {
+		return withCause(ErrConnectTimeout, err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return withCause(ErrConnectTimeout, errors.Wrap(err, "network error"))
+		}
+		return errors.Wrap(err, "network error")
+	}
+	return err
+}
+
+// authScheme returns the scheme of an Authorization or WWW-Authenticate header value, e.g. Digest.
+func authScheme(v string) string {
+	scheme, _, _ := strings.Cut(strings.TrimSpace(v), " ")
+	return scheme
+}
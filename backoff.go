@@ -0,0 +1,64 @@
+package viamrtsp
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultReconnectInterval = 5 * time.Second
+	defaultMaxBackoff        = time.Minute
+)
+
+// reconnectPolicy controls how often the reconnect worker checks the connection & retries failed reconnects.
+type reconnectPolicy struct {
+	// interval is how often the connection is checked while healthy, and the delay after the first failed reconnect.
+	interval time.Duration
+	// maxBackoff caps the delay between failed reconnects.
+	maxBackoff time.Duration
+	// giveUpAfter stops retrying once reconnecting has failed for this long, zero retries forever.
+	giveUpAfter time.Duration
+}
+
+func newReconnectPolicy(conf *Config) reconnectPolicy {
+	p := reconnectPolicy{
+		interval:    defaultReconnectInterval,
+		maxBackoff:  defaultMaxBackoff,
+		giveUpAfter: secondsToDuration(conf.GiveUpAfter),
+	}
+	if conf.ReconnectInterval > 0 {
+		p.interval = secondsToDuration(conf.ReconnectInterval)
+	}
+	if conf.MaxBackoff > 0 {
+		p.maxBackoff = secondsToDuration(conf.MaxBackoff)
+	}
+	if p.maxBackoff < p.interval {
+		p.maxBackoff = p.interval
+	}
+	return p
+}
+
+// backoff returns the delay before the next reconnect after failures consecutive failed reconnects,
+// doubling with every failure up to maxBackoff.
+func (p reconnectPolicy) backoff(failures int) time.Duration {
+	d := p.interval
+	for i := 1; i < failures && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.maxBackoff)
+}
+
+// delay returns how long the reconnect worker waits before its next check. While reconnects are failing
+// the backoff is jittered so that cameras which lost the same server don't all retry at once.
+func (p reconnectPolicy) delay(failures int) time.Duration {
+	if failures == 0 {
+		return p.interval
+	}
+	d := p.backoff(failures)
+	//nolint:gosec
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func secondsToDuration(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
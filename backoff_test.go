@@ -0,0 +1,35 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestReconnectPolicy(t *testing.T) {
+	p := newReconnectPolicy(&Config{})
+	test.That(t, p.interval, test.ShouldEqual, defaultReconnectInterval)
+	test.That(t, p.maxBackoff, test.ShouldEqual, defaultMaxBackoff)
+	test.That(t, p.giveUpAfter, test.ShouldEqual, time.Duration(0))
+
+	p = newReconnectPolicy(&Config{ReconnectInterval: 2, MaxBackoff: 10, GiveUpAfter: 0.5})
+	test.That(t, p.giveUpAfter, test.ShouldEqual, 500*time.Millisecond)
+	test.That(t, p.backoff(1), test.ShouldEqual, 2*time.Second)
+	test.That(t, p.backoff(2), test.ShouldEqual, 4*time.Second)
+	test.That(t, p.backoff(3), test.ShouldEqual, 8*time.Second)
+	test.That(t, p.backoff(4), test.ShouldEqual, 10*time.Second)
+	test.That(t, p.backoff(100), test.ShouldEqual, 10*time.Second)
+
+	// the healthy interval is not jittered
+	test.That(t, p.delay(0), test.ShouldEqual, 2*time.Second)
+	for i := 0; i < 100; i++ {
+		d := p.delay(3)
+		test.That(t, d, test.ShouldBeGreaterThanOrEqualTo, 4*time.Second)
+		test.That(t, d, test.ShouldBeLessThanOrEqualTo, 8*time.Second)
+	}
+
+	// max_backoff is never less than the interval
+	p = newReconnectPolicy(&Config{ReconnectInterval: 90})
+	test.That(t, p.backoff(5), test.ShouldEqual, 90*time.Second)
+}
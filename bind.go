@@ -0,0 +1,62 @@
+package viamrtsp
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	controlFunc      func(network, address string, c syscall.RawConn) error
+	listenPacketFunc func(network, address string) (net.PacketConn, error)
+)
+
+// localBinding binds the connections of the RTSP client to a local network interface or address, so that
+// the streams of multi-homed robots don't traverse e.g. a metered LTE link.
+type localBinding struct {
+	iface string
+	ip    net.IP
+}
+
+// newLocalBinding returns the binding of conf, nil if it binds to neither an interface nor an address.
+func newLocalBinding(conf *Config) *localBinding {
+	if conf.BindInterface == "" && conf.BindAddress == "" {
+		return nil
+	}
+	return &localBinding{iface: conf.BindInterface, ip: net.ParseIP(conf.BindAddress)}
+}
+
+// resolve returns the functions which dial the RTSP connection & listen for UDP packets from the binding.
+// The interface is looked up on every call, as it may come up after the module started.
+func (b *localBinding) resolve() (dialFunc, listenPacketFunc, error) {
+	ip := b.ip
+	var control controlFunc
+	if b.iface != "" {
+		iface, err := net.InterfaceByName(b.iface)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "finding bind_interface '%s'", b.iface)
+		}
+		if control, ip, err = interfaceBinding(iface); err != nil {
+			return nil, nil, errors.Wrapf(err, "binding to bind_interface '%s'", b.iface)
+		}
+	}
+
+	dialer := &net.Dialer{Control: control}
+	if ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	listenConfig := &net.ListenConfig{Control: control}
+	listen := func(network, address string) (net.PacketConn, error) {
+		if ip != nil {
+			_, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			address = net.JoinHostPort(ip.String(), port)
+		}
+		return listenConfig.ListenPacket(context.Background(), network, address)
+	}
+	return dialer.DialContext, listen, nil
+}
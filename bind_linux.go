@@ -0,0 +1,21 @@
+package viamrtsp
+
+import (
+	"net"
+	"syscall"
+)
+
+// interfaceBinding binds sockets to iface with SO_BINDTODEVICE, so that they're routed through iface
+// whichever address the kernel picks for them.
+func interfaceBinding(iface *net.Interface) (controlFunc, net.IP, error) {
+	control := func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		if err := c.Control(func(fd uintptr) {
+			bindErr = syscall.BindToDevice(int(fd), iface.Name)
+		}); err != nil {
+			return err
+		}
+		return bindErr
+	}
+	return control, nil, nil
+}
@@ -0,0 +1,41 @@
+//go:build !linux
+
+package viamrtsp
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// interfaceBinding binds sockets to the address of iface. Unlike SO_BINDTODEVICE on linux this only picks
+// the source address of packets, which the kernel routes through iface unless its routes say otherwise.
+func interfaceBinding(iface *net.Interface) (controlFunc, net.IP, error) {
+	ip, err := interfaceIP(iface)
+	return nil, ip, err
+}
+
+// interfaceIP returns the first IPv4 address of iface, or its first IPv6 address if it has none.
+func interfaceIP(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ip net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+		if ip == nil {
+			ip = ipNet.IP
+		}
+	}
+	if ip == nil {
+		return nil, errors.New("interface has no ip address")
+	}
+	return ip, nil
+}
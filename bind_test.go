@@ -0,0 +1,51 @@
+package viamrtsp
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func loopbackInterface(t *testing.T) string {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	test.That(t, err, test.ShouldBeNil)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestLocalBinding(t *testing.T) {
+	test.That(t, newLocalBinding(&Config{}), test.ShouldBeNil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	test.That(t, err, test.ShouldBeNil)
+	defer listener.Close()
+
+	for _, conf := range []*Config{{BindAddress: "127.0.0.1"}, {BindInterface: loopbackInterface(t)}} {
+		dial, listen, err := newLocalBinding(conf).resolve()
+		test.That(t, err, test.ShouldBeNil)
+
+		conn, err := dial(context.Background(), "tcp", listener.Addr().String())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, conn.LocalAddr().(*net.TCPAddr).IP.IsLoopback(), test.ShouldBeTrue)
+		test.That(t, conn.Close(), test.ShouldBeNil)
+
+		pc, err := listen("udp", ":0")
+		test.That(t, err, test.ShouldBeNil)
+		if conf.BindAddress != "" {
+			test.That(t, pc.LocalAddr().(*net.UDPAddr).IP.String(), test.ShouldEqual, conf.BindAddress)
+		}
+		test.That(t, pc.Close(), test.ShouldBeNil)
+	}
+
+	_, _, err = newLocalBinding(&Config{BindInterface: "missing0"}).resolve()
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "bind_interface 'missing0'")
+}
@@ -2,6 +2,6 @@
 set -e
 
 sudo apt-get update
-sudo apt-get install -y pkg-config
+sudo apt-get install -y pkg-config libdav1d-dev
 
 make module
@@ -0,0 +1,199 @@
+package viamrtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// clipTimeFormat is the local time format of the from & to fields of clip commands, which is
+	// the format used by the video-store module.
+	clipTimeFormat = "2006-01-02_15-04-05"
+	// maxFetchClipSize bounds the size of clips returned by fetch, longer clips should be saved instead.
+	maxFetchClipSize = 16 << 20
+)
+
+var errNoRecording = errors.New("no recording found between from & to")
+
+// parseClipTime parses the time in the key field of cmd, which is either in clipTimeFormat or RFC3339.
+func parseClipTime(cmd map[string]interface{}, key string) (time.Time, error) {
+	s, ok := cmd[key].(string)
+	if !ok {
+		return time.Time{}, errors.Errorf("requires a string '%s' field", key)
+	}
+	if t, err := time.ParseInLocation(clipTimeFormat, s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("'%s' must be formatted as %s or RFC3339, got %q", key, clipTimeFormat, s)
+	}
+	return t, nil
+}
+
+// clipSegments returns the segments which contain frames captured between from & to. Segments
+// end when the next segment starts.
+func clipSegments(segments []segmentFile, from, to time.Time) []segmentFile {
+	var clip []segmentFile
+	for i, s := range segments {
+		if s.start.After(to) {
+			break
+		}
+		if i+1 < len(segments) && !segments[i+1].start.After(from) {
+			continue
+		}
+		clip = append(clip, s)
+	}
+	return clip
+}
+
+// parseClipCommand returns the recording config & the time range of a save or fetch command.
+func (rc *rtspCamera) parseClipCommand(name string, cmd map[string]interface{}) (*RecordingConfig, time.Time, time.Time, error) {
+	conf := rc.recordingConf.Load()
+	if conf == nil {
+		return nil, time.Time{}, time.Time{}, errors.Errorf("%s requires recording to be configured", name)
+	}
+	from, err := parseClipTime(cmd, "from")
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, errors.Wrap(err, name)
+	}
+	to, err := parseClipTime(cmd, "to")
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, errors.Wrap(err, name)
+	}
+	if !from.Before(to) {
+		return nil, time.Time{}, time.Time{}, errors.Errorf("%s requires 'from' to be before 'to'", name)
+	}
+	return conf, from, to, nil
+}
+
+// writeClip remuxes the recording between from & to into a temporary MP4 file, whose path is returned.
+func (rc *rtspCamera) writeClip(conf *RecordingConfig, from, to time.Time) (string, error) {
+	segments, err := listSegments(conf.Directory, rc.Name().Name)
+	if err != nil {
+		return "", errors.Wrap(err, "listing recording segments")
+	}
+	segments = clipSegments(segments, from, to)
+	if len(segments) == 0 {
+		return "", errNoRecording
+	}
+	// clips are written next to the segments, so that saved clips can be renamed into the upload path
+	// & are only synced once complete
+	f, err := os.CreateTemp(conf.Directory, ".clip-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := remuxClip(path, segments, from, to); err != nil {
+		//nolint:errcheck
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// validateClipMetadata returns an error if metadata, which is added to the filename of saved clips, could make
+// the clip be written outside of the upload path.
+func validateClipMetadata(metadata string) error {
+	if strings.ContainsAny(metadata, "/\\\x00") || strings.Contains(metadata, "..") {
+		return errors.Errorf("'metadata' must not contain path separators or '..', got %q", metadata)
+	}
+	return nil
+}
+
+// saveClip saves the recording between from & to in the upload path, to be uploaded by the data manager.
+func (rc *rtspCamera) saveClip(cmd map[string]interface{}) (map[string]interface{}, error) {
+	conf, from, to, err := rc.parseClipCommand(saveCommand, cmd)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _ := cmd["metadata"].(string)
+	if err := validateClipMetadata(metadata); err != nil {
+		return nil, errors.Wrap(err, saveCommand)
+	}
+	dir, err := conf.uploadPath()
+	if err != nil {
+		return nil, errors.Wrap(err, "finding upload path")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, errors.Wrap(err, "creating upload path")
+	}
+	name := fmt.Sprintf("%s_%s", rc.Name().Name, from.Format(clipTimeFormat))
+	if metadata != "" {
+		name += "_" + metadata
+	}
+	name += ".mp4"
+
+	clip, err := rc.writeClip(conf, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if err := moveFile(clip, filepath.Join(dir, name)); err != nil {
+		return nil, errors.Wrap(err, "moving clip to upload path")
+	}
+	return map[string]interface{}{commandKey: saveCommand, "filename": name}, nil
+}
+
+// fetchClip returns the recording between from & to as a base64 encoded MP4 file.
+func (rc *rtspCamera) fetchClip(cmd map[string]interface{}) (map[string]interface{}, error) {
+	conf, from, to, err := rc.parseClipCommand(fetchCommand, cmd)
+	if err != nil {
+		return nil, err
+	}
+	clip, err := rc.writeClip(conf, from, to)
+	if err != nil {
+		return nil, err
+	}
+	//nolint:errcheck
+	defer os.Remove(clip)
+	info, err := os.Stat(clip)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxFetchClipSize {
+		return nil, errors.Errorf("clip is %d bytes, which is larger than the %d bytes fetch can return, use %s instead",
+			info.Size(), maxFetchClipSize, saveCommand)
+	}
+	video, err := os.ReadFile(clip)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{commandKey: fetchCommand, "video": base64.StdEncoding.EncodeToString(video)}, nil
+}
+
+// moveFile renames src to dst, copying it if they are on different file systems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	//nolint:errcheck
+	defer os.Remove(src)
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		//nolint:errcheck
+		out.Close()
+		//nolint:errcheck
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,98 @@
+package viamrtsp
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/test"
+)
+
+func TestParseClipTime(t *testing.T) {
+	at, err := parseClipTime(map[string]interface{}{"from": "2024-05-01_12-30-00"}, "from")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, at.Equal(time.Date(2024, 5, 1, 12, 30, 0, 0, time.Local)), test.ShouldBeTrue)
+
+	at, err = parseClipTime(map[string]interface{}{"to": "2024-05-01T12:30:00.5Z"}, "to")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, at.Equal(time.Date(2024, 5, 1, 12, 30, 0, 5e8, time.UTC)), test.ShouldBeTrue)
+
+	_, err = parseClipTime(map[string]interface{}{"from": "yesterday"}, "from")
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = parseClipTime(map[string]interface{}{}, "from")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestClipSegments(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	segments := []segmentFile{
+		{path: "a", start: start},
+		{path: "b", start: start.Add(time.Minute)},
+		{path: "c", start: start.Add(2 * time.Minute)},
+	}
+	paths := func(segments []segmentFile) []string {
+		var paths []string
+		for _, s := range segments {
+			paths = append(paths, s.path)
+		}
+		return paths
+	}
+
+	test.That(t, paths(clipSegments(segments, start.Add(30*time.Second), start.Add(90*time.Second))),
+		test.ShouldResemble, []string{"a", "b"})
+	test.That(t, paths(clipSegments(segments, start.Add(time.Minute), start.Add(70*time.Second))),
+		test.ShouldResemble, []string{"b"})
+	// the latest segment is still being recorded
+	test.That(t, paths(clipSegments(segments, start.Add(time.Hour), start.Add(2*time.Hour))),
+		test.ShouldResemble, []string{"c"})
+	test.That(t, clipSegments(segments, start.Add(-time.Hour), start.Add(-time.Minute)), test.ShouldBeEmpty)
+}
+
+func TestClipCommands(t *testing.T) {
+	rc := &rtspCamera{Named: camera.Named("cam").AsNamed()}
+	cmd := map[string]interface{}{"command": "fetch", "from": "2024-05-01_12-00-00", "to": "2024-05-01_12-01-00"}
+
+	_, err := rc.DoCommand(context.Background(), cmd)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "requires recording to be configured")
+
+	dir := t.TempDir()
+	rc.recordingConf.Store(&RecordingConfig{Directory: dir})
+	_, err = rc.DoCommand(context.Background(), cmd)
+	test.That(t, err, test.ShouldEqual, errNoRecording)
+
+	cmd["command"] = "save"
+	cmd["to"] = cmd["from"]
+	_, err = rc.DoCommand(context.Background(), cmd)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "'from' to be before 'to'")
+
+	// metadata can't write the clip outside of the upload path
+	cmd["to"] = "2024-05-01_12-01-00"
+	for _, metadata := range []string{"/../../../x", "..", `a\b`} {
+		cmd["metadata"] = metadata
+		_, err = rc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "must not contain path separators")
+	}
+	test.That(t, validateClipMetadata("front-door_motion"), test.ShouldBeNil)
+
+	// no clips are left behind
+	entries, err := os.ReadDir(dir)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, entries, test.ShouldBeEmpty)
+}
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := dir+"/src.mp4", dir+"/dst.mp4"
+	test.That(t, os.WriteFile(src, []byte("clip"), 0o600), test.ShouldBeNil)
+	test.That(t, moveFile(src, dst), test.ShouldBeNil)
+	b, err := os.ReadFile(dst)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(b), test.ShouldEqual, "clip")
+	_, err = os.Stat(src)
+	test.That(t, os.IsNotExist(err), test.ShouldBeTrue)
+}
@@ -6,7 +6,10 @@ import (
 
 	"github.com/erh/viamrtsp"
 	"go.uber.org/zap/zapcore"
+	"go.viam.com/rdk/components/audioinput"
 	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/components/generic"
+	"go.viam.com/rdk/components/sensor"
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/module"
 	"go.viam.com/utils"
@@ -34,6 +37,31 @@ func mainWithArgs(ctx context.Context, _ []string, logger logging.Logger) error
 		}
 	}
 
+	err = myMod.AddModelFromRegistry(ctx, camera.API, viamrtsp.ModelStereo)
+	if err != nil {
+		return err
+	}
+
+	err = myMod.AddModelFromRegistry(ctx, camera.API, viamrtsp.ModelFake)
+	if err != nil {
+		return err
+	}
+
+	err = myMod.AddModelFromRegistry(ctx, audioinput.API, viamrtsp.ModelAudio)
+	if err != nil {
+		return err
+	}
+
+	err = myMod.AddModelFromRegistry(ctx, generic.API, viamrtsp.ModelManager)
+	if err != nil {
+		return err
+	}
+
+	err = myMod.AddModelFromRegistry(ctx, sensor.API, viamrtsp.ModelEvents)
+	if err != nil {
+		return err
+	}
+
 	err = myMod.Start(ctx)
 	defer myMod.Close(ctx)
 	if err != nil {
@@ -0,0 +1,48 @@
+package viamrtsp
+
+import (
+	"github.com/pion/rtp"
+)
+
+// lossGuard suppresses H264 & H265 access units after packet loss until the next intact keyframe, as
+// frames decoded from a corrupted reference frame are smeared until then. Images freeze at the last
+// intact frame instead. gortsplib reorders UDP packets, so gaps in sequence numbers are losses.
+// A lossGuard is only used by the RTP read loop of its track.
+type lossGuard struct {
+	started bool
+	nextSeq uint16
+	// lostSinceAU is set when a packet was lost since the last access unit, which may have belonged to
+	// either the last access unit or the next one
+	lostSinceAU bool
+	// corrupted is set from a lost packet until the next intact keyframe
+	corrupted bool
+}
+
+// packet checks pkt, the next packet of the track, for a gap in sequence numbers.
+func (g *lossGuard) packet(pkt *rtp.Packet) {
+	if g.started && pkt.SequenceNumber != g.nextSeq {
+		g.lostSinceAU = true
+	}
+	g.started = true
+	g.nextSeq = pkt.SequenceNumber + 1
+}
+
+// suppress returns true if an access unit, which was completed by the latest packet, should not be decoded.
+func (g *lossGuard) suppress(keyframe bool) bool {
+	switch {
+	case g.lostSinceAU:
+		g.lostSinceAU = false
+		g.corrupted = true
+	case keyframe:
+		g.corrupted = false
+	}
+	return g.corrupted
+}
+
+// newLossGuard returns a lossGuard for a new H264 or H265 track, or nil if suppress_corrupted_frames is not set.
+func (rc *rtspCamera) newLossGuard() *lossGuard {
+	if !rc.suppressCorrupted {
+		return nil
+	}
+	return &lossGuard{}
+}
@@ -0,0 +1,31 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"go.viam.com/test"
+)
+
+func TestLossGuard(t *testing.T) {
+	var g lossGuard
+	// accessUnit feeds the packets of an access unit to g & returns whether it is suppressed
+	accessUnit := func(keyframe bool, seqs ...uint16) bool {
+		for _, seq := range seqs {
+			g.packet(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}})
+		}
+		return g.suppress(keyframe)
+	}
+
+	test.That(t, accessUnit(true, 65534, 65535), test.ShouldBeFalse)
+	test.That(t, accessUnit(false, 0, 1), test.ShouldBeFalse)
+	// packet 2 was lost, the access unit & the ones after it are suppressed until the next keyframe
+	test.That(t, accessUnit(false, 3), test.ShouldBeTrue)
+	test.That(t, accessUnit(false, 4, 5), test.ShouldBeTrue)
+	test.That(t, accessUnit(true, 6, 7), test.ShouldBeFalse)
+	test.That(t, accessUnit(false, 8), test.ShouldBeFalse)
+	// a keyframe with a lost packet is corrupted too
+	test.That(t, accessUnit(true, 9, 11), test.ShouldBeTrue)
+	test.That(t, accessUnit(false, 12), test.ShouldBeTrue)
+	test.That(t, accessUnit(true, 13), test.ShouldBeFalse)
+}
@@ -0,0 +1,146 @@
+package viamrtsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CredentialsConfig looks up the username & password of the camera outside of the robot config,
+// so that passwords don't need to be stored in plaintext in the component's attributes.
+type CredentialsConfig struct {
+	// UsernameEnv and PasswordEnv name the environment variables holding the credentials.
+	UsernameEnv string `json:"username_env,omitempty"`
+	PasswordEnv string `json:"password_env,omitempty"`
+	// File is a JSON file mapping device keys to credentials, e.g.
+	// {"AA:BB:CC:DD:EE:FF": {"username": "admin", "password": "secret"}}.
+	File string `json:"file,omitempty"`
+	// Key identifies the camera in File, e.g. its serial number or MAC address.
+	Key string `json:"key,omitempty"`
+}
+
+// Validate checks that exactly one credentials source is configured.
+func (c *CredentialsConfig) Validate(path string) error {
+	switch {
+	case c.UsernameEnv != "" && c.File != "":
+		return fmt.Errorf("invalid credentials for component at path '%s': username_env and file can not both be set", path)
+	case c.UsernameEnv == "" && c.File == "":
+		return fmt.Errorf("invalid credentials for component at path '%s': one of username_env or file is required", path)
+	case c.PasswordEnv != "" && c.UsernameEnv == "":
+		return fmt.Errorf("invalid credentials for component at path '%s': password_env requires username_env", path)
+	case c.File != "" && c.Key == "":
+		return fmt.Errorf("invalid credentials for component at path '%s': file requires key", path)
+	case c.Key != "" && c.File == "":
+		return fmt.Errorf("invalid credentials for component at path '%s': key requires file", path)
+	}
+	return nil
+}
+
+// credentialsEntry is the value of each device key in a credentials file.
+type credentialsEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// lookup returns the username & password from the configured source.
+func (c *CredentialsConfig) lookup() (string, string, error) {
+	if c.UsernameEnv != "" {
+		username, ok := os.LookupEnv(c.UsernameEnv)
+		if !ok {
+			return "", "", errors.Errorf("credentials environment variable %s is not set", c.UsernameEnv)
+		}
+		var password string
+		if c.PasswordEnv != "" {
+			if password, ok = os.LookupEnv(c.PasswordEnv); !ok {
+				return "", "", errors.Errorf("credentials environment variable %s is not set", c.PasswordEnv)
+			}
+		}
+		return username, password, nil
+	}
+
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return "", "", errors.Wrap(err, "reading credentials file")
+	}
+	var entries map[string]credentialsEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return "", "", errors.Wrapf(err, "parsing credentials file %s", c.File)
+	}
+	for key, entry := range entries {
+		if normalizeDeviceKey(key) == normalizeDeviceKey(c.Key) {
+			return entry.Username, entry.Password, nil
+		}
+	}
+	return "", "", errors.Errorf("credentials file %s has no entry for key %s", c.File, c.Key)
+}
+
+// normalizeDeviceKey makes MAC addresses and serial numbers match regardless of case & separators.
+func normalizeDeviceKey(key string) string {
+	return strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(strings.TrimSpace(key)))
+}
+
+// envReference matches the ${NAME} references to environment variables which rtsp_address, fallback_addresses,
+// username & password may contain. Bare $NAME references are not expanded, as passwords may contain $.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv returns a copy of conf whose addresses & credentials have their environment variable references
+// replaced with the values of the variables. Values in the userinfo of addresses are escaped, so that credentials
+// containing e.g. @, / or # don't change the address, & other values are substituted as is.
+func (conf *Config) expandEnv() (*Config, error) {
+	var missing []string
+	expand := func(s string, escapeBefore int) string {
+		var b strings.Builder
+		last := 0
+		for _, match := range envReference.FindAllStringSubmatchIndex(s, -1) {
+			name := s[match[2]:match[3]]
+			value, ok := os.LookupEnv(name)
+			if !ok && !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+			if match[0] < escapeBefore {
+				value = url.User(value).String()
+			}
+			b.WriteString(s[last:match[0]])
+			b.WriteString(value)
+			last = match[1]
+		}
+		b.WriteString(s[last:])
+		return b.String()
+	}
+	resolved := *conf
+	resolved.Address = expand(conf.Address, userinfoEnd(conf.Address))
+	resolved.FallbackAddresses = nil
+	for _, address := range conf.FallbackAddresses {
+		resolved.FallbackAddresses = append(resolved.FallbackAddresses, expand(address, userinfoEnd(address)))
+	}
+	resolved.Username = expand(conf.Username, 0)
+	resolved.Password = expand(conf.Password, 0)
+	if len(missing) > 0 {
+		return nil, errors.Errorf("environment variables referenced by the config are not set: %s", strings.Join(missing, ", "))
+	}
+	return &resolved, nil
+}
+
+// userinfoEnd returns the index of the @ which ends the userinfo of address, or 0 if address has no userinfo.
+func userinfoEnd(address string) int {
+	start := strings.Index(address, "://")
+	if start < 0 {
+		return 0
+	}
+	start += len("://")
+	authority := address[start:]
+	if end := strings.IndexAny(authority, "/?#"); end >= 0 {
+		authority = authority[:end]
+	}
+	at := strings.LastIndex(authority, "@")
+	if at < 0 {
+		return 0
+	}
+	return start + at
+}
@@ -0,0 +1,89 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"image"
+
+	"go.viam.com/rdk/rimage"
+)
+
+// CropConfig is the region of interest of decoded frames, in pixels of the served frame, i.e. after decode_scale,
+// flip & rotate_degrees.
+type CropConfig struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Validate checks that the crop config is a non empty region.
+func (c *CropConfig) Validate(path string) error {
+	if c.X < 0 || c.Y < 0 || c.Width <= 0 || c.Height <= 0 {
+		return fmt.Errorf("invalid crop for component at path '%s': x & y must not be negative & width & height must be positive", path)
+	}
+	return nil
+}
+
+func (c *CropConfig) rect() image.Rectangle {
+	if c == nil {
+		return image.Rectangle{}
+	}
+	return image.Rect(c.X, c.Y, c.X+c.Width, c.Y+c.Height)
+}
+
+// cropImage returns the region r of img, with its origin at 0, 0. The region is clipped to the bounds of img, &
+// img is returned as is if they don't overlap. Decoded color & grayscale frames share the memory of img, like
+// uncropped frames do, while depth maps are copied. YUV 4:2:0 regions start at even coordinates, which are
+// rounded down, so that their chroma stays aligned.
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	r = r.Add(img.Bounds().Min).Intersect(img.Bounds())
+	if r.Empty() || r == img.Bounds() {
+		return img
+	}
+	switch img := img.(type) {
+	case *image.YCbCr:
+		if img.SubsampleRatio == image.YCbCrSubsampleRatio420 {
+			r.Min.X -= (r.Min.X - img.Rect.Min.X) % 2
+			r.Min.Y -= (r.Min.Y - img.Rect.Min.Y) % 2
+		}
+		return &image.YCbCr{
+			Y:              img.Y[img.YOffset(r.Min.X, r.Min.Y):],
+			Cb:             img.Cb[img.COffset(r.Min.X, r.Min.Y):],
+			Cr:             img.Cr[img.COffset(r.Min.X, r.Min.Y):],
+			YStride:        img.YStride,
+			CStride:        img.CStride,
+			SubsampleRatio: img.SubsampleRatio,
+			Rect:           image.Rect(0, 0, r.Dx(), r.Dy()),
+		}
+	case *image.RGBA:
+		return &image.RGBA{Pix: img.Pix[img.PixOffset(r.Min.X, r.Min.Y):], Stride: img.Stride, Rect: image.Rect(0, 0, r.Dx(), r.Dy())}
+	case *image.Gray:
+		return &image.Gray{Pix: img.Pix[img.PixOffset(r.Min.X, r.Min.Y):], Stride: img.Stride, Rect: image.Rect(0, 0, r.Dx(), r.Dy())}
+	case *rimage.DepthMap:
+		dm := rimage.NewEmptyDepthMap(r.Dx(), r.Dy())
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				dm.Set(x, y, img.GetDepth(r.Min.X+x, r.Min.Y+y))
+			}
+		}
+		return dm
+	default:
+		return img
+	}
+}
+
+// cropFrame crops decoded frames to the configured crop, warning once for each frame size the crop doesn't fit in.
+func (rc *rtspCamera) cropFrame(img image.Image) image.Image {
+	if rc.crop.Empty() {
+		return img
+	}
+	size := img.Bounds().Size()
+	if !rc.crop.In(image.Rectangle{Max: size}) {
+		key := int64(size.X)<<32 | int64(size.Y)
+		if rc.cropWarnedSize.Swap(key) != key {
+			rc.logger.Warnf("crop %v doesn't fit in the %dx%d frames of the stream, serving the part of it inside the frames, "+
+				"or the whole frames if there's none", rc.crop, size.X, size.Y)
+		}
+	}
+	return cropImage(img, rc.crop)
+}
@@ -0,0 +1,73 @@
+package viamrtsp
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/rimage"
+	"go.viam.com/test"
+)
+
+func TestCropConfig(t *testing.T) {
+	test.That(t, (&CropConfig{X: 10, Y: 20, Width: 640, Height: 480}).Validate("path"), test.ShouldBeNil)
+	test.That(t, (&CropConfig{X: 10, Y: 20, Width: 640, Height: 480}).rect(), test.ShouldResemble, image.Rect(10, 20, 650, 500))
+	test.That(t, (*CropConfig)(nil).rect().Empty(), test.ShouldBeTrue)
+	err := (&CropConfig{X: -1, Width: 640, Height: 480}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "invalid crop for component at path 'path'")
+	test.That(t, (&CropConfig{Width: 640}).Validate("path"), test.ShouldNotBeNil)
+}
+
+func TestCropImage(t *testing.T) {
+	r := image.Rect(3, 2, 7, 6)
+
+	yuv := image.NewYCbCr(image.Rect(0, 0, 8, 8), image.YCbCrSubsampleRatio420)
+	for i := range yuv.Y {
+		yuv.Y[i] = uint8(i)
+	}
+	for i := range yuv.Cb {
+		yuv.Cb[i] = uint8(i)
+	}
+	cropped := cropImage(yuv, r)
+	// the region starts at even coordinates, so that the chroma stays aligned
+	test.That(t, cropped.Bounds(), test.ShouldResemble, image.Rect(0, 0, 5, 4))
+	for _, p := range []image.Point{{0, 0}, {1, 1}, {4, 3}} {
+		test.That(t, cropped.At(p.X, p.Y), test.ShouldResemble, yuv.At(p.X+2, p.Y+2))
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	rgba.Set(3, 2, color.RGBA{R: 255, A: 255})
+	cropped = cropImage(rgba, r)
+	test.That(t, cropped.Bounds(), test.ShouldResemble, image.Rect(0, 0, 4, 4))
+	test.That(t, cropped.At(0, 0), test.ShouldResemble, color.RGBA{R: 255, A: 255})
+	test.That(t, cropped.At(1, 0), test.ShouldResemble, color.RGBA{})
+
+	gray := image.NewGray(image.Rect(0, 0, 8, 8))
+	gray.SetGray(6, 5, color.Gray{Y: 200})
+	cropped = cropImage(gray, r)
+	test.That(t, cropped.At(3, 3), test.ShouldResemble, color.Gray{Y: 200})
+
+	dm := rimage.NewEmptyDepthMap(8, 8)
+	dm.Set(4, 3, 1234)
+	cropped = cropImage(dm, r)
+	test.That(t, cropped.Bounds(), test.ShouldResemble, image.Rect(0, 0, 4, 4))
+	test.That(t, cropped.(*rimage.DepthMap).GetDepth(1, 1), test.ShouldEqual, rimage.Depth(1234))
+
+	// regions are clipped to the frame
+	test.That(t, cropImage(rgba, image.Rect(6, 6, 20, 20)).Bounds(), test.ShouldResemble, image.Rect(0, 0, 2, 2))
+	test.That(t, cropImage(rgba, image.Rect(10, 10, 20, 20)), test.ShouldEqual, rgba)
+}
+
+func TestCropFrame(t *testing.T) {
+	logger, logs := logging.NewObservedTestLogger(t)
+	rc := &rtspCamera{logger: logger}
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	test.That(t, rc.cropFrame(img), test.ShouldEqual, img)
+
+	rc.crop = image.Rect(4, 4, 12, 12)
+	test.That(t, rc.cropFrame(img).Bounds(), test.ShouldResemble, image.Rect(0, 0, 4, 4))
+	rc.cropFrame(img)
+	test.That(t, logs.FilterMessageSnippet("doesn't fit in the 8x8 frames").Len(), test.ShouldEqual, 1)
+}
@@ -0,0 +1,161 @@
+package viamrtsp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+// The types of errors counted by the decodeErrorLog.
+const (
+	// decodeErrorRTP are errors gortsplib reports about received packets, e.g. malformed RTP or RTCP packets.
+	decodeErrorRTP = "rtp"
+	// decodeErrorH264RTP, decodeErrorH265RTP, decodeErrorAV1RTP, decodeErrorVP8RTP, decodeErrorVP9RTP &
+	// decodeErrorMPEG4RTP are errors reassembling access units from RTP packets.
+	decodeErrorH264RTP  = "h264_rtp"
+	decodeErrorH265RTP  = "h265_rtp"
+	decodeErrorAV1RTP   = "av1_rtp"
+	decodeErrorVP8RTP   = "vp8_rtp"
+	decodeErrorVP9RTP   = "vp9_rtp"
+	decodeErrorMPEG4RTP = "mpeg4_rtp"
+	// decodeErrorH264, decodeErrorH265, decodeErrorMJPEG, decodeErrorAV1, decodeErrorVP8, decodeErrorVP9 &
+	// decodeErrorMPEG4 are errors decoding frames.
+	decodeErrorH264  = "h264"
+	decodeErrorH265  = "h265"
+	decodeErrorMJPEG = "mjpeg"
+	decodeErrorAV1   = "av1"
+	decodeErrorVP8   = "vp8"
+	decodeErrorVP9   = "vp9"
+	decodeErrorMPEG4 = "mpeg4"
+	// decodeErrorTranscode are errors encoding the frames of transcoded streams.
+	decodeErrorTranscode = "transcode"
+)
+
+const (
+	// decodeErrorWindow is the period decode errors are counted & summarized over.
+	decodeErrorWindow = time.Minute
+	// decodeErrorFlushInterval is how often the summary of a complete window is logged if no errors occur after it.
+	decodeErrorFlushInterval = 10 * time.Second
+)
+
+// decodeErrorCounts describes the errors of a type.
+type decodeErrorCounts struct {
+	total     uint64
+	lastError string
+	lastSeen  time.Time
+}
+
+// decodeErrorLog counts decode errors by type in one minute windows. Rather than logging every error,
+// which floods the logs while packets are lost, the first error of each type in a window is logged at
+// debug level & a summary of each window with errors is logged once it is complete.
+type decodeErrorLog struct {
+	logger logging.Logger
+
+	mu     sync.Mutex
+	window int64
+	// current counts the errors of each type in the current window, previous those in the last complete one
+	current  map[string]uint64
+	previous map[string]uint64
+	counts   map[string]*decodeErrorCounts
+}
+
+// record counts err, an error of type typ which occurred at now.
+func (l *decodeErrorLog) record(typ string, err error, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(now)
+	if l.current == nil {
+		l.current = map[string]uint64{}
+	}
+	if l.counts == nil {
+		l.counts = map[string]*decodeErrorCounts{}
+	}
+	if l.current[typ] == 0 && l.logger != nil {
+		l.logger.Debugf("%s decode error, further errors are summarized every minute, err: %s", typ, err)
+	}
+	l.current[typ]++
+	counts, ok := l.counts[typ]
+	if !ok {
+		counts = &decodeErrorCounts{}
+		l.counts[typ] = counts
+	}
+	counts.total++
+	counts.lastError = err.Error()
+	counts.lastSeen = now
+}
+
+// roll starts the window of now, logging the summary of the window which ended. l.mu must be held.
+func (l *decodeErrorLog) roll(now time.Time) {
+	window := now.UnixNano() / int64(decodeErrorWindow)
+	if window == l.window {
+		return
+	}
+	if len(l.current) > 0 && l.logger != nil {
+		l.logger.Warnf("decode errors in the last minute: %s", l.summarize(l.current))
+	}
+	if window == l.window+1 {
+		l.previous, l.current = l.current, nil
+	} else {
+		l.previous, l.current = nil, nil
+	}
+	l.window = window
+}
+
+// summarize formats the errors of each type in window along with the last error of the type.
+func (l *decodeErrorLog) summarize(window map[string]uint64) string {
+	parts := make([]string, 0, len(window))
+	for _, typ := range sortedKeys(window) {
+		parts = append(parts, fmt.Sprintf("%s: %d (last err: %s)", typ, window[typ], l.counts[typ].lastError))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// snapshot returns the counts of every type of error which occurred since the camera started, at now.
+func (l *decodeErrorLog) snapshot(now time.Time) map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(now)
+	types := make([]interface{}, 0, len(l.counts))
+	for _, typ := range sortedKeys(l.counts) {
+		counts := l.counts[typ]
+		types = append(types, map[string]interface{}{
+			"type":           typ,
+			"current_minute": l.current[typ],
+			"last_minute":    l.previous[typ],
+			"total":          counts.total,
+			"last_error":     counts.lastError,
+			"last_seen":      counts.lastSeen.Format(time.RFC3339Nano),
+		})
+	}
+	return map[string]interface{}{"errors": types}
+}
+
+// run logs the summary of each window soon after it is complete, even if no more errors occur, until ctx is done.
+func (l *decodeErrorLog) run(ctx context.Context) {
+	ticker := time.NewTicker(decodeErrorFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			l.roll(now)
+			l.mu.Unlock()
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
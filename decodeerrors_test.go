@@ -0,0 +1,64 @@
+package viamrtsp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestDecodeErrorLog(t *testing.T) {
+	logger, logs := logging.NewObservedTestLogger(t)
+	l := decodeErrorLog{logger: logger}
+	start := time.Unix(6000, 0)
+	for i := 0; i < 30; i++ {
+		l.record(decodeErrorH264, errors.New("invalid NAL"), start.Add(time.Duration(i)*time.Second))
+	}
+	l.record(decodeErrorH264RTP, errors.New("fragmented unit"), start.Add(30*time.Second))
+
+	// only the first error of each type is logged
+	test.That(t, logs.FilterMessageSnippet("decode error, further errors").Len(), test.ShouldEqual, 2)
+
+	snapshot := l.snapshot(start.Add(45 * time.Second))
+	types := snapshot["errors"].([]interface{})
+	test.That(t, types, test.ShouldHaveLength, 2)
+	h264 := types[0].(map[string]interface{})
+	test.That(t, h264["type"], test.ShouldEqual, decodeErrorH264)
+	test.That(t, h264["current_minute"], test.ShouldEqual, uint64(30))
+	test.That(t, h264["last_minute"], test.ShouldEqual, uint64(0))
+	test.That(t, h264["total"], test.ShouldEqual, uint64(30))
+	test.That(t, h264["last_error"], test.ShouldEqual, "invalid NAL")
+
+	// the summary is logged once the minute is complete
+	test.That(t, logs.FilterMessageSnippet("decode errors in the last minute").Len(), test.ShouldEqual, 0)
+	snapshot = l.snapshot(start.Add(70 * time.Second))
+	h264 = snapshot["errors"].([]interface{})[0].(map[string]interface{})
+	test.That(t, h264["current_minute"], test.ShouldEqual, uint64(0))
+	test.That(t, h264["last_minute"], test.ShouldEqual, uint64(30))
+	summaries := logs.FilterMessageSnippet("decode errors in the last minute").All()
+	test.That(t, summaries, test.ShouldHaveLength, 1)
+	test.That(t, summaries[0].Message, test.ShouldContainSubstring, "h264: 30 (last err: invalid NAL), h264_rtp: 1")
+
+	// errors are logged again in the next minute
+	l.record(decodeErrorH264, errors.New("invalid NAL"), start.Add(75*time.Second))
+	test.That(t, logs.FilterMessageSnippet("decode error, further errors").Len(), test.ShouldEqual, 3)
+
+	// the counts of a minute without errors are reset
+	snapshot = l.snapshot(start.Add(5 * time.Minute))
+	h264 = snapshot["errors"].([]interface{})[0].(map[string]interface{})
+	test.That(t, h264["last_minute"], test.ShouldEqual, uint64(0))
+	test.That(t, h264["total"], test.ShouldEqual, uint64(31))
+}
+
+func TestGetDecodeErrorsCommand(t *testing.T) {
+	rc := &rtspCamera{}
+	rc.decodeErrors.record(decodeErrorMJPEG, errors.New("invalid JPEG"), time.Now())
+	resp, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": getDecodeErrorsCommand})
+	test.That(t, err, test.ShouldBeNil)
+	types := resp["errors"].([]interface{})
+	test.That(t, types, test.ShouldHaveLength, 1)
+	test.That(t, types[0].(map[string]interface{})["type"], test.ShouldEqual, decodeErrorMJPEG)
+}
@@ -5,30 +5,78 @@ package viamrtsp
 #include <libavcodec/avcodec.h>
 #include <libavutil/imgutils.h>
 #include <libavutil/error.h>
+#include <libavutil/hwcontext.h>
 #include <libswscale/swscale.h>
 #include <stdlib.h>
 */
 import "C"
 
 import (
+	"encoding/binary"
 	"fmt"
 	"image"
+	"math"
+	"time"
 	"unsafe"
 
 	"github.com/pkg/errors"
 	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/rimage"
 )
 
 // decoder is a generic FFmpeg decoder.
 type decoder struct {
-	logger      logging.Logger
-	codecCtx    *C.AVCodecContext
-	srcFrame    *C.AVFrame
-	swsCtx      *C.struct_SwsContext
-	dstFrame    *C.AVFrame
-	dstFramePtr []uint8
+	logger   logging.Logger
+	codec    *C.AVCodec
+	codecCtx *C.AVCodecContext
+	srcFrame *C.AVFrame
+	// hwDeviceCtx is set when decoding with a hwaccel device, in which case decoded
+	// frames are copied from the device into hwTransferFrame before being converted.
+	hwDeviceCtx     *C.AVBufferRef
+	hwTransferFrame *C.AVFrame
+	swsCtx          *C.struct_SwsContext
+	swsSrcFormat    C.int
+	swsSrcWidth     C.int
+	swsSrcHeight    C.int
+	dstFrame        *C.AVFrame
+	dstFramePtr     []uint8
+	// yuv is reused for every planar YUV 4:2:0 frame, which is the output format of the software
+	// decoders, so that those frames are copied rather than converted to RGBA
+	yuv *image.YCbCr
+	// depth makes decode return the luma of each frame as a 16 bit depth map rather than an RGBA image
+	depth bool
+	// gray makes decode return the luma of each frame as an 8 bit grayscale image, skipping chroma entirely.
+	// grayImg is reused for every frame whose luma plane can be copied.
+	gray    bool
+	grayImg *image.Gray
+	// scale downscales frames while they're converted, which skips the copies of unscaled frames
+	scale decodeScale
+	// orientation flips & rotates frames while they're copied. Converted frames are copied into oriented.
+	orientation orientation
+	oriented    []uint8
+	// profile, if set, times decoding & converting frames
+	profile *stageProfiler
+	// throttle, if set, skips converting the decoded frames over max_decode_fps
+	throttle *decodeThrottle
+	// deinterlace blends the fields of interlaced frames, which are copied into deinterlaced as the decoder
+	// may still reference them. blendRows is reused for the lines being blended.
+	deinterlace  bool
+	deinterlaced *C.AVFrame
+	blendRows    []uint8
+	// encoder, if set, re-encodes the decoded frames for transcode, it is closed with the decoder
+	encoder *h264Encoder
 }
 
+// avNoPTSValue is AV_NOPTS_VALUE, which cgo can't translate, the timestamp of packets & frames without one.
+const avNoPTSValue = math.MinInt64
+
+// hardwareDecoders are the supported values of the hardware_decode config attribute.
+// v4l2m2m is a separate FFmpeg decoder, the others are hwaccel device types used by the native decoders.
+var hardwareDecoders = []string{"vaapi", "cuda", "videotoolbox", "v4l2m2m"}
+
+// gray8OutputFormat is the output_format which makes H264 & H265 frames decode to 8 bit grayscale images.
+const gray8OutputFormat = "gray8"
+
 type videoCodec int
 
 const (
@@ -42,6 +90,14 @@ const (
 	H265
 	// MJPEG indicates the mjpeg video codec
 	MJPEG
+	// AV1 indicates the av1 video codec
+	AV1
+	// VP8 indicates the vp8 video codec
+	VP8
+	// VP9 indicates the vp9 video codec
+	VP9
+	// MPEG4 indicates the mpeg4 part 2 video codec
+	MPEG4
 )
 
 func (vc videoCodec) String() string {
@@ -54,6 +110,14 @@ func (vc videoCodec) String() string {
 		return "H265"
 	case MJPEG:
 		return "MJPEG"
+	case AV1:
+		return "AV1"
+	case VP8:
+		return "VP8"
+	case VP9:
+		return "VP9"
+	case MPEG4:
+		return "MPEG4"
 	default:
 		return "Unknown"
 	}
@@ -82,45 +146,172 @@ func SetLibAVLogLevelFatal() {
 	C.av_log_set_level(C.AV_LOG_FATAL)
 }
 
-// newDecoder creates a new decoder for the given codec.
-func newDecoder(codecID C.enum_AVCodecID, logger logging.Logger) (*decoder, error) {
+// newDecoder creates a new decoder for the given codec. If hardwareDecode is not empty the
+// decoder uses that hardware backend, falling back to software decoding if it can not be set up.
+func newDecoder(codecID C.enum_AVCodecID, hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	if hardwareDecode != "" {
+		d, err := newHardwareDecoder(codecID, hardwareDecode, logger)
+		if err == nil {
+			logger.Infof("using %s hardware decoding", hardwareDecode)
+			return d, nil
+		}
+		logger.Warnf("unable to set up %s hardware decoding, falling back to software decoding: %s", hardwareDecode, err)
+	}
+
 	codec := C.avcodec_find_decoder(codecID)
 	if codec == nil {
 		return nil, errors.New("avcodec_find_decoder() failed")
 	}
+	return openDecoder(codec, nil, logger)
+}
 
-	codecCtx := C.avcodec_alloc_context3(codec)
-	if codecCtx == nil {
-		return nil, errors.New("avcodec_alloc_context3() failed")
+// newHardwareDecoder creates a decoder for the given codec which uses the hardwareDecode backend.
+func newHardwareDecoder(codecID C.enum_AVCodecID, hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	if hardwareDecode == "v4l2m2m" {
+		name := C.CString(C.GoString(C.avcodec_get_name(codecID)) + "_v4l2m2m")
+		defer C.free(unsafe.Pointer(name))
+		codec := C.avcodec_find_decoder_by_name(name)
+		if codec == nil {
+			return nil, errors.Errorf("decoder %s not found", C.GoString(name))
+		}
+		return openDecoder(codec, nil, logger)
 	}
 
-	res := C.avcodec_open2(codecCtx, codec, nil)
-	if res < 0 {
-		C.avcodec_close(codecCtx)
-		return nil, errors.New("avcodec_open2() failed")
+	deviceName := C.CString(hardwareDecode)
+	defer C.free(unsafe.Pointer(deviceName))
+	deviceType := C.av_hwdevice_find_type_by_name(deviceName)
+	if deviceType == C.AV_HWDEVICE_TYPE_NONE {
+		return nil, errors.Errorf("hwaccel device type %s not supported by this FFmpeg build", hardwareDecode)
+	}
+	var hwDeviceCtx *C.AVBufferRef
+	if res := C.av_hwdevice_ctx_create(&hwDeviceCtx, deviceType, nil, nil, 0); res < 0 {
+		return nil, errors.Errorf("av_hwdevice_ctx_create() failed: %s", avError(res))
+	}
+
+	codec := C.avcodec_find_decoder(codecID)
+	if codec == nil {
+		C.av_buffer_unref(&hwDeviceCtx)
+		return nil, errors.New("avcodec_find_decoder() failed")
+	}
+	return openDecoder(codec, hwDeviceCtx, logger)
+}
+
+// openDecoder opens codec, decoding on hwDeviceCtx if it is not nil.
+// openDecoder takes ownership of hwDeviceCtx.
+func openDecoder(codec *C.AVCodec, hwDeviceCtx *C.AVBufferRef, logger logging.Logger) (*decoder, error) {
+	d := &decoder{logger: logger, codec: codec, hwDeviceCtx: hwDeviceCtx}
+
+	if hwDeviceCtx != nil {
+		d.hwTransferFrame = C.av_frame_alloc()
+		if d.hwTransferFrame == nil {
+			d.close()
+			return nil, errors.New("av_frame_alloc() failed")
+		}
+	}
+
+	if err := d.openCodecContext(); err != nil {
+		d.close()
+		return nil, err
 	}
 
-	srcFrame := C.av_frame_alloc()
-	if srcFrame == nil {
-		C.avcodec_close(codecCtx)
+	d.srcFrame = C.av_frame_alloc()
+	if d.srcFrame == nil {
+		d.close()
 		return nil, errors.New("av_frame_alloc() failed")
 	}
 
-	return &decoder{
-		logger:   logger,
-		codecCtx: codecCtx,
-		srcFrame: srcFrame,
-	}, nil
+	return d, nil
+}
+
+// openCodecContext allocates & opens the codec context of d.
+func (d *decoder) openCodecContext() error {
+	d.codecCtx = C.avcodec_alloc_context3(d.codec)
+	if d.codecCtx == nil {
+		return errors.New("avcodec_alloc_context3() failed")
+	}
+	if d.hwDeviceCtx != nil {
+		d.codecCtx.hw_device_ctx = C.av_buffer_ref(d.hwDeviceCtx)
+	}
+	if res := C.avcodec_open2(d.codecCtx, d.codec, nil); res < 0 {
+		C.avcodec_free_context(&d.codecCtx)
+		return errors.New("avcodec_open2() failed")
+	}
+	return nil
+}
+
+// reset reopens the codec context, discarding the state of the stream's previous parameter sets, e.g. after
+// its resolution changed. The conversion buffers are released & reallocated for the next frame.
+// If reset fails, decode returns errors until the decoder is replaced.
+func (d *decoder) reset() error {
+	if d.codecCtx != nil {
+		C.avcodec_free_context(&d.codecCtx)
+	}
+	if d.dstFrame != nil {
+		C.av_frame_free(&d.dstFrame)
+	}
+	if d.swsCtx != nil {
+		C.sws_freeContext(d.swsCtx)
+		d.swsCtx = nil
+	}
+	d.dstFramePtr = nil
+	d.yuv = nil
+	d.grayImg = nil
+	d.oriented = nil
+	return d.openCodecContext()
 }
 
 // newH264Decoder creates a new H264 decoder.
-func newH264Decoder(logger logging.Logger) (*decoder, error) {
-	return newDecoder(C.AV_CODEC_ID_H264, logger)
+func newH264Decoder(hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	return newDecoder(C.AV_CODEC_ID_H264, hardwareDecode, logger)
 }
 
 // newH265Decoder creates a new H265 decoder.
-func newH265Decoder(logger logging.Logger) (*decoder, error) {
-	return newDecoder(C.AV_CODEC_ID_H265, logger)
+func newH265Decoder(hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	return newDecoder(C.AV_CODEC_ID_H265, hardwareDecode, logger)
+}
+
+// newAV1Decoder creates a new AV1 decoder. AV1 is decoded in software by libdav1d, as the native AV1 decoder
+// of FFmpeg only decodes with hwaccels.
+func newAV1Decoder(hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	if hardwareDecode != "" {
+		logger.Warnf("hardware_decode %s is not supported for AV1, decoding in software", hardwareDecode)
+	}
+	name := C.CString("libdav1d")
+	defer C.free(unsafe.Pointer(name))
+	codec := C.avcodec_find_decoder_by_name(name)
+	if codec == nil {
+		return nil, errors.New("decoder libdav1d not found, FFmpeg must be built with --enable-libdav1d to decode AV1")
+	}
+	return openDecoder(codec, nil, logger)
+}
+
+// newVP8Decoder creates a new VP8 decoder, which decodes in software.
+func newVP8Decoder(hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	return newSoftwareDecoder(C.AV_CODEC_ID_VP8, hardwareDecode, logger)
+}
+
+// newVP9Decoder creates a new VP9 decoder, which decodes in software.
+func newVP9Decoder(hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	return newSoftwareDecoder(C.AV_CODEC_ID_VP9, hardwareDecode, logger)
+}
+
+// newMPEG4Decoder creates a new MPEG-4 Part 2 decoder, which decodes in software.
+func newMPEG4Decoder(hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	return newSoftwareDecoder(C.AV_CODEC_ID_MPEG4, hardwareDecode, logger)
+}
+
+// newSoftwareDecoder creates a decoder for a codec the FFmpeg build has no hardware acceleration for,
+// warning that hardwareDecode is not used.
+func newSoftwareDecoder(codecID C.enum_AVCodecID, hardwareDecode string, logger logging.Logger) (*decoder, error) {
+	name := C.GoString(C.avcodec_get_name(codecID))
+	if hardwareDecode != "" {
+		logger.Warnf("hardware_decode %s is not supported for %s, decoding in software", hardwareDecode, name)
+	}
+	codec := C.avcodec_find_decoder(codecID)
+	if codec == nil {
+		return nil, errors.Errorf("decoder %s not found in this FFmpeg build", name)
+	}
+	return openDecoder(codec, nil, logger)
 }
 
 // close closes the decoder.
@@ -133,31 +324,146 @@ func (d *decoder) close() {
 		C.sws_freeContext(d.swsCtx)
 	}
 
-	C.av_frame_free(&d.srcFrame)
-	C.avcodec_close(d.codecCtx)
+	if d.srcFrame != nil {
+		C.av_frame_free(&d.srcFrame)
+	}
+
+	if d.hwTransferFrame != nil {
+		C.av_frame_free(&d.hwTransferFrame)
+	}
+
+	if d.deinterlaced != nil {
+		C.av_frame_free(&d.deinterlaced)
+	}
+
+	if d.encoder != nil {
+		d.encoder.close()
+	}
+
+	if d.codecCtx != nil {
+		C.avcodec_free_context(&d.codecCtx)
+	}
+
+	if d.hwDeviceCtx != nil {
+		C.av_buffer_unref(&d.hwDeviceCtx)
+	}
+}
+
+// decode decodes nalu, whose presentation timestamp is pts, returning the decoded frame, if any, & its pts.
+// Streams with B-frames are decoded in decoding order, so the decoder delays frames to output them in
+// presentation order, in which case the returned frame is an earlier one than nalu's & pts identifies it.
+func (d *decoder) decode(nalu []byte, pts int64) (image.Image, int64, error) {
+	return d.decodePacket(append(H2645StartCode(), nalu...), pts)
 }
 
-func (d *decoder) decode(nalu []byte) (image.Image, error) {
-	nalu = append(H2645StartCode(), nalu...)
+// decodePacket decodes data, a packet in the bitstream format of the codec, e.g. an Annex-B NALU or an AV1
+// temporal unit of OBUs with size fields, like decode.
+func (d *decoder) decodePacket(data []byte, pts int64) (image.Image, int64, error) {
+	if d.codecCtx == nil {
+		return nil, 0, errors.New("decoder could not be reinitialized")
+	}
+	framePTS, ok := d.receive(data, pts)
+	if !ok || !d.throttle.shouldConvert(time.Now()) {
+		return nil, 0, nil
+	}
+	start := d.profile.start()
+	img, err := d.convert()
+	d.profile.record(profileConvert, start)
+	if err != nil {
+		return nil, 0, err
+	}
+	return img, framePTS, nil
+}
+
+// decodeFrame decodes data like decodePacket, but returns the decoded frame in system memory rather than
+// converting it, or nil if the decoder needs more data. The frame is only valid until the next call.
+func (d *decoder) decodeFrame(data []byte, pts int64) (*C.AVFrame, int64, error) {
+	if d.codecCtx == nil {
+		return nil, 0, errors.New("decoder could not be reinitialized")
+	}
+	framePTS, ok := d.receive(data, pts)
+	if !ok {
+		return nil, 0, nil
+	}
+	frame, err := d.systemFrame()
+	if err != nil {
+		return nil, 0, err
+	}
+	return frame, framePTS, nil
+}
 
+// receive sends data to the decoder & receives the next decoded frame into srcFrame, returning its
+// timestamp, or false if the decoder needs more data.
+func (d *decoder) receive(data []byte, pts int64) (int64, bool) {
 	// send frame to decoder
 	var avPacket C.AVPacket
-	avPacket.data = (*C.uint8_t)(C.CBytes(nalu))
+	avPacket.data = (*C.uint8_t)(C.CBytes(data))
 	defer C.free(unsafe.Pointer(avPacket.data))
-	avPacket.size = C.int(len(nalu))
+	avPacket.size = C.int(len(data))
+	// the decoding timestamp is unknown, the decoder reorders frames by their picture order count
+	avPacket.pts = C.int64_t(pts)
+	avPacket.dts = C.int64_t(avNoPTSValue)
+	start := d.profile.start()
 	res := C.avcodec_send_packet(d.codecCtx, &avPacket)
 	if res < 0 {
-		return nil, nil
+		d.profile.record(profileDecode, start)
+		return 0, false
 	}
 
 	// receive frame if available
 	res = C.avcodec_receive_frame(d.codecCtx, d.srcFrame)
+	d.profile.record(profileDecode, start)
 	if res < 0 {
-		return nil, nil
+		return 0, false
+	}
+	framePTS := int64(d.srcFrame.pts)
+	if framePTS == avNoPTSValue {
+		framePTS = pts
+	}
+	return framePTS, true
+}
+
+// systemFrame returns srcFrame, the last decoded frame, copying hardware decoded frames back into system memory.
+func (d *decoder) systemFrame() (*C.AVFrame, error) {
+	if d.srcFrame.hw_frames_ctx == nil {
+		return d.srcFrame, nil
+	}
+	C.av_frame_unref(d.hwTransferFrame)
+	if res := C.av_hwframe_transfer_data(d.hwTransferFrame, d.srcFrame, 0); res < 0 {
+		return nil, errors.Errorf("av_hwframe_transfer_data() err: %s", avError(res))
+	}
+	return d.hwTransferFrame, nil
+}
+
+// convert returns the image of srcFrame, the last decoded frame.
+func (d *decoder) convert() (image.Image, error) {
+	var res C.int
+
+	frame, err := d.systemFrame()
+	if err != nil {
+		return nil, err
 	}
 
-	// if frame size has changed, allocate needed objects
-	if d.dstFrame == nil || d.dstFrame.width != d.srcFrame.width || d.dstFrame.height != d.srcFrame.height {
+	// frames transferred from hardware may not keep the flags of the decoded frame
+	if d.deinterlace && d.srcFrame.flags&C.AV_FRAME_FLAG_INTERLACED != 0 {
+		frame, err = d.deinterlaceFrame(frame)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dstWidth, dstHeight := d.scale.size(int(frame.width), int(frame.height))
+	scaled := dstWidth != int(frame.width) || dstHeight != int(frame.height)
+	if d.gray && !scaled && hasLumaPlane(frame) {
+		return d.grayImage(frame), nil
+	}
+	if !d.depth && !d.gray && !scaled && isYUV420P(frame) {
+		return d.yuvImage(frame), nil
+	}
+
+	// if frame size or format has changed, allocate needed objects
+	if d.dstFrame == nil || d.swsSrcWidth != frame.width || d.swsSrcHeight != frame.height || d.swsSrcFormat != frame.format ||
+		int(d.dstFrame.width) != dstWidth || int(d.dstFrame.height) != dstHeight {
 		if d.dstFrame != nil {
 			C.av_frame_free(&d.dstFrame)
 		}
@@ -168,16 +474,31 @@ func (d *decoder) decode(nalu []byte) (image.Image, error) {
 
 		d.dstFrame = C.av_frame_alloc()
 		d.dstFrame.format = C.AV_PIX_FMT_RGBA
-		d.dstFrame.width = d.srcFrame.width
-		d.dstFrame.height = d.srcFrame.height
+		if d.depth {
+			d.dstFrame.format = C.AV_PIX_FMT_GRAY16LE
+		} else if d.gray {
+			d.dstFrame.format = C.AV_PIX_FMT_GRAY8
+		}
+		d.dstFrame.width = C.int(dstWidth)
+		d.dstFrame.height = C.int(dstHeight)
 		d.dstFrame.color_range = C.AVCOL_RANGE_JPEG
 		res = C.av_frame_get_buffer(d.dstFrame, 1)
 		if res < 0 {
 			return nil, errors.New("av_frame_get_buffer() err")
 		}
 
-		d.swsCtx = C.sws_getContext(d.srcFrame.width, d.srcFrame.height, C.AV_PIX_FMT_YUV420P,
-			d.dstFrame.width, d.dstFrame.height, (int32)(d.dstFrame.format), C.SWS_BILINEAR, nil, nil, nil)
+		// downscaled frames favor speed, & depth maps are never interpolated across the edges of objects
+		flags := C.int(C.SWS_BILINEAR)
+		if scaled && d.depth {
+			flags = C.SWS_POINT
+		} else if scaled {
+			flags = C.SWS_FAST_BILINEAR
+		}
+
+		// hardware decoders output frames in formats such as NV12 rather than YUV420P
+		d.swsSrcFormat, d.swsSrcWidth, d.swsSrcHeight = frame.format, frame.width, frame.height
+		d.swsCtx = C.sws_getContext(frame.width, frame.height, (int32)(frame.format),
+			d.dstFrame.width, d.dstFrame.height, (int32)(d.dstFrame.format), flags, nil, nil, nil)
 		if d.swsCtx == nil {
 			return nil, errors.New("sws_getContext() err")
 		}
@@ -186,19 +507,215 @@ func (d *decoder) decode(nalu []byte) (image.Image, error) {
 		d.dstFramePtr = (*[1 << 30]uint8)(unsafe.Pointer(d.dstFrame.data[0]))[:dstFrameSize:dstFrameSize]
 	}
 
-	// convert frame from YUV420 to RGB
-	res = C.sws_scale(d.swsCtx, frameData(d.srcFrame), frameLineSize(d.srcFrame),
-		0, d.srcFrame.height, frameData(d.dstFrame), frameLineSize(d.dstFrame))
+	// convert frame from YUV to RGB
+	res = C.sws_scale(d.swsCtx, frameData(frame), frameLineSize(frame),
+		0, frame.height, frameData(d.dstFrame), frameLineSize(d.dstFrame))
 	if res < 0 {
 		return nil, errors.New("sws_scale() err")
 	}
 
+	if d.depth {
+		return d.depthMap(), nil
+	}
+	if d.gray {
+		pix, width, height := d.orientPixels(1)
+		return &image.Gray{
+			Pix:    pix,
+			Stride: width,
+			Rect: image.Rectangle{
+				Max: image.Point{width, height},
+			},
+		}, nil
+	}
+
 	// embed frame into an image.Image
+	pix, width, height := d.orientPixels(4)
 	return &image.RGBA{
-		Pix:    d.dstFramePtr,
-		Stride: 4 * (int)(d.dstFrame.width),
+		Pix:    pix,
+		Stride: 4 * width,
 		Rect: image.Rectangle{
-			Max: image.Point{(int)(d.dstFrame.width), (int)(d.dstFrame.height)},
+			Max: image.Point{width, height},
 		},
 	}, nil
 }
+
+// orientPixels returns the pixels of the converted frame, which are bpp bytes, & its size, once it's oriented.
+func (d *decoder) orientPixels(bpp int) ([]uint8, int, int) {
+	width, height := int(d.dstFrame.width), int(d.dstFrame.height)
+	if d.orientation.identity() {
+		return d.dstFramePtr, width, height
+	}
+	orientedWidth, orientedHeight := d.orientation.size(width, height)
+	if len(d.oriented) != orientedWidth*orientedHeight*bpp {
+		d.oriented = make([]uint8, orientedWidth*orientedHeight*bpp)
+	}
+	d.orientation.transform(d.oriented, orientedWidth*bpp, d.dstFramePtr, int(d.dstFrame.linesize[0]), width, height, bpp, nil)
+	return d.oriented, orientedWidth, orientedHeight
+}
+
+// deinterlaceFrame returns a copy of frame, an interlaced frame, with its fields blended. Frames in formats
+// without an 8 bit luma plane are returned as they are.
+func (d *decoder) deinterlaceFrame(frame *C.AVFrame) (*C.AVFrame, error) {
+	if !hasLumaPlane(frame) {
+		return frame, nil
+	}
+	if d.deinterlaced == nil {
+		d.deinterlaced = C.av_frame_alloc()
+	}
+	dst := d.deinterlaced
+	if dst.format != frame.format || dst.width != frame.width || dst.height != frame.height {
+		C.av_frame_unref(dst)
+		dst.format, dst.width, dst.height = frame.format, frame.width, frame.height
+		if res := C.av_frame_get_buffer(dst, 0); res < 0 {
+			return nil, errors.Errorf("av_frame_get_buffer() err: %s", avError(res))
+		}
+	}
+	if res := C.av_frame_copy(dst, frame); res < 0 {
+		return nil, errors.Errorf("av_frame_copy() err: %s", avError(res))
+	}
+	if res := C.av_frame_copy_props(dst, frame); res < 0 {
+		return nil, errors.Errorf("av_frame_copy_props() err: %s", avError(res))
+	}
+	for plane, height := range planeHeights(dst) {
+		stride := int(dst.linesize[plane])
+		pix := unsafe.Slice((*uint8)(unsafe.Pointer(dst.data[plane])), stride*height)
+		d.blendRows = blendFields(pix, stride, height, d.blendRows)
+	}
+	return dst, nil
+}
+
+// planeHeights returns the number of lines of each plane of frame, whose format must have an 8 bit luma plane.
+func planeHeights(frame *C.AVFrame) []int {
+	height, chromaHeight := int(frame.height), (int(frame.height)+1)/2
+	switch frame.format {
+	case C.AV_PIX_FMT_NV12:
+		return []int{height, chromaHeight}
+	case C.AV_PIX_FMT_YUV422P, C.AV_PIX_FMT_YUVJ422P, C.AV_PIX_FMT_YUV444P, C.AV_PIX_FMT_YUVJ444P:
+		return []int{height, height, height}
+	default:
+		return []int{height, chromaHeight, chromaHeight}
+	}
+}
+
+// isYUV420P reports whether frame can be copied into an image.YCbCr without conversion.
+func isYUV420P(frame *C.AVFrame) bool {
+	if frame.format != C.AV_PIX_FMT_YUV420P && frame.format != C.AV_PIX_FMT_YUVJ420P {
+		return false
+	}
+	// flipped frames have negative line sizes
+	return frame.linesize[0] > 0 && frame.linesize[1] > 0 && frame.linesize[2] > 0
+}
+
+// yuvImage copies frame, a planar YUV 4:2:0 frame, into the decoder's reusable image, oriented. Limited range
+// frames are expanded to the full range image.YCbCr uses.
+func (d *decoder) yuvImage(frame *C.AVFrame) *image.YCbCr {
+	width, height := int(frame.width), int(frame.height)
+	orientedWidth, orientedHeight := d.orientation.size(width, height)
+	if d.yuv == nil || d.yuv.Rect.Dx() != orientedWidth || d.yuv.Rect.Dy() != orientedHeight {
+		d.yuv = image.NewYCbCr(image.Rect(0, 0, orientedWidth, orientedHeight), image.YCbCrSubsampleRatio420)
+	}
+	lumaLUT, chromaLUT := &limitedToFullLuma, &limitedToFullChroma
+	if isFullRange(frame) {
+		lumaLUT, chromaLUT = nil, nil
+	}
+	chromaWidth, chromaHeight := (width+1)/2, (height+1)/2
+	copyPlane(d.yuv.Y, d.yuv.YStride, frame, 0, width, height, lumaLUT, d.orientation)
+	copyPlane(d.yuv.Cb, d.yuv.CStride, frame, 1, chromaWidth, chromaHeight, chromaLUT, d.orientation)
+	copyPlane(d.yuv.Cr, d.yuv.CStride, frame, 2, chromaWidth, chromaHeight, chromaLUT, d.orientation)
+	return d.yuv
+}
+
+// hasLumaPlane reports whether the first plane of frame holds its 8 bit luma, which is the case for the
+// output formats of the software decoders & of most hardware decoders.
+func hasLumaPlane(frame *C.AVFrame) bool {
+	switch frame.format {
+	case C.AV_PIX_FMT_YUV420P, C.AV_PIX_FMT_YUVJ420P, C.AV_PIX_FMT_YUV422P, C.AV_PIX_FMT_YUVJ422P,
+		C.AV_PIX_FMT_YUV444P, C.AV_PIX_FMT_YUVJ444P, C.AV_PIX_FMT_NV12:
+		return frame.linesize[0] > 0
+	default:
+		return false
+	}
+}
+
+// isFullRange reports whether the samples of frame use the full 0-255 range rather than the limited range.
+func isFullRange(frame *C.AVFrame) bool {
+	switch frame.format {
+	case C.AV_PIX_FMT_YUVJ420P, C.AV_PIX_FMT_YUVJ422P, C.AV_PIX_FMT_YUVJ444P:
+		return true
+	default:
+		return frame.color_range == C.AVCOL_RANGE_JPEG
+	}
+}
+
+// grayImage copies the luma plane of frame into the decoder's reusable grayscale image, oriented, expanding
+// limited range samples to the full range.
+func (d *decoder) grayImage(frame *C.AVFrame) *image.Gray {
+	width, height := int(frame.width), int(frame.height)
+	orientedWidth, orientedHeight := d.orientation.size(width, height)
+	if d.grayImg == nil || d.grayImg.Rect.Dx() != orientedWidth || d.grayImg.Rect.Dy() != orientedHeight {
+		d.grayImg = image.NewGray(image.Rect(0, 0, orientedWidth, orientedHeight))
+	}
+	lut := &limitedToFullLuma
+	if isFullRange(frame) {
+		lut = nil
+	}
+	copyPlane(d.grayImg.Pix, d.grayImg.Stride, frame, 0, width, height, lut, d.orientation)
+	return d.grayImg
+}
+
+// copyPlane copies a plane of frame into dst, oriented by o, mapping each sample through lut if it is not nil.
+func copyPlane(dst []uint8, dstStride int, frame *C.AVFrame, plane, width, height int, lut *[256]uint8, o orientation) {
+	srcStride := int(frame.linesize[plane])
+	src := unsafe.Slice((*uint8)(unsafe.Pointer(frame.data[plane])), srcStride*height)
+	if !o.identity() {
+		o.transform(dst, dstStride, src, srcStride, width, height, 1, lut)
+		return
+	}
+	for y := 0; y < height; y++ {
+		row := dst[y*dstStride : y*dstStride+width]
+		copy(row, src[y*srcStride:y*srcStride+width])
+		if lut != nil {
+			for i, v := range row {
+				row[i] = lut[v]
+			}
+		}
+	}
+}
+
+// limitedToFullLuma & limitedToFullChroma expand limited range samples, where luma is 16-235
+// and chroma is 16-240, to the full 0-255 range.
+var limitedToFullLuma, limitedToFullChroma = rangeExpansionLUTs()
+
+func rangeExpansionLUTs() (luma, chroma [256]uint8) {
+	clamp := func(v int) uint8 {
+		return uint8(min(max(v, 0), 255))
+	}
+	for i := range luma {
+		luma[i] = clamp(roundDiv((i-16)*255, 219))
+		chroma[i] = clamp(128 + roundDiv((i-128)*255, 224))
+	}
+	return luma, chroma
+}
+
+// roundDiv divides a by b, which must be positive, rounding to the nearest integer.
+func roundDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b/2) / b)
+	}
+	return (a + b/2) / b
+}
+
+// depthMap copies the converted 16 bit little endian frame into a depth map, in millimeters, oriented.
+func (d *decoder) depthMap() *rimage.DepthMap {
+	width, height := int(d.dstFrame.width), int(d.dstFrame.height)
+	stride := int(d.dstFrame.linesize[0])
+	dm := rimage.NewEmptyDepthMap(d.orientation.size(width, height))
+	for y := 0; y < height; y++ {
+		row := d.dstFramePtr[y*stride:]
+		for x := 0; x < width; x++ {
+			ox, oy := d.orientation.point(x, y, width, height)
+			dm.Set(ox, oy, rimage.Depth(binary.LittleEndian.Uint16(row[x*2:])))
+		}
+	}
+	return dm
+}
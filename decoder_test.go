@@ -0,0 +1,258 @@
+package viamrtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/erh/viamrtsp/viamrtsptest"
+	"github.com/pion/rtp"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// h265BFrameAUs are the access units of a 64x64 H265 stream with B-frames in decoding order, along with their
+// presentation order. Each frame has a solid luma of 40+25*pts.
+var h265BFrameAUs = []struct {
+	pts int64
+	au  string
+}{
+	{0, "AAAAAUABDAH//wFgAAADAJAAAAMAAAMAHpEQCQAAAAFCAQEBYAAAAwCQAAADAAADAB6gIIEFlkREpMLgEAAAPoAAAnEAgAAAAAFEAcBxgRIA" +
+		"AAEoAawngOrdf/w75PWC5+A="},
+	{3, "AAAAAQIB0BleINSSi//GZP8Xkw=="},
+	{1, "AAAAAQAB4CS1ggnAGk/4pif9X8D+i4L+H/g="},
+	{2, "AAAAAQAB4ESXgglAGkZYDCSNKL8WbQB99/g="},
+	{6, "AAAAAQIB0DHd4g3AkouX9EiT/F5M"},
+	{4, "AAAAAQAB4Ia3WCCcGk/4pif9X8D+i4L+H/g="},
+	{5, "AAAAAQAB4KaV+CCUGkZYDCSNKL8WbQB99/g="},
+}
+
+func TestDecodeReordersBFrames(t *testing.T) {
+	SetLibAVLogLevelFatal()
+	d, err := newH265Decoder("", logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	defer d.close()
+
+	var decoded []int64
+	for _, f := range h265BFrameAUs {
+		b, err := base64.StdEncoding.DecodeString(f.au)
+		test.That(t, err, test.ShouldBeNil)
+		au, err := h264.AnnexBUnmarshal(b)
+		test.That(t, err, test.ShouldBeNil)
+		for _, nalu := range au {
+			img, pts, err := d.decode(nalu, f.pts)
+			test.That(t, err, test.ShouldBeNil)
+			if img == nil {
+				continue
+			}
+			// frames are output in presentation order, tagged with the pts of their access unit
+			yuv, ok := img.(*image.YCbCr)
+			test.That(t, ok, test.ShouldBeTrue)
+			want := int(limitedToFullLuma[40+25*pts])
+			test.That(t, int(yuv.Y[0]), test.ShouldAlmostEqual, want, 2)
+			decoded = append(decoded, pts)
+		}
+	}
+	// the last frames stay in the decoder until they are reordered
+	test.That(t, len(decoded), test.ShouldBeGreaterThanOrEqualTo, 4)
+	for i, pts := range decoded {
+		test.That(t, pts, test.ShouldEqual, int64(i))
+	}
+}
+
+func TestDecodeGray(t *testing.T) {
+	SetLibAVLogLevelFatal()
+	d, err := newH265Decoder("", logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	defer d.close()
+	d.gray = true
+
+	decoded := 0
+	for _, f := range h265BFrameAUs {
+		b, err := base64.StdEncoding.DecodeString(f.au)
+		test.That(t, err, test.ShouldBeNil)
+		au, err := h264.AnnexBUnmarshal(b)
+		test.That(t, err, test.ShouldBeNil)
+		for _, nalu := range au {
+			img, pts, err := d.decode(nalu, f.pts)
+			test.That(t, err, test.ShouldBeNil)
+			if img == nil {
+				continue
+			}
+			// the luma plane is copied, expanded to the full range
+			gray, ok := img.(*image.Gray)
+			test.That(t, ok, test.ShouldBeTrue)
+			test.That(t, gray.Bounds(), test.ShouldResemble, image.Rect(0, 0, 64, 64))
+			want := int(limitedToFullLuma[40+25*pts])
+			test.That(t, int(gray.Pix[0]), test.ShouldAlmostEqual, want, 2)
+			test.That(t, int(gray.Pix[len(gray.Pix)-1]), test.ShouldAlmostEqual, want, 2)
+			decoded++
+		}
+	}
+	test.That(t, decoded, test.ShouldBeGreaterThanOrEqualTo, 4)
+}
+
+func TestDecodeScale(t *testing.T) {
+	SetLibAVLogLevelFatal()
+	for _, gray := range []bool{false, true} {
+		d, err := newH265Decoder("", logging.NewTestLogger(t))
+		test.That(t, err, test.ShouldBeNil)
+		d.gray = gray
+		d.scale = decodeScale{num: 1, den: 2}
+
+		var img image.Image
+		for _, f := range h265BFrameAUs {
+			b, err := base64.StdEncoding.DecodeString(f.au)
+			test.That(t, err, test.ShouldBeNil)
+			au, err := h264.AnnexBUnmarshal(b)
+			test.That(t, err, test.ShouldBeNil)
+			for _, nalu := range au {
+				decoded, _, err := d.decode(nalu, f.pts)
+				test.That(t, err, test.ShouldBeNil)
+				if decoded != nil {
+					img = decoded
+				}
+			}
+		}
+		d.close()
+		test.That(t, img, test.ShouldNotBeNil)
+		test.That(t, img.Bounds(), test.ShouldResemble, image.Rect(0, 0, 32, 32))
+		if gray {
+			_, ok := img.(*image.Gray)
+			test.That(t, ok, test.ShouldBeTrue)
+		} else {
+			_, ok := img.(*image.RGBA)
+			test.That(t, ok, test.ShouldBeTrue)
+		}
+	}
+}
+
+func TestBFrameDetector(t *testing.T) {
+	var d bframeDetector
+	test.That(t, d.detect(0), test.ShouldBeFalse)
+	test.That(t, d.detect(100), test.ShouldBeFalse)
+	test.That(t, d.detect(100), test.ShouldBeFalse)
+	test.That(t, d.detect(300), test.ShouldBeFalse)
+	test.That(t, d.detect(200), test.ShouldBeTrue)
+}
+
+// benchmarkStream is a stream the decode path is benchmarked with, whose access units are decoded in a loop.
+type benchmarkStream struct {
+	name  string
+	codec videoCodec
+	aus   [][][]byte
+}
+
+// benchmarkStreams returns the H264 & H265 streams the decode path is benchmarked with. The H264 streams of
+// sizes there are no fixtures of are encoded by the h264PCMEncoder, as GOPs of 30 uncompressed frames of the
+// fake camera.
+func benchmarkStreams(b *testing.B) []benchmarkStream {
+	b.Helper()
+	h264AU, err := viamrtsptest.AccessUnit(viamrtsptest.H264)
+	test.That(b, err, test.ShouldBeNil)
+	h265AU, err := viamrtsptest.AccessUnit(viamrtsptest.H265)
+	test.That(b, err, test.ShouldBeNil)
+	// the first access unit of the B-frame stream is its keyframe, with its parameter sets
+	annexB, err := base64.StdEncoding.DecodeString(h265BFrameAUs[0].au)
+	test.That(b, err, test.ShouldBeNil)
+	smallH265AU, err := h264.AnnexBUnmarshal(annexB)
+	test.That(b, err, test.ShouldBeNil)
+
+	streams := []benchmarkStream{
+		{name: "h265_64x64", codec: H265, aus: [][][]byte{smallH265AU}},
+		{name: "h264_480x270", codec: H264, aus: [][][]byte{h264AU}},
+		{name: "h265_480x270", codec: H265, aus: [][][]byte{h265AU}},
+	}
+	for _, size := range []image.Point{{640, 480}, {1280, 720}, {1920, 1088}} {
+		encoder := newH264PCMEncoder(size.X, size.Y, 30)
+		img := image.NewYCbCr(image.Rectangle{Max: size}, image.YCbCrSubsampleRatio420)
+		var aus [][][]byte
+		for i := 0; i < 30; i++ {
+			drawFakeFrame(img, time.Unix(int64(i), 0))
+			aus = append(aus, encoder.encode(img))
+		}
+		streams = append(streams, benchmarkStream{name: fmt.Sprintf("h264_pcm_%dx%d", size.X, size.Y), codec: H264, aus: aus})
+	}
+	return streams
+}
+
+// BenchmarkDecode measures the decode path of H264 & H265 streams of several sizes, from RTP packets to stored
+// frames, reporting the time each stage of the frame pipeline took per frame along with the total, e.g.
+//
+//	go test -run '^$' -bench BenchmarkDecode -benchmem
+func BenchmarkDecode(b *testing.B) {
+	SetLibAVLogLevelFatal()
+	for _, s := range benchmarkStreams(b) {
+		s := s
+		b.Run(s.name, func(b *testing.B) {
+			rc := &rtspCamera{logger: logging.NewTestLogger(b)}
+			var (
+				packetize   func([][]byte) ([]*rtp.Packet, error)
+				depacketize func(*rtp.Packet) ([][]byte, error)
+				d           *decoder
+				err         error
+			)
+			switch s.codec {
+			case H264:
+				f := &format.H264{PayloadTyp: 96, PacketizationMode: 1}
+				enc, err := f.CreateEncoder()
+				test.That(b, err, test.ShouldBeNil)
+				dec, err := f.CreateDecoder()
+				test.That(b, err, test.ShouldBeNil)
+				packetize, depacketize = enc.Encode, dec.Decode
+				d, err = newH264Decoder("", rc.logger)
+				test.That(b, err, test.ShouldBeNil)
+			default:
+				f := &format.H265{PayloadTyp: 96}
+				enc, err := f.CreateEncoder()
+				test.That(b, err, test.ShouldBeNil)
+				dec, err := f.CreateDecoder()
+				test.That(b, err, test.ShouldBeNil)
+				packetize, depacketize = enc.Encode, dec.Decode
+				d, err = newH265Decoder("", rc.logger)
+				test.That(b, err, test.ShouldBeNil)
+			}
+			defer d.close()
+			rc.configureDecoder(d)
+
+			packets := make([][]*rtp.Packet, len(s.aus))
+			for i, au := range s.aus {
+				packets[i], err = packetize(au)
+				test.That(b, err, test.ShouldBeNil)
+			}
+
+			rc.profile.startProfiling(time.Now())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, pkt := range packets[i%len(packets)] {
+					start := rc.profile.start()
+					au, err := depacketize(pkt)
+					rc.profile.record(profileDepacketize, start)
+					if err != nil {
+						continue
+					}
+					if s.codec == H264 {
+						rc.storeH264Frame(d, au, time.Now())
+						continue
+					}
+					for _, nalu := range au {
+						if err := rc.decodeAndStore(d, nalu, time.Now()); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			}
+			b.StopTimer()
+
+			test.That(b, rc.metrics.decodeErrors.Load(), test.ShouldEqual, uint64(0))
+			test.That(b, rc.metrics.framesDecoded.Load(), test.ShouldBeGreaterThan, 0)
+			for stage, name := range profileStageNames {
+				b.ReportMetric(float64(rc.profile.stages[stage].totalNanos.Load())/float64(b.N), name+"-ns/op")
+			}
+		})
+	}
+}
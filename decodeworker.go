@@ -0,0 +1,201 @@
+package viamrtsp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/utils"
+)
+
+const (
+	// decodeQueueSize is the number of access units queued for decoding before access units are dropped.
+	decodeQueueSize = 8
+	// decodeHangTimeout is how long decoding an access unit may take before the decoder is considered hung.
+	decodeHangTimeout = 10 * time.Second
+	// decodeStopTimeout bounds how long stopping waits for the access unit being decoded.
+	decodeStopTimeout      = 2 * time.Second
+	decodeWatchdogInterval = time.Second
+)
+
+// decodeJob is a run of access units waiting to be decoded, a single access unit or the GOP buffered while
+// decoding was idle, which is queued as one job so that a long GOP doesn't overflow the queue.
+type decodeJob struct {
+	aus []bufferedAU
+	// done, if set, is called once the access units were decoded
+	done func()
+}
+
+// decodeWorker decodes the access units of a connection on its own goroutine, so that slow or hung
+// FFmpeg calls don't stall the RTP read loop. The worker owns its decoder & closes it once the
+// access unit being decoded, if any, has been decoded, so a hung decoder is never freed while in use.
+type decodeWorker struct {
+	d      *decoder
+	decode func(d *decoder, au [][]byte, capturedAt time.Time)
+	// share schedules decoding with the other cameras of the module, it may be nil
+	share  *decodeShare
+	logger logging.Logger
+	jobs   chan decodeJob
+	stopCh chan struct{}
+	done   chan struct{}
+	// waitingForKeyframe is set once an access unit was dropped, it is only used by submit
+	waitingForKeyframe bool
+	// busySince is the UnixNano time the access unit being decoded was started at, or 0 while idle
+	busySince atomic.Int64
+	// slot is the worker of the decodeScheduler of the access unit being decoded, or last decoded
+	slot     atomic.Pointer[decodeSlot]
+	stopOnce sync.Once
+}
+
+// newDecodeWorker starts decoding access units by calling decode with d, once share is given a worker. onHang is
+// called once if decoding an access unit takes longer than decodeHangTimeout.
+func newDecodeWorker(
+	d *decoder,
+	decode func(d *decoder, au [][]byte, capturedAt time.Time),
+	share *decodeShare,
+	onHang func(),
+	logger logging.Logger,
+) *decodeWorker {
+	w := &decodeWorker{
+		d:      d,
+		decode: decode,
+		share:  share,
+		logger: logger,
+		jobs:   make(chan decodeJob, decodeQueueSize),
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	utils.PanicCapturingGo(w.run)
+	utils.PanicCapturingGo(func() { w.watch(onHang) })
+	return w
+}
+
+// submit queues au to be decoded, returning false if it was dropped. Once an access unit is dropped, access
+// units are dropped until the next keyframe, as inter frames reference the frames before them.
+// submit must be called from a single goroutine.
+func (w *decodeWorker) submit(au [][]byte, capturedAt time.Time, keyframe bool) bool {
+	if w.waitingForKeyframe && !keyframe {
+		return false
+	}
+	return w.queue(decodeJob{aus: []bufferedAU{{au: cloneAU(au), capturedAt: capturedAt}}})
+}
+
+// submitGOP queues gop, which must start with a keyframe & own its NALUs, to be decoded in one job, calling done once
+// every access unit was decoded. It returns false if gop was dropped as the queue is full.
+// submitGOP must be called from the goroutine which calls submit.
+func (w *decodeWorker) submitGOP(gop []bufferedAU, done func()) bool {
+	return w.queue(decodeJob{aus: gop, done: done})
+}
+
+func (w *decodeWorker) queue(job decodeJob) bool {
+	select {
+	case w.jobs <- job:
+		w.waitingForKeyframe = false
+		return true
+	default:
+		w.waitingForKeyframe = true
+		return false
+	}
+}
+
+func (w *decodeWorker) run() {
+	defer close(w.done)
+	defer w.d.close()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case job := <-w.jobs:
+			for _, au := range job.aus {
+				if !w.decodeOne(au) {
+					return
+				}
+			}
+			if job.done != nil {
+				job.done()
+			}
+		}
+	}
+}
+
+// decodeOne decodes au once share is given a worker, returning false if the worker was stopped.
+func (w *decodeWorker) decodeOne(au bufferedAU) bool {
+	select {
+	case <-w.stopCh:
+		return false
+	default:
+	}
+	if !w.share.acquire(w.stopCh) {
+		return false
+	}
+	slot := &decodeSlot{share: w.share, start: time.Now()}
+	w.slot.Store(slot)
+	w.busySince.Store(slot.start.UnixNano())
+	w.decode(w.d, au.au, au.capturedAt)
+	w.busySince.Store(0)
+	slot.release()
+	return true
+}
+
+// watch calls onHang if an access unit has been decoding for longer than decodeHangTimeout.
+func (w *decodeWorker) watch(onHang func()) {
+	ticker := time.NewTicker(decodeWatchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case now := <-ticker.C:
+			if w.hung(now) {
+				w.logger.Errorf("decoding an access unit has taken more than %s, restarting the decoder", decodeHangTimeout)
+				w.releaseSlot()
+				onHang()
+				return
+			}
+		}
+	}
+}
+
+// hung reports whether the access unit being decoded was started more than decodeHangTimeout before now.
+func (w *decodeWorker) hung(now time.Time) bool {
+	busySince := w.busySince.Load()
+	return busySince != 0 && now.Sub(time.Unix(0, busySince)) > decodeHangTimeout
+}
+
+// stop discards the queued access units & waits for the access unit being decoded, giving up after
+// decodeStopTimeout in which case the hung decoder is closed if it ever returns.
+func (w *decodeWorker) stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+	select {
+	case <-w.done:
+	case <-time.After(decodeStopTimeout):
+		w.logger.Warn("decoder did not stop, abandoning it")
+		w.releaseSlot()
+	}
+}
+
+// releaseSlot frees the worker of the decodeScheduler held by a hung decoder, for the other cameras.
+func (w *decodeWorker) releaseSlot() {
+	if slot := w.slot.Load(); slot != nil {
+		slot.release()
+	}
+}
+
+// cloneAU copies the NALUs of au, which may point into buffers the RTP decoder reuses.
+func cloneAU(au [][]byte) [][]byte {
+	cp := make([][]byte, len(au))
+	for i, nalu := range au {
+		cp[i] = append([]byte(nil), nalu...)
+	}
+	return cp
+}
+
+// startDecodeWorker moves d into a new decode worker, which calls decode for each access unit.
+// A hung decoder is replaced by reconnecting.
+func (rc *rtspCamera) startDecodeWorker(d *decoder, decode func(d *decoder, au [][]byte, capturedAt time.Time)) *decodeWorker {
+	return newDecodeWorker(d, decode, rc.decodeShare, func() {
+		rc.metrics.decoderHangs.Add(1)
+		rc.requestReconnect()
+	}, rc.logger)
+}
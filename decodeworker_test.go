@@ -0,0 +1,94 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestDecodeWorker(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	t.Run("drops access units until the next keyframe once the queue is full", func(t *testing.T) {
+		running, unblock := make(chan struct{}), make(chan struct{})
+		decoded := make(chan byte, 2*decodeQueueSize)
+		w := newDecodeWorker(&decoder{}, func(_ *decoder, au [][]byte, _ time.Time) {
+			if au[0][0] == 0 {
+				close(running)
+				<-unblock
+				return
+			}
+			decoded <- au[0][0]
+		}, nil, func() {}, logger)
+		defer w.stop()
+
+		test.That(t, w.submit([][]byte{{0}}, time.Now(), true), test.ShouldBeTrue)
+		<-running
+		for i := 1; i <= decodeQueueSize; i++ {
+			test.That(t, w.submit([][]byte{{byte(i)}}, time.Now(), false), test.ShouldBeTrue)
+		}
+		test.That(t, w.submit([][]byte{{100}}, time.Now(), false), test.ShouldBeFalse)
+		close(unblock)
+		for i := 1; i <= decodeQueueSize; i++ {
+			test.That(t, <-decoded, test.ShouldEqual, byte(i))
+		}
+		// inter frames are dropped until the next keyframe
+		test.That(t, w.submit([][]byte{{101}}, time.Now(), false), test.ShouldBeFalse)
+		test.That(t, w.submit([][]byte{{102}}, time.Now(), true), test.ShouldBeTrue)
+		test.That(t, <-decoded, test.ShouldEqual, byte(102))
+	})
+
+	t.Run("decodes every access unit of a drained GOP longer than the queue", func(t *testing.T) {
+		running, unblock := make(chan struct{}), make(chan struct{})
+		decoded := make(chan byte, 30)
+		w := newDecodeWorker(&decoder{}, func(_ *decoder, au [][]byte, _ time.Time) {
+			if au[0][0] == 0 {
+				close(running)
+				<-unblock
+				return
+			}
+			decoded <- au[0][0]
+		}, nil, func() {}, logger)
+		defer w.stop()
+
+		test.That(t, w.submit([][]byte{{0}}, time.Now(), true), test.ShouldBeTrue)
+		<-running
+		gop := make([]bufferedAU, 30)
+		for i := range gop {
+			gop[i] = bufferedAU{au: [][]byte{{byte(i + 1)}}, capturedAt: time.Now()}
+		}
+		done := make(chan struct{})
+		test.That(t, w.submitGOP(gop, func() { close(done) }), test.ShouldBeTrue)
+		close(unblock)
+		<-done
+		test.That(t, decoded, test.ShouldHaveLength, 30)
+		for i := 1; i <= 30; i++ {
+			test.That(t, <-decoded, test.ShouldEqual, byte(i))
+		}
+	})
+
+	t.Run("copies access units", func(t *testing.T) {
+		decoded := make(chan byte, 1)
+		w := newDecodeWorker(&decoder{}, func(_ *decoder, au [][]byte, _ time.Time) {
+			decoded <- au[0][0]
+		}, nil, func() {}, logger)
+		defer w.stop()
+		au := [][]byte{{1}}
+		// submit may be called with NALUs the RTP decoder reuses
+		w.submit(au, time.Now(), true)
+		au[0][0] = 2
+		test.That(t, <-decoded, test.ShouldEqual, byte(1))
+	})
+
+	t.Run("detects hung decoders", func(t *testing.T) {
+		w := &decodeWorker{}
+		now := time.Now()
+		test.That(t, w.hung(now), test.ShouldBeFalse)
+		w.busySince.Store(now.Add(-time.Second).UnixNano())
+		test.That(t, w.hung(now), test.ShouldBeFalse)
+		w.busySince.Store(now.Add(-decodeHangTimeout - time.Second).UnixNano())
+		test.That(t, w.hung(now), test.ShouldBeTrue)
+	})
+}
@@ -0,0 +1,28 @@
+package viamrtsp
+
+// blendFields deinterlaces a plane of 8 bit samples in place with a linear blend filter, averaging every line
+// with the lines above & below it, which belong to the other field, weighted 1:2:1. It removes the combing
+// of moving objects at the cost of some vertical detail. rows is reused for the original lines, & returned.
+func blendFields(pix []uint8, stride, height int, rows []uint8) []uint8 {
+	if height < 2 {
+		return rows
+	}
+	if len(rows) < 2*stride {
+		rows = make([]uint8, 2*stride)
+	}
+	prev, cur := rows[:stride], rows[stride:2*stride]
+	copy(prev, pix[:stride])
+	for y := 0; y < height; y++ {
+		line := pix[y*stride : (y+1)*stride]
+		copy(cur, line)
+		next := cur
+		if y+1 < height {
+			next = pix[(y+1)*stride : (y+2)*stride]
+		}
+		for x := range line {
+			line[x] = uint8((int(prev[x]) + 2*int(cur[x]) + int(next[x]) + 2) >> 2)
+		}
+		prev, cur = cur, prev
+	}
+	return rows
+}
@@ -0,0 +1,34 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestBlendFields(t *testing.T) {
+	// the fields of a moving edge, which comb, with a padding byte per line
+	pix := []uint8{
+		0, 0, 9,
+		200, 200, 9,
+		0, 0, 9,
+		200, 200, 9,
+	}
+	rows := blendFields(pix, 3, 4, nil)
+	test.That(t, pix, test.ShouldResemble, []uint8{
+		50, 50, 9,
+		100, 100, 9,
+		100, 100, 9,
+		150, 150, 9,
+	})
+	test.That(t, len(rows), test.ShouldEqual, 6)
+
+	// progressive content is kept
+	flat := []uint8{80, 80, 80, 80}
+	blendFields(flat, 2, 2, rows)
+	test.That(t, flat, test.ShouldResemble, []uint8{80, 80, 80, 80})
+
+	single := []uint8{1, 2}
+	blendFields(single, 2, 1, rows)
+	test.That(t, single, test.ShouldResemble, []uint8{1, 2})
+}
@@ -0,0 +1,39 @@
+package viamrtsp
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/pointcloud"
+	"go.viam.com/rdk/rimage"
+	"go.viam.com/rdk/rimage/depthadapter"
+)
+
+const (
+	colorStreamType = "color"
+	depthStreamType = "depth"
+)
+
+// NextPointCloud projects the latest depth frame into a point cloud using the configured intrinsic parameters.
+// It is only supported when stream_type is depth.
+func (rc *rtspCamera) NextPointCloud(ctx context.Context) (pointcloud.PointCloud, error) {
+	if !rc.depth {
+		return nil, errors.New("point clouds are only supported when stream_type is depth")
+	}
+	rc.propsMu.RLock()
+	configured := rc.cameraModel.PinholeCameraIntrinsics != nil
+	rc.propsMu.RUnlock()
+	if !configured {
+		return nil, errors.New("intrinsic_parameters are required to create point clouds")
+	}
+
+	latest := rc.markImageRequested(ctx)
+	if latest == nil {
+		return nil, errors.New("no frame yet")
+	}
+	dm, ok := latest.img.(*rimage.DepthMap)
+	if !ok {
+		return nil, errors.Errorf("expected a depth frame, got %T", latest.img)
+	}
+	return depthadapter.ToPointCloud(dm, rc.intrinsicsFor(dm.Bounds().Size())), nil
+}
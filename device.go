@@ -0,0 +1,289 @@
+package viamrtsp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// onvifDateTime is a date & time of the ONVIF schema.
+type onvifDateTime struct {
+	Year   int `xml:"Date>Year"`
+	Month  int `xml:"Date>Month"`
+	Day    int `xml:"Date>Day"`
+	Hour   int `xml:"Time>Hour"`
+	Minute int `xml:"Time>Minute"`
+	Second int `xml:"Time>Second"`
+}
+
+func (d onvifDateTime) time() time.Time {
+	return time.Date(d.Year, time.Month(d.Month), d.Day, d.Hour, d.Minute, d.Second, 0, time.UTC)
+}
+
+// marshalONVIFDateTime returns t as the Date & Time elements of a DateTime element.
+func marshalONVIFDateTime(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf(`<Date xmlns="%s"><Year>%d</Year><Month>%d</Month><Day>%d</Day></Date>`+
+		`<Time xmlns="%s"><Hour>%d</Hour><Minute>%d</Minute><Second>%d</Second></Time>`,
+		onvifSchemaNamespace, t.Year(), int(t.Month()), t.Day(), onvifSchemaNamespace, t.Hour(), t.Minute(), t.Second())
+}
+
+// systemDateAndTime is the clock of an ONVIF camera.
+type systemDateAndTime struct {
+	DateTimeType    string        `xml:"DateTimeType"`
+	DaylightSavings bool          `xml:"DaylightSavings"`
+	TimeZone        string        `xml:"TimeZone>TZ"`
+	UTCDateTime     onvifDateTime `xml:"UTCDateTime"`
+}
+
+// clockOffset returns how far the clock of the camera, read by a request sent at start & answered at end, is ahead
+// of the clock of the host.
+func clockOffset(camera, start, end time.Time) time.Duration {
+	return camera.Sub(start.Add(end.Sub(start) / 2))
+}
+
+func (c *onvifClient) deviceInformation(ctx context.Context) (map[string]interface{}, error) {
+	var res struct {
+		Info struct {
+			Manufacturer    string `xml:"Manufacturer"`
+			Model           string `xml:"Model"`
+			FirmwareVersion string `xml:"FirmwareVersion"`
+			SerialNumber    string `xml:"SerialNumber"`
+			HardwareID      string `xml:"HardwareId"`
+		} `xml:"Body>GetDeviceInformationResponse"`
+	}
+	if err := c.call(ctx, c.deviceServiceURL, `<GetDeviceInformation xmlns="`+onvifDeviceNamespace+`"/>`, &res); err != nil {
+		return nil, errors.Wrap(err, "getting ONVIF device information")
+	}
+	return map[string]interface{}{
+		"manufacturer":     res.Info.Manufacturer,
+		"model":            res.Info.Model,
+		"firmware_version": res.Info.FirmwareVersion,
+		"serial_number":    res.Info.SerialNumber,
+		"hardware_id":      res.Info.HardwareID,
+	}, nil
+}
+
+// networkInterface is a network interface of an ONVIF camera, whose IPv4 addresses are either configured manually
+// or assigned by DHCP.
+type networkInterface struct {
+	Token     string `xml:"token,attr"`
+	Enabled   bool   `xml:"Enabled"`
+	Name      string `xml:"Info>Name"`
+	HwAddress string `xml:"Info>HwAddress"`
+	IPv4      struct {
+		Enabled bool               `xml:"Enabled"`
+		DHCP    bool               `xml:"Config>DHCP"`
+		Manual  []onvifIPv4Address `xml:"Config>Manual"`
+		DHCPed  []onvifIPv4Address `xml:"Config>FromDHCP"`
+	} `xml:"IPv4"`
+}
+
+type onvifIPv4Address struct {
+	Address      string `xml:"Address"`
+	PrefixLength int    `xml:"PrefixLength"`
+}
+
+// toMap returns the interface as an element of the network_interfaces of the get-device-info command, whose
+// addresses are in CIDR notation.
+func (n networkInterface) toMap() map[string]interface{} {
+	addresses := n.IPv4.Manual
+	if n.IPv4.DHCP {
+		addresses = n.IPv4.DHCPed
+	}
+	cidrs := make([]interface{}, 0, len(addresses))
+	for _, a := range addresses {
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", strings.TrimSpace(a.Address), a.PrefixLength))
+	}
+	return map[string]interface{}{
+		"token":          n.Token,
+		"name":           n.Name,
+		"enabled":        n.Enabled,
+		"mac_address":    strings.ToLower(strings.TrimSpace(n.HwAddress)),
+		"dhcp":           n.IPv4.DHCP,
+		"ipv4_addresses": cidrs,
+	}
+}
+
+func (c *onvifClient) networkInterfaces(ctx context.Context) ([]networkInterface, error) {
+	var res struct {
+		Interfaces []networkInterface `xml:"Body>GetNetworkInterfacesResponse>NetworkInterfaces"`
+	}
+	if err := c.call(ctx, c.deviceServiceURL, `<GetNetworkInterfaces xmlns="`+onvifDeviceNamespace+`"/>`, &res); err != nil {
+		return nil, errors.Wrap(err, "getting ONVIF network interfaces")
+	}
+	return res.Interfaces, nil
+}
+
+func (c *onvifClient) systemDateAndTime(ctx context.Context) (systemDateAndTime, error) {
+	var res struct {
+		DateAndTime systemDateAndTime `xml:"Body>GetSystemDateAndTimeResponse>SystemDateAndTime"`
+	}
+	if err := c.call(ctx, c.deviceServiceURL, `<GetSystemDateAndTime xmlns="`+onvifDeviceNamespace+`"/>`, &res); err != nil {
+		return systemDateAndTime{}, errors.Wrap(err, "getting ONVIF system date & time")
+	}
+	return res.DateAndTime, nil
+}
+
+// setSystemDateAndTime sets the clock of the camera to t, or makes it use NTP if ntp is true, keeping its time zone.
+func (c *onvifClient) setSystemDateAndTime(ctx context.Context, t time.Time, ntp, daylightSavings bool) error {
+	body := `<SetSystemDateAndTime xmlns="` + onvifDeviceNamespace + `">`
+	if ntp {
+		body += fmt.Sprintf(`<DateTimeType>NTP</DateTimeType><DaylightSavings>%v</DaylightSavings>`, daylightSavings)
+	} else {
+		body += fmt.Sprintf(`<DateTimeType>Manual</DateTimeType><DaylightSavings>%v</DaylightSavings>`, daylightSavings) +
+			`<UTCDateTime>` + marshalONVIFDateTime(t) + `</UTCDateTime>`
+	}
+	body += `</SetSystemDateAndTime>`
+	var res struct{}
+	if err := c.call(ctx, c.deviceServiceURL, body, &res); err != nil {
+		return errors.Wrap(err, "setting ONVIF system date & time")
+	}
+	return nil
+}
+
+func (c *onvifClient) systemReboot(ctx context.Context) (string, error) {
+	var res struct {
+		Message string `xml:"Body>SystemRebootResponse>Message"`
+	}
+	if err := c.call(ctx, c.deviceServiceURL, `<SystemReboot xmlns="`+onvifDeviceNamespace+`"/>`, &res); err != nil {
+		return "", errors.Wrap(err, "rebooting ONVIF device")
+	}
+	return res.Message, nil
+}
+
+// getDeviceInfo returns the manufacturer, model, firmware version, serial number & hardware id of the camera.
+func (rc *rtspCamera) getDeviceInfo(ctx context.Context) (map[string]interface{}, error) {
+	_, client, err := rc.deviceClient(getDeviceInfoCommand)
+	if err != nil {
+		return nil, err
+	}
+	info, err := client.deviceInformation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// cameras may only list their network interfaces to administrators, which the device information doesn't need
+	interfaces, err := client.networkInterfaces(ctx)
+	if err != nil {
+		rc.logger.Warnf("unable to get the network interfaces of the onvif camera, err: %s", err)
+		return info, nil
+	}
+	list := make([]interface{}, 0, len(interfaces))
+	for _, n := range interfaces {
+		list = append(list, n.toMap())
+	}
+	info["network_interfaces"] = list
+	return info, nil
+}
+
+// getDateTime returns the clock of the camera, along with its offset from the clock of the host, as a camera
+// whose clock drifted rejects authenticated ONVIF requests & stamps recordings with the wrong time.
+func (rc *rtspCamera) getDateTime(ctx context.Context, command string) (map[string]interface{}, error) {
+	_, client, err := rc.deviceClient(command)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	dt, err := client.systemDateAndTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := dt.UTCDateTime.time()
+	return map[string]interface{}{
+		"date_time":            now.Format(time.RFC3339),
+		"date_time_type":       dt.DateTimeType,
+		"daylight_savings":     dt.DaylightSavings,
+		"time_zone":            dt.TimeZone,
+		"clock_offset_seconds": clockOffset(now, start, time.Now()).Round(time.Second).Seconds(),
+	}, nil
+}
+
+// setDateTime sets the clock of the camera to the `date_time` field of cmd, an RFC 3339 timestamp, or to the
+// clock of the host if it's unset, or makes the camera use NTP if `ntp` is true. It returns the clock once set.
+func (rc *rtspCamera) setDateTime(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	t := time.Now()
+	if v, ok := cmd["date_time"]; ok {
+		str, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("%s requires 'date_time' to be an RFC 3339 timestamp string", setDateTimeCommand)
+		}
+		parsed, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s requires 'date_time' to be an RFC 3339 timestamp", setDateTimeCommand)
+		}
+		t = parsed
+	}
+	ntp := false
+	if v, ok := cmd["ntp"]; ok {
+		if ntp, ok = v.(bool); !ok {
+			return nil, errors.Errorf("%s requires 'ntp' to be a bool", setDateTimeCommand)
+		}
+	}
+	_, client, err := rc.deviceClient(setDateTimeCommand)
+	if err != nil {
+		return nil, err
+	}
+	current, err := client.systemDateAndTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.setSystemDateAndTime(ctx, t, ntp, current.DaylightSavings); err != nil {
+		return nil, err
+	}
+	return rc.getDateTime(ctx, setDateTimeCommand)
+}
+
+// checkClock logs the drift of the camera's clock from the clock of the host once it's over max_clock_drift, as a
+// camera whose clock drifted rejects authenticated ONVIF requests & timestamps its stream wrongly. The camera's
+// clock is set to the clock of the host if sync_clock is set, unless the camera uses NTP.
+func (rc *rtspCamera) checkClock(conf *ONVIFConfig, username, password string) {
+	ctx, cancel := context.WithTimeout(context.Background(), onvifTimeout)
+	defer cancel()
+	start := time.Now()
+	// GetSystemDateAndTime doesn't require authentication, so that it works however far the clock drifted
+	dt, err := conf.client("", "").systemDateAndTime(ctx)
+	if err != nil {
+		rc.logger.Warnf("unable to check the clock of the onvif camera, err: %s", err)
+		return
+	}
+	if dt.UTCDateTime.Year == 0 {
+		rc.logger.Warnf("unable to check the clock of the onvif camera, which didn't report its UTC date & time")
+		return
+	}
+	offset := clockOffset(dt.UTCDateTime.time(), start, time.Now())
+	if offset.Abs() <= conf.maxClockDrift() {
+		return
+	}
+	offset = offset.Round(time.Second)
+	switch {
+	case !conf.SyncClock:
+		rc.logger.Warnf("the clock of the onvif camera is off by %s, which breaks authentication & timestamps, "+
+			"set the sync_clock onvif attribute or use the %s command to correct it", offset, setDateTimeCommand)
+	case dt.DateTimeType == "NTP":
+		rc.logger.Warnf("the clock of the onvif camera is off by %s even though it uses NTP, check its NTP server", offset)
+	default:
+		if err := conf.client(username, password).setSystemDateAndTime(ctx, time.Now(), false, dt.DaylightSavings); err != nil {
+			rc.logger.Warnf("unable to correct the clock of the onvif camera, which is off by %s, err: %s", offset, err)
+			return
+		}
+		rc.logger.Infof("corrected the clock of the onvif camera, which was off by %s", offset)
+	}
+}
+
+// reboot reboots the camera, e.g. when its stream wedged. The reconnect worker reconnects to the stream once the
+// camera is back, retrying every reconnect_interval up to max_backoff.
+func (rc *rtspCamera) reboot(ctx context.Context) (map[string]interface{}, error) {
+	_, client, err := rc.deviceClient(rebootCommand)
+	if err != nil {
+		return nil, err
+	}
+	message, err := client.systemReboot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rc.logger.Warnf("rebooting camera of rtsp stream %s, message: %s", rc.redactedURL(), message)
+	return map[string]interface{}{"rebooting": true, "message": message}, nil
+}
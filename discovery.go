@@ -0,0 +1,416 @@
+package viamrtsp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/pkg/errors"
+)
+
+const (
+	// wsDiscoveryWait is how long ONVIF cameras are given to answer a WS-Discovery probe.
+	wsDiscoveryWait = 3 * time.Second
+	// maxWSDiscoveryMessageSize bounds the size of a WS-Discovery probe match.
+	maxWSDiscoveryMessageSize = 64 << 10
+)
+
+// wsDiscoveryTypeNamespaces are the namespaces of the prefixes of the device types WS-Discovery probes can be
+// filtered by.
+var wsDiscoveryTypeNamespaces = map[string]string{
+	"dn":  "http://www.onvif.org/ver10/network/wsdl",
+	"tds": "http://www.onvif.org/ver10/device/wsdl",
+}
+
+// defaultWSDiscoveryTypes selects ONVIF cameras.
+var defaultWSDiscoveryTypes = []string{"dn:NetworkVideoTransmitter"}
+
+// wsDiscoveryFilter selects the devices found by a WS-Discovery probe. Devices which ignore the filter of the
+// probe are filtered by their probe match.
+type wsDiscoveryFilter struct {
+	// types are the device types, e.g. dn:NetworkVideoTransmitter, devices must have all of, defaultWSDiscoveryTypes
+	// if empty
+	types []string
+	// scopes are the scopes, e.g. onvif://www.onvif.org/Profile/Streaming, devices must have all of, as a scope
+	// or a prefix of a scope at a "/"
+	scopes []string
+}
+
+// validateWSDiscoveryTypes checks that types are prefix:name device types of a known prefix.
+func validateWSDiscoveryTypes(types []string) error {
+	for _, t := range types {
+		prefix, name, ok := strings.Cut(t, ":")
+		if _, known := wsDiscoveryTypeNamespaces[prefix]; !ok || !known || name == "" {
+			return errors.Errorf("'%s' must be a device type prefixed with dn: or tds:, e.g. dn:NetworkVideoTransmitter", t)
+		}
+	}
+	return nil
+}
+
+// probe returns the WS-Discovery probe of the filter.
+func (f wsDiscoveryFilter) probe() []byte {
+	types := f.types
+	if len(types) == 0 {
+		types = defaultWSDiscoveryTypes
+	}
+	prefixes := map[string]bool{}
+	for _, t := range types {
+		prefix, _, _ := strings.Cut(t, ":")
+		prefixes[prefix] = true
+	}
+	var namespaces string
+	for _, prefix := range []string{"dn", "tds"} {
+		if prefixes[prefix] {
+			namespaces += ` xmlns:` + prefix + `="` + wsDiscoveryTypeNamespaces[prefix] + `"`
+		}
+	}
+	id := make([]byte, 16)
+	//nolint:errcheck
+	rand.Read(id)
+	probe := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing">` +
+		`<s:Header><a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</a:Action>` +
+		fmt.Sprintf(`<a:MessageID>uuid:%x-%x-%x-%x-%x</a:MessageID>`, id[0:4], id[4:6], id[6:8], id[8:10], id[10:]) +
+		`<a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To></s:Header>` +
+		`<s:Body><Probe xmlns="http://schemas.xmlsoap.org/ws/2005/04/discovery">` +
+		`<Types` + namespaces + `>` + xmlEscape(strings.Join(types, " ")) + `</Types>`
+	if len(f.scopes) > 0 {
+		probe += `<Scopes>` + xmlEscape(strings.Join(f.scopes, " ")) + `</Scopes>`
+	}
+	return []byte(probe + `</Probe></s:Body></s:Envelope>`)
+}
+
+// probeMatch is a device which answered a WS-Discovery probe.
+type probeMatch struct {
+	Types  string `xml:"Types"`
+	Scopes string `xml:"Scopes"`
+	// XAddrs are the device service addresses of the device, one per network interface
+	XAddrs string `xml:"XAddrs"`
+}
+
+// matches returns whether the device of m has the types & scopes of the filter. Types are compared by name, as
+// devices use prefixes of their own. Devices which don't list their types are assumed to have them.
+func (f wsDiscoveryFilter) matches(m probeMatch) bool {
+	types := f.types
+	if len(types) == 0 {
+		types = defaultWSDiscoveryTypes
+	}
+	if fields := strings.Fields(m.Types); len(fields) > 0 {
+		names := map[string]bool{}
+		for _, t := range fields {
+			_, name, ok := strings.Cut(t, ":")
+			if !ok {
+				name = t
+			}
+			names[name] = true
+		}
+		for _, t := range types {
+			if _, name, _ := strings.Cut(t, ":"); !names[name] {
+				return false
+			}
+		}
+	}
+	scopes := strings.Fields(m.Scopes)
+	for _, want := range f.scopes {
+		want = strings.TrimSuffix(want, "/")
+		if !slices.ContainsFunc(scopes, func(scope string) bool {
+			return scope == want || strings.HasPrefix(scope, want+"/")
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// wsDiscoveryAddresses are the multicast groups & port WS-Discovery probes are sent to, over IPv4 & IPv6. Probes to
+// link-local IPv6 groups are sent on every multicast interface with an IPv6 address.
+var wsDiscoveryAddresses = []string{"239.255.255.250:3702", "[ff02::c]:3702"}
+
+// wsDiscover sends a WS-Discovery probe for the devices of filter to each of wsDiscoveryAddresses & returns the
+// device service addresses of the devices which answer within wait. It only fails if no probe could be sent.
+func wsDiscover(ctx context.Context, wait time.Duration, filter wsDiscoveryFilter) ([]string, error) {
+	probe := filter.probe()
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	var conns []*net.UDPConn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	var sendErr error
+	for _, address := range wsDiscoveryAddresses {
+		conn, err := sendWSDiscoveryProbe(address, probe)
+		if err != nil {
+			sendErr = errors.Wrapf(err, "sending the WS-Discovery probe to %s", address)
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		return nil, sendErr
+	}
+
+	// the probe matches of each group are read concurrently & merged in the order of wsDiscoveryAddresses
+	matches := make([][]string, len(conns))
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn *net.UDPConn) {
+			defer wg.Done()
+			matches[i] = readProbeMatches(conn, deadline, filter)
+		}(i, conn)
+	}
+	wg.Wait()
+	var addresses []string
+	seen := map[string]bool{}
+	for _, m := range matches {
+		for _, address := range m {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// sendWSDiscoveryProbe sends probe to the WS-Discovery group at address, on every multicast interface if it's a
+// link-local IPv6 group, & returns the connection the probe matches are received on.
+func sendWSDiscoveryProbe(address string, probe []byte) (*net.UDPConn, error) {
+	network := "udp4"
+	if host, _, err := net.SplitHostPort(address); err == nil && strings.Contains(host, ":") {
+		network = "udp6"
+	}
+	group, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(network, &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	if network == "udp4" || !group.IP.IsLinkLocalMulticast() {
+		if _, err := conn.WriteToUDP(probe, group); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sendErr := errors.New("no interface with an IPv6 address supports multicast")
+	sent := false
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || !hasIPv6Address(iface) {
+			continue
+		}
+		// link-local groups are scoped to the interface they're sent on
+		dst := *group
+		dst.Zone = iface.Name
+		if _, err := conn.WriteToUDP(probe, &dst); err != nil {
+			sendErr = err
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		conn.Close()
+		return nil, sendErr
+	}
+	return conn, nil
+}
+
+// hasIPv6Address returns whether iface has an IPv6 address.
+func hasIPv6Address(iface net.Interface) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readProbeMatches returns the device service addresses of the probe matches of filter received on conn until
+// deadline.
+func readProbeMatches(conn *net.UDPConn, deadline time.Time, filter wsDiscoveryFilter) []string {
+	if conn.SetReadDeadline(deadline) != nil {
+		return nil
+	}
+	var addresses []string
+	buf := make([]byte, maxWSDiscoveryMessageSize)
+	for {
+		// the read deadline ends the discovery
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return addresses
+		}
+		var res struct {
+			Matches []probeMatch `xml:"Body>ProbeMatches>ProbeMatch"`
+		}
+		if xml.Unmarshal(buf[:n], &res) != nil {
+			continue
+		}
+		for _, match := range res.Matches {
+			if !filter.matches(match) {
+				continue
+			}
+			for _, address := range strings.Fields(match.XAddrs) {
+				if address, ok := deviceServiceAddress(address, from); ok {
+					addresses = append(addresses, address)
+				}
+			}
+		}
+	}
+}
+
+// deviceServiceAddress returns the XAddr address if it's an http(s) URL. Link-local IPv6 addresses, which cameras
+// list without a zone, are scoped to the interface the probe match was received from.
+func deviceServiceAddress(address string, from *net.UDPAddr) (string, bool) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	host, port := u.Hostname(), u.Port()
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil && ip.IsLinkLocalUnicast() && from != nil && from.Zone != "" {
+		u.Host = "[" + host + "%" + from.Zone + "]"
+		if port != "" {
+			u.Host += ":" + port
+		}
+	}
+	return u.String(), true
+}
+
+// normalizeMAC returns the hex digits of a MAC address in lowercase, so that 00:11:22:AA:BB:CC, 00-11-22-aa-bb-cc
+// & 0011.22aa.bbcc match.
+func normalizeMAC(mac string) string {
+	return strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(strings.TrimSpace(mac)))
+}
+
+// pinned returns whether the camera is pinned by serial number or MAC address, so that it's rediscovered if its
+// address changes.
+func (c *ONVIFConfig) pinned() bool {
+	return c.SerialNumber != "" || c.MACAddress != ""
+}
+
+// discoveryFilter returns the filter of the WS-Discovery probes the pinned camera is rediscovered with.
+func (c *ONVIFConfig) discoveryFilter() wsDiscoveryFilter {
+	return wsDiscoveryFilter{types: c.DiscoveryTypes, scopes: c.DiscoveryScopes}
+}
+
+// matches returns whether the camera client is connected to has the serial number & MAC address the config is
+// pinned to.
+func (c *ONVIFConfig) matches(ctx context.Context, client *onvifClient) bool {
+	if c.SerialNumber != "" {
+		info, err := client.deviceInformation(ctx)
+		if err != nil {
+			return false
+		}
+		if serial, _ := info["serial_number"].(string); !strings.EqualFold(strings.TrimSpace(serial), strings.TrimSpace(c.SerialNumber)) {
+			return false
+		}
+	}
+	if c.MACAddress != "" {
+		interfaces, err := client.networkInterfaces(ctx)
+		if err != nil {
+			return false
+		}
+		for _, n := range interfaces {
+			if normalizeMAC(n.HwAddress) == normalizeMAC(c.MACAddress) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// rediscoverONVIF finds the camera the onvif config is pinned to with WS-Discovery once its address changed, e.g.
+// as DHCP assigned it a new one, & streams from the address of its profile at the new address. It returns whether
+// the camera was found at a new address.
+func (rc *rtspCamera) rediscoverONVIF() bool {
+	conf := rc.onvif.Load()
+	if conf == nil || !conf.pinned() {
+		return false
+	}
+	var username, password string
+	var user *url.Userinfo
+	if u := rc.getURL(); u != nil && u.User != nil {
+		user = u.User
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wsDiscoveryWait+onvifTimeout)
+	defer cancel()
+	addresses, err := wsDiscover(ctx, wsDiscoveryWait, conf.discoveryFilter())
+	if err != nil {
+		rc.logger.Warnf("unable to discover onvif cameras, err: %s", err)
+		return false
+	}
+	for _, address := range addresses {
+		if address == conf.DeviceServiceURL {
+			continue
+		}
+		moved := *conf
+		moved.DeviceServiceURL = address
+		if !moved.matches(ctx, moved.client(username, password)) {
+			continue
+		}
+		rc.logger.Infof("found the onvif camera with serial_number '%s' & mac_address '%s' at %s, it moved from %s",
+			conf.SerialNumber, conf.MACAddress, address, conf.DeviceServiceURL)
+		streamAddress, err := rc.resolveONVIFAddress(&moved, username, password)
+		if err != nil {
+			rc.logger.Warnf("unable to stream from the onvif camera at its new address, err: %s", err)
+			return false
+		}
+		u, err := base.ParseURL(streamAddress)
+		if err != nil {
+			return false
+		}
+		u.User = user
+		var substream *base.URL
+		if moved.SubstreamProfile != "" {
+			if substream, err = rc.resolveONVIFSubstream(&moved, username, password); err != nil {
+				rc.logger.Warnf("unable to resolve the substream, only streaming the main stream, err: %s", err)
+			}
+		}
+		rc.onvif.Store(&moved)
+		rc.setONVIFAddress(u, substream)
+		return true
+	}
+	rc.logger.Warnf("the onvif camera with serial_number '%s' & mac_address '%s' wasn't discovered",
+		conf.SerialNumber, conf.MACAddress)
+	return false
+}
+
+// setONVIFAddress replaces the address resolved from the onvif profile, the first of the addresses, & the substream,
+// & streams from the new address.
+func (rc *rtspCamera) setONVIFAddress(u, substream *base.URL) {
+	rc.uMu.Lock()
+	defer rc.uMu.Unlock()
+	if len(rc.addresses) == 0 {
+		rc.addresses = []*base.URL{u}
+	} else {
+		rc.addresses[0] = u
+	}
+	rc.u = u
+	rc.substream, rc.streamingSubstream = substream, false
+}
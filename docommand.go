@@ -0,0 +1,200 @@
+package viamrtsp
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	commandKey = "command"
+	// updateCredentialsCommand replaces the username & password used to connect to the stream.
+	updateCredentialsCommand = "update-credentials"
+	// reconnectCommand tears down & re-establishes the RTSP session, e.g. for cameras which stall silently.
+	reconnectCommand = "reconnect"
+	// pauseCommand pauses the RTSP session until the stream is consumed or resumed, to save bandwidth.
+	pauseCommand = "pause"
+	// resumeCommand resumes the RTSP session after it was paused by the pause command or idle_timeout.
+	resumeCommand = "resume"
+	// getFrameMetadataCommand returns the latest frame's sequence number & the frames dropped since the caller's last read.
+	getFrameMetadataCommand = "get-frame-metadata"
+	// getFrameAtCommand returns the frame of frame_history captured nearest to a time, e.g. the time of a detection.
+	getFrameAtCommand = "get-frame-at"
+	// getMetricsCommand returns the stream health metrics of the camera.
+	getMetricsCommand = "get-metrics"
+	// getStreamInfoCommand returns the codec, resolution & transport of the current connection.
+	getStreamInfoCommand = "get-stream-info"
+	// getVideoPropertiesCommand returns the size of the served images & the measured frame rate of the stream.
+	getVideoPropertiesCommand = "get-video-properties"
+	// getRTCPStatsCommand returns the jitter, loss & sender reports of every track of the current connection.
+	getRTCPStatsCommand = "get-rtcp-stats"
+	// listSubscribersCommand returns what was delivered to & dropped for every rtp_passthrough subscriber.
+	listSubscribersCommand = "list-subscribers"
+	// getDecodeErrorsCommand returns the decode errors of each type in the current & last minute.
+	getDecodeErrorsCommand = "get-decode-errors"
+	// getSEICommand returns the latest SEI messages of H264 & H265 streams.
+	getSEICommand = "get-sei"
+	// getMetadataCommand returns the latest objects & events of the ONVIF metadata track, or the latest KLV unit.
+	getMetadataCommand = "get-metadata"
+	// getImagingSettingsCommand returns the brightness, contrast, exposure, WDR & IR cut filter settings of the camera.
+	getImagingSettingsCommand = "get-imaging-settings"
+	// setImagingSettingsCommand changes the imaging settings of the camera through its ONVIF imaging service.
+	setImagingSettingsCommand = "set-imaging-settings"
+	// getDeviceInfoCommand returns the manufacturer, model & firmware version of the camera from its ONVIF device service.
+	getDeviceInfoCommand = "get-device-info"
+	// getDateTimeCommand returns the clock of the camera & its offset from the clock of the host.
+	getDateTimeCommand = "get-date-time"
+	// setDateTimeCommand sets the clock of the camera, by default to the clock of the host.
+	setDateTimeCommand = "set-date-time"
+	// getVideoEncoderCommand returns the encoding, resolution, bitrate & GOP length of the ONVIF profile's video encoder.
+	getVideoEncoderCommand = "get-video-encoder"
+	// setVideoEncoderCommand changes the video encoder settings of the ONVIF profile, e.g. from H265 to H264.
+	setVideoEncoderCommand = "set-video-encoder"
+	// ptzContinuousMoveCommand pans, tilts & zooms the camera at a velocity until it's stopped, through its ONVIF PTZ service.
+	ptzContinuousMoveCommand = "ptz-continuous-move"
+	// ptzRelativeMoveCommand pans, tilts & zooms the camera by a translation.
+	ptzRelativeMoveCommand = "ptz-relative-move"
+	// ptzAbsoluteMoveCommand pans, tilts & zooms the camera to a position.
+	ptzAbsoluteMoveCommand = "ptz-absolute-move"
+	// ptzStopCommand stops the pan, tilt & zoom movements of the camera.
+	ptzStopCommand = "ptz-stop"
+	// ptzGetPresetsCommand returns the preset positions of the camera.
+	ptzGetPresetsCommand = "ptz-get-presets"
+	// ptzGotoPresetCommand moves the camera to a preset position.
+	ptzGotoPresetCommand = "ptz-goto-preset"
+	// ptzSetPresetCommand saves the current position of the camera as a preset.
+	ptzSetPresetCommand = "ptz-set-preset"
+	// rebootCommand reboots the camera through its ONVIF device service, e.g. when its stream wedged.
+	rebootCommand = "reboot"
+	// startProfilingCommand clears the timings of the frame pipeline stages & starts timing them.
+	startProfilingCommand = "start-profiling"
+	// stopProfilingCommand stops timing the frame pipeline stages & returns their timings.
+	stopProfilingCommand = "stop-profiling"
+	// getProfileCommand returns the timings of the frame pipeline stages since profiling was started.
+	getProfileCommand = "get-profile"
+	// attachSinkCommand attaches the decoder, passthrough or recorder sink to the stream.
+	attachSinkCommand = "attach-sink"
+	// detachSinkCommand detaches the decoder, passthrough or recorder sink from the stream, without affecting the others.
+	detachSinkCommand = "detach-sink"
+	// saveCommand saves a recorded clip to the upload path, to be uploaded by the data manager.
+	saveCommand = "save"
+	// fetchCommand returns a recorded clip.
+	fetchCommand = "fetch"
+)
+
+// DoCommand handles the module specific commands supported by the camera.
+func (rc *rtspCamera) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	name, ok := cmd[commandKey].(string)
+	if !ok {
+		return nil, errors.Errorf("DoCommand requires a string '%s' field", commandKey)
+	}
+	switch name {
+	case updateCredentialsCommand:
+		return rc.updateCredentials(cmd)
+	case reconnectCommand:
+		return rc.reconnect()
+	case pauseCommand:
+		return rc.pause()
+	case resumeCommand:
+		return rc.resume()
+	case getFrameMetadataCommand:
+		return rc.getFrameMetadata(ctx, cmd)
+	case getFrameAtCommand:
+		return rc.getFrameAt(ctx, cmd)
+	case getMetricsCommand:
+		return rc.metrics.snapshot(time.Now()), nil
+	case getStreamInfoCommand:
+		return rc.getStreamInfo()
+	case getVideoPropertiesCommand:
+		return rc.getVideoProperties()
+	case getRTCPStatsCommand:
+		return rc.getRTCPStats()
+	case listSubscribersCommand:
+		return rc.listSubscribers()
+	case getDecodeErrorsCommand:
+		return rc.decodeErrors.snapshot(time.Now()), nil
+	case getSEICommand:
+		return rc.sei.snapshot(), nil
+	case getMetadataCommand:
+		return rc.metadata.snapshot(), nil
+	case getImagingSettingsCommand:
+		return rc.getImagingSettings(ctx)
+	case setImagingSettingsCommand:
+		return rc.setImagingSettings(ctx, cmd)
+	case getDeviceInfoCommand:
+		return rc.getDeviceInfo(ctx)
+	case getDateTimeCommand:
+		return rc.getDateTime(ctx, getDateTimeCommand)
+	case setDateTimeCommand:
+		return rc.setDateTime(ctx, cmd)
+	case rebootCommand:
+		return rc.reboot(ctx)
+	case getVideoEncoderCommand:
+		return rc.getVideoEncoder(ctx)
+	case setVideoEncoderCommand:
+		return rc.setVideoEncoder(ctx, cmd)
+	case ptzContinuousMoveCommand, ptzRelativeMoveCommand, ptzAbsoluteMoveCommand:
+		return rc.ptzMove(ctx, cmd, name)
+	case ptzStopCommand:
+		return rc.ptzStop(ctx)
+	case ptzGetPresetsCommand:
+		return rc.ptzGetPresets(ctx)
+	case ptzGotoPresetCommand:
+		return rc.ptzGotoPreset(ctx, cmd)
+	case ptzSetPresetCommand:
+		return rc.ptzSetPreset(ctx, cmd)
+	case startProfilingCommand:
+		return rc.startProfiling()
+	case stopProfilingCommand:
+		rc.profile.stopProfiling(time.Now())
+		return rc.profile.snapshot(time.Now()), nil
+	case getProfileCommand:
+		return rc.profile.snapshot(time.Now()), nil
+	case attachSinkCommand:
+		return rc.sinkCommand(cmd, true)
+	case detachSinkCommand:
+		return rc.sinkCommand(cmd, false)
+	case saveCommand:
+		return rc.saveClip(cmd)
+	case fetchCommand:
+		return rc.fetchClip(cmd)
+	default:
+		return nil, errors.Errorf("unknown command '%s'", name)
+	}
+}
+
+// updateCredentials swaps the credentials used for the stream without recreating the resource.
+// The new credentials are used on the next reconnect, or immediately if `reconnect` is true.
+// Passthrough subscriptions are kept across the reconnect as long as the codec is unchanged.
+// They're kept across Reconfigure, unless the config changes the credentials.
+func (rc *rtspCamera) updateCredentials(cmd map[string]interface{}) (map[string]interface{}, error) {
+	username, ok := cmd["username"].(string)
+	if !ok || username == "" {
+		return nil, errors.Errorf("%s requires a non empty string 'username' field", updateCredentialsCommand)
+	}
+	password, ok := cmd["password"].(string)
+	if !ok {
+		return nil, errors.Errorf("%s requires a string 'password' field", updateCredentialsCommand)
+	}
+	reconnect, _ := cmd["reconnect"].(bool)
+
+	rc.setCredentials(url.UserPassword(username, password))
+	rc.logger.Infof("updated credentials for rtsp stream %s", rc.redactedURL())
+
+	if reconnect {
+		rc.requestReconnect()
+	}
+	return map[string]interface{}{"reconnecting": reconnect}, nil
+}
+
+// reconnect asks the reconnect worker to re-establish the session, even if the OPTIONS keepalive succeeds
+// or reconnects were given up on. Passthrough subscriptions are kept as long as the codec is unchanged.
+func (rc *rtspCamera) reconnect() (map[string]interface{}, error) {
+	if rc.closed() {
+		return nil, errCameraClosed
+	}
+	rc.requestReconnect()
+	return map[string]interface{}{"reconnecting": true}, nil
+}
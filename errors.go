@@ -0,0 +1,21 @@
+package viamrtsp
+
+// causeError attaches one of the exported errors describing why connecting failed, e.g. ErrUnauthorized, to
+// err, so that supervising code can branch on the cause with errors.Is while the message keeps the details.
+type causeError struct {
+	cause error
+	err   error
+}
+
+// withCause returns err with cause attached.
+func withCause(cause, err error) error {
+	return &causeError{cause: cause, err: err}
+}
+
+func (e *causeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *causeError) Unwrap() []error {
+	return []error{e.err, e.cause}
+}
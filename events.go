@@ -0,0 +1,305 @@
+package viamrtsp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/utils"
+)
+
+const (
+	onvifEventsNamespace = "http://www.onvif.org/ver10/events/wsdl"
+	// wsnNamespace is the namespace of the WS-BaseNotification requests of pull point subscriptions.
+	wsnNamespace = "http://docs.oasis-open.org/wsn/b-2"
+	// eventsSubscriptionTime is how long a pull point subscription lasts unless it's renewed, which it's at half of.
+	eventsSubscriptionTime = time.Minute
+	// eventsPullTimeout is how long the camera holds a PullMessages request open while it has no events.
+	eventsPullTimeout = 5 * time.Second
+	// eventsMessageLimit is the number of events pulled at most per PullMessages request.
+	eventsMessageLimit = 100
+)
+
+// ModelEvents reports the ONVIF events of an RTSP camera, e.g. motion & tamper detection, as sensor readings.
+var ModelEvents = family.WithModel("onvif-events")
+
+func init() {
+	resource.RegisterComponent(sensor.API, ModelEvents, resource.Registration[sensor.Sensor, *EventsConfig]{
+		Constructor: newONVIFEventsSensor,
+	})
+}
+
+// EventsConfig are the config attributes for the ONVIF events model.
+type EventsConfig struct {
+	// Camera is the name of the RTSP camera whose events are reported. The camera must have the `onvif` attribute.
+	Camera string `json:"camera"`
+}
+
+// Validate checks to see if the attributes of the model are valid.
+func (conf *EventsConfig) Validate(path string) ([]string, error) {
+	if conf.Camera == "" {
+		return nil, fmt.Errorf("camera is required for component at path '%s'", path)
+	}
+	return []string{conf.Camera}, nil
+}
+
+// onvifEvent is the latest state of an ONVIF event property, e.g. the motion of a video source.
+type onvifEvent struct {
+	topic   string
+	source  map[string]interface{}
+	data    map[string]interface{}
+	utcTime string
+}
+
+// onvifEvents pulls the events of a camera from a pull point subscription of its ONVIF events service, which
+// is renewed while it's used & recreated if it fails.
+type onvifEvents struct {
+	resource.AlwaysRebuild
+	resource.Named
+
+	cam    *rtspCamera
+	logger logging.Logger
+
+	mu         sync.Mutex
+	events     map[string]onvifEvent
+	subscribed bool
+
+	cancel                  context.CancelFunc
+	activeBackgroundWorkers sync.WaitGroup
+}
+
+func newONVIFEventsSensor(_ context.Context, _ resource.Dependencies, conf resource.Config, logger logging.Logger) (sensor.Sensor, error) {
+	newConf, err := resource.NativeConfig[*EventsConfig](conf)
+	if err != nil {
+		return nil, err
+	}
+	cam, ok := lookupCamera(newConf.Camera)
+	if !ok {
+		return nil, errors.Errorf("camera '%s' is not an RTSP camera of this module", newConf.Camera)
+	}
+	if cam.onvif.Load() == nil {
+		return nil, errors.Errorf("camera '%s' requires the onvif config attribute", newConf.Camera)
+	}
+	return newONVIFEvents(conf.ResourceName().AsNamed(), cam, logger), nil
+}
+
+func newONVIFEvents(named resource.Named, cam *rtspCamera, logger logging.Logger) *onvifEvents {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &onvifEvents{Named: named, cam: cam, logger: logger, events: map[string]onvifEvent{}, cancel: cancel}
+	e.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(func() { e.run(ctx) }, e.activeBackgroundWorkers.Done)
+	return e
+}
+
+// run subscribes to the events of the camera until ctx is canceled, retrying with the reconnect backoff.
+func (e *onvifEvents) run(ctx context.Context) {
+	policy := reconnectPolicy{interval: defaultReconnectInterval, maxBackoff: defaultMaxBackoff}
+	failures := 0
+	for {
+		subscribed, err := e.pull(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if subscribed {
+			failures = 0
+		}
+		failures++
+		e.logger.Warnf("onvif event subscription failed, retrying, err: %s", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(policy.delay(failures)):
+		}
+	}
+}
+
+// pull creates a pull point subscription & stores the events pulled from it until the subscription fails or ctx
+// is canceled, returning whether it subscribed.
+func (e *onvifEvents) pull(ctx context.Context) (bool, error) {
+	_, client, err := e.cam.deviceClient(ModelEvents.Name)
+	if err != nil {
+		return false, err
+	}
+	eventsURL := client.service(client.services(ctx), onvifEventsNamespace)
+	subscription, err := client.createPullPoint(ctx, eventsURL)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		unsubscribeCtx, cancel := context.WithTimeout(context.Background(), onvifTimeout)
+		defer cancel()
+		if err := client.wsnCall(unsubscribeCtx, subscription, `<Unsubscribe xmlns="`+wsnNamespace+`"/>`); err != nil {
+			e.logger.Debugf("unable to unsubscribe from onvif events, err: %s", err)
+		}
+		e.mu.Lock()
+		e.subscribed = false
+		e.mu.Unlock()
+	}()
+	// the camera sends the current state of every property once subscribed
+	e.mu.Lock()
+	e.events, e.subscribed = map[string]onvifEvent{}, true
+	e.mu.Unlock()
+
+	renewAt := time.Now().Add(eventsSubscriptionTime / 2)
+	for ctx.Err() == nil {
+		if time.Now().After(renewAt) {
+			renew := `<Renew xmlns="` + wsnNamespace + `"><TerminationTime>` + onvifDuration(eventsSubscriptionTime) +
+				`</TerminationTime></Renew>`
+			if err := client.wsnCall(ctx, subscription, renew); err != nil {
+				return true, errors.Wrap(err, "renewing the ONVIF event subscription")
+			}
+			renewAt = time.Now().Add(eventsSubscriptionTime / 2)
+		}
+		notifications, err := client.pullMessages(ctx, subscription)
+		if err != nil {
+			return true, err
+		}
+		e.store(notifications)
+	}
+	return true, ctx.Err()
+}
+
+// store updates the events with notifications, keyed by topic & source, e.g. the motion of each video source.
+func (e *onvifEvents) store(notifications []onvifNotification) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, n := range notifications {
+		event := onvifEvent{
+			topic:   strings.TrimSpace(n.Topic),
+			source:  simpleItems(n.Message.Source),
+			data:    simpleItems(n.Message.Data),
+			utcTime: n.Message.UtcTime,
+		}
+		items := make([]string, 0, len(n.Message.Source))
+		for _, item := range n.Message.Source {
+			items = append(items, item.Name+"="+item.Value)
+		}
+		sort.Strings(items)
+		key := event.topic + "|" + strings.Join(items, ",")
+		if n.Message.PropertyOperation == "Deleted" {
+			delete(e.events, key)
+			continue
+		}
+		e.events[key] = event
+	}
+}
+
+// eventState returns whether the data of an event is active, from the first of keys which is a bool.
+func eventState(data map[string]interface{}, keys ...string) (bool, bool) {
+	for _, key := range keys {
+		if v, ok := data[key].(string); ok {
+			if active, err := strconv.ParseBool(v); err == nil {
+				return active, true
+			}
+		}
+	}
+	return false, false
+}
+
+// Readings returns whether the camera detects motion & tampering in any video source, the state of its digital
+// inputs by token, & the latest state of each event.
+func (e *onvifEvents) Readings(_ context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	motion, tamper := false, false
+	inputs := map[string]interface{}{}
+	keys := make([]string, 0, len(e.events))
+	for key := range e.events {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	events := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		event := e.events[key]
+		topic := strings.ToLower(event.topic)
+		switch {
+		case strings.Contains(topic, "tamper") || strings.Contains(topic, "globalscenechange"):
+			if active, ok := eventState(event.data, "IsTamper", "State"); ok {
+				tamper = tamper || active
+			}
+		case strings.Contains(topic, "motion"):
+			if active, ok := eventState(event.data, "IsMotion", "State"); ok {
+				motion = motion || active
+			}
+		case strings.Contains(topic, "digitalinput"):
+			if active, ok := eventState(event.data, "LogicalState"); ok {
+				token, _ := event.source["InputToken"].(string)
+				inputs[token] = active
+			}
+		}
+		events = append(events, map[string]interface{}{
+			"topic":    event.topic,
+			"source":   event.source,
+			"data":     event.data,
+			"utc_time": event.utcTime,
+		})
+	}
+	return map[string]interface{}{
+		"subscribed":     e.subscribed,
+		"motion":         motion,
+		"tamper":         tamper,
+		"digital_inputs": inputs,
+		"events":         events,
+	}, nil
+}
+
+// DoCommand is not supported by the events sensor.
+func (e *onvifEvents) DoCommand(_ context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
+	return nil, resource.ErrDoUnimplemented
+}
+
+// Close unsubscribes from the events of the camera.
+func (e *onvifEvents) Close(_ context.Context) error {
+	e.cancel()
+	e.activeBackgroundWorkers.Wait()
+	return nil
+}
+
+// onvifDuration returns d as an xs:duration in seconds.
+func onvifDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
+
+// createPullPoint creates a pull point subscription to the events of the camera, & returns its address.
+func (c *onvifClient) createPullPoint(ctx context.Context, eventsURL string) (string, error) {
+	var res struct {
+		Address string `xml:"Body>CreatePullPointSubscriptionResponse>SubscriptionReference>Address"`
+	}
+	body := `<CreatePullPointSubscription xmlns="` + onvifEventsNamespace + `"><InitialTerminationTime>` +
+		onvifDuration(eventsSubscriptionTime) + `</InitialTerminationTime></CreatePullPointSubscription>`
+	if err := c.call(ctx, eventsURL, body, &res); err != nil {
+		return "", errors.Wrap(err, "creating an ONVIF event subscription")
+	}
+	address := strings.TrimSpace(res.Address)
+	if address == "" {
+		return "", errors.New("the camera returned no ONVIF event subscription address")
+	}
+	return address, nil
+}
+
+// pullMessages returns the events of the subscription since the last pull, waiting up to eventsPullTimeout for one.
+func (c *onvifClient) pullMessages(ctx context.Context, subscription string) ([]onvifNotification, error) {
+	var res struct {
+		Notifications []onvifNotification `xml:"Body>PullMessagesResponse>NotificationMessage"`
+	}
+	body := `<PullMessages xmlns="` + onvifEventsNamespace + `"><Timeout>` + onvifDuration(eventsPullTimeout) +
+		`</Timeout><MessageLimit>` + strconv.Itoa(eventsMessageLimit) + `</MessageLimit></PullMessages>`
+	if err := c.call(ctx, subscription, body, &res); err != nil {
+		return nil, errors.Wrap(err, "pulling ONVIF events")
+	}
+	return res.Notifications, nil
+}
+
+// wsnCall sends the WS-BaseNotification request body, e.g. Renew or Unsubscribe, to the subscription.
+func (c *onvifClient) wsnCall(ctx context.Context, subscription, body string) error {
+	var res struct{}
+	return c.call(ctx, subscription, body, &res)
+}
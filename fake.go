@@ -0,0 +1,304 @@
+package viamrtsp
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/bluenviron/gortsplib/v4/pkg/rtptime"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/utils"
+)
+
+const (
+	defaultFakeWidth  = 640
+	defaultFakeHeight = 480
+	defaultFakeFPS    = 15
+	maxFakeFPS        = 60
+	// maxFakePixels bounds the size of the uncompressed keyframes of the fake stream.
+	maxFakePixels = 1920 * 1088
+	// fakeWriteQueueSize is how many packets the server of the fake stream buffers for each reader,
+	// which must fit the packets of a keyframe.
+	fakeWriteQueueSize  = 4096
+	fakeTimestampFormat = "2006-01-02 15:04:05.000"
+)
+
+// ModelFake streams synthetic H264 frames generated by the module, for developing without a camera.
+var ModelFake = family.WithModel("rtsp-fake")
+
+func init() {
+	resource.RegisterComponent(camera.API, ModelFake, resource.Registration[camera.Camera, *FakeConfig]{
+		Constructor: newFakeCamera,
+	})
+}
+
+// FakeConfig are the config attributes for the fake model.
+type FakeConfig struct {
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	FPS            float64 `json:"fps,omitempty"`
+	RTPPassthrough bool    `json:"rtp_passthrough,omitempty"`
+}
+
+// Validate checks to see if the attributes of the model are valid.
+func (conf *FakeConfig) Validate(path string) ([]string, error) {
+	width, height := conf.size()
+	if width <= 0 || height <= 0 || width%16 != 0 || height%16 != 0 {
+		return nil, fmt.Errorf("invalid size %dx%d for component at path '%s': width & height must be positive multiples of 16",
+			width, height, path)
+	}
+	if width*height > maxFakePixels {
+		return nil, fmt.Errorf("invalid size %dx%d for component at path '%s': must be at most %d pixels",
+			width, height, path, maxFakePixels)
+	}
+	if conf.FPS < 0 || conf.FPS > maxFakeFPS {
+		return nil, fmt.Errorf("invalid fps %v for component at path '%s': must be between 0 & %d", conf.FPS, path, maxFakeFPS)
+	}
+	return nil, nil
+}
+
+func (conf *FakeConfig) size() (int, int) {
+	width, height := conf.Width, conf.Height
+	if width == 0 {
+		width = defaultFakeWidth
+	}
+	if height == 0 {
+		height = defaultFakeHeight
+	}
+	return width, height
+}
+
+// fakeCamera is an RTSP camera streaming from a fakeStream, so that it behaves like the other models,
+// e.g. supporting rtp_passthrough.
+type fakeCamera struct {
+	*rtspCamera
+	stream *fakeStream
+}
+
+func newFakeCamera(ctx context.Context, _ resource.Dependencies, conf resource.Config, logger logging.Logger) (camera.Camera, error) {
+	newConf, err := resource.NativeConfig[*FakeConfig](conf)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+	width, height := newConf.size()
+	fps := newConf.FPS
+	if fps == 0 {
+		fps = defaultFakeFPS
+	}
+	stream, err := startFakeStream(width, height, fps, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+	rc, err := newRTSPCameraFromConfig(ctx, conf.ResourceName(), ModelH264, &Config{
+		Address:        stream.url(),
+		Transport:      "tcp",
+		RTPPassthrough: newConf.RTPPassthrough,
+	}, nil, logger)
+	if err != nil {
+		stream.close()
+		return nil, err
+	}
+	return &fakeCamera{rtspCamera: rc, stream: stream}, nil
+}
+
+// Reconfigure always rebuilds the camera, as the fake stream can't be changed while it's streamed.
+func (fc *fakeCamera) Reconfigure(_ context.Context, _ resource.Dependencies, conf resource.Config) error {
+	return resource.NewMustRebuildError(conf.ResourceName())
+}
+
+// Close closes the camera & stops the fake stream.
+func (fc *fakeCamera) Close(ctx context.Context) error {
+	err := fc.rtspCamera.Close(ctx)
+	fc.stream.close()
+	return err
+}
+
+// fakeStream publishes synthetic H264 frames, color bars & the time the frame was generated at,
+// on an RTSP server listening on the loopback interface.
+type fakeStream struct {
+	relay   *relayServer
+	addr    string
+	media   *description.Media
+	encoder *h264PCMEncoder
+	rtpEnc  *rtph264.Encoder
+	rtpTime *rtptime.Encoder
+	logger  logging.Logger
+
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+func startFakeStream(width, height int, fps float64, logger logging.Logger) (*fakeStream, error) {
+	// a keyframe is sent every second, so that readers start decoding quickly
+	encoder := newH264PCMEncoder(width, height, int(math.Ceil(fps)))
+	forma := &format.H264{PayloadTyp: 96, PacketizationMode: 1, SPS: encoder.sps(), PPS: encoder.pps()}
+	rtpEnc, err := forma.CreateEncoder()
+	if err != nil {
+		return nil, err
+	}
+	s := &fakeStream{
+		media:   &description.Media{Type: description.MediaTypeVideo, Formats: []format.Format{forma}},
+		encoder: encoder,
+		rtpEnc:  rtpEnc,
+		rtpTime: &rtptime.Encoder{ClockRate: forma.ClockRate()},
+		logger:  logger,
+	}
+	if err := s.rtpTime.Initialize(); err != nil {
+		return nil, err
+	}
+	s.relay, err = startRelayServer(&gortsplib.Server{
+		RTSPAddress:    "127.0.0.1:0",
+		WriteQueueSize: fakeWriteQueueSize,
+		Listen: func(network, address string) (net.Listener, error) {
+			l, err := net.Listen(network, address)
+			if err != nil {
+				return nil, err
+			}
+			s.addr = l.Addr().String()
+			return l, nil
+		},
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	s.relay.publish([]*description.Media{s.media})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.workers.Add(1)
+	utils.ManagedGo(func() {
+		s.run(ctx, image.Rect(0, 0, width, height), time.Duration(float64(time.Second)/fps))
+	}, s.workers.Done)
+	return s, nil
+}
+
+func (s *fakeStream) url() string {
+	return "rtsp://" + s.addr + "/fake"
+}
+
+// run generates & publishes a frame every interval until ctx is done.
+func (s *fakeStream) run(ctx context.Context, bounds image.Rectangle, interval time.Duration) {
+	img := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio420)
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+		drawFakeFrame(img, now)
+		pkts, err := s.rtpEnc.Encode(s.encoder.encode(img))
+		if err != nil {
+			s.logger.Debugf("unable to packetize fake frame, err: %s", err)
+			continue
+		}
+		ts := s.rtpTime.Encode(now.Sub(start))
+		for _, pkt := range pkts {
+			pkt.Timestamp = ts
+			s.relay.writePacketRTP(s.media, pkt)
+		}
+	}
+}
+
+func (s *fakeStream) close() {
+	s.cancel()
+	s.workers.Wait()
+	s.relay.close()
+}
+
+// fakeBars are the 75% SMPTE color bars, as RGB.
+var fakeBars = [][3]float64{
+	{191, 191, 191}, {191, 191, 0}, {0, 191, 191}, {0, 191, 0}, {191, 0, 191}, {191, 0, 0}, {0, 0, 191},
+}
+
+// drawFakeFrame draws color bars with a timestamp of t in the bottom left corner to img.
+func drawFakeFrame(img *image.YCbCr, t time.Time) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	for x := 0; x < width; x++ {
+		yy, _, _ := limitedRangeYCbCr(fakeBars[x*len(fakeBars)/width])
+		for y := 0; y < height; y++ {
+			img.Y[y*img.YStride+x] = yy
+		}
+	}
+	for x := 0; x < width/2; x++ {
+		_, cb, cr := limitedRangeYCbCr(fakeBars[2*x*len(fakeBars)/width])
+		for y := 0; y < height/2; y++ {
+			img.Cb[y*img.CStride+x] = cb
+			img.Cr[y*img.CStride+x] = cr
+		}
+	}
+
+	text := t.Format(fakeTimestampFormat)
+	// glyphs are drawn in cells of 6x9 pixels, scaled to a readable size
+	scale := max(1, min(height/120, width/((len(text)+1)*6)))
+	box := image.Rect(0, 0, (len(text)*6+1)*scale, 9*scale).Add(image.Pt(2*scale, height-11*scale))
+	if !box.In(img.Rect) {
+		return
+	}
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		for x := box.Min.X; x < box.Max.X; x++ {
+			img.Y[y*img.YStride+x] = 16
+			img.Cb[y/2*img.CStride+x/2] = 128
+			img.Cr[y/2*img.CStride+x/2] = 128
+		}
+	}
+	for i, r := range text {
+		glyph := fakeFont[r]
+		for row := 0; row < 7; row++ {
+			for col := 0; col < 5; col++ {
+				if glyph[row]>>(4-col)&1 == 0 {
+					continue
+				}
+				x0 := box.Min.X + (i*6+col+1)*scale
+				y0 := box.Min.Y + (row+1)*scale
+				for y := y0; y < y0+scale; y++ {
+					for x := x0; x < x0+scale; x++ {
+						img.Y[y*img.YStride+x] = 235
+					}
+				}
+			}
+		}
+	}
+}
+
+// limitedRangeYCbCr converts an RGB color to BT.601 limited range YCbCr, which decoders assume
+// when the stream doesn't specify its color range.
+func limitedRangeYCbCr(rgb [3]float64) (uint8, uint8, uint8) {
+	r, g, b := rgb[0], rgb[1], rgb[2]
+	y := 16 + (65.738*r+129.057*g+25.064*b)/256
+	cb := 128 + (-37.945*r-74.494*g+112.439*b)/256
+	cr := 128 + (112.439*r-94.154*g-18.285*b)/256
+	return uint8(math.Round(y)), uint8(math.Round(cb)), uint8(math.Round(cr))
+}
+
+// fakeFont is a 5x7 pixel font of the characters of timestamps, each row's pixels are the 5 low bits.
+var fakeFont = map[rune][7]uint8{
+	'0': {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1': {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2': {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3': {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4': {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5': {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6': {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8': {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9': {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'-': {0, 0, 0, 0b11111, 0, 0, 0},
+	':': {0, 0b01100, 0b01100, 0, 0b01100, 0b01100, 0},
+	'.': {0, 0, 0, 0, 0, 0b01100, 0b01100},
+}
@@ -0,0 +1,80 @@
+package viamrtsp
+
+import (
+	"context"
+	"image"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/test"
+)
+
+func TestFakeConfig(t *testing.T) {
+	_, err := (&FakeConfig{}).Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+	_, err = (&FakeConfig{Width: 1280, Height: 720, FPS: 30}).Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+
+	_, err = (&FakeConfig{Width: 650}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "multiples of 16")
+	_, err = (&FakeConfig{Width: 3840, Height: 2160}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "at most")
+	_, err = (&FakeConfig{FPS: -1}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "invalid fps")
+}
+
+func TestDrawFakeFrame(t *testing.T) {
+	img := image.NewYCbCr(image.Rect(0, 0, 640, 480), image.YCbCrSubsampleRatio420)
+	now := time.Date(2024, 5, 1, 12, 30, 15, 0, time.Local)
+	drawFakeFrame(img, now)
+
+	// the first bar is 75% white & the last one is blue
+	test.That(t, img.YCbCrAt(0, 0).Y, test.ShouldEqual, uint8(180))
+	test.That(t, img.YCbCrAt(0, 0).Cb, test.ShouldEqual, uint8(128))
+	blue := img.YCbCrAt(639, 0)
+	test.That(t, blue.Cb, test.ShouldBeGreaterThan, uint8(200))
+	test.That(t, blue.Cr, test.ShouldBeLessThan, uint8(128))
+
+	// only the timestamp changes between frames
+	prev := cloneYCbCr(img, nil)
+	drawFakeFrame(img, now.Add(time.Millisecond))
+	changed := image.Rectangle{}
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			if img.Y[y*img.YStride+x] != prev.Y[y*prev.YStride+x] {
+				changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	test.That(t, changed.Empty(), test.ShouldBeFalse)
+	test.That(t, changed.Min.Y, test.ShouldBeGreaterThan, 400)
+}
+
+func TestFakeCamera(t *testing.T) {
+	SetLibAVLogLevelFatal()
+	logger := logging.NewTestLogger(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	config := resource.NewEmptyConfig(camera.Named("fake"), ModelFake)
+	config.ConvertedAttributes = &FakeConfig{Width: 320, Height: 240, FPS: 10, RTPPassthrough: true}
+	cam, err := newFakeCamera(ctx, nil, config, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() { test.That(t, cam.Close(context.Background()), test.ShouldBeNil) }()
+
+	im := waitForImage(t, cam)
+	test.That(t, im.Bounds(), test.ShouldResemble, image.Rect(0, 0, 320, 240))
+	// the last bar is blue
+	r, g, b, _ := im.At(315, 10).RGBA()
+	test.That(t, r>>8, test.ShouldBeLessThan, 50)
+	test.That(t, g>>8, test.ShouldBeLessThan, 50)
+	test.That(t, b>>8, test.ShouldBeGreaterThan, 150)
+
+	test.That(t, cam.(*fakeCamera).validateSupportsPassthrough(), test.ShouldBeNil)
+	test.That(t, cam.Reconfigure(ctx, nil, config), test.ShouldNotBeNil)
+}
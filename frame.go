@@ -0,0 +1,407 @@
+package viamrtsp
+
+import (
+	"context"
+	"encoding/base64"
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/pion/rtp"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/gostream"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/rdk/rimage"
+	rutils "go.viam.com/rdk/utils"
+)
+
+// maxFrameHistory bounds frame_history, as every frame held is a copy of a decoded frame.
+const maxFrameHistory = 300
+
+// frame is a decoded image along with metadata about when it was captured & received.
+type frame struct {
+	img        image.Image
+	seq        uint64
+	receivedAt time.Time
+	// capturedAt is derived from RTCP sender reports when the server sends them,
+	// otherwise it is the time the frame's last packet was received.
+	capturedAt time.Time
+}
+
+// droppedSince returns the number of frames decoded between the frame numbered lastSeq, which a consumer read
+// before, & the frame numbered seq, so that each consumer can detect the gaps in its own sampling.
+// Reading the same frame twice, or a frame without a sequence number like a snapshot, is no drop.
+func droppedSince(seq, lastSeq uint64) uint64 {
+	if lastSeq == 0 || seq <= lastSeq+1 {
+		return 0
+	}
+	return seq - lastSeq - 1
+}
+
+// packetTime returns the wall clock capture time of pkt, mapped from its RTP timestamp
+// using RTCP sender reports, falling back to the current time if no report was received yet.
+func (rc *rtspCamera) packetTime(media *description.Media, pkt *rtp.Packet) time.Time {
+	if ntp, ok := rc.client.PacketNTP(media, pkt); ok {
+		return ntp
+	}
+	return time.Now()
+}
+
+// storeFrame makes img the latest frame, assigning it the next sequence number. With frame_history, img is
+// copied so that the older frames aren't overwritten by the decoder.
+func (rc *rtspCamera) storeFrame(img image.Image, capturedAt time.Time) {
+	seq := rc.frameSeq.Add(1)
+	if rc.frames.keepsHistory() {
+		img = cloneImage(img)
+	}
+	f := &frame{img: img, seq: seq, receivedAt: time.Now(), capturedAt: capturedAt}
+	rc.metrics.frameDecoded(f.receivedAt)
+	rc.frames.add(f)
+	if rc.onFrame != nil {
+		rc.onFrame(f)
+	}
+}
+
+// readFrame serves the latest frame and records its metadata, or the current GOP if MimeTypeH264 is requested.
+func (rc *rtspCamera) readFrame(ctx context.Context) (image.Image, func(), error) {
+	next := rc.nextFrame
+	if mimeType, _ := rutils.CheckLazyMIMEType(gostream.MIMETypeHint(ctx, "")); mimeType == MimeTypeH264 {
+		next = func(context.Context) (*frame, error) { return rc.h264Image() }
+	}
+	f, err := next(ctx)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	return f.img, func() {}, nil
+}
+
+// Images returns the latest frame along with the time it was captured, which is derived from
+// RTCP sender reports when the server sends them.
+func (rc *rtspCamera) Images(ctx context.Context) ([]camera.NamedImage, resource.ResponseMetadata, error) {
+	f, err := rc.nextFrame(ctx)
+	if err != nil {
+		return nil, resource.ResponseMetadata{}, err
+	}
+	return []camera.NamedImage{{Image: f.img}}, resource.ResponseMetadata{CapturedAt: f.capturedAt}, nil
+}
+
+// errDecodingDisabled is returned instead of an image when decode_frames is false & there's no snapshot to serve.
+var errDecodingDisabled = errors.New("no image available since decode_frames is false")
+
+// errDecoderDetached is returned instead of an image while the decoder sink is detached & there's no snapshot to serve.
+var errDecoderDetached = errors.New("no image available since the decoder sink is detached")
+
+// errCameraClosed is returned by image requests once the camera is closed, including requests which were waiting.
+var errCameraClosed = errors.New("camera is closed")
+
+// requestContext returns a context derived from ctx which is also canceled when the camera is closed,
+// so that image requests which wait, e.g. for decoding to catch up or for a snapshot, don't block Close.
+func (rc *rtspCamera) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if rc.closeCtx == nil {
+		// the camera was never started
+		return ctx, cancel
+	}
+	stop := context.AfterFunc(rc.closeCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// closed returns true once the camera is closed.
+func (rc *rtspCamera) closed() bool {
+	return rc.closeCtx != nil && rc.closeCtx.Err() != nil
+}
+
+// nextFrame returns the frame to serve. If snapshot_fallback is enabled
+// and no frame was decoded recently, a still from the snapshot URL is served instead.
+func (rc *rtspCamera) nextFrame(ctx context.Context) (*frame, error) {
+	if rc.closed() {
+		return nil, errCameraClosed
+	}
+	ctx, cancel := rc.requestContext(ctx)
+	defer cancel()
+	latest := rc.markImageRequested(ctx)
+	if snapshots := rc.snapshots.Load(); snapshots != nil && frameIsStale(latest, time.Now()) {
+		img, err := snapshots.fetch(ctx)
+		if err == nil {
+			now := time.Now()
+			return &frame{img: img, receivedAt: now, capturedAt: now}, nil
+		}
+		rc.logger.Debugf("unable to fetch snapshot, err: %s", err)
+	}
+	if rc.closed() {
+		return nil, errCameraClosed
+	}
+	if err := rc.health.notReady(); err != nil {
+		return nil, err
+	}
+	if rc.sinkDetached(decoderSink) {
+		return nil, errDecoderDetached
+	}
+	if latest == nil {
+		if !rc.decodeFrames.Load() {
+			return nil, errDecodingDisabled
+		}
+		return nil, errors.New("no frame yet")
+	}
+	if maxAge := time.Duration(rc.maxFrameAge.Load()); maxAge > 0 {
+		if age := time.Since(latest.receivedAt); age > maxAge {
+			return nil, errors.Wrapf(ErrStaleFrame, "received %s ago, max_frame_age_ms is %d", age, maxAge.Milliseconds())
+		}
+	}
+	return latest, nil
+}
+
+// getFrameMetadata serves the latest frame like an image request & returns its metadata, with the frame itself
+// if 'include_image' is set, encoded as the optional 'mime_type' of cmd, JPEG by default. The frames dropped are
+// counted since the frame numbered 'last_sequence', the sequence of the caller's previous read, so that callers
+// sampling the same camera don't affect each other's count.
+func (rc *rtspCamera) getFrameMetadata(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	var lastSeq uint64
+	if v, ok := cmd["last_sequence"]; ok {
+		n, ok := v.(float64)
+		if !ok || n < 0 || n != math.Trunc(n) {
+			return nil, errors.Errorf("%s requires 'last_sequence' to be a non-negative integer, got %v", getFrameMetadataCommand, v)
+		}
+		lastSeq = uint64(n)
+	}
+	f, err := rc.nextFrame(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]interface{}{
+		"sequence":                f.seq,
+		"dropped_since_last_read": droppedSince(f.seq, lastSeq),
+		"received_at":             f.receivedAt.Format(time.RFC3339Nano),
+		"captured_at":             f.capturedAt.Format(time.RFC3339Nano),
+	}
+	if include, _ := cmd["include_image"].(bool); include {
+		mimeType, _ := cmd["mime_type"].(string)
+		if mimeType == "" {
+			mimeType = rutils.MimeTypeJPEG
+		}
+		b, err := rimage.EncodeImage(ctx, f.img, mimeType)
+		if err != nil {
+			return nil, err
+		}
+		res["mime_type"] = mimeType
+		res["image"] = base64.StdEncoding.EncodeToString(b)
+	}
+	return res, nil
+}
+
+// getFrameAt returns the frame captured nearest to the RFC 3339 'time' of cmd, encoded as the optional 'mime_type'
+// of cmd, JPEG by default. It fails if the frame was captured more than the optional 'max_offset_ms' from the time.
+func (rc *rtspCamera) getFrameAt(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	s, ok := cmd["time"].(string)
+	if !ok {
+		return nil, errors.Errorf("%s requires a string 'time' field", getFrameAtCommand)
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, errors.Errorf("%s requires 'time' to be formatted as RFC3339, got %q", getFrameAtCommand, s)
+	}
+	f := rc.frames.nearest(t)
+	if f == nil {
+		return nil, errors.New("no frame yet")
+	}
+	offset := f.capturedAt.Sub(t)
+	if maxOffsetMs, ok := cmd["max_offset_ms"].(float64); ok && offset.Abs() > time.Duration(maxOffsetMs*float64(time.Millisecond)) {
+		return nil, errors.Errorf("the nearest frame was captured %s from %s, more than max_offset_ms", offset, s)
+	}
+	mimeType, _ := cmd["mime_type"].(string)
+	if mimeType == "" {
+		mimeType = rutils.MimeTypeJPEG
+	}
+	b, err := rimage.EncodeImage(ctx, f.img, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"mime_type":   mimeType,
+		"image":       base64.StdEncoding.EncodeToString(b),
+		"sequence":    f.seq,
+		"captured_at": f.capturedAt.Format(time.RFC3339Nano),
+		"received_at": f.receivedAt.Format(time.RFC3339Nano),
+		"offset_ms":   float64(offset) / float64(time.Millisecond),
+	}, nil
+}
+
+// frameHistory holds the most recent frames, oldest first. The zero value holds only the latest frame.
+type frameHistory struct {
+	mu     sync.Mutex
+	size   int
+	frames []*frame
+}
+
+func newFrameHistory(size int) *frameHistory {
+	return &frameHistory{size: size, frames: make([]*frame, 0, size)}
+}
+
+func (h *frameHistory) add(f *frame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if excess := len(h.frames) - max(h.size, 1) + 1; excess > 0 {
+		h.frames = append(h.frames[:0], h.frames[excess:]...)
+	}
+	h.frames = append(h.frames, f)
+}
+
+// resize changes the number of frames held, dropping the oldest frames beyond it. Growing the history copies
+// the held frames, which may share memory with the decoder.
+func (h *frameHistory) resize(size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.size <= 1 && size > 1 {
+		for i, f := range h.frames {
+			cp := *f
+			cp.img = cloneImage(f.img)
+			h.frames[i] = &cp
+		}
+	}
+	h.size = size
+	if excess := len(h.frames) - max(h.size, 1); excess > 0 {
+		n := copy(h.frames, h.frames[excess:])
+		clear(h.frames[n:])
+		h.frames = h.frames[:n]
+	}
+}
+
+// keepsHistory reports whether frames older than the latest are held, which must not share memory with the decoder.
+func (h *frameHistory) keepsHistory() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.size > 1
+}
+
+// reset drops the frames.
+func (h *frameHistory) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clear(h.frames)
+	h.frames = h.frames[:0]
+}
+
+// latest returns the most recent frame, or nil if there is none.
+func (h *frameHistory) latest() *frame {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.frames) == 0 {
+		return nil
+	}
+	return h.frames[len(h.frames)-1]
+}
+
+// nearest returns the frame captured closest to t, the newer one if two are equally close, or nil if there is none.
+func (h *frameHistory) nearest(t time.Time) *frame {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var best *frame
+	var bestDiff time.Duration
+	for _, f := range h.frames {
+		diff := f.capturedAt.Sub(t)
+		if diff < 0 {
+			diff = -diff
+		}
+		if best == nil || diff <= bestDiff {
+			best, bestDiff = f, diff
+		}
+	}
+	return best
+}
+
+// snapshot returns a copy of the frames currently in the history, oldest first.
+func (h *frameHistory) snapshot() []*frame {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*frame(nil), h.frames...)
+}
+
+// cloneImage returns a copy of img that does not share memory with it.
+// Decoded frames point at buffers the decoder reuses, so they must be
+// cloned before being kept around.
+func cloneImage(img image.Image) image.Image {
+	if _, ok := img.(*rimage.LazyEncodedImage); ok {
+		// encoded images own their bytes & are never modified
+		return img
+	}
+	if yuv, ok := img.(*image.YCbCr); ok {
+		return &image.YCbCr{
+			Y:              append([]uint8(nil), yuv.Y...),
+			Cb:             append([]uint8(nil), yuv.Cb...),
+			Cr:             append([]uint8(nil), yuv.Cr...),
+			YStride:        yuv.YStride,
+			CStride:        yuv.CStride,
+			SubsampleRatio: yuv.SubsampleRatio,
+			Rect:           yuv.Rect,
+		}
+	}
+	if gray, ok := img.(*image.Gray); ok {
+		return &image.Gray{
+			Pix:    append([]uint8(nil), gray.Pix...),
+			Stride: gray.Stride,
+			Rect:   gray.Rect,
+		}
+	}
+	if rgba, ok := img.(*image.RGBA); ok {
+		return &image.RGBA{
+			Pix:    append([]uint8(nil), rgba.Pix...),
+			Stride: rgba.Stride,
+			Rect:   rgba.Rect,
+		}
+	}
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, img, b.Min, draw.Src)
+	return out
+}
+
+// decodeThrottle limits the rate decoded frames are converted & stored at to max_decode_fps. Every access unit is
+// still decoded, as inter frames reference the frames before them, but converting frames, which copies them out of
+// the decoder & applies output_format, decode_scale & rotate_degrees, is skipped for the frames over the limit.
+// The zero value doesn't limit the rate.
+type decodeThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is when the next frame may be converted
+	next time.Time
+}
+
+// setMaxFPS limits the rate to maxFPS frames per second, no limit if maxFPS is not positive.
+func (t *decodeThrottle) setMaxFPS(maxFPS float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interval = 0
+	if maxFPS > 0 {
+		t.interval = time.Duration(float64(time.Second) / maxFPS)
+	}
+}
+
+// shouldConvert reports whether a frame decoded at now should be converted & stored.
+// A nil throttle converts every frame.
+func (t *decodeThrottle) shouldConvert(now time.Time) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.interval == 0 {
+		return true
+	}
+	if now.Before(t.next) {
+		return false
+	}
+	// frames are converted on a schedule of one per interval, so that the rate matches max_decode_fps even if it
+	// doesn't divide the frame rate. The schedule doesn't fall behind by more than an interval, e.g. while paused.
+	if earliest := now.Add(-t.interval); t.next.Before(earliest) {
+		t.next = earliest
+	}
+	t.next = t.next.Add(t.interval)
+	return true
+}
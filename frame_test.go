@@ -0,0 +1,242 @@
+package viamrtsp
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestFrameDropAccounting(t *testing.T) {
+	rc := &rtspCamera{}
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	getMetadata := func(cmd map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		cmd["command"] = getFrameMetadataCommand
+		res, err := rc.DoCommand(context.Background(), cmd)
+		test.That(t, err, test.ShouldBeNil)
+		return res
+	}
+
+	_, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": getFrameMetadataCommand})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// frames decoded before a caller's first read are not counted as dropped
+	rc.storeFrame(img, time.Now())
+	rc.storeFrame(img, time.Now())
+	res := getMetadata(map[string]interface{}{})
+	test.That(t, res["sequence"], test.ShouldEqual, uint64(2))
+	test.That(t, res["dropped_since_last_read"], test.ShouldEqual, uint64(0))
+	test.That(t, res["image"], test.ShouldBeNil)
+
+	// reading the same frame twice does not count as a drop
+	res = getMetadata(map[string]interface{}{"last_sequence": 2.0})
+	test.That(t, res["dropped_since_last_read"], test.ShouldEqual, uint64(0))
+
+	// frames 3, 4 & 5 are never served, & the count only depends on the caller's own last read
+	for i := 0; i < 4; i++ {
+		rc.storeFrame(img, time.Now())
+	}
+	res = getMetadata(map[string]interface{}{"last_sequence": 2.0, "include_image": true})
+	test.That(t, res["sequence"], test.ShouldEqual, uint64(6))
+	test.That(t, res["dropped_since_last_read"], test.ShouldEqual, uint64(3))
+	test.That(t, res["mime_type"], test.ShouldEqual, "image/jpeg")
+	test.That(t, res["image"], test.ShouldNotBeEmpty)
+	res = getMetadata(map[string]interface{}{"last_sequence": 5.0})
+	test.That(t, res["dropped_since_last_read"], test.ShouldEqual, uint64(0))
+
+	_, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": getFrameMetadataCommand, "last_sequence": -1.0})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "non-negative integer")
+}
+
+func TestDecodeThrottle(t *testing.T) {
+	var unlimited *decodeThrottle
+	test.That(t, unlimited.shouldConvert(time.Now()), test.ShouldBeTrue)
+	var throttle decodeThrottle
+	start := time.Now()
+	test.That(t, throttle.shouldConvert(start), test.ShouldBeTrue)
+	test.That(t, throttle.shouldConvert(start), test.ShouldBeTrue)
+
+	// converts 10 seconds of a 30 fps stream at the limit, which doesn't divide the frame rate
+	converted := func(maxFPS float64) int {
+		throttle.setMaxFPS(maxFPS)
+		start = start.Add(time.Hour)
+		var n int
+		for i := 0; i < 300; i++ {
+			if throttle.shouldConvert(start.Add(time.Duration(i) * time.Second / 30)) {
+				n++
+			}
+		}
+		return n
+	}
+	test.That(t, converted(25), test.ShouldBeBetweenOrEqual, 249, 251)
+	test.That(t, converted(2), test.ShouldBeBetweenOrEqual, 20, 21)
+	test.That(t, converted(0), test.ShouldEqual, 300)
+}
+
+func TestMaxFrameAge(t *testing.T) {
+	rc := &rtspCamera{}
+	rc.maxFrameAge.Store(int64(time.Second))
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	rc.storeFrame(img, time.Now())
+	_, _, err := rc.readFrame(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+
+	rc.frames.add(&frame{img: img, seq: 2, receivedAt: time.Now().Add(-2 * time.Second)})
+	_, _, err = rc.readFrame(context.Background())
+	test.That(t, errors.Is(err, ErrStaleFrame), test.ShouldBeTrue)
+}
+
+func TestDecodingDisabled(t *testing.T) {
+	rc := &rtspCamera{}
+	_, _, err := rc.Images(context.Background())
+	test.That(t, errors.Is(err, errDecodingDisabled), test.ShouldBeTrue)
+
+	rc.decodeFrames.Store(true)
+	_, _, err = rc.Images(context.Background())
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, errors.Is(err, errDecodingDisabled), test.ShouldBeFalse)
+}
+
+func TestCloseUnblocksImageRequests(t *testing.T) {
+	rc := &rtspCamera{}
+	rc.closeCtx, rc.closeCancel = context.WithCancel(context.Background())
+	// with lazy_decode idle, image requests wait for decoding to catch up
+	rc.lazyDecode.Store(true)
+	rc.storeFrame(image.NewRGBA(image.Rect(0, 0, 1, 1)), time.Now())
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := rc.readFrame(context.Background())
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	rc.closeCancel()
+	err := <-errCh
+	test.That(t, time.Since(start), test.ShouldBeLessThan, lazyDecodeWaitTimeout/2)
+	test.That(t, errors.Is(err, errCameraClosed), test.ShouldBeTrue)
+
+	_, _, err = rc.Images(context.Background())
+	test.That(t, errors.Is(err, errCameraClosed), test.ShouldBeTrue)
+}
+
+func TestImagesCapturedAt(t *testing.T) {
+	rc := &rtspCamera{}
+	_, _, err := rc.Images(context.Background())
+	test.That(t, err, test.ShouldNotBeNil)
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	capturedAt := time.Now().Add(-150 * time.Millisecond)
+	rc.storeFrame(img, capturedAt)
+	imgs, md, err := rc.Images(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, imgs, test.ShouldHaveLength, 1)
+	test.That(t, imgs[0].Image, test.ShouldEqual, img)
+	test.That(t, md.CapturedAt, test.ShouldEqual, capturedAt)
+}
+
+func TestFrameHistory(t *testing.T) {
+	rc := &rtspCamera{}
+	test.That(t, rc.frames.latest(), test.ShouldBeNil)
+	test.That(t, rc.frames.nearest(time.Now()), test.ShouldBeNil)
+
+	// only the latest frame is held by default, & it isn't copied
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	start := time.Now()
+	rc.storeFrame(img, start)
+	rc.storeFrame(img, start.Add(100*time.Millisecond))
+	test.That(t, rc.frames.snapshot(), test.ShouldHaveLength, 1)
+	test.That(t, rc.frames.latest().img, test.ShouldEqual, img)
+
+	// with frame_history the frames are copied, as the decoder reuses its buffers
+	rc.frames.resize(3)
+	for i := 2; i < 6; i++ {
+		rc.storeFrame(img, start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	frames := rc.frames.snapshot()
+	test.That(t, frames, test.ShouldHaveLength, 3)
+	test.That(t, frames[0].seq, test.ShouldEqual, uint64(4))
+	test.That(t, rc.frames.latest().seq, test.ShouldEqual, uint64(6))
+	test.That(t, rc.frames.latest().img != image.Image(img), test.ShouldBeTrue)
+	test.That(t, rc.frames.nearest(start.Add(420*time.Millisecond)).seq, test.ShouldEqual, uint64(5))
+	// the newer frame is preferred when two are equally close
+	test.That(t, rc.frames.nearest(start.Add(350*time.Millisecond)).seq, test.ShouldEqual, uint64(5))
+	test.That(t, rc.frames.nearest(start).seq, test.ShouldEqual, uint64(4))
+	test.That(t, rc.frames.nearest(start.Add(time.Hour)).seq, test.ShouldEqual, uint64(6))
+
+	// shrinking the history keeps the newest frames
+	rc.frames.resize(0)
+	frames = rc.frames.snapshot()
+	test.That(t, frames, test.ShouldHaveLength, 1)
+	test.That(t, frames[0].seq, test.ShouldEqual, uint64(6))
+
+	// growing the history copies the latest frame, which is held without copying
+	rc.storeFrame(img, start.Add(time.Second))
+	test.That(t, rc.frames.latest().img, test.ShouldEqual, img)
+	rc.frames.resize(2)
+	test.That(t, rc.frames.latest().seq, test.ShouldEqual, uint64(7))
+	test.That(t, rc.frames.latest().img != image.Image(img), test.ShouldBeTrue)
+}
+
+func TestGetFrameAt(t *testing.T) {
+	rc := &rtspCamera{}
+	cmd := map[string]interface{}{"command": getFrameAtCommand, "time": "2024-05-03T20:33:04.1Z"}
+	_, err := rc.DoCommand(context.Background(), cmd)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "no frame yet")
+
+	rc.frames.resize(2)
+	captured, err := time.Parse(time.RFC3339Nano, "2024-05-03T20:33:04Z")
+	test.That(t, err, test.ShouldBeNil)
+	rc.storeFrame(image.NewRGBA(image.Rect(0, 0, 4, 2)), captured)
+	rc.storeFrame(image.NewRGBA(image.Rect(0, 0, 4, 2)), captured.Add(time.Second))
+	res, err := rc.DoCommand(context.Background(), cmd)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["sequence"], test.ShouldEqual, uint64(1))
+	test.That(t, res["captured_at"], test.ShouldEqual, "2024-05-03T20:33:04Z")
+	test.That(t, res["offset_ms"], test.ShouldAlmostEqual, -100)
+	test.That(t, res["mime_type"], test.ShouldEqual, "image/jpeg")
+	test.That(t, res["image"], test.ShouldNotBeEmpty)
+
+	cmd["max_offset_ms"] = 50.0
+	_, err = rc.DoCommand(context.Background(), cmd)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "more than max_offset_ms")
+
+	_, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": getFrameAtCommand, "time": "yesterday"})
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestCloneImage(t *testing.T) {
+	yuv := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	yuv.Y[0], yuv.Cb[0], yuv.Cr[0] = 10, 20, 30
+	clone, ok := cloneImage(yuv).(*image.YCbCr)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, clone, test.ShouldResemble, yuv)
+	yuv.Y[0] = 11
+	test.That(t, clone.Y[0], test.ShouldEqual, 10)
+
+	gray := image.NewGray(image.Rect(0, 0, 4, 2))
+	gray.Pix[0] = 10
+	grayClone, ok := cloneImage(gray).(*image.Gray)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, grayClone, test.ShouldResemble, gray)
+	gray.Pix[0] = 11
+	test.That(t, grayClone.Pix[0], test.ShouldEqual, 10)
+}
+
+func TestRangeExpansionLUTs(t *testing.T) {
+	test.That(t, limitedToFullLuma[0], test.ShouldEqual, 0)
+	test.That(t, limitedToFullLuma[16], test.ShouldEqual, 0)
+	test.That(t, limitedToFullLuma[235], test.ShouldEqual, 255)
+	test.That(t, limitedToFullLuma[255], test.ShouldEqual, 255)
+	test.That(t, limitedToFullChroma[16], test.ShouldEqual, 0)
+	test.That(t, limitedToFullChroma[128], test.ShouldEqual, 128)
+	test.That(t, limitedToFullChroma[240], test.ShouldEqual, 255)
+}
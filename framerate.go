@@ -0,0 +1,119 @@
+package viamrtsp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/rtp"
+)
+
+// frameRateWindow is the stream time the frame rate is measured over.
+const frameRateWindow = 2 * time.Second
+
+// frameRateMeter measures the frame rate of the video track from the RTP timestamps of its frames, which is the
+// rate the camera encodes at regardless of network jitter, max_decode_fps or lazy_decode.
+type frameRateMeter struct {
+	mu        sync.Mutex
+	clockRate uint32
+	started   bool
+	// start is the timestamp of the first frame of the window & last the largest timestamp of the window, as
+	// frames of streams with B-frames are received out of presentation order
+	start  uint32
+	last   uint32
+	frames int
+	fps    float64
+}
+
+// reset discards the measured frame rate, for a new track whose timestamps have clockRate ticks per second.
+func (m *frameRateMeter) reset(clockRate int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clockRate = uint32(clockRate)
+	m.started, m.start, m.last, m.frames, m.fps = false, 0, 0, 0, 0
+}
+
+// packet measures the frame rate from pkt. The marker bit is set on the last packet of each frame by the RTP
+// payload formats of every supported video codec.
+func (m *frameRateMeter) packet(pkt *rtp.Packet) {
+	if !pkt.Marker {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.clockRate == 0 || (m.started && pkt.Timestamp == m.last) {
+		return
+	}
+	window := uint32(frameRateWindow.Seconds()) * m.clockRate
+	// the differences wrap around like the 32 bit timestamps. Reordered frames are counted without moving the
+	// end of the window. The window restarts after gaps in the stream, e.g. while it was paused, & at jumps back
+	// in time, which would skew the frame rate.
+	delta := int32(pkt.Timestamp - m.last)
+	if !m.started || delta > int32(window) || delta < -int32(window) {
+		m.started, m.start, m.last, m.frames = true, pkt.Timestamp, pkt.Timestamp, 0
+		return
+	}
+	if delta > 0 {
+		m.last = pkt.Timestamp
+	}
+	m.frames++
+	if elapsed := m.last - m.start; elapsed >= window {
+		m.fps = float64(m.frames) * float64(m.clockRate) / float64(elapsed)
+		m.start, m.frames = m.last, 0
+	}
+}
+
+// rate returns the frame rate measured over the latest complete window, or 0 until a window completed.
+func (m *frameRateMeter) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fps
+}
+
+// videoProperties returns the size of the served images & the frame rate of the stream, & whether the frame rate
+// was measured. The size is the size of the latest frame, which reflects decode_scale, crop & rotate_degrees, or
+// the size coded in the stream until a frame was decoded. The frame rate is coded in the stream until it's measured.
+// Unknown values are 0.
+func (rc *rtspCamera) videoProperties() (prop.Video, bool) {
+	var props prop.Video
+	info := rc.streamInfo.Load()
+	if latest := rc.frames.latest(); latest != nil {
+		size := latest.img.Bounds().Size()
+		props.Width, props.Height = size.X, size.Y
+	} else if info != nil {
+		props.Width, props.Height = info.width, info.height
+	}
+	if fps := rc.frameRate.rate(); fps > 0 {
+		props.FrameRate = float32(fps)
+		return props, true
+	}
+	if info != nil {
+		props.FrameRate = float32(info.fps)
+	}
+	return props, false
+}
+
+// MediaProperties returns the size of the served images & the frame rate of the stream, or a *NotReadyError
+// while the camera isn't streaming.
+func (rc *rtspCamera) MediaProperties(_ context.Context) (prop.Video, error) {
+	if err := rc.health.notReady(); err != nil {
+		return prop.Video{}, err
+	}
+	props, _ := rc.videoProperties()
+	return props, nil
+}
+
+// getVideoProperties returns the properties of MediaProperties for the get-video-properties command.
+func (rc *rtspCamera) getVideoProperties() (map[string]interface{}, error) {
+	if err := rc.health.notReady(); err != nil {
+		return nil, err
+	}
+	props, measured := rc.videoProperties()
+	return map[string]interface{}{
+		"width":        props.Width,
+		"height":       props.Height,
+		"fps":          props.FrameRate,
+		"fps_measured": measured,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package viamrtsp
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/pion/rtp"
+	"go.viam.com/test"
+)
+
+func TestFrameRateMeter(t *testing.T) {
+	var m frameRateMeter
+	m.reset(90000)
+	// 25 fps, with the timestamps wrapping around, & 2 packets per frame of which only the last has the marker bit
+	ts := uint32(1<<32 - 90000)
+	for i := 0; i < 60; i++ {
+		m.packet(&rtp.Packet{Header: rtp.Header{Timestamp: ts}})
+		m.packet(&rtp.Packet{Header: rtp.Header{Timestamp: ts, Marker: true}})
+		if i == 49 {
+			test.That(t, m.rate(), test.ShouldEqual, 0)
+		}
+		ts += 3600
+	}
+	test.That(t, m.rate(), test.ShouldEqual, 25)
+
+	// a gap in the stream restarts the window without skewing the frame rate
+	ts += 10 * 90000
+	m.packet(&rtp.Packet{Header: rtp.Header{Timestamp: ts, Marker: true}})
+	test.That(t, m.rate(), test.ShouldEqual, 25)
+	for i := 0; i < 20; i++ {
+		ts += 9000
+		m.packet(&rtp.Packet{Header: rtp.Header{Timestamp: ts, Marker: true}})
+	}
+	test.That(t, m.rate(), test.ShouldEqual, 10)
+
+	// frames of streams with B-frames are received out of presentation order, e.g. I P B B P B B
+	m.reset(90000)
+	m.packet(&rtp.Packet{Header: rtp.Header{Timestamp: 0, Marker: true}})
+	for ts := uint32(0); ts < 4*90000; ts += 3 * 3000 {
+		for _, offset := range []uint32{3, 1, 2} {
+			m.packet(&rtp.Packet{Header: rtp.Header{Timestamp: ts + offset*3000, Marker: true}})
+		}
+	}
+	test.That(t, m.rate(), test.ShouldEqual, 30)
+
+	m.reset(90000)
+	test.That(t, m.rate(), test.ShouldEqual, 0)
+}
+
+func TestVideoProperties(t *testing.T) {
+	rc := &rtspCamera{}
+	_, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": getVideoPropertiesCommand})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// the size & frame rate coded in the stream are reported until a frame was decoded & the frame rate measured
+	rc.health.set(StreamStreaming, nil, nil)
+	rc.streamInfo.Store(&streamInfo{width: 1920, height: 1080, fps: 30})
+	res, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": getVideoPropertiesCommand})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res, test.ShouldResemble, map[string]interface{}{
+		"width": 1920, "height": 1080, "fps": float32(30), "fps_measured": false,
+	})
+
+	rc.frames.add(&frame{img: image.NewRGBA(image.Rect(0, 0, 960, 540))})
+	rc.frameRate.reset(90000)
+	for ts := uint32(0); ts <= 2*90000; ts += 6000 {
+		rc.frameRate.packet(&rtp.Packet{Header: rtp.Header{Timestamp: ts, Marker: true}})
+	}
+	props, err := rc.MediaProperties(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, props.Width, test.ShouldEqual, 960)
+	test.That(t, props.Height, test.ShouldEqual, 540)
+	test.That(t, props.FrameRate, test.ShouldEqual, 15)
+}
@@ -0,0 +1,211 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph265"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/pion/rtp"
+	"go.viam.com/test"
+)
+
+// The fuzz targets feed malformed packets & documents, as camera firmware emits, into the parsers which run on
+// data received from cameras. They run their seeds as part of the tests, & are fuzzed with e.g.
+//
+//	go test -run '^$' -fuzz FuzzH264Depacketize -fuzztime 5m
+
+// fuzzRTPPackets splits data, RTP packets which are each prefixed by their 2 byte big endian size as when
+// they're interleaved in the RTSP connection, into packets, skipping those which aren't valid RTP packets.
+func fuzzRTPPackets(data []byte) []*rtp.Packet {
+	var pkts []*rtp.Packet
+	for len(data) >= 2 {
+		size := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if size > len(data) {
+			size = len(data)
+		}
+		var pkt rtp.Packet
+		if pkt.Unmarshal(data[:size]) == nil {
+			pkts = append(pkts, &pkt)
+		}
+		data = data[size:]
+	}
+	return pkts
+}
+
+// marshalFuzzRTPPackets is the inverse of fuzzRTPPackets.
+func marshalFuzzRTPPackets(t testing.TB, pkts []*rtp.Packet) []byte {
+	var b []byte
+	for _, pkt := range pkts {
+		buf, err := pkt.Marshal()
+		test.That(t, err, test.ShouldBeNil)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(buf)))
+		b = append(b, buf...)
+	}
+	return b
+}
+
+func FuzzH264Depacketize(f *testing.F) {
+	// a keyframe & a frame of a small stream, with an SEI, fragmented over several packets
+	encoder := newH264PCMEncoder(32, 32, 30)
+	img := image.NewYCbCr(image.Rect(0, 0, 32, 32), image.YCbCrSubsampleRatio420)
+	sei := append([]byte{byte(h264.NALUTypeSEI), seiTypeUserDataUnregistered, 20}, bytes.Repeat([]byte{0xAB}, 20)...)
+	rtpEnc := &rtph264.Encoder{PayloadType: 96, PacketizationMode: 1, PayloadMaxSize: 300}
+	test.That(f, rtpEnc.Init(), test.ShouldBeNil)
+	var pkts []*rtp.Packet
+	for i := 0; i < 2; i++ {
+		drawFakeFrame(img, time.Unix(int64(i), 0))
+		encoded, err := rtpEnc.Encode(append([][]byte{append(sei, 0x80)}, encoder.encode(img)...))
+		test.That(f, err, test.ShouldBeNil)
+		pkts = append(pkts, encoded...)
+	}
+	f.Add(marshalFuzzRTPPackets(f, pkts))
+	f.Add(marshalFuzzRTPPackets(f, pkts[:1]))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := &rtph264.Decoder{}
+		test.That(t, dec.Init(), test.ShouldBeNil)
+		resolution := newResolutionWatcher(H264, nil)
+		var store seiStore
+		for _, pkt := range fuzzRTPPackets(data) {
+			au, err := dec.Decode(pkt)
+			if err != nil {
+				continue
+			}
+			store.store(parseSEI(H264, au), time.Now())
+			store.snapshot()
+			h264.IDRPresent(au)
+			resolution.update(au)
+			h264DecoderInputs(au)
+		}
+	})
+}
+
+func FuzzH265Depacketize(f *testing.F) {
+	rtpEnc := &rtph265.Encoder{PayloadType: 96, PayloadMaxSize: 20}
+	test.That(f, rtpEnc.Init(), test.ShouldBeNil)
+	var pkts []*rtp.Packet
+	for _, bframe := range h265BFrameAUs {
+		b, err := base64.StdEncoding.DecodeString(bframe.au)
+		test.That(f, err, test.ShouldBeNil)
+		au, err := h264.AnnexBUnmarshal(b)
+		test.That(f, err, test.ShouldBeNil)
+		encoded, err := rtpEnc.Encode(au)
+		test.That(f, err, test.ShouldBeNil)
+		pkts = append(pkts, encoded...)
+	}
+	f.Add(marshalFuzzRTPPackets(f, pkts))
+	f.Add(marshalFuzzRTPPackets(f, pkts[:3]))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := &rtph265.Decoder{}
+		test.That(t, dec.Init(), test.ShouldBeNil)
+		resolution := newResolutionWatcher(H265, nil)
+		var store seiStore
+		for _, pkt := range fuzzRTPPackets(data) {
+			au, err := dec.Decode(pkt)
+			if err != nil {
+				continue
+			}
+			store.store(parseSEI(H265, au), time.Now())
+			store.snapshot()
+			h265.IsRandomAccess(au)
+			resolution.update(au)
+		}
+	})
+}
+
+func FuzzMetadata(f *testing.F) {
+	doc := []byte(onvifMetadataDoc)
+	f.Add(marshalFuzzRTPPackets(f, []*rtp.Packet{
+		{Header: rtp.Header{Timestamp: 1}, Payload: doc[:len(doc)/2]},
+		{Header: rtp.Header{Timestamp: 1, Marker: true}, Payload: doc[len(doc)/2:]},
+	}))
+	f.Add(marshalFuzzRTPPackets(f, []*rtp.Packet{
+		{Header: rtp.Header{Timestamp: 2, Marker: true}, Payload: append(bytes.Repeat([]byte{0x06}, klvKeySize), 0x01, 0x02)},
+	}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var reassembler metadataReassembler
+		var store metadataStore
+		for _, pkt := range fuzzRTPPackets(data) {
+			unit := reassembler.add(pkt)
+			if unit == nil {
+				continue
+			}
+			//nolint:errcheck
+			store.storeONVIF(unit, time.Now())
+			store.storeKLV(unit, time.Now())
+			store.snapshot()
+		}
+	})
+}
+
+// fuzzONVIFTransport answers the ONVIF requests of a client with the fuzzed responses of their type, without
+// connecting to the addresses of the fuzzed responses.
+type fuzzONVIFTransport map[string]string
+
+func (tr fuzzONVIFTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	res := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: req}
+	for request, response := range tr {
+		if bytes.Contains(body, []byte("<"+request+" ")) {
+			res.Body = io.NopCloser(strings.NewReader(response))
+		}
+	}
+	return res, nil
+}
+
+// onvifResponse wraps body in a SOAP envelope.
+func onvifResponse(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		`<s:Body>` + body + `</s:Body></s:Envelope>`
+}
+
+func FuzzONVIFResponses(f *testing.F) {
+	f.Add(
+		onvifResponse(`<tds:GetServicesResponse xmlns:tds="`+onvifDeviceNamespace+`"><tds:Service>`+
+			`<tds:Namespace>`+onvifMediaNamespace+`</tds:Namespace><tds:XAddr>http://192.168.1.2/onvif/media</tds:XAddr>`+
+			`</tds:Service></tds:GetServicesResponse>`),
+		onvifResponse(`<trt:GetProfilesResponse xmlns:trt="`+onvifMediaNamespace+`" xmlns:tt="`+onvifSchemaNamespace+`">`+
+			`<trt:Profiles token="Profile_1"><tt:Name>mainStream</tt:Name>`+
+			`<tt:VideoSourceConfiguration><tt:SourceToken>VideoSource_1</tt:SourceToken></tt:VideoSourceConfiguration>`+
+			`</trt:Profiles></trt:GetProfilesResponse>`),
+		onvifResponse(`<trt:GetStreamUriResponse xmlns:trt="`+onvifMediaNamespace+`" xmlns:tt="`+onvifSchemaNamespace+`">`+
+			`<trt:MediaUri><tt:Uri>rtsp://192.168.1.2:554/Profile_1</tt:Uri></trt:MediaUri></trt:GetStreamUriResponse>`),
+		onvifResponse(`<tds:GetSystemDateAndTimeResponse xmlns:tds="`+onvifDeviceNamespace+`" xmlns:tt="`+onvifSchemaNamespace+`">`+
+			`<tds:SystemDateAndTime><tt:DateTimeType>NTP</tt:DateTimeType><tt:UTCDateTime>`+marshalONVIFDateTime(time.Unix(0, 0))+
+			`</tt:UTCDateTime></tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`),
+		"mainStream",
+	)
+	f.Add(onvifResponse(""), "<", onvifResponse(`<GetStreamUriResponse><Uri>`), "", "")
+
+	f.Fuzz(func(t *testing.T, services, profiles, streamURI, dateTime, profile string) {
+		client := (&ONVIFConfig{DeviceServiceURL: "http://192.168.1.2/onvif/device_service"}).client("admin", "password")
+		client.httpClient.Transport = fuzzONVIFTransport{
+			"GetServices":          services,
+			"GetProfiles":          profiles,
+			"GetStreamUri":         streamURI,
+			"GetSystemDateAndTime": dateTime,
+		}
+		//nolint:errcheck
+		client.profileStreamURI(context.Background(), profile)
+		if dt, err := client.systemDateAndTime(context.Background()); err == nil {
+			clockOffset(dt.UTCDateTime.time(), time.Now(), time.Now())
+		}
+	})
+}
@@ -9,6 +9,8 @@ require (
 	github.com/bluenviron/mediacommon v1.9.2
 	github.com/edaniels/golinters v0.0.5-0.20220906153528-641155550742
 	github.com/golangci/golangci-lint v1.57.2
+	github.com/pion/mediadevices v0.6.4
+	github.com/pion/rtcp v1.2.14
 	github.com/pion/rtp v1.8.5
 	github.com/pkg/errors v0.9.1
 	github.com/rhysd/actionlint v1.6.27
@@ -210,9 +212,7 @@ require (
 	github.com/pion/interceptor v0.1.25 // indirect
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/mdns v0.0.12 // indirect
-	github.com/pion/mediadevices v0.6.4 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
-	github.com/pion/rtcp v1.2.14 // indirect
 	github.com/pion/sctp v1.8.14 // indirect
 	github.com/pion/sdp/v3 v3.0.9 // indirect
 	github.com/pion/srtp/v2 v2.0.18 // indirect
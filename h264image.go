@@ -0,0 +1,107 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/rimage"
+)
+
+// MimeTypeH264 is the MIME type clients which decode H264 themselves, e.g. ML accelerators with built in
+// decoders, can request from Image. Rather than a decoded frame, the Annex B encoded parameter sets &
+// access units since the latest IDR are returned, so that the latest frame can be decoded from the
+// response alone, without decoding the stream twice.
+const MimeTypeH264 = "video/h264"
+
+// h264GOP holds the latest parameter sets & the access units since the latest IDR of an H264 stream. Access
+// units are only held once MimeTypeH264 was requested, until it wasn't requested for lazyDecodeIdleTimeout.
+type h264GOP struct {
+	mu       sync.Mutex
+	sps, pps []byte
+	aus      *gopBuffer
+	// lastRequest is when MimeTypeH264 was last requested
+	lastRequest time.Time
+}
+
+func newH264GOP(sps, pps []byte) *h264GOP {
+	return &h264GOP{sps: sps, pps: pps, aus: newGOPBuffer()}
+}
+
+// add holds au if MimeTypeH264 was requested recently, and keeps the parameter sets sent in band.
+func (g *h264GOP) add(au [][]byte, capturedAt time.Time, keyframe bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, nalu := range au {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch h264.NALUType(nalu[0] & 0x1F) {
+		case h264.NALUTypeSPS:
+			if !bytes.Equal(g.sps, nalu) {
+				g.sps = append([]byte(nil), nalu...)
+			}
+		case h264.NALUTypePPS:
+			if !bytes.Equal(g.pps, nalu) {
+				g.pps = append([]byte(nil), nalu...)
+			}
+		}
+	}
+	if time.Since(g.lastRequest) > lazyDecodeIdleTimeout {
+		if len(g.aus.aus) > 0 {
+			g.aus = newGOPBuffer()
+		}
+		return
+	}
+	g.aus.add(au, capturedAt, keyframe)
+}
+
+// request starts holding access units, which are held from the next IDR on.
+func (g *h264GOP) request() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastRequest = time.Now()
+}
+
+// annexB returns the GOP in Annex B format along with the capture time of its last access unit.
+func (g *h264GOP) annexB() ([]byte, time.Time, error) {
+	g.mu.Lock()
+	var nalus [][]byte
+	for _, ps := range [][]byte{g.sps, g.pps} {
+		if ps != nil {
+			nalus = append(nalus, ps)
+		}
+	}
+	var capturedAt time.Time
+	for _, buffered := range g.aus.aus {
+		nalus = append(nalus, buffered.au...)
+		capturedAt = buffered.capturedAt
+	}
+	empty := len(g.aus.aus) == 0
+	g.mu.Unlock()
+
+	if empty {
+		return nil, time.Time{}, errors.New("no H264 keyframe yet")
+	}
+	b, err := h264.AnnexBMarshal(nalus)
+	return b, capturedAt, err
+}
+
+// h264Image returns the current GOP as an image of type MimeTypeH264.
+func (rc *rtspCamera) h264Image() (*frame, error) {
+	if rc.closed() {
+		return nil, errCameraClosed
+	}
+	gop := rc.h264GOP.Load()
+	if gop == nil {
+		return nil, errors.Errorf("%s images are only available for H264 streams", MimeTypeH264)
+	}
+	gop.request()
+	b, capturedAt, err := gop.annexB()
+	if err != nil {
+		return nil, err
+	}
+	return &frame{img: rimage.NewLazyEncodedImage(b, MimeTypeH264), receivedAt: time.Now(), capturedAt: capturedAt}, nil
+}
@@ -0,0 +1,71 @@
+package viamrtsp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"go.viam.com/rdk/gostream"
+	"go.viam.com/rdk/rimage"
+	rutils "go.viam.com/rdk/utils"
+	"go.viam.com/test"
+)
+
+func TestH264Image(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1e}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 0x88, 0x84}
+	nonIDR := []byte{0x41, 0x9a, 0x02}
+	h264Ctx := gostream.WithMIMETypeHint(context.Background(), rutils.WithLazyMIMEType(MimeTypeH264))
+
+	rc := &rtspCamera{}
+	_, _, err := rc.readFrame(h264Ctx)
+	test.That(t, err, test.ShouldBeError, "video/h264 images are only available for H264 streams")
+
+	gop := newH264GOP(sps, pps)
+	rc.h264GOP.Store(gop)
+	// access units are only held once video/h264 was requested
+	gop.add([][]byte{idr}, time.Now(), true)
+	_, _, err = rc.readFrame(h264Ctx)
+	test.That(t, err, test.ShouldBeError, "no H264 keyframe yet")
+	// access units before the first IDR can't be decoded
+	gop.add([][]byte{nonIDR}, time.Now(), false)
+	_, _, err = rc.readFrame(h264Ctx)
+	test.That(t, err, test.ShouldBeError, "no H264 keyframe yet")
+
+	capturedAt := time.Unix(1714768381, 0)
+	gop.add([][]byte{idr}, capturedAt, true)
+	gop.add([][]byte{nonIDR}, capturedAt.Add(time.Second), false)
+	img, _, err := rc.readFrame(h264Ctx)
+	test.That(t, err, test.ShouldBeNil)
+	lazy, ok := img.(*rimage.LazyEncodedImage)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, lazy.MIMEType(), test.ShouldEqual, MimeTypeH264)
+	expected, err := h264.AnnexBMarshal([][]byte{sps, pps, idr, nonIDR})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, lazy.RawData(), test.ShouldResemble, expected)
+
+	f, err := rc.h264Image()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, f.capturedAt, test.ShouldEqual, capturedAt.Add(time.Second))
+
+	// a new IDR starts a new GOP, & parameter sets sent in band replace those of the SDP
+	inBandSPS := []byte{0x67, 0x64, 0x00, 0x28}
+	gop.add([][]byte{inBandSPS, idr}, capturedAt.Add(2*time.Second), true)
+	b, _, err := gop.annexB()
+	test.That(t, err, test.ShouldBeNil)
+	expected, err = h264.AnnexBMarshal([][]byte{inBandSPS, pps, inBandSPS, idr})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, b, test.ShouldResemble, expected)
+
+	// access units are dropped once video/h264 wasn't requested for a while
+	gop.lastRequest = time.Now().Add(-2 * lazyDecodeIdleTimeout)
+	gop.add([][]byte{idr}, capturedAt.Add(3*time.Second), true)
+	_, _, err = gop.annexB()
+	test.That(t, err, test.ShouldBeError, "no H264 keyframe yet")
+
+	// other MIME types are served the decoded frame
+	_, _, err = rc.readFrame(gostream.WithMIMETypeHint(context.Background(), rutils.MimeTypeJPEG))
+	test.That(t, err, test.ShouldBeError, errDecodingDisabled)
+}
@@ -0,0 +1,286 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"image"
+	"math/bits"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+)
+
+const (
+	// h264MBTypeIPCM is the mb_type of I_PCM macroblocks in I slices, in P slices it's offset by the 5 P macroblock types.
+	h264MBTypeIPCM = 25
+	h264PMBTypes   = 5
+	h264SliceTypeP = 5
+	h264SliceTypeI = 7
+	// h264Log2MaxFrameNum is the number of bits of frame_num, which counts the frames since the last keyframe.
+	h264Log2MaxFrameNum = 8
+)
+
+// h264PCMEncoder encodes frames to baseline H264 without compressing them, as libx264, the only H264
+// encoder FFmpeg may be built with, is GPL licensed & only included in opt-in builds. Keyframes consist
+// of I_PCM macroblocks, which contain the raw samples of the macroblock, and other frames only contain
+// the macroblocks which changed since the previous frame, skipping the others. This suits synthetic
+// frames, e.g. of the rtsp-fake model, in which little changes.
+type h264PCMEncoder struct {
+	mbWidth, mbHeight int
+	keyframeInterval  int
+
+	frames   int
+	frameNum int
+	idrPicID uint32
+	prev     *image.YCbCr
+}
+
+// newH264PCMEncoder returns an encoder of frames of width & height, which must be multiples of 16,
+// which emits a keyframe every keyframeInterval frames.
+func newH264PCMEncoder(width, height, keyframeInterval int) *h264PCMEncoder {
+	return &h264PCMEncoder{mbWidth: width / 16, mbHeight: height / 16, keyframeInterval: max(keyframeInterval, 1)}
+}
+
+// sps returns the sequence parameter set of the stream.
+func (e *h264PCMEncoder) sps() []byte {
+	var w bitWriter
+	w.writeBits(66, 8)   // profile_idc: baseline
+	w.writeBits(0xC0, 8) // constraint_set0_flag & constraint_set1_flag: constrained baseline
+	w.writeBits(40, 8)   // level_idc
+	w.writeUE(0)         // seq_parameter_set_id
+	w.writeUE(h264Log2MaxFrameNum - 4)
+	w.writeUE(2)       // pic_order_cnt_type: output order is decoding order
+	w.writeUE(1)       // max_num_ref_frames
+	w.writeFlag(false) // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(uint32(e.mbWidth - 1))
+	w.writeUE(uint32(e.mbHeight - 1))
+	w.writeFlag(true)  // frame_mbs_only_flag
+	w.writeFlag(true)  // direct_8x8_inference_flag
+	w.writeFlag(false) // frame_cropping_flag
+	w.writeFlag(true)  // vui_parameters_present_flag
+	// the only VUI parameters are the bitstream restrictions, so that decoders don't wait to reorder frames
+	for i := 0; i < 8; i++ {
+		w.writeFlag(false)
+	}
+	w.writeFlag(true) // bitstream_restriction_flag
+	w.writeFlag(true) // motion_vectors_over_pic_boundaries_flag
+	w.writeUE(0)      // max_bytes_per_pic_denom
+	w.writeUE(0)      // max_bits_per_mb_denom
+	w.writeUE(15)     // log2_max_mv_length_horizontal
+	w.writeUE(15)     // log2_max_mv_length_vertical
+	w.writeUE(0)      // max_num_reorder_frames
+	w.writeUE(1)      // max_dec_frame_buffering
+	w.writeTrailingBits()
+	return h264NALU(byte(h264.NALUTypeSPS)|3<<5, w.bytes())
+}
+
+// pps returns the picture parameter set of the stream.
+func (e *h264PCMEncoder) pps() []byte {
+	var w bitWriter
+	w.writeUE(0)       // pic_parameter_set_id
+	w.writeUE(0)       // seq_parameter_set_id
+	w.writeFlag(false) // entropy_coding_mode_flag: CAVLC
+	w.writeFlag(false) // bottom_field_pic_order_in_frame_present_flag
+	w.writeUE(0)       // num_slice_groups_minus1
+	w.writeUE(0)       // num_ref_idx_l0_default_active_minus1
+	w.writeUE(0)       // num_ref_idx_l1_default_active_minus1
+	w.writeFlag(false) // weighted_pred_flag
+	w.writeBits(0, 2)  // weighted_bipred_idc
+	w.writeSE(0)       // pic_init_qp_minus26
+	w.writeSE(0)       // pic_init_qs_minus26
+	w.writeSE(0)       // chroma_qp_index_offset
+	w.writeFlag(true)  // deblocking_filter_control_present_flag
+	w.writeFlag(false) // constrained_intra_pred_flag
+	w.writeFlag(false) // redundant_pic_cnt_present_flag
+	w.writeTrailingBits()
+	return h264NALU(byte(h264.NALUTypePPS)|3<<5, w.bytes())
+}
+
+// encode returns the access unit of img, which must be of the size of the stream with 4:2:0 chroma.
+// Keyframes start with the SPS & PPS.
+func (e *h264PCMEncoder) encode(img *image.YCbCr) [][]byte {
+	keyframe := e.prev == nil || e.frames%e.keyframeInterval == 0
+	e.frames++
+	var w bitWriter
+	w.writeUE(0) // first_mb_in_slice
+	if keyframe {
+		e.frameNum = 0
+		w.writeUE(h264SliceTypeI)
+	} else {
+		e.frameNum = (e.frameNum + 1) % (1 << h264Log2MaxFrameNum)
+		w.writeUE(h264SliceTypeP)
+	}
+	w.writeUE(0) // pic_parameter_set_id
+	w.writeBits(uint64(e.frameNum), h264Log2MaxFrameNum)
+	if keyframe {
+		w.writeUE(e.idrPicID)
+		e.idrPicID = (e.idrPicID + 1) % 65536
+	} else {
+		w.writeFlag(false) // num_ref_idx_active_override_flag
+		w.writeFlag(false) // ref_pic_list_modification_flag_l0
+	}
+	// dec_ref_pic_marking, every frame is a reference for the next one
+	if keyframe {
+		w.writeFlag(false) // no_output_of_prior_pics_flag
+		w.writeFlag(false) // long_term_reference_flag
+	} else {
+		w.writeFlag(false) // adaptive_ref_pic_marking_mode_flag
+	}
+	w.writeSE(0) // slice_qp_delta
+	w.writeUE(1) // disable_deblocking_filter_idc
+
+	skipped := 0
+	for mbY := 0; mbY < e.mbHeight; mbY++ {
+		for mbX := 0; mbX < e.mbWidth; mbX++ {
+			if keyframe {
+				w.writeUE(h264MBTypeIPCM)
+				writePCMSamples(&w, img, mbX, mbY)
+				continue
+			}
+			if sameMacroblock(img, e.prev, mbX, mbY) {
+				// skipped macroblocks are copied from the previous frame, as every motion vector is zero
+				skipped++
+				continue
+			}
+			w.writeUE(uint32(skipped)) // mb_skip_run
+			skipped = 0
+			w.writeUE(h264PMBTypes + h264MBTypeIPCM)
+			writePCMSamples(&w, img, mbX, mbY)
+		}
+	}
+	if skipped > 0 {
+		w.writeUE(uint32(skipped))
+	}
+	w.writeTrailingBits()
+	e.prev = cloneYCbCr(img, e.prev)
+
+	if keyframe {
+		return [][]byte{e.sps(), e.pps(), h264NALU(byte(h264.NALUTypeIDR)|3<<5, w.bytes())}
+	}
+	return [][]byte{h264NALU(byte(h264.NALUTypeNonIDR)|2<<5, w.bytes())}
+}
+
+// writePCMSamples writes the pcm samples of the macroblock at mbX, mbY, which follow its mb_type.
+func writePCMSamples(w *bitWriter, img *image.YCbCr, mbX, mbY int) {
+	w.alignZero()
+	for y := 0; y < 16; y++ {
+		off := (mbY*16+y)*img.YStride + mbX*16
+		w.writeBytes(img.Y[off : off+16])
+	}
+	for _, plane := range [][]byte{img.Cb, img.Cr} {
+		for y := 0; y < 8; y++ {
+			off := (mbY*8+y)*img.CStride + mbX*8
+			w.writeBytes(plane[off : off+8])
+		}
+	}
+}
+
+// sameMacroblock returns true if the samples of the macroblock at mbX, mbY of a & b are equal.
+func sameMacroblock(a, b *image.YCbCr, mbX, mbY int) bool {
+	for y := 0; y < 16; y++ {
+		off := (mbY*16+y)*a.YStride + mbX*16
+		if !bytes.Equal(a.Y[off:off+16], b.Y[off:off+16]) {
+			return false
+		}
+	}
+	for y := 0; y < 8; y++ {
+		off := (mbY*8+y)*a.CStride + mbX*8
+		if !bytes.Equal(a.Cb[off:off+8], b.Cb[off:off+8]) || !bytes.Equal(a.Cr[off:off+8], b.Cr[off:off+8]) {
+			return false
+		}
+	}
+	return true
+}
+
+// cloneYCbCr copies src to dst, which is allocated if it is nil.
+func cloneYCbCr(src, dst *image.YCbCr) *image.YCbCr {
+	if dst == nil {
+		dst = image.NewYCbCr(src.Rect, src.SubsampleRatio)
+	}
+	copy(dst.Y, src.Y)
+	copy(dst.Cb, src.Cb)
+	copy(dst.Cr, src.Cr)
+	return dst
+}
+
+// h264NALU returns a NAL unit with the header byte header & the RBSP payload, inserting emulation prevention bytes.
+func h264NALU(header byte, rbsp []byte) []byte {
+	out := make([]byte, 1, len(rbsp)+len(rbsp)/64+1)
+	out[0] = header
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// bitWriter writes the bits of an RBSP, most significant bit first.
+type bitWriter struct {
+	buf  []byte
+	cur  byte
+	nCur int
+}
+
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>i&1)
+		w.nCur++
+		if w.nCur == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.nCur = 0, 0
+		}
+	}
+}
+
+func (w *bitWriter) writeFlag(f bool) {
+	if f {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+}
+
+// writeUE writes v as an unsigned exp-Golomb code.
+func (w *bitWriter) writeUE(v uint32) {
+	n := bits.Len64(uint64(v) + 1)
+	w.writeBits(0, n-1)
+	w.writeBits(uint64(v)+1, n)
+}
+
+// writeSE writes v as a signed exp-Golomb code.
+func (w *bitWriter) writeSE(v int32) {
+	if v > 0 {
+		w.writeUE(uint32(2*v - 1))
+	} else {
+		w.writeUE(uint32(-2 * v))
+	}
+}
+
+// writeBytes writes b, the writer must be byte aligned.
+func (w *bitWriter) writeBytes(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+// alignZero writes zero bits until the writer is byte aligned.
+func (w *bitWriter) alignZero() {
+	if w.nCur > 0 {
+		w.writeBits(0, 8-w.nCur)
+	}
+}
+
+// writeTrailingBits writes the rbsp_trailing_bits which end an RBSP.
+func (w *bitWriter) writeTrailingBits() {
+	w.writeBits(1, 1)
+	w.alignZero()
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
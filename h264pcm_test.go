@@ -0,0 +1,125 @@
+package viamrtsp
+
+import (
+	"image"
+	"testing"
+
+	"github.com/bluenviron/mediacommon/pkg/bits"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"go.viam.com/test"
+)
+
+func TestBitWriter(t *testing.T) {
+	var w bitWriter
+	w.writeUE(0)  // 1
+	w.writeUE(3)  // 00100
+	w.writeSE(-1) // 011
+	w.writeSE(1)  // 010
+	w.writeTrailingBits()
+	test.That(t, w.bytes(), test.ShouldResemble, []byte{0b10010001, 0b10101000})
+
+	// emulation prevention bytes are inserted after two zero bytes
+	test.That(t, h264NALU(0x65, []byte{0, 0, 1, 0, 0, 0, 0, 0, 4}), test.ShouldResemble,
+		[]byte{0x65, 0, 0, 3, 1, 0, 0, 3, 0, 0, 3, 0, 4})
+}
+
+// readSliceHeader reads the slice header written by h264PCMEncoder, returning the slice type, frame_num
+// & the position of the slice data.
+func readSliceHeader(t *testing.T, rbsp []byte, idr bool) (uint32, uint64, int) {
+	t.Helper()
+	var pos int
+	firstMB, err := bits.ReadGolombUnsigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, firstMB, test.ShouldEqual, uint32(0))
+	sliceType, err := bits.ReadGolombUnsigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	ppsID, err := bits.ReadGolombUnsigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, ppsID, test.ShouldEqual, uint32(0))
+	frameNum, err := bits.ReadBits(rbsp, &pos, h264Log2MaxFrameNum)
+	test.That(t, err, test.ShouldBeNil)
+	if idr {
+		_, err = bits.ReadGolombUnsigned(rbsp, &pos) // idr_pic_id
+		test.That(t, err, test.ShouldBeNil)
+		pos += 2 // no_output_of_prior_pics_flag & long_term_reference_flag
+	} else {
+		pos += 3 // num_ref_idx_active_override_flag, ref_pic_list_modification_flag_l0 & adaptive_ref_pic_marking_mode_flag
+	}
+	qpDelta, err := bits.ReadGolombSigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, qpDelta, test.ShouldEqual, int32(0))
+	deblocking, err := bits.ReadGolombUnsigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, deblocking, test.ShouldEqual, uint32(1))
+	return sliceType, frameNum, pos
+}
+
+func TestH264PCMEncoder(t *testing.T) {
+	const width, height = 64, 32
+	const mbs = width / 16 * height / 16
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	for i := range img.Y {
+		img.Y[i] = byte(i)
+	}
+	e := newH264PCMEncoder(width, height, 3)
+
+	var sps h264.SPS
+	test.That(t, sps.Unmarshal(e.sps()), test.ShouldBeNil)
+	test.That(t, sps.ProfileIdc, test.ShouldEqual, uint8(66))
+	test.That(t, sps.Width(), test.ShouldEqual, width)
+	test.That(t, sps.Height(), test.ShouldEqual, height)
+	test.That(t, sps.PicOrderCntType, test.ShouldEqual, uint32(2))
+	test.That(t, sps.VUI, test.ShouldNotBeNil)
+	test.That(t, sps.VUI.BitstreamRestriction, test.ShouldNotBeNil)
+	test.That(t, sps.VUI.BitstreamRestriction.MaxNumReorderFrames, test.ShouldEqual, uint32(0))
+
+	// keyframes consist of the SPS, PPS & an IDR of I_PCM macroblocks
+	au := e.encode(img)
+	test.That(t, au, test.ShouldHaveLength, 3)
+	test.That(t, h264.NALUType(au[0][0]&0x1F), test.ShouldEqual, h264.NALUTypeSPS)
+	test.That(t, h264.NALUType(au[1][0]&0x1F), test.ShouldEqual, h264.NALUTypePPS)
+	test.That(t, h264.IDRPresent(au), test.ShouldBeTrue)
+	rbsp := h264.EmulationPreventionRemove(au[2][1:])
+	sliceType, frameNum, pos := readSliceHeader(t, rbsp, true)
+	test.That(t, sliceType, test.ShouldEqual, uint32(h264SliceTypeI))
+	test.That(t, frameNum, test.ShouldEqual, uint64(0))
+	mbType, err := bits.ReadGolombUnsigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, mbType, test.ShouldEqual, uint32(h264MBTypeIPCM))
+	pos = (pos + 7) / 8
+	test.That(t, rbsp[pos:pos+16], test.ShouldResemble, img.Y[:16])
+	test.That(t, rbsp[pos+16:pos+32], test.ShouldResemble, img.Y[width:width+16])
+
+	// frames which didn't change only skip macroblocks
+	au = e.encode(img)
+	test.That(t, au, test.ShouldHaveLength, 1)
+	test.That(t, h264.NALUType(au[0][0]&0x1F), test.ShouldEqual, h264.NALUTypeNonIDR)
+	rbsp = h264.EmulationPreventionRemove(au[0][1:])
+	sliceType, frameNum, pos = readSliceHeader(t, rbsp, false)
+	test.That(t, sliceType, test.ShouldEqual, uint32(h264SliceTypeP))
+	test.That(t, frameNum, test.ShouldEqual, uint64(1))
+	skipRun, err := bits.ReadGolombUnsigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, skipRun, test.ShouldEqual, uint32(mbs))
+
+	// only macroblocks which changed are coded
+	img.Cr[len(img.Cr)-1]++
+	au = e.encode(img)
+	rbsp = h264.EmulationPreventionRemove(au[0][1:])
+	_, frameNum, pos = readSliceHeader(t, rbsp, false)
+	test.That(t, frameNum, test.ShouldEqual, uint64(2))
+	skipRun, err = bits.ReadGolombUnsigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, skipRun, test.ShouldEqual, uint32(mbs-1))
+	mbType, err = bits.ReadGolombUnsigned(rbsp, &pos)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, mbType, test.ShouldEqual, uint32(h264PMBTypes+h264MBTypeIPCM))
+	pos = (pos + 7) / 8
+	// the samples are followed by the rbsp_trailing_bits
+	test.That(t, len(rbsp)-pos, test.ShouldEqual, 384+1)
+	test.That(t, rbsp[len(rbsp)-2], test.ShouldEqual, img.Cr[len(img.Cr)-1])
+
+	// keyframes are sent every keyframeInterval frames
+	au = e.encode(img)
+	test.That(t, h264.IDRPresent(au), test.ShouldBeTrue)
+}
@@ -0,0 +1,118 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/logging"
+)
+
+// StreamState describes whether the camera is streaming, which image & properties requests report when it isn't.
+type StreamState string
+
+const (
+	// StreamConnecting is the state of a camera which hasn't connected to its stream yet.
+	StreamConnecting StreamState = "connecting"
+	// StreamStreaming is the state of a camera which is connected to its stream.
+	StreamStreaming StreamState = "streaming"
+	// StreamReconnecting is the state of a camera which lost its stream, or is reconnecting after a reconfigure.
+	StreamReconnecting StreamState = "reconnecting"
+	// StreamGaveUp is the state of a camera which stopped reconnecting after failing for give_up_after.
+	StreamGaveUp StreamState = "gave_up"
+	// StreamPaused is the state of a camera whose stream was paused by the pause command or idle_timeout.
+	StreamPaused StreamState = "paused"
+)
+
+// NotReadyError is returned by image & properties requests while the camera isn't streaming.
+type NotReadyError struct {
+	State StreamState
+	// Since is when the camera entered State.
+	Since time.Time
+	// Reason is the error of the last connection attempt, if it failed.
+	Reason error
+}
+
+func (e *NotReadyError) Error() string {
+	msg := fmt.Sprintf("camera is not ready, %s for %s", e.State, time.Since(e.Since).Round(time.Millisecond))
+	if e.Reason != nil {
+		msg += ", last error: " + e.Reason.Error()
+	}
+	return msg
+}
+
+func (e *NotReadyError) Unwrap() error {
+	return e.Reason
+}
+
+// streamHealth tracks the StreamState of a camera & logs how long it spent in each state. Its zero value
+// has no state, which is reported as ready, so that cameras which never connect, e.g. in tests, serve frames.
+type streamHealth struct {
+	mu     sync.Mutex
+	state  StreamState
+	since  time.Time
+	reason error
+}
+
+// set moves to state, logging the transition along with how long the previous state lasted.
+func (h *streamHealth) set(state StreamState, reason error, logger logging.Logger) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reason = reason
+	if state == h.state {
+		return
+	}
+	now := time.Now()
+	if h.state != "" && logger != nil {
+		msg := fmt.Sprintf("stream is %s after being %s for %s", state, h.state, now.Sub(h.since).Round(time.Millisecond))
+		if state == StreamStreaming {
+			logger.Info(msg)
+		} else {
+			logger.Warn(msg)
+		}
+	}
+	h.state, h.since = state, now
+}
+
+// connecting is called when a connection attempt starts.
+func (h *streamHealth) connecting(logger logging.Logger) {
+	h.mu.Lock()
+	state := h.state
+	h.mu.Unlock()
+	switch state {
+	case "":
+		h.set(StreamConnecting, nil, logger)
+	case StreamStreaming, StreamGaveUp, StreamPaused:
+		h.set(StreamReconnecting, nil, logger)
+	case StreamConnecting, StreamReconnecting:
+		// the reason of the previous attempt is kept until this one completes
+	}
+}
+
+// connected is called with the result of a connection attempt.
+func (h *streamHealth) connected(err error, logger logging.Logger) {
+	if err == nil {
+		h.set(StreamStreaming, nil, logger)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reason = err
+}
+
+// notReady returns a *NotReadyError unless the camera is streaming.
+func (h *streamHealth) notReady() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == "" || h.state == StreamStreaming {
+		return nil
+	}
+	return &NotReadyError{State: h.state, Since: h.since, Reason: h.reason}
+}
+
+// snapshot returns the current state & when it was entered.
+func (h *streamHealth) snapshot() (StreamState, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state, h.since
+}
@@ -0,0 +1,77 @@
+package viamrtsp
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestStreamHealth(t *testing.T) {
+	logger, logs := logging.NewObservedTestLogger(t)
+	var h streamHealth
+	// cameras which never connected are reported as ready
+	test.That(t, h.notReady(), test.ShouldBeNil)
+
+	h.connecting(logger)
+	var notReady *NotReadyError
+	test.That(t, errors.As(h.notReady(), &notReady), test.ShouldBeTrue)
+	test.That(t, notReady.State, test.ShouldEqual, StreamConnecting)
+	test.That(t, notReady.Reason, test.ShouldBeNil)
+
+	// failed attempts keep the state & report their error
+	refused := errors.New("connection refused")
+	h.connected(refused, logger)
+	h.connecting(logger)
+	err := h.notReady()
+	test.That(t, errors.As(err, &notReady), test.ShouldBeTrue)
+	test.That(t, notReady.State, test.ShouldEqual, StreamConnecting)
+	test.That(t, errors.Is(err, refused), test.ShouldBeTrue)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "camera is not ready, connecting for ")
+	test.That(t, err.Error(), test.ShouldEndWith, "last error: connection refused")
+	test.That(t, logs.Len(), test.ShouldEqual, 0)
+
+	h.connected(nil, logger)
+	test.That(t, h.notReady(), test.ShouldBeNil)
+	test.That(t, logs.FilterMessageSnippet("stream is streaming after being connecting for").Len(), test.ShouldEqual, 1)
+
+	h.connecting(logger)
+	test.That(t, errors.As(h.notReady(), &notReady), test.ShouldBeTrue)
+	test.That(t, notReady.State, test.ShouldEqual, StreamReconnecting)
+	test.That(t, logs.FilterMessageSnippet("stream is reconnecting after being streaming for").Len(), test.ShouldEqual, 1)
+
+	h.set(StreamGaveUp, refused, logger)
+	state, since := h.snapshot()
+	test.That(t, state, test.ShouldEqual, StreamGaveUp)
+	test.That(t, time.Since(since), test.ShouldBeLessThan, time.Minute)
+
+	// requested reconnects after giving up reconnect again
+	h.connecting(logger)
+	test.That(t, errors.As(h.notReady(), &notReady), test.ShouldBeTrue)
+	test.That(t, notReady.State, test.ShouldEqual, StreamReconnecting)
+}
+
+func TestNotReadyImages(t *testing.T) {
+	rc := &rtspCamera{}
+	rc.storeFrame(image.NewRGBA(image.Rect(0, 0, 1, 1)), time.Now())
+	_, _, err := rc.readFrame(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+
+	// the last frame isn't served while reconnecting
+	rc.health.set(StreamReconnecting, errors.New("connection refused"), nil)
+	_, _, err = rc.readFrame(context.Background())
+	var notReady *NotReadyError
+	test.That(t, errors.As(err, &notReady), test.ShouldBeTrue)
+	test.That(t, notReady.State, test.ShouldEqual, StreamReconnecting)
+
+	rc.u, err = base.ParseURL("rtsp://127.0.0.1:8554/stream")
+	test.That(t, err, test.ShouldBeNil)
+	info, err := rc.getStreamInfo()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, info["state"], test.ShouldEqual, "reconnecting")
+}
@@ -0,0 +1,144 @@
+package viamrtsp
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// httpTunnelTransport tunnels RTSP, with interleaved TCP RTP, through HTTP for cameras & NVRs behind
+// firewalls which only allow HTTP, as described by Apple's "Tunneling QuickTime RTSP and RTP over HTTP".
+const httpTunnelTransport = "http-tunnel"
+
+const httpTunnelContentType = "application/x-rtsp-tunnelled"
+
+// isHTTPTunnel returns true if the transport config attribute selects tunneling through HTTP.
+func isHTTPTunnel(transport string) bool {
+	return strings.EqualFold(transport, httpTunnelTransport)
+}
+
+// httpTunnelDialer returns a gortsplib DialContext which tunnels the RTSP connection through HTTP
+// requests to path, opening the connections of the tunnel with dial.
+func httpTunnelDialer(dial dialFunc, path string) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialHTTPTunnel(ctx, dial, network, address, path)
+	}
+}
+
+// dialHTTPTunnel opens a tunnel, which consists of a GET request whose response carries the data sent by
+// the server & a POST request whose body carries the base64 encoded data sent by the client.
+// The requests are tied together by a session cookie.
+func dialHTTPTunnel(ctx context.Context, dial dialFunc, network, address, path string) (_ net.Conn, err error) {
+	cookie := make([]byte, 16)
+	if _, err := rand.Read(cookie); err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("x-sessioncookie: %s\r\nPragma: no-cache\r\nCache-Control: no-cache\r\n", hex.EncodeToString(cookie))
+
+	get, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			//nolint:errcheck
+			get.Close()
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := get.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	req := fmt.Sprintf("GET %s HTTP/1.0\r\n%sAccept: %s\r\n\r\n", path, header, httpTunnelContentType)
+	if _, err := get.Write([]byte(req)); err != nil {
+		return nil, errors.Wrap(err, "sending http tunnel GET request")
+	}
+	reader := bufio.NewReader(get)
+	// the response body is the RTSP stream, which is read from reader once the header is parsed
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading http tunnel GET response")
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("http tunnel GET request responded with status_code: %d", res.StatusCode)
+	}
+	if err := get.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	post, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	// the server does not respond to the POST request, whose body lasts as long as the tunnel
+	req = fmt.Sprintf("POST %s HTTP/1.0\r\n%sContent-Type: %s\r\nContent-Length: 32767\r\n"+
+		"Expires: Sun, 9 Jan 1972 00:00:00 GMT\r\n\r\n", path, header, httpTunnelContentType)
+	if _, err := post.Write([]byte(req)); err != nil {
+		//nolint:errcheck
+		post.Close()
+		return nil, errors.Wrap(err, "sending http tunnel POST request")
+	}
+	return &httpTunnelConn{get: get, reader: reader, post: post}, nil
+}
+
+// httpTunnelConn is a net.Conn which reads from the GET response & writes to the POST body of a tunnel.
+type httpTunnelConn struct {
+	get    net.Conn
+	reader *bufio.Reader
+	post   net.Conn
+}
+
+func (c *httpTunnelConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// Write encodes b on its own, as servers decode each write of the POST body separately.
+func (c *httpTunnelConn) Write(b []byte) (int, error) {
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(encoded, b)
+	if _, err := c.post.Write(encoded); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *httpTunnelConn) Close() error {
+	err := c.post.Close()
+	if getErr := c.get.Close(); err == nil {
+		err = getErr
+	}
+	return err
+}
+
+func (c *httpTunnelConn) LocalAddr() net.Addr {
+	return c.get.LocalAddr()
+}
+
+func (c *httpTunnelConn) RemoteAddr() net.Addr {
+	return c.get.RemoteAddr()
+}
+
+func (c *httpTunnelConn) SetDeadline(t time.Time) error {
+	if err := c.get.SetDeadline(t); err != nil {
+		return err
+	}
+	return c.post.SetDeadline(t)
+}
+
+func (c *httpTunnelConn) SetReadDeadline(t time.Time) error {
+	return c.get.SetReadDeadline(t)
+}
+
+func (c *httpTunnelConn) SetWriteDeadline(t time.Time) error {
+	return c.post.SetWriteDeadline(t)
+}
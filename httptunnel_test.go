@@ -0,0 +1,103 @@
+package viamrtsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+// serveHTTPTunnel accepts one tunnel on ln, echoing the data received through the POST request
+// back through the GET response, and returns the GET & POST requests.
+func serveHTTPTunnel(t *testing.T, ln net.Listener) <-chan []*http.Request {
+	t.Helper()
+	requests := make(chan []*http.Request, 1)
+	go func() {
+		get, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer get.Close()
+		getReq, err := http.ReadRequest(bufio.NewReader(get))
+		if err != nil {
+			return
+		}
+		if _, err := get.Write([]byte("HTTP/1.0 200 OK\r\nContent-Type: application/x-rtsp-tunnelled\r\n\r\n")); err != nil {
+			return
+		}
+
+		post, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer post.Close()
+		postReader := bufio.NewReader(post)
+		postReq, err := http.ReadRequest(postReader)
+		if err != nil {
+			return
+		}
+		requests <- []*http.Request{getReq, postReq}
+		// the body is read directly, as the declared length is only an upper bound
+		//nolint:errcheck
+		io.Copy(get, base64.NewDecoder(base64.StdEncoding, postReader))
+	}()
+	return requests
+}
+
+func TestHTTPTunnel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	test.That(t, err, test.ShouldBeNil)
+	defer ln.Close()
+	requests := serveHTTPTunnel(t, ln)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dial := httpTunnelDialer((&net.Dialer{}).DialContext, "/stream1?channel=2")
+	conn, err := dial(ctx, "tcp", ln.Addr().String())
+	test.That(t, err, test.ShouldBeNil)
+	defer conn.Close()
+
+	reqs := <-requests
+	test.That(t, reqs[0].Method, test.ShouldEqual, http.MethodGet)
+	test.That(t, reqs[0].RequestURI, test.ShouldEqual, "/stream1?channel=2")
+	test.That(t, reqs[0].Header.Get("Accept"), test.ShouldEqual, httpTunnelContentType)
+	test.That(t, reqs[1].Method, test.ShouldEqual, http.MethodPost)
+	test.That(t, reqs[1].Header.Get("Content-Type"), test.ShouldEqual, httpTunnelContentType)
+	test.That(t, reqs[0].Header.Get("x-sessioncookie"), test.ShouldNotBeEmpty)
+	test.That(t, reqs[1].Header.Get("x-sessioncookie"), test.ShouldEqual, reqs[0].Header.Get("x-sessioncookie"))
+
+	// every write is encoded on its own & echoed back by the server, whose decoder only accepts
+	// padding at the end, so only the last write is not a multiple of 3 bytes long
+	request := "OPTIONS rtsp://127.0.0.1/stream1 RTSP/1.0\r\nCSeq: 1\r\n\r\n"
+	for _, chunk := range []string{request[:12], request[12:]} {
+		n, err := conn.Write([]byte(chunk))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, n, test.ShouldEqual, len(chunk))
+	}
+	test.That(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)), test.ShouldBeNil)
+	echoed := make([]byte, len(request))
+	_, err = io.ReadFull(conn, echoed)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(echoed), test.ShouldEqual, request)
+}
+
+func TestHTTPTunnelRejected(t *testing.T) {
+	srv := &http.Server{
+		Handler:           http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }),
+		ReadHeaderTimeout: time.Second,
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	test.That(t, err, test.ShouldBeNil)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	_, err = httpTunnelDialer((&net.Dialer{}).DialContext, "/")(context.Background(), "tcp", ln.Addr().String())
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "status_code: 404")
+}
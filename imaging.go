@@ -0,0 +1,216 @@
+package viamrtsp
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// imagingSettings are the ONVIF imaging settings of a video source which can be read & changed with the
+// get-imaging-settings & set-imaging-settings commands. Settings the camera doesn't report are nil or empty.
+type imagingSettings struct {
+	Brightness            *float64 `xml:"Brightness"`
+	Contrast              *float64 `xml:"Contrast"`
+	ExposureMode          string   `xml:"Exposure>Mode"`
+	IRCutFilter           string   `xml:"IrCutFilter"`
+	WideDynamicRangeMode  string   `xml:"WideDynamicRange>Mode"`
+	WideDynamicRangeLevel *float64 `xml:"WideDynamicRange>Level"`
+}
+
+// toMap returns the settings as the response of the get-imaging-settings command.
+func (s imagingSettings) toMap() map[string]interface{} {
+	m := map[string]interface{}{}
+	if s.Brightness != nil {
+		m["brightness"] = *s.Brightness
+	}
+	if s.Contrast != nil {
+		m["contrast"] = *s.Contrast
+	}
+	if s.ExposureMode != "" {
+		m["exposure_mode"] = s.ExposureMode
+	}
+	if s.IRCutFilter != "" {
+		m["ir_cut_filter"] = s.IRCutFilter
+	}
+	if s.WideDynamicRangeMode != "" {
+		m["wide_dynamic_range"] = s.WideDynamicRangeMode
+	}
+	if s.WideDynamicRangeLevel != nil {
+		m["wide_dynamic_range_level"] = *s.WideDynamicRangeLevel
+	}
+	return m
+}
+
+// update sets the settings of the fields of the set-imaging-settings command cmd, returning whether any were set.
+func (s *imagingSettings) update(cmd map[string]interface{}) (bool, error) {
+	updated := false
+	number := func(key string, dst **float64) error {
+		v, ok := cmd[key]
+		if !ok {
+			return nil
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return errors.Errorf("%s must be a number", key)
+		}
+		*dst = &f
+		updated = true
+		return nil
+	}
+	mode := func(key string, dst *string, modes ...string) error {
+		v, ok := cmd[key]
+		if !ok {
+			return nil
+		}
+		str, ok := v.(string)
+		if !ok || !containsMode(modes, strings.ToUpper(str)) {
+			return errors.Errorf("%s must be one of %s", key, strings.Join(modes, ", "))
+		}
+		*dst = strings.ToUpper(str)
+		updated = true
+		return nil
+	}
+	for _, err := range []error{
+		number("brightness", &s.Brightness),
+		number("contrast", &s.Contrast),
+		mode("exposure_mode", &s.ExposureMode, "AUTO", "MANUAL"),
+		mode("ir_cut_filter", &s.IRCutFilter, "ON", "OFF", "AUTO"),
+		mode("wide_dynamic_range", &s.WideDynamicRangeMode, "ON", "OFF"),
+		number("wide_dynamic_range_level", &s.WideDynamicRangeLevel),
+	} {
+		if err != nil {
+			return false, err
+		}
+	}
+	return updated, nil
+}
+
+func containsMode(modes []string, mode string) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// marshal returns the settings as an ImagingSettings element, whose elements are in the order of its schema.
+func (s imagingSettings) marshal() string {
+	var b strings.Builder
+	element := func(name, value string) {
+		b.WriteString(`<` + name + ` xmlns="` + onvifSchemaNamespace + `">` + value + `</` + name + `>`)
+	}
+	float := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	b.WriteString(`<ImagingSettings>`)
+	if s.Brightness != nil {
+		element("Brightness", float(*s.Brightness))
+	}
+	if s.Contrast != nil {
+		element("Contrast", float(*s.Contrast))
+	}
+	if s.ExposureMode != "" {
+		element("Exposure", `<Mode>`+s.ExposureMode+`</Mode>`)
+	}
+	if s.IRCutFilter != "" {
+		element("IrCutFilter", s.IRCutFilter)
+	}
+	if s.WideDynamicRangeMode != "" {
+		wdr := `<Mode>` + s.WideDynamicRangeMode + `</Mode>`
+		if s.WideDynamicRangeLevel != nil {
+			wdr += `<Level>` + float(*s.WideDynamicRangeLevel) + `</Level>`
+		}
+		element("WideDynamicRange", wdr)
+	}
+	b.WriteString(`</ImagingSettings>`)
+	return b.String()
+}
+
+// imagingService returns a client of the camera's ONVIF imaging service, & the token of the video source of
+// the configured profile.
+func (rc *rtspCamera) imagingService(ctx context.Context, command string) (*onvifClient, string, string, error) {
+	conf, client, err := rc.deviceClient(command)
+	if err != nil {
+		return nil, "", "", err
+	}
+	services := client.services(ctx)
+	_, _, profile, err := client.mediaProfile(ctx, services, conf.Profile)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if profile.videoSourceToken() == "" {
+		return nil, "", "", errors.Errorf("ONVIF profile '%s' has no video source", profile.Token)
+	}
+	return client, client.service(services, onvifImagingNamespace), profile.videoSourceToken(), nil
+}
+
+func (c *onvifClient) imagingSettings(ctx context.Context, imagingURL, videoSource string) (imagingSettings, error) {
+	var res struct {
+		Settings imagingSettings `xml:"Body>GetImagingSettingsResponse>ImagingSettings"`
+	}
+	body := `<GetImagingSettings xmlns="` + onvifImagingNamespace + `"><VideoSourceToken>` + xmlEscape(videoSource) +
+		`</VideoSourceToken></GetImagingSettings>`
+	if err := c.call(ctx, imagingURL, body, &res); err != nil {
+		return imagingSettings{}, errors.Wrap(err, "getting ONVIF imaging settings")
+	}
+	return res.Settings, nil
+}
+
+func (c *onvifClient) setImagingSettings(ctx context.Context, imagingURL, videoSource string, s imagingSettings) error {
+	body := `<SetImagingSettings xmlns="` + onvifImagingNamespace + `"><VideoSourceToken>` + xmlEscape(videoSource) +
+		`</VideoSourceToken>` + s.marshal() + `<ForcePersistence>true</ForcePersistence></SetImagingSettings>`
+	var res struct{}
+	if err := c.call(ctx, imagingURL, body, &res); err != nil {
+		return errors.Wrap(err, "setting ONVIF imaging settings")
+	}
+	return nil
+}
+
+// getImagingSettings returns the brightness, contrast, exposure mode, wide dynamic range & IR cut filter of the
+// video source of the configured ONVIF profile.
+func (rc *rtspCamera) getImagingSettings(ctx context.Context) (map[string]interface{}, error) {
+	client, imagingURL, videoSource, err := rc.imagingService(ctx, getImagingSettingsCommand)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := client.imagingSettings(ctx, imagingURL, videoSource)
+	if err != nil {
+		return nil, err
+	}
+	return settings.toMap(), nil
+}
+
+// setImagingSettings changes the imaging settings of the fields of cmd, keeping the others, & returns the
+// settings once they're changed.
+func (rc *rtspCamera) setImagingSettings(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	var requested imagingSettings
+	updated, err := requested.update(cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, setImagingSettingsCommand)
+	}
+	if !updated {
+		return nil, errors.Errorf("%s requires at least one of brightness, contrast, exposure_mode, ir_cut_filter, "+
+			"wide_dynamic_range & wide_dynamic_range_level", setImagingSettingsCommand)
+	}
+	client, imagingURL, videoSource, err := rc.imagingService(ctx, setImagingSettingsCommand)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := client.imagingSettings(ctx, imagingURL, videoSource)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := settings.update(cmd); err != nil {
+		return nil, err
+	}
+	if err := client.setImagingSettings(ctx, imagingURL, videoSource, settings); err != nil {
+		return nil, err
+	}
+	if settings, err = client.imagingSettings(ctx, imagingURL, videoSource); err != nil {
+		return nil, err
+	}
+	return settings.toMap(), nil
+}
@@ -0,0 +1,60 @@
+package viamrtsp
+
+import (
+	"image"
+	"math"
+
+	"go.viam.com/rdk/rimage/transform"
+)
+
+// maxAspectRatioDifference is how much the aspect ratios of the intrinsics & the stream may differ,
+// e.g. for streams whose height is padded to a multiple of 16, for the intrinsics to be rescaled.
+const maxAspectRatioDifference = 0.01
+
+// scaleIntrinsics returns intrinsics scaled to a stream of size, which is not possible if the aspect
+// ratio of the stream differs from the one the intrinsics were calibrated at.
+func scaleIntrinsics(intrinsics *transform.PinholeCameraIntrinsics, size image.Point) (*transform.PinholeCameraIntrinsics, bool) {
+	if intrinsics.Width <= 0 || intrinsics.Height <= 0 || size.X <= 0 || size.Y <= 0 {
+		return nil, false
+	}
+	sx := float64(size.X) / float64(intrinsics.Width)
+	sy := float64(size.Y) / float64(intrinsics.Height)
+	if math.Abs(sx/sy-1) > maxAspectRatioDifference {
+		return nil, false
+	}
+	return &transform.PinholeCameraIntrinsics{
+		Width:  size.X,
+		Height: size.Y,
+		Fx:     intrinsics.Fx * sx,
+		Fy:     intrinsics.Fy * sy,
+		Ppx:    intrinsics.Ppx * sx,
+		Ppy:    intrinsics.Ppy * sy,
+	}, true
+}
+
+// intrinsicsFor returns the configured intrinsics for frames of size. Intrinsics calibrated at another
+// resolution are rescaled, or returned as is with a warning if the aspect ratios differ.
+// A warning is logged once for each mismatched size.
+func (rc *rtspCamera) intrinsicsFor(size image.Point) *transform.PinholeCameraIntrinsics {
+	rc.propsMu.RLock()
+	intrinsics := rc.cameraModel.PinholeCameraIntrinsics
+	rc.propsMu.RUnlock()
+	if intrinsics == nil || (intrinsics.Width == size.X && intrinsics.Height == size.Y) {
+		return intrinsics
+	}
+	scaled, ok := scaleIntrinsics(intrinsics, size)
+	key := int64(size.X)<<32 | int64(size.Y)
+	if rc.intrinsicsWarnedSize.Swap(key) != key {
+		if ok {
+			rc.logger.Warnf("intrinsic_parameters are for %dx%d but the stream is %dx%d, rescaling them",
+				intrinsics.Width, intrinsics.Height, size.X, size.Y)
+		} else {
+			rc.logger.Warnf("intrinsic_parameters are for %dx%d but the stream is %dx%d, which has another aspect ratio, "+
+				"recalibrate the camera at the stream's resolution", intrinsics.Width, intrinsics.Height, size.X, size.Y)
+		}
+	}
+	if !ok {
+		return intrinsics
+	}
+	return scaled
+}
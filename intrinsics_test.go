@@ -0,0 +1,44 @@
+package viamrtsp
+
+import (
+	"image"
+	"testing"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/rimage/transform"
+	"go.viam.com/test"
+)
+
+func TestScaleIntrinsics(t *testing.T) {
+	intrinsics := &transform.PinholeCameraIntrinsics{Width: 1920, Height: 1080, Fx: 1400, Fy: 1390, Ppx: 960, Ppy: 540}
+
+	scaled, ok := scaleIntrinsics(intrinsics, image.Pt(640, 360))
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, scaled.Width, test.ShouldEqual, 640)
+	test.That(t, scaled.Height, test.ShouldEqual, 360)
+	test.That(t, scaled.Fx, test.ShouldAlmostEqual, 1400.0/3)
+	test.That(t, scaled.Fy, test.ShouldAlmostEqual, 1390.0/3)
+	test.That(t, scaled.Ppx, test.ShouldAlmostEqual, 320)
+	test.That(t, scaled.Ppy, test.ShouldAlmostEqual, 180)
+
+	// heights padded to a multiple of 16 are close enough
+	_, ok = scaleIntrinsics(intrinsics, image.Pt(1920, 1088))
+	test.That(t, ok, test.ShouldBeTrue)
+
+	_, ok = scaleIntrinsics(intrinsics, image.Pt(640, 480))
+	test.That(t, ok, test.ShouldBeFalse)
+}
+
+func TestIntrinsicsFor(t *testing.T) {
+	intrinsics := &transform.PinholeCameraIntrinsics{Width: 1280, Height: 720, Fx: 900, Fy: 900, Ppx: 640, Ppy: 360}
+	rc := &rtspCamera{logger: logging.NewTestLogger(t)}
+	rc.setCameraModel(&Config{IntrinsicParams: intrinsics})
+
+	test.That(t, rc.intrinsicsFor(image.Pt(1280, 720)), test.ShouldResemble, intrinsics)
+	test.That(t, rc.intrinsicsFor(image.Pt(640, 360)).Fx, test.ShouldAlmostEqual, 450)
+	// mismatched aspect ratios can't be rescaled
+	test.That(t, rc.intrinsicsFor(image.Pt(640, 480)), test.ShouldResemble, intrinsics)
+
+	rc.setCameraModel(&Config{})
+	test.That(t, rc.intrinsicsFor(image.Pt(640, 360)), test.ShouldBeNil)
+}
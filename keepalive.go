@@ -0,0 +1,122 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
+)
+
+const (
+	keepaliveAuto         = "auto"
+	keepaliveOptions      = "options"
+	keepaliveGetParameter = "get_parameter"
+)
+
+// keepalivePolicy is how the RTSP session is kept alive while streaming. gortsplib sends keepalives every 80% of
+// the session timeout of the SETUP response, or every 30 seconds without one, using GET_PARAMETER if the camera
+// lists it in its OPTIONS response & OPTIONS otherwise. It has no settings for either, so the policy rewrites the
+// OPTIONS response & the session timeout of responses through the client's callbacks. Its zero value keeps the
+// gortsplib behavior.
+type keepalivePolicy struct {
+	// method of keepalives, empty lets gortsplib pick it
+	method base.Method
+	// interval between keepalives, 0 uses 80% of the session timeout
+	interval time.Duration
+}
+
+// newKeepalivePolicy returns the policy of the keepalive_method & keepalive_interval config attributes.
+func newKeepalivePolicy(method string, interval float64) (keepalivePolicy, error) {
+	if interval < 0 {
+		return keepalivePolicy{}, fmt.Errorf("keepalive_interval %v must not be negative", interval)
+	}
+	k := keepalivePolicy{interval: secondsToDuration(interval)}
+	switch strings.ToLower(method) {
+	case "", keepaliveAuto:
+	case keepaliveOptions:
+		k.method = base.Options
+	case keepaliveGetParameter:
+		k.method = base.GetParameter
+	default:
+		return keepalivePolicy{}, fmt.Errorf("keepalive_method '%s' must be '%s', '%s' or '%s'",
+			method, keepaliveAuto, keepaliveOptions, keepaliveGetParameter)
+	}
+	return k, nil
+}
+
+// keepaliveTracker applies a keepalivePolicy to a connection through the client's OnRequest & OnResponse callbacks.
+type keepaliveTracker struct {
+	policy keepalivePolicy
+	mu     sync.Mutex
+	// method of the latest request
+	method base.Method
+}
+
+func newKeepaliveTracker(policy keepalivePolicy) *keepaliveTracker {
+	return &keepaliveTracker{policy: policy}
+}
+
+func (k *keepaliveTracker) onRequest(req *base.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.method = req.Method
+}
+
+func (k *keepaliveTracker) onResponse(res *base.Response) {
+	k.mu.Lock()
+	method := k.method
+	k.mu.Unlock()
+	if method == base.Options && res.StatusCode == base.StatusOK {
+		k.policy.setPublic(res.Header)
+	}
+	k.policy.setSessionTimeout(res.Header)
+}
+
+// setPublic sets whether the Public header of an OPTIONS response lists GET_PARAMETER, which gortsplib uses
+// as the method of keepalives if it does.
+func (k keepalivePolicy) setPublic(header base.Header) {
+	if k.method == "" {
+		return
+	}
+	var methods []string
+	if pub, ok := header["Public"]; ok && len(pub) == 1 {
+		for _, m := range strings.Split(pub[0], ",") {
+			if m = strings.TrimSpace(m); m != "" && base.Method(m) != base.GetParameter {
+				methods = append(methods, m)
+			}
+		}
+	}
+	if k.method == base.GetParameter {
+		methods = append(methods, string(base.GetParameter))
+	}
+	header["Public"] = base.HeaderValue{strings.Join(methods, ", ")}
+}
+
+// setSessionTimeout sets the session timeout of a response so that gortsplib sends keepalives every interval.
+// The session timeout of the camera is honored, keepalives are never sent less often than gortsplib sends
+// them for it.
+func (k keepalivePolicy) setSessionTimeout(header base.Header) {
+	if k.interval <= 0 {
+		return
+	}
+	v, ok := header["Session"]
+	if !ok {
+		return
+	}
+	var session headers.Session
+	if err := session.Unmarshal(v); err != nil {
+		// gortsplib fails the request
+		return
+	}
+	// gortsplib sends keepalives every 80% of the timeout, in whole seconds
+	timeout := uint(max(math.Floor(k.interval.Seconds()*10/8), 1))
+	if session.Timeout != nil && *session.Timeout > 0 && *session.Timeout < timeout {
+		return
+	}
+	session.Timeout = &timeout
+	header["Session"] = session.Marshal()
+}
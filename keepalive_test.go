@@ -0,0 +1,82 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"go.viam.com/test"
+)
+
+func TestNewKeepalivePolicy(t *testing.T) {
+	k, err := newKeepalivePolicy("", 0)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, k, test.ShouldResemble, keepalivePolicy{})
+	k, err = newKeepalivePolicy("auto", 0)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, k, test.ShouldResemble, keepalivePolicy{})
+	k, err = newKeepalivePolicy("GET_PARAMETER", 5)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, k, test.ShouldResemble, keepalivePolicy{method: base.GetParameter, interval: 5 * time.Second})
+	k, err = newKeepalivePolicy("options", 0.5)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, k, test.ShouldResemble, keepalivePolicy{method: base.Options, interval: 500 * time.Millisecond})
+	_, err = newKeepalivePolicy("describe", 0)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = newKeepalivePolicy("", -1)
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestKeepaliveTracker(t *testing.T) {
+	options := func(k *keepaliveTracker, public base.HeaderValue) base.Header {
+		k.onRequest(&base.Request{Method: base.Options})
+		res := &base.Response{StatusCode: base.StatusOK, Header: base.Header{}}
+		if public != nil {
+			res.Header["Public"] = public
+		}
+		k.onResponse(res)
+		return res.Header
+	}
+	setup := func(k *keepaliveTracker, session string) base.HeaderValue {
+		k.onRequest(&base.Request{Method: base.Setup})
+		res := &base.Response{StatusCode: base.StatusOK, Header: base.Header{"Session": base.HeaderValue{session}}}
+		k.onResponse(res)
+		return res.Header["Session"]
+	}
+	public := base.HeaderValue{"DESCRIBE, SETUP, PLAY, GET_PARAMETER, TEARDOWN"}
+
+	t.Run("auto keeps the responses as they are", func(t *testing.T) {
+		k := newKeepaliveTracker(keepalivePolicy{})
+		test.That(t, options(k, public)["Public"], test.ShouldResemble, public)
+		test.That(t, setup(k, "12345678;timeout=60"), test.ShouldResemble, base.HeaderValue{"12345678;timeout=60"})
+	})
+
+	t.Run("options", func(t *testing.T) {
+		k := newKeepaliveTracker(keepalivePolicy{method: base.Options})
+		test.That(t, options(k, public)["Public"], test.ShouldResemble, base.HeaderValue{"DESCRIBE, SETUP, PLAY, TEARDOWN"})
+	})
+
+	t.Run("get_parameter", func(t *testing.T) {
+		k := newKeepaliveTracker(keepalivePolicy{method: base.GetParameter})
+		test.That(t, options(k, base.HeaderValue{"DESCRIBE, SETUP, PLAY"})["Public"], test.ShouldResemble,
+			base.HeaderValue{"DESCRIBE, SETUP, PLAY, GET_PARAMETER"})
+		test.That(t, options(k, nil)["Public"], test.ShouldResemble, base.HeaderValue{"GET_PARAMETER"})
+		// only OPTIONS responses are changed
+		k.onRequest(&base.Request{Method: base.Describe})
+		header := base.Header{"Public": base.HeaderValue{"DESCRIBE"}}
+		k.onResponse(&base.Response{StatusCode: base.StatusOK, Header: header})
+		test.That(t, header["Public"], test.ShouldResemble, base.HeaderValue{"DESCRIBE"})
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		k := newKeepaliveTracker(keepalivePolicy{interval: 8 * time.Second})
+		// gortsplib sends keepalives every 80% of the timeout
+		test.That(t, setup(k, "12345678;timeout=60"), test.ShouldResemble, base.HeaderValue{"12345678;timeout=10"})
+		test.That(t, setup(k, "12345678"), test.ShouldResemble, base.HeaderValue{"12345678;timeout=10"})
+		// the timeout of the camera is honored
+		test.That(t, setup(k, "12345678;timeout=5"), test.ShouldResemble, base.HeaderValue{"12345678;timeout=5"})
+
+		k = newKeepaliveTracker(keepalivePolicy{interval: 500 * time.Millisecond})
+		test.That(t, setup(k, "12345678"), test.ShouldResemble, base.HeaderValue{"12345678;timeout=1"})
+	})
+}
@@ -0,0 +1,121 @@
+package viamrtsp
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// lazyDecodeIdleTimeout is how long after the last image request decoding stops when lazy_decode is enabled.
+	lazyDecodeIdleTimeout = 10 * time.Second
+	// lazyDecodeWaitTimeout is how long an image request waits for decoding to catch up after being idle.
+	lazyDecodeWaitTimeout = 2 * time.Second
+	// maxBufferedAccessUnits bounds the memory used by long GOPs while decoding is idle.
+	maxBufferedAccessUnits = 600
+)
+
+// bufferedAU is an access unit which was received while decoding was idle.
+type bufferedAU struct {
+	au         [][]byte
+	capturedAt time.Time
+}
+
+// gopBuffer holds the access units since the most recent keyframe, which is the
+// minimum needed to decode the latest frame.
+type gopBuffer struct {
+	aus []bufferedAU
+	// waitingForKeyframe is true when the buffer is empty or overflowed, as inter frames
+	// can't be decoded without the keyframe they reference.
+	waitingForKeyframe bool
+}
+
+func newGOPBuffer() *gopBuffer {
+	return &gopBuffer{waitingForKeyframe: true}
+}
+
+// add buffers au, dropping the previous GOP if au is a keyframe.
+func (b *gopBuffer) add(au [][]byte, capturedAt time.Time, keyframe bool) {
+	if keyframe {
+		b.aus = b.aus[:0]
+		b.waitingForKeyframe = false
+	}
+	if b.waitingForKeyframe {
+		return
+	}
+	if len(b.aus) == maxBufferedAccessUnits {
+		b.aus = b.aus[:0]
+		b.waitingForKeyframe = true
+		return
+	}
+	// NALUs may point into buffers the RTP decoder reuses
+	cp := make([][]byte, len(au))
+	for i, nalu := range au {
+		cp[i] = append([]byte(nil), nalu...)
+	}
+	b.aus = append(b.aus, bufferedAU{au: cp, capturedAt: capturedAt})
+}
+
+// drain returns the buffered access units, oldest first, and empties the buffer.
+func (b *gopBuffer) drain() []bufferedAU {
+	aus := b.aus
+	b.aus = nil
+	b.waitingForKeyframe = true
+	return aus
+}
+
+// decodeIdle reports whether decoding should be skipped because lazy_decode is enabled
+// and no images were requested recently.
+func (rc *rtspCamera) decodeIdle() bool {
+	if !rc.lazyDecode.Load() {
+		return false
+	}
+	return time.Since(time.Unix(0, rc.lastImageRequest.Load())) > lazyDecodeIdleTimeout
+}
+
+// markImageRequested keeps decoding & the stream active. If decoding was idle it waits for all of the buffered
+// access units to be decoded, & if the stream was paused it waits for it to resume, returning the latest frame.
+func (rc *rtspCamera) markImageRequested(ctx context.Context) *frame {
+	latest := rc.frames.latest()
+	wasIdle := rc.decodeIdle()
+	rc.lastImageRequest.Store(time.Now().UnixNano())
+	var wait time.Duration
+	if wasIdle {
+		wait = lazyDecodeWaitTimeout
+	}
+	if rc.resumeStream() {
+		wait = resumeWaitTimeout
+	}
+	if wait == 0 {
+		return latest
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-waitCtx.Done():
+			return rc.frames.latest()
+		case <-ticker.C:
+			if f := rc.frames.latest(); f != latest && rc.drainedGOPDecoded() {
+				return f
+			}
+		}
+	}
+}
+
+// drainedGOPDecoded reports whether the GOP buffered while decoding was idle, if any, has been decoded, so that
+// the frame of its keyframe isn't served as the latest frame.
+func (rc *rtspCamera) drainedGOPDecoded() bool {
+	decoded := rc.drainedGOP.Load()
+	if decoded == nil {
+		return true
+	}
+	select {
+	case <-*decoded:
+		return true
+	default:
+		return false
+	}
+}
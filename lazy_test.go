@@ -0,0 +1,90 @@
+package viamrtsp
+
+import (
+	"context"
+	"image"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestGOPBuffer(t *testing.T) {
+	b := newGOPBuffer()
+	now := time.Now()
+	// inter frames before the first keyframe can't be decoded
+	b.add([][]byte{{1}}, now, false)
+	test.That(t, b.drain(), test.ShouldBeEmpty)
+
+	b.add([][]byte{{2}}, now, true)
+	b.add([][]byte{{3}}, now, false)
+	// a new keyframe drops the previous GOP
+	b.add([][]byte{{4}}, now, true)
+	b.add([][]byte{{5}}, now, false)
+	aus := b.drain()
+	test.That(t, aus, test.ShouldHaveLength, 2)
+	test.That(t, aus[0].au, test.ShouldResemble, [][]byte{{4}})
+	test.That(t, aus[1].au, test.ShouldResemble, [][]byte{{5}})
+
+	// after draining, buffering resumes at the next keyframe
+	b.add([][]byte{{6}}, now, false)
+	test.That(t, b.drain(), test.ShouldBeEmpty)
+
+	// GOPs longer than the buffer are dropped
+	b.add([][]byte{{7}}, now, true)
+	for i := 0; i < maxBufferedAccessUnits; i++ {
+		b.add([][]byte{{8}}, now, false)
+	}
+	test.That(t, b.drain(), test.ShouldBeEmpty)
+}
+
+func TestLazyDecode(t *testing.T) {
+	rc := &rtspCamera{}
+	test.That(t, rc.decodeIdle(), test.ShouldBeFalse)
+
+	rc.lazyDecode.Store(true)
+	test.That(t, rc.decodeIdle(), test.ShouldBeTrue)
+
+	// reading while idle waits for a new frame to be decoded
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	go func() {
+		for rc.decodeIdle() {
+			time.Sleep(time.Millisecond)
+		}
+		rc.storeFrame(img, time.Now())
+	}()
+	got, _, err := rc.readFrame(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, got, test.ShouldEqual, img)
+	test.That(t, rc.decodeIdle(), test.ShouldBeFalse)
+}
+
+func TestLazyDecodeWaitsForDrainedGOP(t *testing.T) {
+	rc := &rtspCamera{}
+	rc.lazyDecode.Store(true)
+	logger := logging.NewTestLogger(t)
+
+	// the drained GOP is decoded in one job, whose frames are stored in order
+	w := newDecodeWorker(&decoder{}, func(_ *decoder, au [][]byte, _ time.Time) {
+		rc.storeFrame(image.NewGray(image.Rect(0, 0, int(au[0][0]), 1)), time.Now())
+	}, nil, func() {}, logger)
+	defer w.stop()
+	go func() {
+		for rc.decodeIdle() {
+			time.Sleep(time.Millisecond)
+		}
+		gop := make([]bufferedAU, 30)
+		for i := range gop {
+			gop[i] = bufferedAU{au: [][]byte{{byte(i + 1)}}, capturedAt: time.Now()}
+		}
+		decoded := make(chan struct{})
+		rc.drainedGOP.Store(&decoded)
+		w.submitGOP(gop, func() { close(decoded) })
+	}()
+
+	// the frame of the last access unit is served rather than the frame of the keyframe
+	got, _, err := rc.readFrame(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, got.Bounds().Dx(), test.ShouldEqual, 30)
+}
@@ -0,0 +1,469 @@
+package viamrtsp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/components/generic"
+	"go.viam.com/rdk/gostream"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/rdk/rimage"
+	rutils "go.viam.com/rdk/utils"
+	"go.viam.com/utils"
+)
+
+const (
+	// managerRetryInterval is how often the manager retries creating the cameras whose first connection failed.
+	managerRetryInterval = 30 * time.Second
+	// listCamerasCommand returns the cameras of a manager & whether they're connected.
+	listCamerasCommand = "list-cameras"
+	// getCameraConfigsCommand returns the config of a camera resource for each of the cameras of a manager.
+	getCameraConfigsCommand = "get-camera-configs"
+	// getImageCommand returns the latest image of one of the cameras of a manager.
+	getImageCommand = "get-image"
+	// managerCameraKey names the camera of a manager a command is for.
+	managerCameraKey = "camera"
+	// includeSecretsKey makes get-camera-configs return the passwords & tokens of the configs.
+	includeSecretsKey = "include_secrets"
+	// redactedSecret replaces secrets in the configs returned by get-camera-configs, as url.URL.Redacted does.
+	redactedSecret = "xxxxx"
+)
+
+// ModelManager creates a camera for each of a list of RTSP addresses, which share the rest of their config.
+var ModelManager = family.WithModel("rtsp-manager")
+
+func init() {
+	resource.RegisterComponent(generic.API, ModelManager, resource.Registration[resource.Resource, *ManagerConfig]{
+		Constructor: newManager,
+	})
+}
+
+// ManagerConfig are the config attributes for the manager model.
+type ManagerConfig struct {
+	// Addresses are the RTSP addresses of the cameras to create.
+	Addresses []string `json:"rtsp_addresses"`
+	// Camera is the config of every camera, except for its rtsp_address.
+	Camera Config `json:"camera"`
+}
+
+// Validate checks to see if the attributes of the model are valid.
+func (conf *ManagerConfig) Validate(path string) ([]string, error) {
+	if len(conf.Addresses) == 0 {
+		return nil, fmt.Errorf("rtsp_addresses is required for component at path '%s'", path)
+	}
+	if conf.Camera.Address != "" {
+		return nil, fmt.Errorf("invalid camera for component at path '%s': rtsp_address must not be set, use rtsp_addresses", path)
+	}
+	// the cameras would all try to listen on the same address, unlike metrics_address whose listener is shared
+	if conf.Camera.RelayAddress != "" {
+		return nil, fmt.Errorf("invalid camera for component at path '%s': relay_address can not be shared", path)
+	}
+	names := map[string]string{}
+	for i, address := range conf.Addresses {
+		if _, err := conf.cameraConfig(address).Validate(fmt.Sprintf("%s.rtsp_addresses.%d", path, i)); err != nil {
+			return nil, err
+		}
+		name := managedCameraName(address)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("invalid rtsp_addresses for component at path '%s': '%s' & '%s' are the same camera", path, other, address)
+		}
+		names[name] = address
+	}
+	return nil, nil
+}
+
+// cameraConfig returns the config of the camera at address.
+func (conf *ManagerConfig) cameraConfig(address string) *Config {
+	c := conf.Camera
+	c.Address = address
+	return &c
+}
+
+// managedCameraName returns the name of the camera at address, relative to the name of its manager.
+// It is derived from the host & path of address, so that it doesn't change when addresses are added or removed.
+func managedCameraName(address string) string {
+	name := address
+	if u, err := base.ParseURL(address); err == nil {
+		name = u.Host + u.Path
+	}
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, name), "-")
+}
+
+// managedCamera is a camera of a manager, which is nil until its first connection succeeds.
+type managedCamera struct {
+	name    string
+	address string
+	cam     *rtspCamera
+	// conf is the config cam was created or last reconfigured with
+	conf *ManagerConfig
+	// err is the error of the last attempt to create the camera
+	err error
+}
+
+// manager creates & removes cameras as its rtsp_addresses change. As a module can't add resources to the robot,
+// its cameras are served by its DoCommands & can be used by rtsp-audio inputs by name, and get-camera-configs
+// returns the config of a camera resource for each of them.
+type manager struct {
+	resource.Named
+	logger logging.Logger
+
+	mu      sync.Mutex
+	conf    *ManagerConfig
+	cameras map[string]*managedCamera
+	// removed are the cameras whose address was removed, which are closed by the sync worker
+	removed []*managedCamera
+	// syncRequests wakes the sync worker up after the config changed
+	syncRequests chan struct{}
+
+	cancel                  context.CancelFunc
+	activeBackgroundWorkers sync.WaitGroup
+}
+
+func newManager(ctx context.Context, _ resource.Dependencies, conf resource.Config, logger logging.Logger) (resource.Resource, error) {
+	m := &manager{
+		Named:        conf.ResourceName().AsNamed(),
+		logger:       logger,
+		cameras:      map[string]*managedCamera{},
+		syncRequests: make(chan struct{}, 1),
+	}
+	if err := m.Reconfigure(ctx, nil, conf); err != nil {
+		return nil, err
+	}
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(func() {
+		ticker := time.NewTicker(managerRetryInterval)
+		defer ticker.Stop()
+		for {
+			m.syncCameras(cancelCtx)
+			select {
+			case <-cancelCtx.Done():
+				return
+			case <-ticker.C:
+			case <-m.syncRequests:
+			}
+		}
+	}, m.activeBackgroundWorkers.Done)
+	return m, nil
+}
+
+// Reconfigure updates the addresses & the config of the cameras, which are then created, reconfigured & closed in
+// the background, so that cameras which are slow to connect don't block reconfiguring the robot.
+// Cameras whose first connection fails are retried every 30 seconds rather than failing the manager.
+func (m *manager) Reconfigure(_ context.Context, _ resource.Dependencies, conf resource.Config) error {
+	newConf, err := resource.NativeConfig[*ManagerConfig](conf)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	addresses := map[string]bool{}
+	for _, address := range newConf.Addresses {
+		addresses[address] = true
+	}
+	for address, mc := range m.cameras {
+		if addresses[address] {
+			continue
+		}
+		if mc.cam != nil {
+			// rtsp-audio inputs stop finding the camera right away, even before it's closed
+			unregisterCamera(mc.cam)
+		}
+		m.removed = append(m.removed, mc)
+		delete(m.cameras, address)
+	}
+	for _, address := range newConf.Addresses {
+		if _, ok := m.cameras[address]; !ok {
+			m.cameras[address] = &managedCamera{name: m.Name().Name + "-" + managedCameraName(address), address: address}
+		}
+	}
+	m.conf = newConf
+	m.mu.Unlock()
+
+	select {
+	case m.syncRequests <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// syncCameras closes the removed cameras, and concurrently reconfigures the cameras whose config changed & creates
+// the cameras which weren't created yet. It is only called by the sync worker.
+func (m *manager) syncCameras(ctx context.Context) {
+	m.mu.Lock()
+	conf := m.conf
+	removed := m.removed
+	m.removed = nil
+	var stale []*managedCamera
+	for _, mc := range m.cameras {
+		if mc.cam == nil || mc.conf != conf {
+			stale = append(stale, mc)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, mc := range removed {
+		if mc.cam == nil {
+			continue
+		}
+		if err := mc.cam.Close(ctx); err != nil {
+			m.logger.Warnf("error closing removed camera %s: %s", mc.name, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, mc := range stale {
+		mc := mc
+		wg.Add(1)
+		utils.PanicCapturingGo(func() {
+			defer wg.Done()
+			m.syncCamera(ctx, conf, mc)
+		})
+	}
+	wg.Wait()
+}
+
+// syncCamera reconfigures the camera of mc with conf, or creates it if it wasn't created yet or can't be reconfigured.
+func (m *manager) syncCamera(ctx context.Context, conf *ManagerConfig, mc *managedCamera) {
+	m.mu.Lock()
+	cam := mc.cam
+	m.mu.Unlock()
+	if cam != nil {
+		camConf := resource.NewEmptyConfig(camera.Named(mc.name), ModelAgnostic)
+		camConf.ConvertedAttributes = conf.cameraConfig(mc.address)
+		err := cam.Reconfigure(ctx, nil, camConf)
+		m.mu.Lock()
+		if err == nil {
+			mc.conf = conf
+			m.mu.Unlock()
+			return
+		}
+		// e.g. stream_type changed, which requires creating the camera again
+		mc.cam = nil
+		m.mu.Unlock()
+		m.logger.Infof("recreating camera %s after reconfiguring failed, err: %s", mc.name, err)
+		if err := cam.Close(ctx); err != nil {
+			m.logger.Warnf("error closing camera %s: %s", mc.name, err)
+		}
+	}
+
+	cam, err := newRTSPCameraFromConfig(
+		ctx, camera.Named(mc.name), ModelAgnostic, conf.cameraConfig(mc.address), nil, m.logger.Sublogger(mc.name))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.logger.Warnf("unable to create camera %s, retrying in %s, err: %s", mc.name, managerRetryInterval, err)
+		mc.err = err
+		return
+	}
+	if m.cameras[mc.address] != mc {
+		// the camera was removed while connecting
+		utils.UncheckedError(cam.Close(ctx))
+		return
+	}
+	mc.cam, mc.conf, mc.err = cam, conf, nil
+	registerCamera(cam)
+}
+
+// DoCommand lists the cameras, returns the image of a camera, or passes commands with a `camera` field on to the camera.
+func (m *manager) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	switch name, _ := cmd[commandKey].(string); name {
+	case listCamerasCommand:
+		return m.listCameras(), nil
+	case getCameraConfigsCommand:
+		includeSecrets, _ := cmd[includeSecretsKey].(bool)
+		return m.getCameraConfigs(includeSecrets)
+	}
+	name, ok := cmd[managerCameraKey].(string)
+	if !ok {
+		return nil, errors.Errorf("DoCommand requires a '%s' command or a string '%s' field", listCamerasCommand, managerCameraKey)
+	}
+	cam, err := m.camera(name)
+	if err != nil {
+		return nil, err
+	}
+	if command, _ := cmd[commandKey].(string); command == getImageCommand {
+		return getImage(ctx, cam, cmd)
+	}
+	return cam.DoCommand(ctx, cmd)
+}
+
+// camera returns the connected camera named name.
+func (m *manager) camera(name string) (*rtspCamera, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mc := range m.cameras {
+		if mc.name != name {
+			continue
+		}
+		if mc.cam == nil {
+			return nil, errors.Errorf("camera '%s' is not connected yet", name)
+		}
+		return mc.cam, nil
+	}
+	return nil, errors.Errorf("unknown camera '%s'", name)
+}
+
+func (m *manager) listCameras() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.cameras))
+	byName := map[string]*managedCamera{}
+	for _, mc := range m.cameras {
+		names = append(names, mc.name)
+		byName[mc.name] = mc
+	}
+	sort.Strings(names)
+	cameras := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		mc := byName[name]
+		c := map[string]interface{}{"name": name, "address": mc.address}
+		if u, err := base.ParseURL(mc.address); err == nil {
+			c["address"] = u.CloneWithoutCredentials().String()
+		}
+		if mc.cam != nil {
+			codec := videoCodec(mc.cam.currentCodec.Load())
+			c["connected"] = codec != Unknown
+			c["codec"] = codec.String()
+		} else {
+			c["connected"] = false
+			if mc.err != nil {
+				c["error"] = mc.err.Error()
+			}
+		}
+		cameras = append(cameras, c)
+	}
+	return map[string]interface{}{"cameras": cameras}
+}
+
+// getCameraConfigs returns the config of a camera component for each address, in the order of rtsp_addresses, which
+// can be added to the robot's config for the cameras to be resources of their own, e.g. to capture their images with
+// the data manager. Passwords & tokens are redacted unless includeSecrets is set.
+func (m *manager) getCameraConfigs(includeSecrets bool) (map[string]interface{}, error) {
+	m.mu.Lock()
+	conf := m.conf
+	names := make([]string, 0, len(conf.Addresses))
+	for _, address := range conf.Addresses {
+		names = append(names, m.cameras[address].name)
+	}
+	m.mu.Unlock()
+
+	components := make([]interface{}, 0, len(conf.Addresses))
+	for i, address := range conf.Addresses {
+		c := conf.cameraConfig(address)
+		if !includeSecrets {
+			c = c.redactSecrets()
+		}
+		b, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		var attributes map[string]interface{}
+		if err := json.Unmarshal(b, &attributes); err != nil {
+			return nil, err
+		}
+		components = append(components, map[string]interface{}{
+			"name":       names[i],
+			"api":        camera.API.String(),
+			"model":      ModelAgnostic.String(),
+			"attributes": attributes,
+		})
+	}
+	return map[string]interface{}{"components": components}, nil
+}
+
+// redactSecrets returns a copy of c whose passwords, including those of its addresses, & token request headers
+// are replaced with redactedSecret.
+func (c *Config) redactSecrets() *Config {
+	redacted := *c
+	redactURL := func(address string) string {
+		u, err := url.Parse(address)
+		if err != nil {
+			return address
+		}
+		return u.Redacted()
+	}
+	redacted.Address = redactURL(c.Address)
+	redacted.FallbackAddresses = nil
+	for _, address := range c.FallbackAddresses {
+		redacted.FallbackAddresses = append(redacted.FallbackAddresses, redactURL(address))
+	}
+	redacted.SnapshotURL = redactURL(c.SnapshotURL)
+	if c.Password != "" {
+		redacted.Password = redactedSecret
+	}
+	if c.RelayPassword != "" {
+		redacted.RelayPassword = redactedSecret
+	}
+	if c.TokenAuth != nil {
+		tokenAuth := *c.TokenAuth
+		tokenAuth.TokenURL = redactURL(c.TokenAuth.TokenURL)
+		tokenAuth.RequestHeaders = make(map[string]string, len(c.TokenAuth.RequestHeaders))
+		for k := range c.TokenAuth.RequestHeaders {
+			tokenAuth.RequestHeaders[k] = redactedSecret
+		}
+		redacted.TokenAuth = &tokenAuth
+	}
+	return &redacted
+}
+
+// getImage returns the latest image of cam encoded as the optional `mime_type` of cmd, JPEG by default.
+func getImage(ctx context.Context, cam *rtspCamera, cmd map[string]interface{}) (map[string]interface{}, error) {
+	mimeType, _ := cmd["mime_type"].(string)
+	if mimeType == "" {
+		mimeType = rutils.MimeTypeJPEG
+	}
+	img, release, err := cam.readFrame(gostream.WithMIMETypeHint(ctx, mimeType))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	b, err := rimage.EncodeImage(ctx, img, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"mime_type": mimeType, "image": base64.StdEncoding.EncodeToString(b)}, nil
+}
+
+// Close closes every camera.
+func (m *manager) Close(ctx context.Context) error {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.activeBackgroundWorkers.Wait()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	toClose := m.removed
+	for _, mc := range m.cameras {
+		toClose = append(toClose, mc)
+	}
+	var err error
+	for _, mc := range toClose {
+		if mc.cam == nil {
+			continue
+		}
+		if closeErr := mc.cam.Close(ctx); err == nil {
+			err = closeErr
+		}
+	}
+	m.cameras = map[string]*managedCamera{}
+	m.removed = nil
+	return err
+}
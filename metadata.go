@@ -0,0 +1,252 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"github.com/pkg/errors"
+)
+
+const (
+	// onvifMetadataCodec & klvCodec are the RTP encoding names of ONVIF metadata & KLV (RFC 6597) tracks.
+	onvifMetadataCodec = "vnd.onvif.metadata"
+	klvCodec           = "smpte336m"
+	// maxMetadataUnitSize bounds the size of a metadata document or KLV unit reassembled from RTP packets.
+	maxMetadataUnitSize = 1 << 20
+	// maxMetadataEvents is the number of most recent ONVIF events which are kept.
+	maxMetadataEvents = 50
+	// klvKeySize is the size of the universal key which starts a KLV unit.
+	klvKeySize = 16
+)
+
+// findMetadataTrack returns the first ONVIF metadata or KLV track of session & its encoding name.
+func findMetadataTrack(session *description.Session) (*description.Media, *format.Generic, string) {
+	for _, media := range session.Medias {
+		for _, f := range media.Formats {
+			g, ok := f.(*format.Generic)
+			if !ok {
+				continue
+			}
+			codec := strings.ToLower(strings.Split(g.RTPMap(), "/")[0])
+			if codec == onvifMetadataCodec || codec == klvCodec {
+				return media, g, codec
+			}
+		}
+	}
+	return nil, nil, ""
+}
+
+// initMetadata sets up the client to receive the stream's metadata track, if there is one.
+func (rc *rtspCamera) initMetadata(session *description.Session) error {
+	media, f, codec := findMetadataTrack(session)
+	if media == nil {
+		rc.logger.Warn("metadata is enabled but the stream has no ONVIF metadata or KLV track")
+		return nil
+	}
+	if _, err := rc.client.Setup(session.BaseURL, media, 0, 0); err != nil {
+		return errors.Wrapf(err, "when calling RTSP Setup on %s for %s", session.BaseURL.CloneWithoutCredentials(), codec)
+	}
+
+	var reassembler metadataReassembler
+	rc.onPacketRTP(media, f, func(pkt *rtp.Packet) {
+		unit := reassembler.add(pkt)
+		if unit == nil {
+			return
+		}
+		capturedAt := rc.packetTime(media, pkt)
+		if codec == klvCodec {
+			rc.metadata.storeKLV(unit, capturedAt)
+			return
+		}
+		if err := rc.metadata.storeONVIF(unit, capturedAt); err != nil {
+			rc.logger.Debugf("error parsing ONVIF metadata err: %s", err)
+		}
+	})
+	return nil
+}
+
+// metadataReassembler reassembles the metadata documents or KLV units of a track, which are split across
+// RTP packets, the last of which has the marker bit set.
+type metadataReassembler struct {
+	buf       []byte
+	timestamp uint32
+	// discarding is set after a unit outgrew maxMetadataUnitSize, until its last packet
+	discarding bool
+}
+
+// add adds the payload of pkt, returning the unit it completes, if any.
+func (r *metadataReassembler) add(pkt *rtp.Packet) []byte {
+	if len(r.buf) > 0 && pkt.Timestamp != r.timestamp {
+		// the last packet of the previous unit was lost
+		r.buf = r.buf[:0]
+	}
+	r.timestamp = pkt.Timestamp
+	if !r.discarding {
+		r.buf = append(r.buf, pkt.Payload...)
+		if len(r.buf) > maxMetadataUnitSize {
+			r.buf, r.discarding = r.buf[:0], true
+		}
+	}
+	if !pkt.Marker {
+		return nil
+	}
+	if r.discarding {
+		r.discarding = false
+		return nil
+	}
+	unit := append([]byte(nil), r.buf...)
+	r.buf = r.buf[:0]
+	return unit
+}
+
+// onvifMetadataStream is a tt:MetadataStream document, of which the video analytics frames & the events are parsed.
+// Elements are matched by their local names, as cameras use various namespace prefixes.
+type onvifMetadataStream struct {
+	Frames []struct {
+		UtcTime string `xml:"UtcTime,attr"`
+		Objects []struct {
+			ObjectID    string            `xml:"ObjectId,attr"`
+			BoundingBox *onvifBoundingBox `xml:"Appearance>Shape>BoundingBox"`
+			Class       struct {
+				// Types are the classes of ONVIF 2.x, ClassCandidates those of ONVIF 1.x
+				Types []struct {
+					Likelihood float64 `xml:"Likelihood,attr"`
+					Value      string  `xml:",chardata"`
+				} `xml:"Type"`
+				ClassCandidates []struct {
+					Type       string  `xml:"Type"`
+					Likelihood float64 `xml:"Likelihood"`
+				} `xml:"ClassCandidate"`
+			} `xml:"Appearance>Class"`
+		} `xml:"Object"`
+	} `xml:"VideoAnalytics>Frame"`
+	Notifications []onvifNotification `xml:"Event>NotificationMessage"`
+}
+
+// onvifNotification is an ONVIF event, as a wsnt:NotificationMessage of a metadata stream or of the events service.
+type onvifNotification struct {
+	Topic   string `xml:"Topic"`
+	Message struct {
+		UtcTime           string            `xml:"UtcTime,attr"`
+		PropertyOperation string            `xml:"PropertyOperation,attr"`
+		Source            []onvifSimpleItem `xml:"Source>SimpleItem"`
+		Data              []onvifSimpleItem `xml:"Data>SimpleItem"`
+	} `xml:"Message>Message"`
+}
+
+// onvifBoundingBox is a bounding box in the normalized coordinates of ONVIF, from -1 to 1 unless the
+// camera transforms them.
+type onvifBoundingBox struct {
+	Left   float64 `xml:"left,attr"`
+	Top    float64 `xml:"top,attr"`
+	Right  float64 `xml:"right,attr"`
+	Bottom float64 `xml:"bottom,attr"`
+}
+
+type onvifSimpleItem struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:"Value,attr"`
+}
+
+func simpleItems(items []onvifSimpleItem) map[string]interface{} {
+	m := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		m[item.Name] = item.Value
+	}
+	return m
+}
+
+// metadataStore holds the latest video analytics frame, the most recent events & the latest KLV unit
+// received on the metadata track.
+type metadataStore struct {
+	mu     sync.Mutex
+	frame  map[string]interface{}
+	events []map[string]interface{}
+	klv    map[string]interface{}
+}
+
+// storeONVIF parses doc, a tt:MetadataStream document received at capturedAt.
+func (s *metadataStore) storeONVIF(doc []byte, capturedAt time.Time) error {
+	var stream onvifMetadataStream
+	if err := xml.NewDecoder(bytes.NewReader(doc)).Decode(&stream); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, frame := range stream.Frames {
+		objects := make([]interface{}, 0, len(frame.Objects))
+		for _, obj := range frame.Objects {
+			o := map[string]interface{}{"id": obj.ObjectID}
+			if box := obj.BoundingBox; box != nil {
+				o["bounding_box"] = map[string]interface{}{"left": box.Left, "top": box.Top, "right": box.Right, "bottom": box.Bottom}
+			}
+			// the classes are listed from the most likely
+			if types := obj.Class.Types; len(types) > 0 {
+				o["class"], o["likelihood"] = strings.TrimSpace(types[0].Value), types[0].Likelihood
+			} else if candidates := obj.Class.ClassCandidates; len(candidates) > 0 {
+				o["class"], o["likelihood"] = strings.TrimSpace(candidates[0].Type), candidates[0].Likelihood
+			}
+			objects = append(objects, o)
+		}
+		s.frame = map[string]interface{}{
+			"utc_time":    frame.UtcTime,
+			"captured_at": capturedAt.Format(time.RFC3339Nano),
+			"objects":     objects,
+		}
+	}
+	for _, n := range stream.Notifications {
+		s.events = append(s.events, map[string]interface{}{
+			"topic":              strings.TrimSpace(n.Topic),
+			"utc_time":           n.Message.UtcTime,
+			"property_operation": n.Message.PropertyOperation,
+			"source":             simpleItems(n.Message.Source),
+			"data":               simpleItems(n.Message.Data),
+			"captured_at":        capturedAt.Format(time.RFC3339Nano),
+		})
+	}
+	if len(s.events) > maxMetadataEvents {
+		s.events = append([]map[string]interface{}(nil), s.events[len(s.events)-maxMetadataEvents:]...)
+	}
+	return nil
+}
+
+// storeKLV keeps unit, a KLV unit received at capturedAt, whose universal key is returned separately
+// from the rest of the unit.
+func (s *metadataStore) storeKLV(unit []byte, capturedAt time.Time) {
+	klv := map[string]interface{}{"captured_at": capturedAt.Format(time.RFC3339Nano)}
+	if len(unit) >= klvKeySize {
+		klv["key"] = hex.EncodeToString(unit[:klvKeySize])
+		klv["value"] = base64.StdEncoding.EncodeToString(unit[klvKeySize:])
+	} else {
+		klv["value"] = base64.StdEncoding.EncodeToString(unit)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.klv = klv
+}
+
+// snapshot returns the latest video analytics frame, the most recent events, oldest first, & the latest KLV unit.
+func (s *metadataStore) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]interface{}, 0, len(s.events))
+	for _, event := range s.events {
+		events = append(events, event)
+	}
+	resp := map[string]interface{}{"events": events}
+	if s.frame != nil {
+		resp["frame"] = s.frame
+	}
+	if s.klv != nil {
+		resp["klv"] = s.klv
+	}
+	return resp
+}
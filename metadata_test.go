@@ -0,0 +1,163 @@
+package viamrtsp
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"go.viam.com/test"
+)
+
+const onvifMetadataDoc = `<?xml version="1.0" encoding="UTF-8"?>
+<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2"
+    xmlns:tns1="http://www.onvif.org/ver10/topics">
+  <tt:VideoAnalytics>
+    <tt:Frame UtcTime="2024-05-03T20:33:01.5Z">
+      <tt:Object ObjectId="12">
+        <tt:Appearance>
+          <tt:Shape>
+            <tt:BoundingBox left="-0.4" top="0.6" right="-0.1" bottom="0.1"/>
+            <tt:CenterOfGravity x="-0.25" y="0.35"/>
+          </tt:Shape>
+          <tt:Class>
+            <tt:Type Likelihood="0.9">Human</tt:Type>
+          </tt:Class>
+        </tt:Appearance>
+      </tt:Object>
+      <tt:Object ObjectId="13">
+        <tt:Appearance>
+          <tt:Class>
+            <tt:ClassCandidate>
+              <tt:Type>Vehical</tt:Type>
+              <tt:Likelihood>0.7</tt:Likelihood>
+            </tt:ClassCandidate>
+          </tt:Class>
+        </tt:Appearance>
+      </tt:Object>
+    </tt:Frame>
+  </tt:VideoAnalytics>
+  <tt:Event>
+    <wsnt:NotificationMessage>
+      <wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">
+        tns1:RuleEngine/CellMotionDetector/Motion
+      </wsnt:Topic>
+      <wsnt:Message>
+        <tt:Message UtcTime="2024-05-03T20:33:00Z" PropertyOperation="Changed">
+          <tt:Source>
+            <tt:SimpleItem Name="VideoSourceConfigurationToken" Value="VideoSource_1"/>
+            <tt:SimpleItem Name="Rule" Value="MyMotionDetectorRule"/>
+          </tt:Source>
+          <tt:Data>
+            <tt:SimpleItem Name="IsMotion" Value="true"/>
+          </tt:Data>
+        </tt:Message>
+      </wsnt:Message>
+    </wsnt:NotificationMessage>
+  </tt:Event>
+</tt:MetadataStream>`
+
+func TestFindMetadataTrack(t *testing.T) {
+	video := &description.Media{Type: description.MediaTypeVideo, Formats: []format.Format{&format.H264{PayloadTyp: 96}}}
+	onvif := &format.Generic{PayloadTyp: 107, RTPMa: "vnd.onvif.metadata/90000"}
+	klv := &format.Generic{PayloadTyp: 108, RTPMa: "SMPTE336M/1000"}
+
+	media, f, codec := findMetadataTrack(&description.Session{Medias: []*description.Media{
+		video,
+		{Type: description.MediaTypeApplication, Formats: []format.Format{onvif}},
+	}})
+	test.That(t, media, test.ShouldNotBeNil)
+	test.That(t, f, test.ShouldEqual, onvif)
+	test.That(t, codec, test.ShouldEqual, onvifMetadataCodec)
+
+	_, f, codec = findMetadataTrack(&description.Session{Medias: []*description.Media{
+		video,
+		{Type: description.MediaTypeApplication, Formats: []format.Format{klv}},
+	}})
+	test.That(t, f, test.ShouldEqual, klv)
+	test.That(t, codec, test.ShouldEqual, klvCodec)
+
+	media, _, _ = findMetadataTrack(&description.Session{Medias: []*description.Media{video}})
+	test.That(t, media, test.ShouldBeNil)
+}
+
+func TestMetadataReassembler(t *testing.T) {
+	var r metadataReassembler
+	pkt := func(ts uint32, marker bool, payload string) *rtp.Packet {
+		return &rtp.Packet{Header: rtp.Header{Timestamp: ts, Marker: marker}, Payload: []byte(payload)}
+	}
+
+	test.That(t, r.add(pkt(1, false, "<a>")), test.ShouldBeNil)
+	test.That(t, r.add(pkt(1, false, "b")), test.ShouldBeNil)
+	test.That(t, string(r.add(pkt(1, true, "</a>"))), test.ShouldEqual, "<a>b</a>")
+
+	t.Run("drops a unit whose last packet was lost", func(t *testing.T) {
+		test.That(t, r.add(pkt(2, false, "<lost>")), test.ShouldBeNil)
+		test.That(t, string(r.add(pkt(3, true, "<c/>"))), test.ShouldEqual, "<c/>")
+	})
+
+	t.Run("drops a unit which is too large", func(t *testing.T) {
+		chunk := string(make([]byte, maxMetadataUnitSize/2+1))
+		test.That(t, r.add(pkt(4, false, chunk)), test.ShouldBeNil)
+		test.That(t, r.add(pkt(4, false, chunk)), test.ShouldBeNil)
+		test.That(t, r.add(pkt(4, true, "</a>")), test.ShouldBeNil)
+		test.That(t, string(r.add(pkt(5, true, "<d/>"))), test.ShouldEqual, "<d/>")
+	})
+}
+
+func TestMetadataStore(t *testing.T) {
+	var store metadataStore
+	test.That(t, store.snapshot(), test.ShouldResemble, map[string]interface{}{"events": []interface{}{}})
+
+	capturedAt := time.Unix(1714768381, 500000000).UTC()
+	test.That(t, store.storeONVIF([]byte(onvifMetadataDoc), capturedAt), test.ShouldBeNil)
+	snapshot := store.snapshot()
+	test.That(t, snapshot["frame"], test.ShouldResemble, map[string]interface{}{
+		"utc_time":    "2024-05-03T20:33:01.5Z",
+		"captured_at": "2024-05-03T20:33:01.5Z",
+		"objects": []interface{}{
+			map[string]interface{}{
+				"id":           "12",
+				"bounding_box": map[string]interface{}{"left": -0.4, "top": 0.6, "right": -0.1, "bottom": 0.1},
+				"class":        "Human",
+				"likelihood":   0.9,
+			},
+			map[string]interface{}{"id": "13", "class": "Vehical", "likelihood": 0.7},
+		},
+	})
+	test.That(t, snapshot["events"], test.ShouldResemble, []interface{}{
+		map[string]interface{}{
+			"topic":              "tns1:RuleEngine/CellMotionDetector/Motion",
+			"utc_time":           "2024-05-03T20:33:00Z",
+			"property_operation": "Changed",
+			"source":             map[string]interface{}{"VideoSourceConfigurationToken": "VideoSource_1", "Rule": "MyMotionDetectorRule"},
+			"data":               map[string]interface{}{"IsMotion": "true"},
+			"captured_at":        "2024-05-03T20:33:01.5Z",
+		},
+	})
+
+	t.Run("keeps the most recent events", func(t *testing.T) {
+		for i := 0; i < maxMetadataEvents; i++ {
+			test.That(t, store.storeONVIF([]byte(onvifMetadataDoc), capturedAt.Add(time.Second)), test.ShouldBeNil)
+		}
+		events := store.snapshot()["events"].([]interface{})
+		test.That(t, events, test.ShouldHaveLength, maxMetadataEvents)
+		test.That(t, events[0].(map[string]interface{})["captured_at"], test.ShouldEqual, "2024-05-03T20:33:02.5Z")
+	})
+
+	t.Run("rejects malformed documents", func(t *testing.T) {
+		test.That(t, store.storeONVIF([]byte("<tt:MetadataStream><tt:Event>"), capturedAt), test.ShouldNotBeNil)
+	})
+
+	t.Run("stores KLV units", func(t *testing.T) {
+		key := []byte{6, 14, 43, 52, 2, 11, 1, 1, 14, 1, 3, 1, 1, 0, 0, 0}
+		store.storeKLV(append(append([]byte{}, key...), 3, 2, 0, 1), capturedAt)
+		test.That(t, store.snapshot()["klv"], test.ShouldResemble, map[string]interface{}{
+			"captured_at": "2024-05-03T20:33:01.5Z",
+			"key":         "060e2b34020b01010e01030101000000",
+			"value":       base64.StdEncoding.EncodeToString([]byte{3, 2, 0, 1}),
+		})
+	})
+}
@@ -0,0 +1,269 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+	"github.com/pion/rtp"
+	"github.com/pkg/errors"
+	"go.viam.com/utils"
+)
+
+// streamMetrics counts events which describe the health of a camera's stream.
+// The counters are kept across reconnects & reconfigures.
+type streamMetrics struct {
+	framesDecoded      atomic.Uint64
+	rtpPacketsReceived atomic.Uint64
+	rtpPacketsLost     atomic.Uint64
+	reconnects         atomic.Uint64
+	reconnectFailures  atomic.Uint64
+	decodeErrors       atomic.Uint64
+	subscriberDrops    atomic.Uint64
+	decodeQueueDrops   atomic.Uint64
+	decoderHangs       atomic.Uint64
+	framesSuppressed   atomic.Uint64
+
+	framesPerSecond rateCounter
+}
+
+// snapshot returns the current values of the metrics, keyed by their names without the metric name prefix.
+func (m *streamMetrics) snapshot(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"frames_decoded":         m.framesDecoded.Load(),
+		"frames_decoded_per_sec": m.framesPerSecond.rate(now),
+		"rtp_packets_received":   m.rtpPacketsReceived.Load(),
+		"rtp_packets_lost":       m.rtpPacketsLost.Load(),
+		"reconnects":             m.reconnects.Load(),
+		"reconnect_failures":     m.reconnectFailures.Load(),
+		"decode_errors":          m.decodeErrors.Load(),
+		"subscriber_queue_drops": m.subscriberDrops.Load(),
+		"decode_queue_drops":     m.decodeQueueDrops.Load(),
+		"decoder_hangs":          m.decoderHangs.Load(),
+		"frames_suppressed":      m.framesSuppressed.Load(),
+	}
+}
+
+// frameDecoded counts a decoded frame.
+func (m *streamMetrics) frameDecoded(now time.Time) {
+	m.framesDecoded.Add(1)
+	m.framesPerSecond.add(now)
+}
+
+// packetsLost counts the packets reported lost by a gortsplib OnPacketLost error.
+func (m *streamMetrics) packetsLost(err error) {
+	var lost liberrors.ErrClientRTPPacketsLost
+	if errors.As(err, &lost) {
+		m.rtpPacketsLost.Add(uint64(lost.Lost))
+		return
+	}
+	m.rtpPacketsLost.Add(1)
+}
+
+// countPackets wraps an OnPacketRTP callback so that every packet it receives is counted.
+func (m *streamMetrics) countPackets(cb gortsplib.OnPacketRTPFunc) gortsplib.OnPacketRTPFunc {
+	return func(pkt *rtp.Packet) {
+		m.rtpPacketsReceived.Add(1)
+		cb(pkt)
+	}
+}
+
+// rateCounter reports the number of events which happened in the last complete second.
+type rateCounter struct {
+	mu       sync.Mutex
+	second   int64
+	current  uint64
+	previous uint64
+}
+
+func (r *rateCounter) add(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roll(now.Unix())
+	r.current++
+}
+
+func (r *rateCounter) rate(now time.Time) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roll(now.Unix())
+	return r.previous
+}
+
+func (r *rateCounter) roll(second int64) {
+	switch {
+	case second == r.second:
+	case second == r.second+1:
+		r.previous, r.current = r.current, 0
+	default:
+		r.previous, r.current = 0, 0
+	}
+	r.second = second
+}
+
+// metricDescriptions are the help texts of the metrics served by the metrics listener.
+var metricDescriptions = map[string]struct {
+	help    string
+	counter bool
+}{
+	"frames_decoded":         {"Frames decoded from the stream.", true},
+	"frames_decoded_per_sec": {"Frames decoded in the last complete second.", false},
+	"rtp_packets_received":   {"RTP packets received.", true},
+	"rtp_packets_lost":       {"RTP packets lost.", true},
+	"reconnects":             {"Successful reconnects to the stream.", true},
+	"reconnect_failures":     {"Failed attempts to reconnect to the stream.", true},
+	"decode_errors":          {"Errors decoding the stream.", true},
+	"subscriber_queue_drops": {"Packets & audio chunks dropped because a subscriber's queue was full.", true},
+	"decode_queue_drops":     {"Access units dropped because the decoder fell behind.", true},
+	"decoder_hangs":          {"Decoders restarted because decoding an access unit hung.", true},
+	"frames_suppressed":      {"Access units not decoded because packet loss corrupted them or their reference frames.", true},
+}
+
+// writePrometheusMetrics writes the metrics of the cameras, keyed by camera name, in the Prometheus text exposition
+// format, with the samples of every camera grouped under each metric.
+func writePrometheusMetrics(w io.Writer, cameras map[string]map[string]interface{}) error {
+	cameraNames := make([]string, 0, len(cameras))
+	var names []string
+	for cameraName, metrics := range cameras {
+		cameraNames = append(cameraNames, cameraName)
+		if names == nil {
+			for name := range metrics {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(cameraNames)
+	sort.Strings(names)
+	for _, name := range names {
+		desc := metricDescriptions[name]
+		fullName, typ := "viamrtsp_"+name, "gauge"
+		if desc.counter {
+			fullName, typ = fullName+"_total", "counter"
+		}
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", fullName, desc.help, fullName, typ); err != nil {
+			return err
+		}
+		for _, cameraName := range cameraNames {
+			if _, err := fmt.Fprintf(w, "%s{camera=%q} %v\n", fullName, cameraName, cameras[cameraName][name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// metricsServers serves the metrics of the cameras of the module which share a metrics_address from one listener,
+// so that a single scrape target covers all of them.
+type metricsServers struct {
+	mu      sync.Mutex
+	servers map[string]*metricsServer
+}
+
+// metricsServer is the listener of a metrics_address & the cameras whose metrics it serves.
+type metricsServer struct {
+	srv *http.Server
+
+	mu      sync.Mutex
+	cameras map[*rtspCamera]struct{}
+}
+
+// sharedMetricsServers serves the metrics of every camera of the module.
+var sharedMetricsServers = newMetricsServers()
+
+func newMetricsServers() *metricsServers {
+	return &metricsServers{servers: map[string]*metricsServer{}}
+}
+
+// add serves the metrics of rc on http://<address>/metrics, starting to listen on address unless another camera
+// already serves its metrics there.
+func (s *metricsServers) add(address string, rc *rtspCamera) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if server, ok := s.servers[address]; ok {
+		server.mu.Lock()
+		server.cameras[rc] = struct{}{}
+		server.mu.Unlock()
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrapf(err, "unable to listen for metrics on %s", address)
+	}
+	server := &metricsServer{cameras: map[*rtspCamera]struct{}{rc: {}}}
+	logger := rc.logger
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := writePrometheusMetrics(w, server.snapshot(time.Now())); err != nil {
+			logger.Debugf("error writing metrics err: %s", err)
+		}
+	})
+	server.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	utils.PanicCapturingGo(func() {
+		if err := server.srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logger.Warnf("metrics server on %s stopped, err: %s", address, err)
+		}
+	})
+	s.servers[address] = server
+	rc.logger.Infof("serving metrics on http://%s/metrics", ln.Addr())
+	return nil
+}
+
+// remove stops serving the metrics of rc on address, closing the listener once no camera uses it.
+func (s *metricsServers) remove(address string, rc *rtspCamera) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	server, ok := s.servers[address]
+	if !ok {
+		return
+	}
+	server.mu.Lock()
+	delete(server.cameras, rc)
+	remaining := len(server.cameras)
+	server.mu.Unlock()
+	if remaining > 0 {
+		return
+	}
+	delete(s.servers, address)
+	if err := server.srv.Close(); err != nil {
+		rc.logger.Debugf("error closing metrics server err: %s", err)
+	}
+}
+
+// snapshot returns the metrics of the cameras of the server, keyed by camera name.
+func (s *metricsServer) snapshot(now time.Time) map[string]map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cameras := make(map[string]map[string]interface{}, len(s.cameras))
+	for rc := range s.cameras {
+		cameras[rc.Name().Name] = rc.metrics.snapshot(now)
+	}
+	return cameras
+}
+
+// startMetricsServer serves the metrics of the camera on http://<address>/metrics, along with the metrics of the
+// other cameras of the module with the same metrics_address.
+func (rc *rtspCamera) startMetricsServer(address string) error {
+	if err := sharedMetricsServers.add(address, rc); err != nil {
+		return err
+	}
+	rc.metricsAddress = address
+	return nil
+}
+
+// stopMetricsServer stops serving the metrics of the camera if they are served.
+func (rc *rtspCamera) stopMetricsServer() {
+	if rc.metricsAddress == "" {
+		return
+	}
+	sharedMetricsServers.remove(rc.metricsAddress, rc)
+	rc.metricsAddress = ""
+}
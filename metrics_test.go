@@ -0,0 +1,98 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+	"github.com/pion/rtp"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestRateCounter(t *testing.T) {
+	var r rateCounter
+	start := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		r.add(start)
+	}
+	// the current second is not complete yet
+	test.That(t, r.rate(start), test.ShouldEqual, uint64(0))
+	test.That(t, r.rate(start.Add(time.Second)), test.ShouldEqual, uint64(3))
+	// no events in the last complete second
+	test.That(t, r.rate(start.Add(2*time.Second)), test.ShouldEqual, uint64(0))
+	r.add(start.Add(5 * time.Second))
+	test.That(t, r.rate(start.Add(10*time.Second)), test.ShouldEqual, uint64(0))
+}
+
+func TestStreamMetrics(t *testing.T) {
+	var m streamMetrics
+	var received int
+	cb := m.countPackets(func(*rtp.Packet) { received++ })
+	cb(&rtp.Packet{})
+	cb(&rtp.Packet{})
+	test.That(t, received, test.ShouldEqual, 2)
+
+	m.packetsLost(liberrors.ErrClientRTPPacketsLost{Lost: 5})
+	m.packetsLost(context.DeadlineExceeded)
+
+	snapshot := m.snapshot(time.Now())
+	test.That(t, snapshot["rtp_packets_received"], test.ShouldEqual, uint64(2))
+	test.That(t, snapshot["rtp_packets_lost"], test.ShouldEqual, uint64(6))
+
+	var buf bytes.Buffer
+	test.That(t, writePrometheusMetrics(&buf, map[string]map[string]interface{}{"cam": snapshot}), test.ShouldBeNil)
+	test.That(t, buf.String(), test.ShouldContainSubstring,
+		"# TYPE viamrtsp_rtp_packets_lost_total counter\nviamrtsp_rtp_packets_lost_total{camera=\"cam\"} 6\n")
+	test.That(t, buf.String(), test.ShouldContainSubstring,
+		"# TYPE viamrtsp_frames_decoded_per_sec gauge\nviamrtsp_frames_decoded_per_sec{camera=\"cam\"} 0\n")
+}
+
+func TestGetMetricsCommand(t *testing.T) {
+	rc := &rtspCamera{}
+	rc.metrics.reconnects.Add(1)
+	resp, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": "get-metrics"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["reconnects"], test.ShouldEqual, uint64(1))
+}
+
+func TestSharedMetricsServer(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+	servers := newMetricsServers()
+	a := &rtspCamera{Named: camera.Named("a").AsNamed(), logger: logger}
+	b := &rtspCamera{Named: camera.Named("b").AsNamed(), logger: logger}
+	a.metrics.reconnects.Add(1)
+	b.metrics.reconnects.Add(2)
+
+	// the cameras of the module with the same metrics_address share one listener
+	const address = "127.0.0.1:32610"
+	test.That(t, servers.add(address, a), test.ShouldBeNil)
+	test.That(t, servers.add(address, b), test.ShouldBeNil)
+	scrape := func() (string, error) {
+		res, err := http.Get("http://" + address + "/metrics")
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		return string(body), err
+	}
+	body, err := scrape()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, body, test.ShouldContainSubstring, "# TYPE viamrtsp_reconnects_total counter\n"+
+		"viamrtsp_reconnects_total{camera=\"a\"} 1\nviamrtsp_reconnects_total{camera=\"b\"} 2\n")
+
+	// the listener is closed once no camera uses it
+	servers.remove(address, a)
+	body, err = scrape()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, body, test.ShouldNotContainSubstring, `camera="a"`)
+	servers.remove(address, b)
+	_, err = scrape()
+	test.That(t, err, test.ShouldNotBeNil)
+}
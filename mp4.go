@@ -0,0 +1,373 @@
+package viamrtsp
+
+/*
+#cgo pkg-config: libavformat libavcodec libavutil
+#include <libavformat/avformat.h>
+#include <libavcodec/avcodec.h>
+#include <libavutil/dict.h>
+#include <libavutil/mem.h>
+#include <stdlib.h>
+#include <string.h>
+*/
+import "C"
+
+import (
+	"math"
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// mp4TimeBase is the time base packets are written in, which is the RTP clock rate of H264 & H265.
+var mp4TimeBase = C.AVRational{num: 1, den: 90000}
+
+// mp4Muxer remuxes an H264 or H265 elementary stream into a fragmented MP4 file, without transcoding.
+// Fragmented MP4 files stay playable if the module stops before the file is closed.
+type mp4Muxer struct {
+	fmtCtx *C.AVFormatContext
+	stream *C.AVStream
+	packet *C.AVPacket
+}
+
+// newMP4Muxer creates the MP4 file at path. params are the Annex-B encoded parameter sets of the stream.
+func newMP4Muxer(path string, codec videoCodec, params []byte, width, height int) (*mp4Muxer, error) {
+	var codecID C.enum_AVCodecID
+	switch codec {
+	case H264:
+		codecID = C.AV_CODEC_ID_H264
+	case H265:
+		codecID = C.AV_CODEC_ID_HEVC
+	case Unknown, Agnostic, MJPEG, AV1, VP8, VP9, MPEG4:
+		return nil, errors.Errorf("recording is not supported for codec %s", codec)
+	default:
+		return nil, errors.Errorf("recording is not supported for codec %s", codec)
+	}
+
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	cFormat := C.CString("mp4")
+	defer C.free(unsafe.Pointer(cFormat))
+
+	m := &mp4Muxer{}
+	if res := C.avformat_alloc_output_context2(&m.fmtCtx, nil, cFormat, cPath); res < 0 {
+		return nil, errors.Errorf("avformat_alloc_output_context2() failed: %s", avError(res))
+	}
+
+	m.stream = C.avformat_new_stream(m.fmtCtx, nil)
+	if m.stream == nil {
+		m.free()
+		return nil, errors.New("avformat_new_stream() failed")
+	}
+	m.stream.time_base = mp4TimeBase
+	par := m.stream.codecpar
+	par.codec_type = C.AVMEDIA_TYPE_VIDEO
+	par.codec_id = codecID
+	par.width = C.int(width)
+	par.height = C.int(height)
+	if len(params) > 0 {
+		// extradata must be allocated by libav & padded, it is freed with the format context
+		par.extradata = (*C.uint8_t)(C.av_mallocz(C.size_t(len(params) + C.AV_INPUT_BUFFER_PADDING_SIZE)))
+		if par.extradata == nil {
+			m.free()
+			return nil, errors.New("av_mallocz() failed")
+		}
+		C.memcpy(unsafe.Pointer(par.extradata), unsafe.Pointer(&params[0]), C.size_t(len(params)))
+		par.extradata_size = C.int(len(params))
+	}
+
+	if res := C.avio_open(&m.fmtCtx.pb, cPath, C.AVIO_FLAG_WRITE); res < 0 {
+		m.free()
+		return nil, errors.Errorf("avio_open() failed: %s", avError(res))
+	}
+
+	var opts *C.AVDictionary
+	cKey := C.CString("movflags")
+	defer C.free(unsafe.Pointer(cKey))
+	cValue := C.CString("frag_keyframe+empty_moov+default_base_moof")
+	defer C.free(unsafe.Pointer(cValue))
+	C.av_dict_set(&opts, cKey, cValue, 0)
+	res := C.avformat_write_header(m.fmtCtx, &opts)
+	C.av_dict_free(&opts)
+	if res < 0 {
+		C.avio_closep(&m.fmtCtx.pb)
+		m.free()
+		return nil, errors.Errorf("avformat_write_header() failed: %s", avError(res))
+	}
+
+	m.packet = C.av_packet_alloc()
+	if m.packet == nil {
+		m.close()
+		return nil, errors.New("av_packet_alloc() failed")
+	}
+	return m, nil
+}
+
+// writePacket writes an Annex-B encoded access unit. pts & dts are relative to the start of the file.
+func (m *mp4Muxer) writePacket(au []byte, pts, dts time.Duration, keyframe bool) error {
+	if len(au) == 0 {
+		return nil
+	}
+	data := C.CBytes(au)
+	defer C.free(data)
+
+	// the packet is not reference counted, so libavformat copies the data
+	m.packet.data = (*C.uint8_t)(data)
+	m.packet.size = C.int(len(au))
+	m.packet.stream_index = m.stream.index
+	m.packet.pts = C.av_rescale_q(C.int64_t(durationToTicks(pts)), mp4TimeBase, m.stream.time_base)
+	m.packet.dts = C.av_rescale_q(C.int64_t(durationToTicks(dts)), mp4TimeBase, m.stream.time_base)
+	m.packet.flags = 0
+	if keyframe {
+		m.packet.flags = C.AV_PKT_FLAG_KEY
+	}
+	res := C.av_interleaved_write_frame(m.fmtCtx, m.packet)
+	C.av_packet_unref(m.packet)
+	if res < 0 {
+		return errors.Errorf("av_interleaved_write_frame() failed: %s", avError(res))
+	}
+	return nil
+}
+
+// close finishes & closes the file.
+func (m *mp4Muxer) close() error {
+	var err error
+	if res := C.av_write_trailer(m.fmtCtx); res < 0 {
+		err = errors.Errorf("av_write_trailer() failed: %s", avError(res))
+	}
+	C.avio_closep(&m.fmtCtx.pb)
+	if m.packet != nil {
+		C.av_packet_free(&m.packet)
+	}
+	m.free()
+	return err
+}
+
+func (m *mp4Muxer) free() {
+	C.avformat_free_context(m.fmtCtx)
+	m.fmtCtx = nil
+}
+
+// durationToTicks converts d to ticks of the 90kHz clock.
+func durationToTicks(d time.Duration) int64 {
+	return int64(d) * 90000 / int64(time.Second)
+}
+
+// clipPacket is a packet read from a segment, whose timestamps are in timeBase relative to the segment start.
+type clipPacket struct {
+	packet       *C.AVPacket
+	timeBase     C.AVRational
+	segmentStart time.Time
+}
+
+// clipWriter remuxes the packets of consecutive segments into a single MP4 file, without transcoding.
+type clipWriter struct {
+	path   string
+	fmtCtx *C.AVFormatContext
+	stream *C.AVStream
+	// base is the capture time of the first packet written, which has a DTS of 0
+	base    time.Time
+	started bool
+	lastDTS int64
+	// pending holds the packets since the last keyframe before the start of the clip, which are
+	// needed to decode the first frames of the clip
+	pending []clipPacket
+}
+
+// remuxClip writes the packets of segments captured between from & to into a new MP4 file at path.
+// The clip starts at the last keyframe at or before from, so that its first frame can be decoded.
+func remuxClip(path string, segments []segmentFile, from, to time.Time) error {
+	w := &clipWriter{path: path}
+	defer w.dropPending()
+	for _, s := range segments {
+		done, err := w.addSegment(s, from, to)
+		if err != nil {
+			w.abort()
+			return err
+		}
+		if done {
+			break
+		}
+	}
+	return w.close()
+}
+
+// addSegment writes the packets of s which were captured before to, returning true once a packet
+// captured after to is read.
+func (w *clipWriter) addSegment(s segmentFile, from, to time.Time) (bool, error) {
+	cPath := C.CString(s.path)
+	defer C.free(unsafe.Pointer(cPath))
+	var in *C.AVFormatContext
+	if res := C.avformat_open_input(&in, cPath, nil, nil); res < 0 {
+		return false, errors.Errorf("avformat_open_input() failed for %s: %s", s.path, avError(res))
+	}
+	defer C.avformat_close_input(&in)
+	if in.nb_streams < 1 {
+		return false, errors.Errorf("recording segment %s has no streams", s.path)
+	}
+	// segments only contain a single video stream
+	inStream := *in.streams
+	if w.fmtCtx == nil {
+		if err := w.open(inStream.codecpar); err != nil {
+			return false, err
+		}
+	}
+
+	for {
+		pkt := C.av_packet_alloc()
+		if pkt == nil {
+			return false, errors.New("av_packet_alloc() failed")
+		}
+		// read errors are treated as the end of the segment, as the segment being recorded ends mid fragment
+		if res := C.av_read_frame(in, pkt); res < 0 {
+			C.av_packet_free(&pkt)
+			return false, nil
+		}
+		p := clipPacket{packet: pkt, timeBase: inStream.time_base, segmentStart: s.start}
+		if pkt.stream_index != inStream.index || pkt.pts == C.int64_t(math.MinInt64) || pkt.dts == C.int64_t(math.MinInt64) {
+			C.av_packet_free(&pkt)
+			continue
+		}
+		capturedAt := s.start.Add(ticksToDuration(int64(C.av_rescale_q(pkt.pts, p.timeBase, mp4TimeBase))))
+		if capturedAt.After(to) {
+			C.av_packet_free(&pkt)
+			return true, nil
+		}
+		keyframe := pkt.flags&C.AV_PKT_FLAG_KEY != 0
+		if !w.started {
+			if keyframe {
+				w.dropPending()
+			}
+			if len(w.pending) == 0 && !keyframe {
+				// frames before the first keyframe can't be decoded
+				C.av_packet_free(&pkt)
+				continue
+			}
+			w.pending = append(w.pending, p)
+			if capturedAt.Before(from) {
+				continue
+			}
+			pending := w.pending
+			w.pending = nil
+			for i, pendingPacket := range pending {
+				if err := w.write(pendingPacket); err != nil {
+					w.pending = pending[i+1:]
+					return false, err
+				}
+			}
+			continue
+		}
+		if err := w.write(p); err != nil {
+			return false, err
+		}
+	}
+}
+
+func (w *clipWriter) open(par *C.AVCodecParameters) error {
+	cPath := C.CString(w.path)
+	defer C.free(unsafe.Pointer(cPath))
+	cFormat := C.CString("mp4")
+	defer C.free(unsafe.Pointer(cFormat))
+
+	if res := C.avformat_alloc_output_context2(&w.fmtCtx, nil, cFormat, cPath); res < 0 {
+		return errors.Errorf("avformat_alloc_output_context2() failed: %s", avError(res))
+	}
+	w.stream = C.avformat_new_stream(w.fmtCtx, nil)
+	if w.stream == nil {
+		w.free()
+		return errors.New("avformat_new_stream() failed")
+	}
+	if res := C.avcodec_parameters_copy(w.stream.codecpar, par); res < 0 {
+		w.free()
+		return errors.Errorf("avcodec_parameters_copy() failed: %s", avError(res))
+	}
+	w.stream.codecpar.codec_tag = 0
+	w.stream.time_base = mp4TimeBase
+	if res := C.avio_open(&w.fmtCtx.pb, cPath, C.AVIO_FLAG_WRITE); res < 0 {
+		w.free()
+		return errors.Errorf("avio_open() failed: %s", avError(res))
+	}
+	if res := C.avformat_write_header(w.fmtCtx, nil); res < 0 {
+		C.avio_closep(&w.fmtCtx.pb)
+		w.free()
+		return errors.Errorf("avformat_write_header() failed: %s", avError(res))
+	}
+	return nil
+}
+
+// write writes & frees p. Timestamps are made relative to the first packet of the clip, so that
+// the gaps between segments, e.g. while reconnecting, are kept.
+func (w *clipWriter) write(p clipPacket) error {
+	defer C.av_packet_free(&p.packet)
+	pts := int64(C.av_rescale_q(p.packet.pts, p.timeBase, mp4TimeBase))
+	dts := int64(C.av_rescale_q(p.packet.dts, p.timeBase, mp4TimeBase))
+	if !w.started {
+		w.started = true
+		w.base = p.segmentStart.Add(ticksToDuration(dts))
+		w.lastDTS = -1
+	}
+	offset := durationToTicks(p.segmentStart.Sub(w.base))
+	if dts+offset <= w.lastDTS {
+		// segments can overlap if the camera's clock jumped back
+		return nil
+	}
+	w.lastDTS = dts + offset
+	p.packet.pts = C.av_rescale_q(C.int64_t(pts+offset), mp4TimeBase, w.stream.time_base)
+	p.packet.dts = C.av_rescale_q(C.int64_t(dts+offset), mp4TimeBase, w.stream.time_base)
+	p.packet.stream_index = w.stream.index
+	p.packet.pos = -1
+	if res := C.av_interleaved_write_frame(w.fmtCtx, p.packet); res < 0 {
+		return errors.Errorf("av_interleaved_write_frame() failed: %s", avError(res))
+	}
+	return nil
+}
+
+func (w *clipWriter) dropPending() {
+	for i := range w.pending {
+		C.av_packet_free(&w.pending[i].packet)
+	}
+	w.pending = nil
+}
+
+// close finishes the clip, deleting it if no packets were captured between from & to.
+func (w *clipWriter) close() error {
+	if w.fmtCtx == nil {
+		return errNoRecording
+	}
+	var err error
+	if res := C.av_write_trailer(w.fmtCtx); res < 0 {
+		err = errors.Errorf("av_write_trailer() failed: %s", avError(res))
+	}
+	C.avio_closep(&w.fmtCtx.pb)
+	w.free()
+	if !w.started {
+		err = errNoRecording
+	}
+	if err != nil {
+		//nolint:errcheck
+		os.Remove(w.path)
+	}
+	return err
+}
+
+// abort closes & deletes a clip which failed to be written.
+func (w *clipWriter) abort() {
+	if w.fmtCtx == nil {
+		return
+	}
+	C.avio_closep(&w.fmtCtx.pb)
+	w.free()
+	//nolint:errcheck
+	os.Remove(w.path)
+}
+
+func (w *clipWriter) free() {
+	C.avformat_free_context(w.fmtCtx)
+	w.fmtCtx = nil
+}
+
+// ticksToDuration converts ticks of the 90kHz clock to a duration.
+func ticksToDuration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / 90000
+}
@@ -0,0 +1,163 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"math/bits"
+
+	mcbits "github.com/bluenviron/mediacommon/pkg/bits"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4video"
+	"github.com/pkg/errors"
+)
+
+// mpeg4Profiles names the profiles of MPEG-4 Part 2 video by the upper 4 bits of their profile_and_level_indication.
+var mpeg4Profiles = map[uint8]string{
+	0x0: "Simple",
+	0x1: "Simple Scalable",
+	0x2: "Core",
+	0x3: "Main",
+	0x9: "Advanced Real Time Simple",
+	0xb: "Advanced Coding Efficiency",
+	0xf: "Advanced Simple",
+}
+
+const (
+	// mpeg4ShapeRectangular & mpeg4ShapeGrayscale are values of video_object_layer_shape.
+	mpeg4ShapeRectangular = 0
+	mpeg4ShapeGrayscale   = 3
+	// mpeg4AspectRatioExtendedPAR is the aspect_ratio_info of layers which code their pixel aspect ratio.
+	mpeg4AspectRatioExtendedPAR = 0xf
+)
+
+// mpeg4StartCode returns the bytes following the first start code of buf for which match returns true,
+// or nil if buf has none.
+func mpeg4StartCode(buf []byte, match func(code mpeg4video.StartCode) bool) []byte {
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] == 0 && buf[i+1] == 0 && buf[i+2] == 1 && match(mpeg4video.StartCode(buf[i+3])) {
+			return buf[i+4:]
+		}
+	}
+	return nil
+}
+
+// mpeg4IsKeyframe returns true if frame contains an intra coded VOP, which is decoded without reference frames.
+func mpeg4IsKeyframe(frame []byte) bool {
+	vop := mpeg4StartCode(frame, func(code mpeg4video.StartCode) bool { return code == mpeg4video.VOPStartCode })
+	// vop_coding_type is the first 2 bits of the VOP, 0 for I-VOPs
+	return len(vop) > 0 && vop[0]>>6 == 0
+}
+
+// mpeg4StreamInfo parses the stream info from the visual object sequence & video object layer headers of
+// MPEG-4 Part 2 video, which the config of the SDP contains & encoders usually repeat before keyframes.
+// It returns nil if buf has no video object layer header or it can't be parsed.
+func mpeg4StreamInfo(buf []byte) *streamInfo {
+	vol := mpeg4StartCode(buf, func(code mpeg4video.StartCode) bool {
+		return code >= mpeg4video.VideoObjectLayerStartCodeFirst && code <= mpeg4video.VideoObjectLayerStartCodeLast
+	})
+	if vol == nil {
+		return nil
+	}
+	info := &streamInfo{}
+	if err := parseMPEG4VideoObjectLayer(vol, info); err != nil {
+		return nil
+	}
+	vos := mpeg4StartCode(buf, func(code mpeg4video.StartCode) bool { return code == mpeg4video.VisualObjectSequenceStartCode })
+	if len(vos) > 0 {
+		info.profile, info.level = mpeg4ProfileLevel(vos[0])
+	}
+	return info
+}
+
+// mpeg4ProfileLevel names the profile & level of a profile_and_level_indication.
+func mpeg4ProfileLevel(indication uint8) (string, string) {
+	profile := profileName(mpeg4Profiles, indication>>4)
+	switch indication {
+	case 0x08:
+		return profile, "0"
+	case 0x09:
+		return profile, "0b"
+	case 0x04:
+		return profile, "4a"
+	case 0xf7:
+		return profile, "3b"
+	default:
+		return profile, fmt.Sprintf("%d", indication&0x0f)
+	}
+}
+
+// bitReader reads the fields of a header, keeping the first error so that it only needs to be checked once.
+type bitReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) read(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var v uint64
+	v, r.err = mcbits.ReadBits(r.buf, &r.pos, n)
+	return v
+}
+
+func (r *bitReader) flag() bool {
+	return r.read(1) == 1
+}
+
+// parseMPEG4VideoObjectLayer parses the resolution, interlacing & fixed frame rate of the video object layer
+// header vol, which follows its start code, as specified by ISO 14496-2 6.2.3.
+func parseMPEG4VideoObjectLayer(vol []byte, info *streamInfo) error {
+	r := &bitReader{buf: vol}
+	r.read(1) // random_accessible_vol
+	r.read(8) // video_object_type_indication
+	verid := uint64(1)
+	if r.flag() { // is_object_layer_identifier
+		verid = r.read(4)
+		r.read(3) // video_object_layer_priority
+	}
+	if r.read(4) == mpeg4AspectRatioExtendedPAR {
+		r.read(16) // par_width & par_height
+	}
+	if r.flag() { // vol_control_parameters
+		// chroma_format & low_delay
+		r.read(3)
+		if r.flag() { // vbv_parameters
+			r.read(79)
+		}
+	}
+	shape := r.read(2)
+	if shape == mpeg4ShapeGrayscale && verid != 1 {
+		r.read(4) // video_object_layer_shape_extension
+	}
+	r.read(1) // marker_bit
+	timeIncrementResolution := r.read(16)
+	r.read(1) // marker_bit
+	fixedVOPRate := r.flag()
+	if r.err == nil && timeIncrementResolution == 0 {
+		return errors.New("invalid vop_time_increment_resolution 0")
+	}
+	var fixedVOPTimeIncrement uint64
+	if fixedVOPRate {
+		// coded in as many bits as vop_time_increment_resolution - 1 needs, at least 1
+		fixedVOPTimeIncrement = r.read(max(bits.Len64(timeIncrementResolution-1), 1))
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if shape != mpeg4ShapeRectangular {
+		return errors.Errorf("unsupported video_object_layer_shape %d", shape)
+	}
+	r.read(1) // marker_bit
+	info.width = int(r.read(13))
+	r.read(1) // marker_bit
+	info.height = int(r.read(13))
+	r.read(1) // marker_bit
+	info.interlaced = r.flag()
+	if r.err != nil {
+		return r.err
+	}
+	if fixedVOPRate && fixedVOPTimeIncrement > 0 {
+		info.fps = float64(timeIncrementResolution) / float64(fixedVOPTimeIncrement)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestMPEG4StreamInfo(t *testing.T) {
+	// the config of a 1920x1080 Simple profile stream encoded by FFmpeg
+	info := mpeg4StreamInfo([]byte{
+		0x00, 0x00, 0x01, 0xb0, 0x01, 0x00, 0x00, 0x01, 0xb5, 0x89, 0x13, 0x00, 0x00, 0x01, 0x00, 0x00,
+		0x00, 0x01, 0x20, 0x00, 0xc4, 0x8d, 0x88, 0x00, 0xf5, 0x3c, 0x04, 0x87, 0x14, 0x43, 0x00, 0x00,
+		0x01, 0xb2, 0x4c, 0x61, 0x76, 0x63, 0x36, 0x30, 0x2e, 0x32, 0x33, 0x2e, 0x31, 0x30, 0x30,
+	})
+	test.That(t, info, test.ShouldNotBeNil)
+	test.That(t, info.profile, test.ShouldEqual, "Simple")
+	test.That(t, info.level, test.ShouldEqual, "1")
+	test.That(t, info.width, test.ShouldEqual, 1920)
+	test.That(t, info.height, test.ShouldEqual, 1080)
+	test.That(t, info.fps, test.ShouldEqual, 0)
+	test.That(t, info.interlaced, test.ShouldBeFalse)
+
+	// an interlaced 704x576 Advanced Simple profile stream at a fixed 25 fps
+	info = mpeg4StreamInfo([]byte{
+		0x00, 0x00, 0x01, 0xb0, 0xf5, 0x00, 0x00, 0x01, 0x20, 0x00, 0x84, 0x40, 0x06, 0x70, 0xc5, 0x81, 0x12, 0x07,
+	})
+	test.That(t, info, test.ShouldNotBeNil)
+	test.That(t, info.profile, test.ShouldEqual, "Advanced Simple")
+	test.That(t, info.level, test.ShouldEqual, "5")
+	test.That(t, info.width, test.ShouldEqual, 704)
+	test.That(t, info.height, test.ShouldEqual, 576)
+	test.That(t, info.fps, test.ShouldEqual, 25)
+	test.That(t, info.interlaced, test.ShouldBeTrue)
+
+	// a truncated video object layer
+	test.That(t, mpeg4StreamInfo([]byte{0x00, 0x00, 0x01, 0x20, 0x00, 0x84}), test.ShouldBeNil)
+	// a VOP without headers
+	test.That(t, mpeg4StreamInfo([]byte{0x00, 0x00, 0x01, 0xb6, 0x10}), test.ShouldBeNil)
+}
+
+func TestMPEG4IsKeyframe(t *testing.T) {
+	test.That(t, mpeg4IsKeyframe([]byte{0x00, 0x00, 0x01, 0xb3, 0x00, 0x00, 0x01, 0xb6, 0x10}), test.ShouldBeTrue)
+	// a P-VOP
+	test.That(t, mpeg4IsKeyframe([]byte{0x00, 0x00, 0x01, 0xb6, 0x50}), test.ShouldBeFalse)
+	test.That(t, mpeg4IsKeyframe([]byte{0x00, 0x00, 0x01, 0xb0, 0x01}), test.ShouldBeFalse)
+}
+
+func TestMPEG4ProfileLevel(t *testing.T) {
+	for indication, expected := range map[uint8][2]string{
+		0x08: {"Simple", "0"},
+		0x03: {"Simple", "3"},
+		0xf7: {"Advanced Simple", "3b"},
+		0x22: {"Core", "2"},
+		0x71: {"unknown (7)", "1"},
+	} {
+		profile, level := mpeg4ProfileLevel(indication)
+		test.That(t, profile, test.ShouldEqual, expected[0])
+		test.That(t, level, test.ShouldEqual, expected[1])
+	}
+}
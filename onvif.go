@@ -0,0 +1,428 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // WS-Security password digests are defined as SHA-1
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// onvifTimeout bounds each ONVIF request, & resolving the stream URI of the ONVIF profile.
+	onvifTimeout = 10 * time.Second
+	// maxONVIFResponseSize bounds the memory used by a single ONVIF response.
+	maxONVIFResponseSize = 4 << 20
+	// defaultMaxClockDrift is the default drift of the camera's clock above which it's logged & corrected.
+	defaultMaxClockDrift = 5 * time.Second
+
+	onvifDeviceNamespace  = "http://www.onvif.org/ver10/device/wsdl"
+	onvifMediaNamespace   = "http://www.onvif.org/ver10/media/wsdl"
+	onvifMedia2Namespace  = "http://www.onvif.org/ver20/media/wsdl"
+	onvifImagingNamespace = "http://www.onvif.org/ver20/imaging/wsdl"
+	onvifPTZNamespace     = "http://www.onvif.org/ver20/ptz/wsdl"
+	onvifSchemaNamespace  = "http://www.onvif.org/ver10/schema"
+)
+
+// ONVIFConfig resolves the RTSP address of the camera from its ONVIF device service, so that the address
+// doesn't need to be hardcoded & survives firmware updates which change the camera's URL scheme.
+type ONVIFConfig struct {
+	// DeviceServiceURL is the ONVIF device service of the camera, e.g. http://192.168.1.2/onvif/device_service.
+	DeviceServiceURL string `json:"device_service_url"`
+	// Profile is the token or name of the media profile to stream, e.g. "mainStream". The first profile
+	// is streamed if it's empty.
+	Profile string `json:"profile,omitempty"`
+	// SubstreamProfile is the token or name of a lower resolution media profile, e.g. "subStream", which is
+	// streamed instead of Profile while no rtp_passthrough subscriber is active.
+	SubstreamProfile string `json:"substream_profile,omitempty"`
+	// MaxClockDrift is how far, in seconds, the camera's clock can drift from the clock of the host before the
+	// drift is logged & corrected when the camera is configured. Defaults to 5 seconds.
+	MaxClockDrift float64 `json:"max_clock_drift,omitempty"`
+	// SyncClock sets the camera's clock to the clock of the host once it drifted more than MaxClockDrift.
+	SyncClock bool `json:"sync_clock,omitempty"`
+	// DisableBFrames asks the camera to encode Profile with the Baseline H264 profile once B-frames, which WebRTC
+	// doesn't support, are detected while rtp_passthrough is enabled, instead of disabling rtp_passthrough.
+	DisableBFrames bool `json:"disable_b_frames,omitempty"`
+	// SerialNumber & MACAddress pin the camera, which is rediscovered with WS-Discovery at a new address, e.g. after
+	// DHCP renumbering, once reconnecting to it fails repeatedly.
+	SerialNumber string `json:"serial_number,omitempty"`
+	MACAddress   string `json:"mac_address,omitempty"`
+	// DiscoveryTypes & DiscoveryScopes filter the devices the pinned camera is rediscovered among, so that discovery
+	// on large networks only queries the relevant devices. DiscoveryTypes defaults to dn:NetworkVideoTransmitter.
+	DiscoveryTypes  []string `json:"discovery_types,omitempty"`
+	DiscoveryScopes []string `json:"discovery_scopes,omitempty"`
+}
+
+// Validate checks that the ONVIF config has a device service URL & a valid max_clock_drift.
+func (c *ONVIFConfig) Validate(path string) error {
+	if c.DeviceServiceURL == "" {
+		return fmt.Errorf("invalid onvif config for component at path '%s': device_service_url is required", path)
+	}
+	if !strings.HasPrefix(c.DeviceServiceURL, "http://") && !strings.HasPrefix(c.DeviceServiceURL, "https://") {
+		return fmt.Errorf("invalid onvif config for component at path '%s': device_service_url must be an http:// or https:// url", path)
+	}
+	if c.MaxClockDrift < 0 {
+		return fmt.Errorf("invalid onvif config for component at path '%s': max_clock_drift must not be negative", path)
+	}
+	if c.SubstreamProfile != "" && strings.EqualFold(c.SubstreamProfile, c.Profile) {
+		return fmt.Errorf("invalid onvif config for component at path '%s': substream_profile must differ from profile", path)
+	}
+	if err := validateWSDiscoveryTypes(c.DiscoveryTypes); err != nil {
+		return fmt.Errorf("invalid onvif discovery_types for component at path '%s': %w", path, err)
+	}
+	for _, scope := range c.DiscoveryScopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return fmt.Errorf("invalid onvif discovery_scopes for component at path '%s': '%s' must be a non empty scope "+
+				"without whitespace", path, scope)
+		}
+	}
+	return nil
+}
+
+func (c *ONVIFConfig) maxClockDrift() time.Duration {
+	if c.MaxClockDrift == 0 {
+		return defaultMaxClockDrift
+	}
+	return time.Duration(c.MaxClockDrift * float64(time.Second))
+}
+
+// onvifProfile is a media profile of an ONVIF camera.
+type onvifProfile struct {
+	Token string `xml:"token,attr"`
+	Name  string `xml:"Name"`
+	// the token of the video source of the profile, which Media & Media2 responses have in different elements
+	VideoSource       string `xml:"VideoSourceConfiguration>SourceToken"`
+	Media2VideoSource string `xml:"Configurations>VideoSource>SourceToken"`
+	// the video encoder configuration of Media profiles, which Media2 services return by profile instead
+	VideoEncoder struct {
+		Token string `xml:"token,attr"`
+	} `xml:"VideoEncoderConfiguration"`
+}
+
+func (p onvifProfile) videoSourceToken() string {
+	if p.VideoSource != "" {
+		return p.VideoSource
+	}
+	return p.Media2VideoSource
+}
+
+// onvifClient sends the few ONVIF requests needed to resolve the stream URI of a profile & to control the
+// imaging settings of its video source. Requests are authenticated with a WS-Security UsernameToken if
+// username is set.
+type onvifClient struct {
+	deviceServiceURL string
+	username         string
+	password         string
+	httpClient       *http.Client
+}
+
+func (c *ONVIFConfig) client(username, password string) *onvifClient {
+	return &onvifClient{
+		deviceServiceURL: c.DeviceServiceURL,
+		username:         username,
+		password:         password,
+		httpClient:       &http.Client{Timeout: onvifTimeout},
+	}
+}
+
+// deviceClient returns a client of the configured ONVIF device service, authenticated with the current
+// credentials of the stream, for command.
+func (rc *rtspCamera) deviceClient(command string) (*ONVIFConfig, *onvifClient, error) {
+	conf := rc.onvif.Load()
+	if conf == nil {
+		return nil, nil, errors.Errorf("%s requires the onvif config attribute", command)
+	}
+	var username, password string
+	if u := rc.getURL(); u != nil && u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	return conf, conf.client(username, password), nil
+}
+
+// streamURI returns the RTSP URI of the configured profile.
+func (c *ONVIFConfig) streamURI(ctx context.Context, username, password string) (string, error) {
+	return c.client(username, password).profileStreamURI(ctx, c.Profile)
+}
+
+// substreamURI returns the RTSP URI of the configured substream profile.
+func (c *ONVIFConfig) substreamURI(ctx context.Context, username, password string) (string, error) {
+	return c.client(username, password).profileStreamURI(ctx, c.SubstreamProfile)
+}
+
+// snapshotURI returns the HTTP snapshot URI of the configured profile.
+func (c *ONVIFConfig) snapshotURI(ctx context.Context, username, password string) (string, error) {
+	return c.client(username, password).profileSnapshotURI(ctx, c.Profile)
+}
+
+// selectONVIFProfile returns the profile whose token or name is profile, or the first one if profile is empty.
+func selectONVIFProfile(profiles []onvifProfile, profile string) (onvifProfile, error) {
+	if len(profiles) == 0 {
+		return onvifProfile{}, errors.New("the camera has no ONVIF media profiles")
+	}
+	if profile == "" {
+		return profiles[0], nil
+	}
+	for _, p := range profiles {
+		if p.Token == profile {
+			return p, nil
+		}
+	}
+	for _, p := range profiles {
+		if strings.EqualFold(p.Name, profile) {
+			return p, nil
+		}
+	}
+	available := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		available = append(available, fmt.Sprintf("%s (token %s)", p.Name, p.Token))
+	}
+	return onvifProfile{}, errors.Errorf("no ONVIF profile with the token or name '%s', the camera has: %s",
+		profile, strings.Join(available, ", "))
+}
+
+// services returns the addresses of the services of the camera by namespace, which is empty if the camera
+// doesn't list its services.
+func (c *onvifClient) services(ctx context.Context) map[string]string {
+	var res struct {
+		Services []struct {
+			Namespace string `xml:"Namespace"`
+			XAddr     string `xml:"XAddr"`
+		} `xml:"Body>GetServicesResponse>Service"`
+	}
+	body := `<GetServices xmlns="` + onvifDeviceNamespace + `"><IncludeCapability>false</IncludeCapability></GetServices>`
+	services := map[string]string{}
+	if err := c.call(ctx, c.deviceServiceURL, body, &res); err != nil {
+		return services
+	}
+	for _, s := range res.Services {
+		services[s.Namespace] = s.XAddr
+	}
+	return services
+}
+
+// service returns the address of the service of namespace. Cameras which don't list a service are assumed
+// to serve its requests on the device service.
+func (c *onvifClient) service(services map[string]string, namespace string) string {
+	if address, ok := services[namespace]; ok {
+		return address
+	}
+	return c.deviceServiceURL
+}
+
+// mediaService returns the address of the Media2 service, or of the Media service, & whether it's Media2.
+func (c *onvifClient) mediaService(services map[string]string) (string, bool) {
+	if address, ok := services[onvifMedia2Namespace]; ok {
+		return address, true
+	}
+	return c.service(services, onvifMediaNamespace), false
+}
+
+// profile returns the profile whose token or name is profile, or the first one if profile is empty.
+func (c *onvifClient) profile(ctx context.Context, mediaURL string, media2 bool, profile string) (onvifProfile, error) {
+	var res struct {
+		Profiles []onvifProfile `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+	body := `<GetProfiles xmlns="` + onvifMediaNamespace + `"/>`
+	if media2 {
+		// Media2 profiles only have the configurations of the requested types
+		body = `<GetProfiles xmlns="` + onvifMedia2Namespace + `"><Type>VideoSource</Type></GetProfiles>`
+	}
+	if err := c.call(ctx, mediaURL, body, &res); err != nil {
+		return onvifProfile{}, errors.Wrap(err, "getting ONVIF profiles")
+	}
+	return selectONVIFProfile(res.Profiles, profile)
+}
+
+// withMedia calls fn with the Media2 service of Profile T cameras if the camera has one, & with the Media service
+// otherwise or if fn fails with Media2, as some cameras list a Media2 service they don't fully implement. The error
+// of Media2 is returned if both fail, since cameras which only report H265 profiles do so through Media2.
+func (c *onvifClient) withMedia(services map[string]string, fn func(mediaURL string, media2 bool) error) error {
+	mediaURL, media2 := c.mediaService(services)
+	err := fn(mediaURL, media2)
+	if err == nil || !media2 {
+		return err
+	}
+	if fn(c.service(services, onvifMediaNamespace), false) == nil {
+		return nil
+	}
+	return err
+}
+
+// mediaProfile returns the address of the media service the profile whose token or name is profile, or the first
+// one if profile is empty, is found with, whether it's Media2, & the profile.
+func (c *onvifClient) mediaProfile(
+	ctx context.Context, services map[string]string, profile string,
+) (string, bool, onvifProfile, error) {
+	var mediaURL string
+	var media2 bool
+	var p onvifProfile
+	err := c.withMedia(services, func(url string, isMedia2 bool) error {
+		found, err := c.profile(ctx, url, isMedia2, profile)
+		if err != nil {
+			return err
+		}
+		mediaURL, media2, p = url, isMedia2, found
+		return nil
+	})
+	return mediaURL, media2, p, err
+}
+
+// profileStreamURI returns the RTSP URI of the profile whose token or name is profile, or of the first one if
+// profile is empty.
+func (c *onvifClient) profileStreamURI(ctx context.Context, profile string) (string, error) {
+	var uri string
+	err := c.withMedia(c.services(ctx), func(mediaURL string, media2 bool) error {
+		p, err := c.profile(ctx, mediaURL, media2, profile)
+		if err != nil {
+			return err
+		}
+		uri, err = c.streamURI(ctx, mediaURL, media2, p.Token)
+		return err
+	})
+	return uri, err
+}
+
+func (c *onvifClient) streamURI(ctx context.Context, mediaURL string, media2 bool, token string) (string, error) {
+	var res struct {
+		// Media2 responses have the URI in Uri, Media responses in MediaUri>Uri
+		URI      string `xml:"Body>GetStreamUriResponse>Uri"`
+		MediaURI string `xml:"Body>GetStreamUriResponse>MediaUri>Uri"`
+	}
+	var body string
+	if media2 {
+		body = `<GetStreamUri xmlns="` + onvifMedia2Namespace + `"><Protocol>RTSP</Protocol>` +
+			`<ProfileToken>` + xmlEscape(token) + `</ProfileToken></GetStreamUri>`
+	} else {
+		body = `<GetStreamUri xmlns="` + onvifMediaNamespace + `"><StreamSetup>` +
+			`<Stream xmlns="` + onvifSchemaNamespace + `">RTP-Unicast</Stream>` +
+			`<Transport xmlns="` + onvifSchemaNamespace + `"><Protocol>RTSP</Protocol></Transport>` +
+			`</StreamSetup><ProfileToken>` + xmlEscape(token) + `</ProfileToken></GetStreamUri>`
+	}
+	if err := c.call(ctx, mediaURL, body, &res); err != nil {
+		return "", errors.Wrapf(err, "getting the stream URI of ONVIF profile '%s'", token)
+	}
+	uri := strings.TrimSpace(res.URI)
+	if uri == "" {
+		uri = strings.TrimSpace(res.MediaURI)
+	}
+	if uri == "" {
+		return "", errors.Errorf("the camera returned no stream URI for ONVIF profile '%s'", token)
+	}
+	return uri, nil
+}
+
+// profileSnapshotURI returns the HTTP URI of JPEG snapshots of the profile whose token or name is profile, or of
+// the first one if profile is empty.
+func (c *onvifClient) profileSnapshotURI(ctx context.Context, profile string) (string, error) {
+	var uri string
+	err := c.withMedia(c.services(ctx), func(mediaURL string, media2 bool) error {
+		p, err := c.profile(ctx, mediaURL, media2, profile)
+		if err != nil {
+			return err
+		}
+		uri, err = c.snapshotURI(ctx, mediaURL, media2, p.Token)
+		return err
+	})
+	return uri, err
+}
+
+func (c *onvifClient) snapshotURI(ctx context.Context, mediaURL string, media2 bool, token string) (string, error) {
+	var res struct {
+		// Media2 responses have the URI in Uri, Media responses in MediaUri>Uri
+		URI      string `xml:"Body>GetSnapshotUriResponse>Uri"`
+		MediaURI string `xml:"Body>GetSnapshotUriResponse>MediaUri>Uri"`
+	}
+	namespace := onvifMediaNamespace
+	if media2 {
+		namespace = onvifMedia2Namespace
+	}
+	body := `<GetSnapshotUri xmlns="` + namespace + `"><ProfileToken>` + xmlEscape(token) + `</ProfileToken></GetSnapshotUri>`
+	if err := c.call(ctx, mediaURL, body, &res); err != nil {
+		return "", errors.Wrapf(err, "getting the snapshot URI of ONVIF profile '%s'", token)
+	}
+	uri := strings.TrimSpace(res.URI)
+	if uri == "" {
+		uri = strings.TrimSpace(res.MediaURI)
+	}
+	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		return "", errors.Errorf("the camera returned no HTTP snapshot URI for ONVIF profile '%s'", token)
+	}
+	return uri, nil
+}
+
+// call posts the SOAP request body to url & decodes the response envelope into res.
+func (c *onvifClient) call(ctx context.Context, url, body string, res interface{}) error {
+	envelope := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` + c.securityHeader(time.Now()) +
+		`<s:Body>` + body + `</s:Body></s:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxONVIFResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault struct {
+			Reason string `xml:"Body>Fault>Reason>Text"`
+		}
+		if xml.Unmarshal(data, &fault) == nil && fault.Reason != "" {
+			return errors.Errorf("onvif request failed with status_code: %d, reason: %s", resp.StatusCode, fault.Reason)
+		}
+		return errors.Errorf("onvif request failed with status_code: %d", resp.StatusCode)
+	}
+	return xml.NewDecoder(bytes.NewReader(data)).Decode(res)
+}
+
+// securityHeader returns the WS-Security header of a request, which authenticates it with a password digest.
+func (c *onvifClient) securityHeader(now time.Time) string {
+	if c.username == "" {
+		return ""
+	}
+	nonce := make([]byte, 16)
+	//nolint:errcheck
+	rand.Read(nonce)
+	created := now.UTC().Format(time.RFC3339)
+	return `<s:Header><Security s:mustUnderstand="1" ` +
+		`xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"><UsernameToken>` +
+		`<Username>` + xmlEscape(c.username) + `</Username>` +
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` +
+		passwordDigest(nonce, created, c.password) + `</Password>` +
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` +
+		base64.StdEncoding.EncodeToString(nonce) + `</Nonce>` +
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` +
+		created + `</Created></UsernameToken></Security></s:Header>`
+}
+
+// passwordDigest returns the WS-Security password digest, Base64(SHA-1(nonce + created + password)).
+func passwordDigest(nonce []byte, created, password string) string {
+	//nolint:gosec
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(password))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	//nolint:errcheck
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
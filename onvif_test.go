@@ -0,0 +1,370 @@
+package viamrtsp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+// newONVIFServer returns a fake ONVIF camera with a mainStream & a subStream profile of one video source, which
+// checks the password digest of requests other than GetSystemDateAndTime. If media2 is false it has no Media2 service.
+// Requests to failingPaths, e.g. "/onvif/media2", fail. Its clock is at 2024-03-09T04:05:06Z until it's set.
+func newONVIFServer(t *testing.T, media2 bool, failingPaths ...string) *httptest.Server {
+	t.Helper()
+	var s *httptest.Server
+	presets := []string{"Home"}
+	// events are the ONVIF events of the next PullMessages response, as the topic & message of each
+	event := func(topic, operation, source, data string) string {
+		return topic + `</wsnt:Topic><wsnt:Message><tt:Message UtcTime="2024-03-09T04:05:06Z" PropertyOperation="` +
+			operation + `"><tt:Source>` + source + `</tt:Source><tt:Data>` + data + `</tt:Data>`
+	}
+	events := []string{
+		event("tns1:RuleEngine/CellMotionDetector/Motion", "Initialized",
+			`<tt:SimpleItem Name="VideoSourceConfigurationToken" Value="VSC_1"/>`, `<tt:SimpleItem Name="IsMotion" Value="true"/>`),
+		event("tns1:RuleEngine/TamperDetector/Tamper", "Initialized",
+			`<tt:SimpleItem Name="VideoSourceConfigurationToken" Value="VSC_1"/>`, `<tt:SimpleItem Name="IsTamper" Value="false"/>`),
+		event("tns1:Device/Trigger/DigitalInput", "Initialized",
+			`<tt:SimpleItem Name="InputToken" Value="DI_1"/>`, `<tt:SimpleItem Name="LogicalState" Value="true"/>`),
+	}
+	encoder := `<trt:Configuration token="VEC_2"><tt:Name>subEncoder</tt:Name><tt:UseCount>1</tt:UseCount>` +
+		`<tt:Encoding>H264</tt:Encoding><tt:Resolution><tt:Width>640</tt:Width><tt:Height>360</tt:Height></tt:Resolution>` +
+		`<tt:Quality>4</tt:Quality><tt:RateControl><tt:FrameRateLimit>15</tt:FrameRateLimit>` +
+		`<tt:EncodingInterval>1</tt:EncodingInterval><tt:BitrateLimit>512</tt:BitrateLimit></tt:RateControl>` +
+		`<tt:H264><tt:GovLength>30</tt:GovLength><tt:H264Profile>Main</tt:H264Profile></tt:H264>` +
+		`<tt:SessionTimeout>PT60S</tt:SessionTimeout></trt:Configuration>`
+	if media2 {
+		encoder = `<tr2:Configurations token="VEC_2" GovLength="30" Profile="Main"><tt:Name>subEncoder</tt:Name>` +
+			`<tt:UseCount>1</tt:UseCount><tt:Encoding>H265</tt:Encoding><tt:Resolution><tt:Width>640</tt:Width>` +
+			`<tt:Height>360</tt:Height></tt:Resolution><tt:RateControl ConstantBitRate="false">` +
+			`<tt:FrameRateLimit>15</tt:FrameRateLimit><tt:BitrateLimit>512</tt:BitrateLimit></tt:RateControl>` +
+			`<tt:Quality>4</tt:Quality></tr2:Configurations>`
+	}
+	imaging := `<tt:Brightness>50</tt:Brightness><tt:Contrast>40</tt:Contrast><tt:Exposure><tt:Mode>AUTO</tt:Mode></tt:Exposure>` +
+		`<tt:IrCutFilter>AUTO</tt:IrCutFilter><tt:WideDynamicRange><tt:Mode>OFF</tt:Mode></tt:WideDynamicRange>`
+	dateTimeType, dateTime := "Manual", `<tt:Date><tt:Year>2024</tt:Year><tt:Month>3</tt:Month><tt:Day>9</tt:Day></tt:Date>`+
+		`<tt:Time><tt:Hour>4</tt:Hour><tt:Minute>5</tt:Minute><tt:Second>6</tt:Second></tt:Time>`
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `xml:"Header>Security>UsernameToken>Username"`
+			Password string `xml:"Header>Security>UsernameToken>Password"`
+			Nonce    string `xml:"Header>Security>UsernameToken>Nonce"`
+			Created  string `xml:"Header>Security>UsernameToken>Created"`
+			Body     struct {
+				Inner []byte `xml:",innerxml"`
+			} `xml:"Body"`
+		}
+		data, err := io.ReadAll(r.Body)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, xml.Unmarshal(data, &req), test.ShouldBeNil)
+		nonce, err := base64.StdEncoding.DecodeString(req.Nonce)
+		test.That(t, err, test.ShouldBeNil)
+		preAuth := strings.Contains(string(req.Body.Inner), "GetSystemDateAndTime")
+		if !preAuth && (req.Username != "admin" || req.Password != passwordDigest(nonce, req.Created, "p@ss<word>")) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Envelope><Body><Fault><Reason><Text>Sender not authorized</Text></Reason></Fault></Body></Envelope>`)
+			return
+		}
+		for _, path := range failingPaths {
+			if r.URL.Path == path {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `<Envelope><Body><Fault><Reason><Text>Action not supported</Text></Reason></Fault></Body></Envelope>`)
+				return
+			}
+		}
+		// media requests are answered in the format of the service they're sent to
+		media2Request := r.URL.Path == "/onvif/media2"
+		body := string(req.Body.Inner)
+		var res string
+		switch {
+		case strings.Contains(body, "GetServices"):
+			service := func(namespace, path string) string {
+				return `<tds:Service><tds:Namespace>` + namespace + `</tds:Namespace><tds:XAddr>` + s.URL + path + `</tds:XAddr></tds:Service>`
+			}
+			res = `<tds:GetServicesResponse>` + service(onvifDeviceNamespace, "/onvif/device_service") +
+				service(onvifMediaNamespace, "/onvif/media") + service(onvifImagingNamespace, "/onvif/imaging") +
+				service(onvifPTZNamespace, "/onvif/ptz") + service(onvifEventsNamespace, "/onvif/events")
+			if media2 {
+				res += service(onvifMedia2Namespace, "/onvif/media2")
+			}
+			res += `</tds:GetServicesResponse>`
+		case strings.Contains(body, "GetProfiles"):
+			source := `<tt:VideoSourceConfiguration token="VSC_1"><tt:SourceToken>VideoSource_1</tt:SourceToken>` +
+				`</tt:VideoSourceConfiguration>`
+			if media2Request {
+				source = `<tr2:Configurations><tr2:VideoSource token="VSC_1"><tt:SourceToken>VideoSource_1</tt:SourceToken>` +
+					`</tr2:VideoSource></tr2:Configurations>`
+			}
+			encoderConfig := func(token string) string {
+				if media2Request {
+					return ""
+				}
+				return `<tt:VideoEncoderConfiguration token="` + token + `"><tt:Name>` + token + `</tt:Name></tt:VideoEncoderConfiguration>`
+			}
+			res = `<trt:GetProfilesResponse>` +
+				`<trt:Profiles token="Profile_1" fixed="true"><tt:Name>mainStream</tt:Name>` + source + encoderConfig("VEC_1") + `</trt:Profiles>` +
+				`<trt:Profiles token="Profile_2" fixed="true"><tt:Name>subStream</tt:Name>` + source + encoderConfig("VEC_2") + `</trt:Profiles>` +
+				`</trt:GetProfilesResponse>`
+		case strings.Contains(body, "GetStreamUri"):
+			token := "Profile_1"
+			if strings.Contains(body, "Profile_2") {
+				token = "Profile_2"
+			}
+			if media2Request {
+				res = `<tr2:GetStreamUriResponse><tr2:Uri>rtsp://192.168.1.2:554/` + token + `</tr2:Uri></tr2:GetStreamUriResponse>`
+			} else {
+				res = `<trt:GetStreamUriResponse><trt:MediaUri><tt:Uri>rtsp://192.168.1.2:554/media/` + token +
+					`</tt:Uri></trt:MediaUri></trt:GetStreamUriResponse>`
+			}
+		case strings.Contains(body, "GetSnapshotUri"):
+			token := "Profile_1"
+			if strings.Contains(body, "Profile_2") {
+				token = "Profile_2"
+			}
+			if media2Request {
+				res = `<tr2:GetSnapshotUriResponse><tr2:Uri>http://192.168.1.2/snapshot/` + token + `</tr2:Uri></tr2:GetSnapshotUriResponse>`
+			} else {
+				res = `<trt:GetSnapshotUriResponse><trt:MediaUri><tt:Uri>http://192.168.1.2/media/snapshot/` + token +
+					`</tt:Uri></trt:MediaUri></trt:GetSnapshotUriResponse>`
+			}
+		case strings.Contains(body, "GetImagingSettings"):
+			test.That(t, r.URL.Path, test.ShouldEqual, "/onvif/imaging")
+			test.That(t, body, test.ShouldContainSubstring, "<VideoSourceToken>VideoSource_1</VideoSourceToken>")
+			res = `<timg:GetImagingSettingsResponse><timg:ImagingSettings>` + imaging +
+				`</timg:ImagingSettings></timg:GetImagingSettingsResponse>`
+		case strings.Contains(body, "SetImagingSettings"):
+			var set struct {
+				Settings struct {
+					Inner string `xml:",innerxml"`
+				} `xml:"ImagingSettings"`
+			}
+			test.That(t, r.URL.Path, test.ShouldEqual, "/onvif/imaging")
+			test.That(t, xml.Unmarshal(req.Body.Inner, &set), test.ShouldBeNil)
+			imaging = set.Settings.Inner
+			res = `<timg:SetImagingSettingsResponse/>`
+		case strings.Contains(body, "SetVideoEncoderConfiguration"):
+			var set struct {
+				Config           xmlNode `xml:"SetVideoEncoderConfiguration>Configuration"`
+				ForcePersistence string  `xml:"SetVideoEncoderConfiguration>ForcePersistence"`
+			}
+			test.That(t, xml.Unmarshal([]byte("<Body>"+body+"</Body>"), &set), test.ShouldBeNil)
+			test.That(t, set.Config.attr("token"), test.ShouldEqual, "VEC_2")
+			test.That(t, set.Config.text("Name"), test.ShouldEqual, "subEncoder")
+			test.That(t, set.ForcePersistence, test.ShouldEqual, map[bool]string{true: "", false: "true"}[media2])
+			element := map[bool]string{true: "Configurations", false: "Configuration"}[media2]
+			encoder, err = set.Config.marshal(set.Config.XMLName.Space, element)
+			test.That(t, err, test.ShouldBeNil)
+			res = `<trt:SetVideoEncoderConfigurationResponse/>`
+		case strings.Contains(body, "GetVideoEncoderConfiguration"):
+			test.That(t, r.URL.Path, test.ShouldEqual, map[bool]string{true: "/onvif/media2", false: "/onvif/media"}[media2])
+			if media2 {
+				test.That(t, body, test.ShouldContainSubstring, "<ProfileToken>Profile_2</ProfileToken>")
+				res = `<tr2:GetVideoEncoderConfigurationsResponse>` + encoder + `</tr2:GetVideoEncoderConfigurationsResponse>`
+			} else {
+				test.That(t, body, test.ShouldContainSubstring, "<ConfigurationToken>VEC_2</ConfigurationToken>")
+				res = `<trt:GetVideoEncoderConfigurationResponse>` + encoder + `</trt:GetVideoEncoderConfigurationResponse>`
+			}
+		case strings.Contains(body, "<GetPresets"):
+			test.That(t, r.URL.Path, test.ShouldEqual, "/onvif/ptz")
+			res = `<tptz:GetPresetsResponse>`
+			for i, name := range presets {
+				res += fmt.Sprintf(`<tptz:Preset token="Preset_%d"><tt:Name>%s</tt:Name></tptz:Preset>`, i+1, name)
+			}
+			res += `</tptz:GetPresetsResponse>`
+		case strings.Contains(body, "<SetPreset"):
+			var set struct {
+				Name string `xml:"SetPreset>PresetName"`
+			}
+			test.That(t, xml.Unmarshal([]byte("<Body>"+body+"</Body>"), &set), test.ShouldBeNil)
+			presets = append(presets, set.Name)
+			res = fmt.Sprintf(`<tptz:SetPresetResponse><tptz:PresetToken>Preset_%d</tptz:PresetToken></tptz:SetPresetResponse>`, len(presets))
+		case strings.Contains(body, "<ContinuousMove"):
+			test.That(t, body, test.ShouldContainSubstring, `<Velocity><PanTilt xmlns="`+onvifSchemaNamespace+`" x="0.5" y="-0.25"/></Velocity>`)
+			test.That(t, body, test.ShouldContainSubstring, `<Timeout>PT2S</Timeout>`)
+			res = `<tptz:ContinuousMoveResponse/>`
+		case strings.Contains(body, "<RelativeMove"):
+			test.That(t, body, test.ShouldContainSubstring, `<Translation><Zoom xmlns="`+onvifSchemaNamespace+`" x="0.1"/></Translation>`)
+			test.That(t, body, test.ShouldContainSubstring, `<Speed><PanTilt xmlns="`+onvifSchemaNamespace+`" x="0.5" y="0.5"/>`)
+			res = `<tptz:RelativeMoveResponse/>`
+		case strings.Contains(body, "<GotoPreset"):
+			test.That(t, r.URL.Path, test.ShouldEqual, "/onvif/ptz")
+			test.That(t, body, test.ShouldContainSubstring, `<ProfileToken>Profile_2</ProfileToken><PresetToken>Preset_1</PresetToken>`)
+			res = `<tptz:GotoPresetResponse/>`
+		case strings.Contains(body, "<Stop"):
+			test.That(t, body, test.ShouldContainSubstring, `<ProfileToken>Profile_2</ProfileToken><PanTilt>true</PanTilt><Zoom>true</Zoom>`)
+			res = `<tptz:StopResponse/>`
+		case strings.Contains(body, "CreatePullPointSubscription"):
+			test.That(t, r.URL.Path, test.ShouldEqual, "/onvif/events")
+			test.That(t, body, test.ShouldContainSubstring, "<InitialTerminationTime>PT60S</InitialTerminationTime>")
+			res = `<tev:CreatePullPointSubscriptionResponse><tev:SubscriptionReference><wsa:Address>` + s.URL +
+				`/onvif/events/subscription</wsa:Address></tev:SubscriptionReference></tev:CreatePullPointSubscriptionResponse>`
+		case strings.Contains(body, "PullMessages"):
+			test.That(t, r.URL.Path, test.ShouldEqual, "/onvif/events/subscription")
+			res = `<tev:PullMessagesResponse>`
+			if len(events) == 0 {
+				// the camera holds the request open while it has no events
+				select {
+				case <-r.Context().Done():
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+			for _, event := range events {
+				res += `<wsnt:NotificationMessage><wsnt:Topic>` + event + `</tt:Message></wsnt:Message></wsnt:NotificationMessage>`
+			}
+			events = nil
+			res += `</tev:PullMessagesResponse>`
+		case strings.Contains(body, "<Renew"), strings.Contains(body, "<Unsubscribe"):
+			test.That(t, r.URL.Path, test.ShouldEqual, "/onvif/events/subscription")
+			res = `<wsnt:Response/>`
+		case strings.Contains(body, "GetDeviceInformation"):
+			res = `<tds:GetDeviceInformationResponse><tds:Manufacturer>Acme</tds:Manufacturer><tds:Model>Cam 2</tds:Model>` +
+				`<tds:FirmwareVersion>V5.7.3</tds:FirmwareVersion><tds:SerialNumber>SN123</tds:SerialNumber>` +
+				`<tds:HardwareId>HW1</tds:HardwareId></tds:GetDeviceInformationResponse>`
+		case strings.Contains(body, "GetNetworkInterfaces"):
+			res = `<tds:GetNetworkInterfacesResponse><tds:NetworkInterfaces token="eth0"><tt:Enabled>true</tt:Enabled>` +
+				`<tt:Info><tt:Name>eth0</tt:Name><tt:HwAddress>00:11:22:AA:BB:CC</tt:HwAddress><tt:MTU>1500</tt:MTU></tt:Info>` +
+				`<tt:IPv4><tt:Enabled>true</tt:Enabled><tt:Config><tt:LinkLocal><tt:Address>169.254.1.2</tt:Address>` +
+				`<tt:PrefixLength>16</tt:PrefixLength></tt:LinkLocal><tt:FromDHCP><tt:Address>192.168.1.2</tt:Address>` +
+				`<tt:PrefixLength>24</tt:PrefixLength></tt:FromDHCP><tt:DHCP>true</tt:DHCP></tt:Config></tt:IPv4>` +
+				`</tds:NetworkInterfaces></tds:GetNetworkInterfacesResponse>`
+		case strings.Contains(body, "GetSystemDateAndTime"):
+			res = `<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime><tt:DateTimeType>` + dateTimeType +
+				`</tt:DateTimeType><tt:DaylightSavings>false</tt:DaylightSavings><tt:TimeZone><tt:TZ>UTC</tt:TZ></tt:TimeZone>` +
+				`<tt:UTCDateTime>` + dateTime + `</tt:UTCDateTime></tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`
+		case strings.Contains(body, "SetSystemDateAndTime"):
+			var set struct {
+				DateTimeType string `xml:"DateTimeType"`
+				UTCDateTime  struct {
+					Inner string `xml:",innerxml"`
+				} `xml:"UTCDateTime"`
+			}
+			test.That(t, xml.Unmarshal(req.Body.Inner, &set), test.ShouldBeNil)
+			dateTimeType = set.DateTimeType
+			if set.UTCDateTime.Inner != "" {
+				dateTime = set.UTCDateTime.Inner
+			}
+			res = `<tds:SetSystemDateAndTimeResponse/>`
+		case strings.Contains(body, "SystemReboot"):
+			res = `<tds:SystemRebootResponse><tds:Message>Rebooting in 30 seconds</tds:Message></tds:SystemRebootResponse>`
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" `+
+			`xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" `+
+			`xmlns:tr2="http://www.onvif.org/ver20/media/wsdl" xmlns:timg="http://www.onvif.org/ver20/imaging/wsdl" `+
+			`xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl" xmlns:tev="http://www.onvif.org/ver10/events/wsdl" `+
+			`xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:wsa="http://www.w3.org/2005/08/addressing" `+
+			`xmlns:tt="http://www.onvif.org/ver10/schema"><s:Body>`+
+			res+`</s:Body></s:Envelope>`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestONVIFStreamURI(t *testing.T) {
+	for _, media2 := range []bool{true, false} {
+		media2 := media2
+		t.Run(fmt.Sprintf("media2=%v", media2), func(t *testing.T) {
+			s := newONVIFServer(t, media2)
+			prefix := "rtsp://192.168.1.2:554/"
+			if !media2 {
+				prefix += "media/"
+			}
+			conf := &ONVIFConfig{DeviceServiceURL: s.URL + "/onvif/device_service"}
+			uri, err := conf.streamURI(context.Background(), "admin", "p@ss<word>")
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, uri, test.ShouldEqual, prefix+"Profile_1")
+
+			conf.Profile = "Profile_2"
+			uri, err = conf.streamURI(context.Background(), "admin", "p@ss<word>")
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, uri, test.ShouldEqual, prefix+"Profile_2")
+
+			conf.Profile = "substream"
+			uri, err = conf.streamURI(context.Background(), "admin", "p@ss<word>")
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, uri, test.ShouldEqual, prefix+"Profile_2")
+
+			conf.SubstreamProfile = "subStream"
+			uri, err = conf.substreamURI(context.Background(), "admin", "p@ss<word>")
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, uri, test.ShouldEqual, prefix+"Profile_2")
+
+			conf.Profile = "thirdStream"
+			_, err = conf.streamURI(context.Background(), "admin", "p@ss<word>")
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, "mainStream (token Profile_1), subStream (token Profile_2)")
+
+			conf.Profile = "subStream"
+			uri, err = conf.snapshotURI(context.Background(), "admin", "p@ss<word>")
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, uri, test.ShouldEqual, strings.Replace(prefix, "rtsp://192.168.1.2:554/", "http://192.168.1.2/", 1)+
+				"snapshot/Profile_2")
+
+			conf.Profile = ""
+			_, err = conf.streamURI(context.Background(), "admin", "wrong")
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, "Sender not authorized")
+		})
+	}
+
+	t.Run("falls back to media when media2 fails", func(t *testing.T) {
+		s := newONVIFServer(t, true, "/onvif/media2")
+		conf := &ONVIFConfig{DeviceServiceURL: s.URL + "/onvif/device_service", Profile: "subStream"}
+		uri, err := conf.streamURI(context.Background(), "admin", "p@ss<word>")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, uri, test.ShouldEqual, "rtsp://192.168.1.2:554/media/Profile_2")
+		uri, err = conf.snapshotURI(context.Background(), "admin", "p@ss<word>")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, uri, test.ShouldEqual, "http://192.168.1.2/media/snapshot/Profile_2")
+
+		// the error of media2 is returned if both fail
+		s = newONVIFServer(t, true, "/onvif/media2", "/onvif/media")
+		conf.DeviceServiceURL = s.URL + "/onvif/device_service"
+		_, err = conf.streamURI(context.Background(), "admin", "p@ss<word>")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "Action not supported")
+	})
+}
+
+func TestONVIFConfig(t *testing.T) {
+	test.That(t, (&ONVIFConfig{DeviceServiceURL: "http://192.168.1.2/onvif/device_service"}).Validate("path"), test.ShouldBeNil)
+	err := (&ONVIFConfig{}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "invalid onvif config for component at path 'path'")
+	test.That(t, (&ONVIFConfig{DeviceServiceURL: "rtsp://192.168.1.2/stream"}).Validate("path"), test.ShouldNotBeNil)
+	err = (&ONVIFConfig{DeviceServiceURL: "http://192.168.1.2/onvif/device_service", MaxClockDrift: -1}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "max_clock_drift must not be negative")
+	err = (&ONVIFConfig{DeviceServiceURL: "http://192.168.1.2/onvif/device_service", Profile: "mainStream", SubstreamProfile: "MainStream"}).
+		Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "substream_profile must differ from profile")
+	err = (&ONVIFConfig{
+		DeviceServiceURL: "http://192.168.1.2/onvif/device_service",
+		DiscoveryTypes:   []string{"tds:Device", "dn:NetworkVideoTransmitter"},
+		DiscoveryScopes:  []string{"onvif://www.onvif.org/Profile/Streaming"},
+	}).Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+	err = (&ONVIFConfig{DeviceServiceURL: "http://192.168.1.2/onvif/device_service", DiscoveryTypes: []string{"NetworkVideoTransmitter"}}).
+		Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "invalid onvif discovery_types")
+	err = (&ONVIFConfig{DeviceServiceURL: "http://192.168.1.2/onvif/device_service", DiscoveryScopes: []string{""}}).Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "invalid onvif discovery_scopes")
+
+	// the rtsp_address is optional with onvif
+	rtspConf := &Config{ONVIF: &ONVIFConfig{DeviceServiceURL: "http://192.168.1.2/onvif/device_service", Profile: "mainStream"}}
+	_, err = rtspConf.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+}
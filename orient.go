@@ -0,0 +1,90 @@
+package viamrtsp
+
+import "fmt"
+
+const (
+	flipHorizontal = "horizontal"
+	flipVertical   = "vertical"
+)
+
+// orientation flips & then rotates decoded frames, e.g. for ceiling mounted cameras. Its zero value keeps
+// frames as they are.
+type orientation struct {
+	// rotate is the clockwise rotation, in degrees, which is 0, 90, 180 or 270
+	rotate         int
+	flipHorizontal bool
+	flipVertical   bool
+}
+
+// newOrientation returns the orientation of the rotate_degrees & flip config attributes.
+func newOrientation(rotateDegrees int, flip string) (orientation, error) {
+	o := orientation{rotate: rotateDegrees}
+	switch rotateDegrees {
+	case 0, 90, 180, 270:
+	default:
+		return orientation{}, fmt.Errorf("rotate_degrees %d must be 0, 90, 180 or 270", rotateDegrees)
+	}
+	switch flip {
+	case "":
+	case flipHorizontal:
+		o.flipHorizontal = true
+	case flipVertical:
+		o.flipVertical = true
+	default:
+		return orientation{}, fmt.Errorf("flip '%s' must be '%s' or '%s'", flip, flipHorizontal, flipVertical)
+	}
+	return o, nil
+}
+
+func (o orientation) identity() bool {
+	return o == orientation{}
+}
+
+// size returns the size of frames of width x height once they're oriented.
+func (o orientation) size(width, height int) (int, int) {
+	if o.rotate == 90 || o.rotate == 270 {
+		return height, width
+	}
+	return width, height
+}
+
+// point returns where the pixel at x, y of a frame of width x height is once it's oriented.
+func (o orientation) point(x, y, width, height int) (int, int) {
+	if o.flipHorizontal {
+		x = width - 1 - x
+	}
+	if o.flipVertical {
+		y = height - 1 - y
+	}
+	switch o.rotate {
+	case 90:
+		return height - 1 - y, x
+	case 180:
+		return width - 1 - x, height - 1 - y
+	case 270:
+		return y, width - 1 - x
+	default:
+		return x, y
+	}
+}
+
+// transform copies the width x height plane src, whose pixels are bpp bytes, into dst, oriented. 8 bit samples
+// are mapped through lut if it is not nil. Each row of src is written along a line of dst, so the destination of
+// its first two pixels gives the step between all of them.
+func (o orientation) transform(dst []uint8, dstStride int, src []uint8, srcStride, width, height, bpp int, lut *[256]uint8) {
+	for y := 0; y < height; y++ {
+		x0, y0 := o.point(0, y, width, height)
+		x1, y1 := o.point(1, y, width, height)
+		i := y0*dstStride + x0*bpp
+		step := (y1-y0)*dstStride + (x1-x0)*bpp
+		row := src[y*srcStride : y*srcStride+width*bpp]
+		for x := 0; x < width; x++ {
+			if lut != nil {
+				dst[i] = lut[row[x]]
+			} else {
+				copy(dst[i:i+bpp], row[x*bpp:x*bpp+bpp])
+			}
+			i += step
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestNewOrientation(t *testing.T) {
+	o, err := newOrientation(0, "")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, o.identity(), test.ShouldBeTrue)
+	o, err = newOrientation(180, flipHorizontal)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, o, test.ShouldResemble, orientation{rotate: 180, flipHorizontal: true})
+
+	_, err = newOrientation(45, "")
+	test.That(t, err, test.ShouldBeError, "rotate_degrees 45 must be 0, 90, 180 or 270")
+	_, err = newOrientation(0, "diagonal")
+	test.That(t, err, test.ShouldBeError, "flip 'diagonal' must be 'horizontal' or 'vertical'")
+}
+
+func TestOrientationTransform(t *testing.T) {
+	// 1 2 3
+	// 4 5 6
+	src := []uint8{1, 2, 3, 4, 5, 6}
+	for _, tc := range []struct {
+		o             orientation
+		width, height int
+		want          []uint8
+	}{
+		{orientation{}, 3, 2, []uint8{1, 2, 3, 4, 5, 6}},
+		{orientation{rotate: 90}, 2, 3, []uint8{4, 1, 5, 2, 6, 3}},
+		{orientation{rotate: 180}, 3, 2, []uint8{6, 5, 4, 3, 2, 1}},
+		{orientation{rotate: 270}, 2, 3, []uint8{3, 6, 2, 5, 1, 4}},
+		{orientation{flipHorizontal: true}, 3, 2, []uint8{3, 2, 1, 6, 5, 4}},
+		{orientation{flipVertical: true}, 3, 2, []uint8{4, 5, 6, 1, 2, 3}},
+		{orientation{rotate: 90, flipHorizontal: true}, 2, 3, []uint8{6, 3, 5, 2, 4, 1}},
+	} {
+		width, height := tc.o.size(3, 2)
+		test.That(t, width, test.ShouldEqual, tc.width)
+		test.That(t, height, test.ShouldEqual, tc.height)
+		dst := make([]uint8, len(src))
+		tc.o.transform(dst, width, src, 3, 3, 2, 1, nil)
+		test.That(t, dst, test.ShouldResemble, tc.want)
+	}
+
+	// multi byte pixels are moved whole, & samples are mapped through the lut
+	rgba := []uint8{1, 1, 1, 1, 2, 2, 2, 2}
+	dst := make([]uint8, len(rgba))
+	orientation{rotate: 180}.transform(dst, 8, rgba, 8, 2, 1, 4, nil)
+	test.That(t, dst, test.ShouldResemble, []uint8{2, 2, 2, 2, 1, 1, 1, 1})
+	var lut [256]uint8
+	lut[1], lut[2] = 10, 20
+	dst = make([]uint8, 2)
+	orientation{rotate: 180}.transform(dst, 2, []uint8{1, 2}, 2, 2, 1, 1, &lut)
+	test.That(t, dst, test.ShouldResemble, []uint8{20, 10})
+}
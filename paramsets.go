@@ -0,0 +1,72 @@
+package viamrtsp
+
+import (
+	"github.com/bluenviron/mediacommon/pkg/codecs/av1"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+)
+
+// resolutionWatcher detects SPS, or sequence headers in AV1, received in band which change the resolution of
+// an H264, H265 or AV1 stream, e.g. after the camera was reconfigured, which requires the decoder to be reinitialized.
+// A resolutionWatcher is only used by the decode worker of its track.
+type resolutionWatcher struct {
+	codec videoCodec
+	info  *streamInfo
+}
+
+// newResolutionWatcher returns a watcher for a stream whose initial SPS, from the SDP, is sps, which may be nil.
+func newResolutionWatcher(codec videoCodec, sps []byte) *resolutionWatcher {
+	w := &resolutionWatcher{codec: codec}
+	w.info = w.parse(sps)
+	return w
+}
+
+func (w *resolutionWatcher) parse(sps []byte) *streamInfo {
+	if w.codec == H265 {
+		return h265StreamInfo(sps)
+	}
+	if w.codec == AV1 {
+		return av1StreamInfo(sps)
+	}
+	return h264StreamInfo(sps)
+}
+
+// update returns the stream info of the SPS in au if it changes the resolution of the stream.
+func (w *resolutionWatcher) update(au [][]byte) (*streamInfo, bool) {
+	for _, nalu := range au {
+		if len(nalu) == 0 || !w.isSPS(nalu) {
+			continue
+		}
+		info := w.parse(nalu)
+		if info == nil {
+			continue
+		}
+		previous := w.info
+		w.info = info
+		if previous != nil && (previous.width != info.width || previous.height != info.height) {
+			return info, true
+		}
+	}
+	return nil, false
+}
+
+func (w *resolutionWatcher) isSPS(nalu []byte) bool {
+	if w.codec == H265 {
+		return h265.NALUType((nalu[0]>>1)&0b111111) == h265.NALUType_SPS_NUT
+	}
+	if w.codec == AV1 {
+		return av1.OBUType((nalu[0]>>3)&0b1111) == av1.OBUTypeSequenceHeader
+	}
+	return h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeSPS
+}
+
+// onResolutionChange reinitializes d, which is owned by the calling decode worker, for the stream's new resolution.
+// The stream is reconnected if the decoder can't be reinitialized.
+func (rc *rtspCamera) onResolutionChange(d *decoder, info *streamInfo) {
+	rc.streamInfo.Store(info)
+	rc.logger.Infof("stream resolution changed to %dx%d, reinitializing the decoder", info.width, info.height)
+	if err := d.reset(); err != nil {
+		rc.logger.Warnf("unable to reinitialize the decoder, reconnecting, err: %s", err)
+		rc.requestReconnect()
+	}
+}
@@ -0,0 +1,42 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestResolutionWatcher(t *testing.T) {
+	sps352x288 := []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	sps1280x720 := []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+		0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+		0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+		0xcb,
+	}
+	pps := []byte{0x68, 0x01}
+	idr := []byte{0x65, 0x01}
+	nonIDR := []byte{0x41, 0x01}
+
+	w := newResolutionWatcher(H264, sps352x288)
+	_, changed := w.update([][]byte{sps352x288, pps, idr})
+	test.That(t, changed, test.ShouldBeFalse)
+	_, changed = w.update([][]byte{nonIDR})
+	test.That(t, changed, test.ShouldBeFalse)
+
+	info, changed := w.update([][]byte{sps1280x720, pps, idr})
+	test.That(t, changed, test.ShouldBeTrue)
+	test.That(t, info.width, test.ShouldEqual, 1280)
+	test.That(t, info.height, test.ShouldEqual, 720)
+	_, changed = w.update([][]byte{sps1280x720, pps, idr})
+	test.That(t, changed, test.ShouldBeFalse)
+
+	// the first SPS is not a change if the SDP had none
+	w = newResolutionWatcher(H264, nil)
+	_, changed = w.update([][]byte{sps1280x720, pps, idr})
+	test.That(t, changed, test.ShouldBeFalse)
+}
@@ -0,0 +1,113 @@
+package viamrtsp
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// resumeWaitTimeout is how long an image request waits for the first frame after resuming a paused stream.
+const resumeWaitTimeout = 5 * time.Second
+
+// shouldPause reports whether the stream should be paused, which it is when the pause command was used, or when
+// idle_timeout is set & nothing consumed the stream for that long since it started streaming.
+func (rc *rtspCamera) shouldPause(now time.Time) bool {
+	if rc.pauseRequested.Load() {
+		return true
+	}
+	if rc.idleTimeout <= 0 || rc.streamConsumed() {
+		return false
+	}
+	_, since := rc.health.snapshot()
+	if consumed := time.Unix(0, rc.lastConsumed.Load()); consumed.After(since) {
+		since = consumed
+	}
+	return now.Sub(since) > rc.idleTimeout
+}
+
+// streamConsumed reports whether anything other than image requests consumes the stream.
+func (rc *rtspCamera) streamConsumed() bool {
+	if rc.onFrame != nil {
+		return true
+	}
+	rc.subsMu.RLock()
+	subscribers := len(rc.bufAndCBByID)
+	rc.subsMu.RUnlock()
+	rc.audioMu.Lock()
+	audioSubscribers := len(rc.audioSubs)
+	rc.audioMu.Unlock()
+	if subscribers > 0 || audioSubscribers > 0 {
+		return true
+	}
+	if rc.relay != nil && rc.relay.readers() > 0 {
+		return true
+	}
+	p := rc.pipeline.Load()
+	return p != nil && p.isAttached(recorderSink)
+}
+
+// updatePause pauses or resumes the session of the client as needed. It's only called by the reconnect worker,
+// which owns the client. While paused the worker's OPTIONS requests keep the session alive, as gortsplib only
+// sends keepalives while playing.
+func (rc *rtspCamera) updatePause() error {
+	if rc.client == nil {
+		return nil
+	}
+	pause := rc.shouldPause(time.Now())
+	if pause == rc.paused.Load() {
+		return nil
+	}
+	if pause {
+		if _, err := rc.client.Pause(); err != nil {
+			rc.logger.Warnf("unable to pause rtsp stream %s, err: %s", rc.redactedURL(), err)
+			return nil
+		}
+		rc.paused.Store(true)
+		rc.health.set(StreamPaused, nil, rc.logger)
+		return nil
+	}
+	if _, err := rc.client.Play(nil); err != nil {
+		return errors.Wrap(err, "unable to resume paused rtsp stream")
+	}
+	rc.paused.Store(false)
+	rc.health.set(StreamStreaming, nil, rc.logger)
+	return nil
+}
+
+// requestPauseUpdate wakes the reconnect worker up to pause or resume the stream. It does not block.
+func (rc *rtspCamera) requestPauseUpdate() {
+	select {
+	case rc.pauseRequests <- struct{}{}:
+	default:
+	}
+}
+
+// resumeStream is called when the stream is consumed. It clears a pause requested by the pause command & asks
+// the reconnect worker to resume the stream if it's paused, reporting whether it is.
+func (rc *rtspCamera) resumeStream() bool {
+	rc.lastConsumed.Store(time.Now().UnixNano())
+	rc.pauseRequested.Store(false)
+	if !rc.paused.Load() {
+		return false
+	}
+	rc.requestPauseUpdate()
+	return true
+}
+
+// pause pauses the stream until it's resumed by the resume command or consumed.
+func (rc *rtspCamera) pause() (map[string]interface{}, error) {
+	if rc.closed() {
+		return nil, errCameraClosed
+	}
+	rc.pauseRequested.Store(true)
+	rc.requestPauseUpdate()
+	return map[string]interface{}{"pausing": true}, nil
+}
+
+// resume resumes the stream, which is paused again after idle_timeout if it's set & nothing consumes the stream.
+func (rc *rtspCamera) resume() (map[string]interface{}, error) {
+	if rc.closed() {
+		return nil, errCameraClosed
+	}
+	return map[string]interface{}{"resuming": rc.resumeStream()}, nil
+}
@@ -0,0 +1,79 @@
+package viamrtsp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/components/camera/rtppassthrough"
+	"go.viam.com/test"
+)
+
+func TestShouldPause(t *testing.T) {
+	rc := &rtspCamera{}
+	now := time.Now()
+	// streams are never paused without idle_timeout
+	test.That(t, rc.shouldPause(now), test.ShouldBeFalse)
+
+	rc.idleTimeout = time.Minute
+	rc.health.set(StreamStreaming, nil, nil)
+	test.That(t, rc.shouldPause(now), test.ShouldBeFalse)
+	test.That(t, rc.shouldPause(now.Add(2*time.Minute)), test.ShouldBeTrue)
+
+	// image requests keep the stream active
+	test.That(t, rc.markImageRequested(context.Background()), test.ShouldBeNil)
+	test.That(t, rc.shouldPause(now.Add(30*time.Second)), test.ShouldBeFalse)
+	test.That(t, rc.shouldPause(time.Now().Add(2*time.Minute)), test.ShouldBeTrue)
+
+	// as do subscribers, however long they've been subscribed
+	rc.bufAndCBByID = map[rtppassthrough.SubscriptionID]bufAndCB{{}: {}}
+	test.That(t, rc.shouldPause(now.Add(time.Hour)), test.ShouldBeFalse)
+	rc.bufAndCBByID = nil
+	_, unsubscribe := rc.subscribeAudio()
+	test.That(t, rc.shouldPause(now.Add(time.Hour)), test.ShouldBeFalse)
+	unsubscribe()
+	test.That(t, rc.shouldPause(now.Add(time.Hour)), test.ShouldBeTrue)
+	p := rc.startPipeline()
+	test.That(t, p.register(recorderSink, func() (*videoSink, error) { return &videoSink{}, nil }, false), test.ShouldBeNil)
+	test.That(t, rc.shouldPause(now.Add(time.Hour)), test.ShouldBeFalse)
+	rc.closePipeline()
+	rc.onFrame = func(*frame) {}
+	test.That(t, rc.shouldPause(now.Add(time.Hour)), test.ShouldBeFalse)
+}
+
+func TestPauseCommands(t *testing.T) {
+	rc := &rtspCamera{pauseRequests: make(chan struct{}, 1)}
+	res, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": pauseCommand})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["pausing"], test.ShouldBeTrue)
+	test.That(t, len(rc.pauseRequests), test.ShouldEqual, 1)
+	// the pause command pauses streams which are consumed
+	rc.onFrame = func(*frame) {}
+	test.That(t, rc.shouldPause(time.Now()), test.ShouldBeTrue)
+	<-rc.pauseRequests
+
+	// resuming a stream which isn't paused yet cancels the pause
+	res, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": resumeCommand})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["resuming"], test.ShouldBeFalse)
+	test.That(t, rc.shouldPause(time.Now()), test.ShouldBeFalse)
+	test.That(t, len(rc.pauseRequests), test.ShouldEqual, 0)
+
+	rc.paused.Store(true)
+	res, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": resumeCommand})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["resuming"], test.ShouldBeTrue)
+	test.That(t, len(rc.pauseRequests), test.ShouldEqual, 1)
+
+	// consuming the stream cancels the pause command
+	_, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": pauseCommand})
+	test.That(t, err, test.ShouldBeNil)
+	_, unsubscribe := rc.subscribeAudio()
+	defer unsubscribe()
+	test.That(t, rc.pauseRequested.Load(), test.ShouldBeFalse)
+
+	rc.closeCtx, rc.closeCancel = context.WithCancel(context.Background())
+	rc.closeCancel()
+	_, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": pauseCommand})
+	test.That(t, err, test.ShouldBeError, errCameraClosed)
+}
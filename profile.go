@@ -0,0 +1,135 @@
+package viamrtsp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The stages of the frame pipeline timed by the stageProfiler.
+const (
+	// profileDepacketize is reassembling access units from RTP packets, timed per packet.
+	profileDepacketize = iota
+	// profileDecode is decoding NALUs in libav, timed per NALU fed to the decoder.
+	profileDecode
+	// profileConvert is converting decoded frames to images, including scaling, rotating & copying hardware frames.
+	profileConvert
+	// profileStore is storing frames for image requests, including cropping them.
+	profileStore
+	numProfileStages
+)
+
+var profileStageNames = [numProfileStages]string{"depacketize", "decode", "convert", "store"}
+
+// stageTimings sums the time spent in a stage.
+type stageTimings struct {
+	count      atomic.Int64
+	totalNanos atomic.Int64
+	maxNanos   atomic.Int64
+}
+
+// stageProfiler times the stages of the frame pipeline while profiling is started, so that performance
+// regressions & slow cameras can be measured in place. Timing a stage costs a clock read while profiling
+// is stopped. A nil profiler times nothing.
+type stageProfiler struct {
+	enabled atomic.Bool
+	stages  [numProfileStages]stageTimings
+
+	mu        sync.Mutex
+	startedAt time.Time
+	stoppedAt time.Time
+}
+
+// start returns the time a stage starts at, which is zero while profiling is stopped.
+func (p *stageProfiler) start() time.Time {
+	if p == nil || !p.enabled.Load() {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// record counts a run of stage which started at start.
+func (p *stageProfiler) record(stage int, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	took := int64(time.Since(start))
+	s := &p.stages[stage]
+	s.count.Add(1)
+	s.totalNanos.Add(took)
+	for {
+		current := s.maxNanos.Load()
+		if took <= current || s.maxNanos.CompareAndSwap(current, took) {
+			return
+		}
+	}
+}
+
+// startProfiling clears the timings & starts timing the stages.
+func (p *stageProfiler) startProfiling(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.stages {
+		p.stages[i].count.Store(0)
+		p.stages[i].totalNanos.Store(0)
+		p.stages[i].maxNanos.Store(0)
+	}
+	p.startedAt, p.stoppedAt = now, time.Time{}
+	p.enabled.Store(true)
+}
+
+// stopProfiling stops timing the stages, keeping the timings until profiling is started again.
+func (p *stageProfiler) stopProfiling(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.enabled.Swap(false) {
+		p.stoppedAt = now
+	}
+}
+
+// snapshot returns the count, total, mean & max duration of every stage, in milliseconds.
+func (p *stageProfiler) snapshot(now time.Time) map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stages := map[string]interface{}{}
+	for i, name := range profileStageNames {
+		s := &p.stages[i]
+		count, total := s.count.Load(), s.totalNanos.Load()
+		var mean float64
+		if count > 0 {
+			mean = float64(total) / float64(count)
+		}
+		stages[name] = map[string]interface{}{
+			"count":    count,
+			"total_ms": nanosToMillis(float64(total)),
+			"mean_ms":  nanosToMillis(mean),
+			"max_ms":   nanosToMillis(float64(s.maxNanos.Load())),
+		}
+	}
+	var duration time.Duration
+	switch {
+	case p.startedAt.IsZero():
+	case p.stoppedAt.IsZero():
+		duration = now.Sub(p.startedAt)
+	default:
+		duration = p.stoppedAt.Sub(p.startedAt)
+	}
+	return map[string]interface{}{
+		"profiling":        p.enabled.Load(),
+		"duration_seconds": duration.Seconds(),
+		"stages":           stages,
+	}
+}
+
+func nanosToMillis(nanos float64) float64 {
+	return nanos / float64(time.Millisecond)
+}
+
+// startProfiling starts timing the stages of the frame pipeline of the camera, until the stop-profiling command.
+func (rc *rtspCamera) startProfiling() (map[string]interface{}, error) {
+	if rc.closed() {
+		return nil, errCameraClosed
+	}
+	rc.profile.startProfiling(time.Now())
+	return map[string]interface{}{"profiling": true}, nil
+}
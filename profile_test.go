@@ -0,0 +1,84 @@
+package viamrtsp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func profileStage(t *testing.T, snapshot map[string]interface{}, name string) map[string]interface{} {
+	t.Helper()
+	stages, ok := snapshot["stages"].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	stage, ok := stages[name].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	return stage
+}
+
+func TestStageProfiler(t *testing.T) {
+	var p stageProfiler
+	now := time.Now()
+
+	// stages aren't timed until profiling is started
+	p.record(profileDecode, p.start())
+	snapshot := p.snapshot(now)
+	test.That(t, snapshot["profiling"], test.ShouldBeFalse)
+	test.That(t, snapshot["duration_seconds"], test.ShouldEqual, 0.)
+	test.That(t, profileStage(t, snapshot, "decode")["count"], test.ShouldEqual, int64(0))
+
+	p.startProfiling(now)
+	test.That(t, p.start().IsZero(), test.ShouldBeFalse)
+	p.record(profileDecode, time.Now().Add(-2*time.Millisecond))
+	p.record(profileDecode, time.Now().Add(-4*time.Millisecond))
+	p.record(profileStore, time.Now())
+	snapshot = p.snapshot(now.Add(time.Second))
+	test.That(t, snapshot["profiling"], test.ShouldBeTrue)
+	test.That(t, snapshot["duration_seconds"], test.ShouldEqual, 1.)
+	decode := profileStage(t, snapshot, "decode")
+	test.That(t, decode["count"], test.ShouldEqual, int64(2))
+	test.That(t, decode["total_ms"], test.ShouldBeGreaterThanOrEqualTo, 6.)
+	test.That(t, decode["mean_ms"], test.ShouldBeGreaterThanOrEqualTo, 3.)
+	test.That(t, decode["max_ms"], test.ShouldBeGreaterThanOrEqualTo, 4.)
+	test.That(t, decode["max_ms"], test.ShouldBeLessThan, decode["total_ms"])
+	test.That(t, profileStage(t, snapshot, "store")["count"], test.ShouldEqual, int64(1))
+	test.That(t, profileStage(t, snapshot, "depacketize")["count"], test.ShouldEqual, int64(0))
+
+	// the timings are kept once profiling is stopped
+	p.stopProfiling(now.Add(2 * time.Second))
+	test.That(t, p.start().IsZero(), test.ShouldBeTrue)
+	snapshot = p.snapshot(now.Add(time.Hour))
+	test.That(t, snapshot["profiling"], test.ShouldBeFalse)
+	test.That(t, snapshot["duration_seconds"], test.ShouldEqual, 2.)
+	test.That(t, profileStage(t, snapshot, "decode")["count"], test.ShouldEqual, int64(2))
+
+	// & cleared once it's started again
+	p.startProfiling(now)
+	test.That(t, profileStage(t, p.snapshot(now), "decode")["count"], test.ShouldEqual, int64(0))
+	test.That(t, profileStage(t, p.snapshot(now), "decode")["max_ms"], test.ShouldEqual, 0.)
+
+	// decoders without a profiler aren't timed
+	var unset *stageProfiler
+	unset.record(profileConvert, unset.start())
+}
+
+func TestProfilingCommands(t *testing.T) {
+	ctx := context.Background()
+	rc := &rtspCamera{}
+	res, err := rc.DoCommand(ctx, map[string]interface{}{"command": startProfilingCommand})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res, test.ShouldResemble, map[string]interface{}{"profiling": true})
+
+	rc.profile.record(profileDepacketize, rc.profile.start())
+	res, err = rc.DoCommand(ctx, map[string]interface{}{"command": getProfileCommand})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["profiling"], test.ShouldBeTrue)
+	test.That(t, profileStage(t, res, "depacketize")["count"], test.ShouldEqual, int64(1))
+
+	res, err = rc.DoCommand(ctx, map[string]interface{}{"command": stopProfilingCommand})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["profiling"], test.ShouldBeFalse)
+	test.That(t, profileStage(t, res, "depacketize")["count"], test.ShouldEqual, int64(1))
+	test.That(t, rc.profile.start().IsZero(), test.ShouldBeTrue)
+}
@@ -0,0 +1,242 @@
+package viamrtsp
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ptzVector is a pan, tilt & zoom of the ONVIF generic spaces, where pan & tilt range from -1 to 1 & zoom from
+// 0 to 1 for positions, & each ranges from -1 to 1 for velocities & translations. Axes which are nil aren't moved.
+type ptzVector struct {
+	pan, tilt, zoom *float64
+}
+
+// parsePTZVector returns the pan, tilt & zoom fields of cmd, at least one of which must be set.
+func parsePTZVector(cmd map[string]interface{}, command string) (ptzVector, error) {
+	var v ptzVector
+	for key, dst := range map[string]**float64{"pan": &v.pan, "tilt": &v.tilt, "zoom": &v.zoom} {
+		raw, ok := cmd[key]
+		if !ok {
+			continue
+		}
+		f, ok := raw.(float64)
+		if !ok || f < -1 || f > 1 {
+			return ptzVector{}, errors.Errorf("%s requires '%s' to be a number between -1 & 1", command, key)
+		}
+		*dst = &f
+	}
+	if v.pan == nil && v.tilt == nil && v.zoom == nil {
+		return ptzVector{}, errors.Errorf("%s requires at least one of pan, tilt & zoom", command)
+	}
+	return v, nil
+}
+
+// marshal returns the vector as the PTZVector or PTZSpeed element name. An axis of pan & tilt which isn't set is
+// 0 if the other is set, as they're sent together.
+func (v ptzVector) marshal(name string) string {
+	float := func(f *float64) string {
+		if f == nil {
+			return "0"
+		}
+		return strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+	s := `<` + name + `>`
+	if v.pan != nil || v.tilt != nil {
+		s += `<PanTilt xmlns="` + onvifSchemaNamespace + `" x="` + float(v.pan) + `" y="` + float(v.tilt) + `"/>`
+	}
+	if v.zoom != nil {
+		s += `<Zoom xmlns="` + onvifSchemaNamespace + `" x="` + float(v.zoom) + `"/>`
+	}
+	return s + `</` + name + `>`
+}
+
+// ptzSpeed returns the Speed element of the optional 'speed' field of cmd, from 0 to 1, which applies to every axis.
+func ptzSpeed(cmd map[string]interface{}, command string) (string, error) {
+	raw, ok := cmd["speed"]
+	if !ok {
+		return "", nil
+	}
+	speed, ok := raw.(float64)
+	if !ok || speed < 0 || speed > 1 {
+		return "", errors.Errorf("%s requires 'speed' to be a number between 0 & 1", command)
+	}
+	return ptzVector{pan: &speed, tilt: &speed, zoom: &speed}.marshal("Speed"), nil
+}
+
+// ptzPreset is a preset position of an ONVIF PTZ camera.
+type ptzPreset struct {
+	Token string `xml:"token,attr"`
+	Name  string `xml:"Name"`
+}
+
+// ptzService returns a client of the camera's ONVIF PTZ service, & the token of the configured profile.
+func (rc *rtspCamera) ptzService(ctx context.Context, command string) (*onvifClient, string, string, error) {
+	conf, client, err := rc.deviceClient(command)
+	if err != nil {
+		return nil, "", "", err
+	}
+	services := client.services(ctx)
+	_, _, profile, err := client.mediaProfile(ctx, services, conf.Profile)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return client, client.service(services, onvifPTZNamespace), profile.Token, nil
+}
+
+// ptzCall sends the PTZ request operation for the profile, with the elements after its ProfileToken.
+func (c *onvifClient) ptzCall(ctx context.Context, ptzURL, operation, profile, elements string, res interface{}) error {
+	body := `<` + operation + ` xmlns="` + onvifPTZNamespace + `"><ProfileToken>` + xmlEscape(profile) + `</ProfileToken>` +
+		elements + `</` + operation + `>`
+	if err := c.call(ctx, ptzURL, body, res); err != nil {
+		return errors.Wrapf(err, "ONVIF PTZ %s", operation)
+	}
+	return nil
+}
+
+func (c *onvifClient) ptzPresets(ctx context.Context, ptzURL, profile string) ([]ptzPreset, error) {
+	var res struct {
+		Presets []ptzPreset `xml:"Body>GetPresetsResponse>Preset"`
+	}
+	if err := c.ptzCall(ctx, ptzURL, "GetPresets", profile, "", &res); err != nil {
+		return nil, err
+	}
+	return res.Presets, nil
+}
+
+// ptzMove moves the camera with the pan, tilt & zoom fields of cmd: continuously at that velocity until ptz-stop,
+// or for the optional 'timeout_seconds', by that translation, or to that position.
+func (rc *rtspCamera) ptzMove(ctx context.Context, cmd map[string]interface{}, command string) (map[string]interface{}, error) {
+	v, err := parsePTZVector(cmd, command)
+	if err != nil {
+		return nil, err
+	}
+	var operation, elements string
+	switch command {
+	case ptzContinuousMoveCommand:
+		operation, elements = "ContinuousMove", v.marshal("Velocity")
+		if raw, ok := cmd["timeout_seconds"]; ok {
+			timeout, ok := raw.(float64)
+			if !ok || timeout <= 0 {
+				return nil, errors.Errorf("%s requires 'timeout_seconds' to be a positive number", command)
+			}
+			elements += `<Timeout>PT` + strconv.FormatFloat(timeout, 'f', -1, 64) + `S</Timeout>`
+		}
+	case ptzRelativeMoveCommand:
+		operation, elements = "RelativeMove", v.marshal("Translation")
+	default:
+		operation, elements = "AbsoluteMove", v.marshal("Position")
+	}
+	if command != ptzContinuousMoveCommand {
+		speed, err := ptzSpeed(cmd, command)
+		if err != nil {
+			return nil, err
+		}
+		elements += speed
+	}
+	client, ptzURL, profile, err := rc.ptzService(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	var res struct{}
+	if err := client.ptzCall(ctx, ptzURL, operation, profile, elements, &res); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"moving": true}, nil
+}
+
+// ptzStop stops the pan, tilt & zoom movements of the camera.
+func (rc *rtspCamera) ptzStop(ctx context.Context) (map[string]interface{}, error) {
+	client, ptzURL, profile, err := rc.ptzService(ctx, ptzStopCommand)
+	if err != nil {
+		return nil, err
+	}
+	var res struct{}
+	if err := client.ptzCall(ctx, ptzURL, "Stop", profile, `<PanTilt>true</PanTilt><Zoom>true</Zoom>`, &res); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"stopped": true}, nil
+}
+
+// ptzGetPresets returns the tokens & names of the preset positions of the camera.
+func (rc *rtspCamera) ptzGetPresets(ctx context.Context) (map[string]interface{}, error) {
+	client, ptzURL, profile, err := rc.ptzService(ctx, ptzGetPresetsCommand)
+	if err != nil {
+		return nil, err
+	}
+	presets, err := client.ptzPresets(ctx, ptzURL, profile)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, 0, len(presets))
+	for _, p := range presets {
+		list = append(list, map[string]interface{}{"token": p.Token, "name": p.Name})
+	}
+	return map[string]interface{}{"presets": list}, nil
+}
+
+// ptzGotoPreset moves the camera to the preset whose token or name is the 'preset' field of cmd, at the optional
+// 'speed'.
+func (rc *rtspCamera) ptzGotoPreset(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	preset, ok := cmd["preset"].(string)
+	if !ok || preset == "" {
+		return nil, errors.Errorf("%s requires a string 'preset' field, the token or name of the preset", ptzGotoPresetCommand)
+	}
+	speed, err := ptzSpeed(cmd, ptzGotoPresetCommand)
+	if err != nil {
+		return nil, err
+	}
+	client, ptzURL, profile, err := rc.ptzService(ctx, ptzGotoPresetCommand)
+	if err != nil {
+		return nil, err
+	}
+	presets, err := client.ptzPresets(ctx, ptzURL, profile)
+	if err != nil {
+		return nil, err
+	}
+	token := ""
+	for _, p := range presets {
+		if p.Token == preset || (token == "" && strings.EqualFold(p.Name, preset)) {
+			token = p.Token
+		}
+	}
+	if token == "" {
+		return nil, errors.Errorf("no PTZ preset with the token or name '%s'", preset)
+	}
+	var res struct{}
+	if err := client.ptzCall(ctx, ptzURL, "GotoPreset", profile,
+		`<PresetToken>`+xmlEscape(token)+`</PresetToken>`+speed, &res); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"moving": true, "preset": token}, nil
+}
+
+// ptzSetPreset saves the current position of the camera as a preset with the optional 'name' of cmd, replacing the
+// preset whose token is the optional 'preset' of cmd, & returns the token of the preset.
+func (rc *rtspCamera) ptzSetPreset(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	var elements string
+	for _, field := range []struct{ key, element string }{{"name", "PresetName"}, {"preset", "PresetToken"}} {
+		raw, ok := cmd[field.key]
+		if !ok {
+			continue
+		}
+		s, ok := raw.(string)
+		if !ok {
+			return nil, errors.Errorf("%s requires '%s' to be a string", ptzSetPresetCommand, field.key)
+		}
+		elements += `<` + field.element + `>` + xmlEscape(s) + `</` + field.element + `>`
+	}
+	client, ptzURL, profile, err := rc.ptzService(ctx, ptzSetPresetCommand)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Token string `xml:"Body>SetPresetResponse>PresetToken"`
+	}
+	if err := client.ptzCall(ctx, ptzURL, "SetPreset", profile, elements, &res); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"preset": res.Token}, nil
+}
@@ -0,0 +1,174 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.viam.com/utils"
+)
+
+const (
+	// dropNewest drops units published while the queue is full.
+	dropNewest = "drop_newest"
+	// dropOldest drops the oldest queued unit to make room for the unit being published.
+	dropOldest = "drop_oldest"
+	// subscriberCloseTimeout bounds how long closing a queue waits for the callback being run,
+	// so that a subscriber which stopped consuming can't block closing the camera.
+	subscriberCloseTimeout = 2 * time.Second
+)
+
+// PassthroughQueueConfig configures the queue of units waiting to be sent to each rtp_passthrough subscriber.
+type PassthroughQueueConfig struct {
+	// Size is the number of queued units, which defaults to the buffer size requested by the subscriber.
+	Size int `json:"size,omitempty"`
+	// DropPolicy is drop_newest or drop_oldest, defaulting to drop_newest.
+	DropPolicy string `json:"drop_policy,omitempty"`
+	// PreserveKeyframes drops whole GOPs rather than single units, so that subscribers never
+	// receive frames which reference dropped frames.
+	PreserveKeyframes bool `json:"preserve_keyframes,omitempty"`
+}
+
+// Validate checks that the queue config is usable.
+func (c *PassthroughQueueConfig) Validate(path string) error {
+	if c.Size < 0 {
+		return fmt.Errorf("invalid rtp_passthrough_queue size %d for component at path '%s': must not be negative", c.Size, path)
+	}
+	switch c.DropPolicy {
+	case "", dropNewest, dropOldest:
+		return nil
+	default:
+		return fmt.Errorf("invalid rtp_passthrough_queue drop_policy '%s' for component at path '%s': must be %s or %s",
+			c.DropPolicy, path, dropNewest, dropOldest)
+	}
+}
+
+// queuedUnit is a call to a subscriber's callback waiting to be run.
+type queuedUnit struct {
+	run      func()
+	keyframe bool
+}
+
+// subscriberQueue runs the callbacks of a passthrough subscriber in order on its own goroutine,
+// dropping units according to the configured policy when the subscriber falls behind.
+type subscriberQueue struct {
+	size              int
+	dropPolicy        string
+	preserveKeyframes bool
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	units  []queuedUnit
+	closed bool
+	// awaitingKeyframe is set after a drop when preserveKeyframes is set, until the next keyframe is published
+	awaitingKeyframe bool
+	done             chan struct{}
+}
+
+// newSubscriberQueue starts a queue. bufferSize is the size requested by the subscriber, which is
+// used unless conf sets a size.
+func newSubscriberQueue(conf *PassthroughQueueConfig, bufferSize int) *subscriberQueue {
+	q := &subscriberQueue{size: bufferSize, dropPolicy: dropNewest, done: make(chan struct{})}
+	if conf != nil {
+		if conf.Size > 0 {
+			q.size = conf.Size
+		}
+		if conf.DropPolicy != "" {
+			q.dropPolicy = conf.DropPolicy
+		}
+		q.preserveKeyframes = conf.PreserveKeyframes
+	}
+	q.size = max(q.size, 1)
+	q.cond = sync.NewCond(&q.mu)
+	utils.PanicCapturingGo(q.runUnits)
+	return q
+}
+
+// publish queues run, returning the number of units dropped to do so, including run itself.
+// keyframe is whether the unit contains a keyframe.
+func (q *subscriberQueue) publish(run func(), keyframe bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 1
+	}
+	if q.awaitingKeyframe {
+		if !keyframe {
+			return 1
+		}
+		q.awaitingKeyframe = false
+	}
+	u := queuedUnit{run: run, keyframe: keyframe}
+	if len(q.units) < q.size {
+		q.units = append(q.units, u)
+		q.cond.Signal()
+		return 0
+	}
+
+	if q.dropPolicy == dropNewest {
+		q.awaitingKeyframe = q.preserveKeyframes
+		return 1
+	}
+	if !q.preserveKeyframes {
+		q.units = append(q.units[1:], u)
+		return 1
+	}
+	// drop the oldest GOP, the queued units up to the next queued keyframe
+	if next := slices.IndexFunc(q.units[1:], func(u queuedUnit) bool { return u.keyframe }); next >= 0 {
+		dropped := next + 1
+		q.units = append(q.units[dropped:], u)
+		return dropped
+	}
+	dropped := len(q.units)
+	q.units = q.units[:0]
+	if !keyframe {
+		q.awaitingKeyframe = true
+		return dropped + 1
+	}
+	q.units = append(q.units, u)
+	return dropped
+}
+
+// len returns the number of queued units.
+func (q *subscriberQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.units)
+}
+
+func (q *subscriberQueue) runUnits() {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		for len(q.units) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		u := q.units[0]
+		q.units[0] = queuedUnit{}
+		q.units = q.units[1:]
+		q.mu.Unlock()
+		u.run()
+	}
+}
+
+// close stops the queue, discarding the queued units, & waits for the callback being run, if any.
+// It returns false if it gave up waiting after subscriberCloseTimeout, in which case the queue stops
+// once the callback returns. It must not be called from a callback.
+func (q *subscriberQueue) close() bool {
+	q.mu.Lock()
+	q.closed = true
+	q.units = nil
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return true
+	case <-time.After(subscriberCloseTimeout):
+		return false
+	}
+}
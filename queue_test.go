@@ -0,0 +1,147 @@
+package viamrtsp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erh/viamrtsp/formatprocessor"
+	"go.viam.com/rdk/components/camera/rtppassthrough"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestSubscriberQueue(t *testing.T) {
+	type unit struct {
+		id       int
+		keyframe bool
+	}
+	units := []unit{{1, true}, {2, false}, {3, false}, {4, true}, {5, false}}
+
+	for _, tc := range []struct {
+		name    string
+		conf    *PassthroughQueueConfig
+		ran     []int
+		dropped int
+	}{
+		{"drop_newest is the default", &PassthroughQueueConfig{Size: 3}, []int{1, 2, 3}, 2},
+		{"drop_oldest", &PassthroughQueueConfig{Size: 3, DropPolicy: dropOldest}, []int{3, 4, 5}, 2},
+		{
+			"drop_newest preserving keyframes drops until the next keyframe is queued",
+			&PassthroughQueueConfig{Size: 2, PreserveKeyframes: true}, []int{1, 2}, 3,
+		},
+		{
+			"drop_oldest preserving keyframes drops the oldest gop",
+			&PassthroughQueueConfig{Size: 3, DropPolicy: dropOldest, PreserveKeyframes: true}, []int{4, 5}, 3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := newSubscriberQueue(tc.conf, 1)
+			defer q.close()
+			// block the queue so that the published units stay queued
+			running, unblock := make(chan struct{}), make(chan struct{})
+			test.That(t, q.publish(func() {
+				close(running)
+				<-unblock
+			}, true), test.ShouldEqual, 0)
+			<-running
+
+			var mu sync.Mutex
+			var ran []int
+			var dropped int
+			for _, u := range units {
+				u := u
+				dropped += q.publish(func() {
+					mu.Lock()
+					defer mu.Unlock()
+					ran = append(ran, u.id)
+				}, u.keyframe)
+			}
+			test.That(t, dropped, test.ShouldEqual, tc.dropped)
+
+			close(unblock)
+			for i := 0; i < 100; i++ {
+				mu.Lock()
+				n := len(ran)
+				mu.Unlock()
+				if n == len(tc.ran) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			test.That(t, ran, test.ShouldResemble, tc.ran)
+		})
+	}
+
+	t.Run("defaults to the requested buffer size", func(t *testing.T) {
+		for _, tc := range []struct {
+			conf *PassthroughQueueConfig
+			size int
+		}{{nil, 5}, {&PassthroughQueueConfig{Size: 2}, 2}} {
+			q := newSubscriberQueue(tc.conf, 5)
+			test.That(t, q.size, test.ShouldEqual, tc.size)
+			q.close()
+		}
+	})
+
+	t.Run("drops units after closing", func(t *testing.T) {
+		q := newSubscriberQueue(nil, 10)
+		q.close()
+		test.That(t, q.publish(func() { t.Error("unit ran after closing") }, true), test.ShouldEqual, 1)
+	})
+
+	t.Run("gives up closing when the subscriber is stuck", func(t *testing.T) {
+		q := newSubscriberQueue(nil, 10)
+		running, unblock := make(chan struct{}), make(chan struct{})
+		defer close(unblock)
+		test.That(t, q.publish(func() {
+			close(running)
+			<-unblock
+		}, true), test.ShouldEqual, 0)
+		<-running
+		start := time.Now()
+		test.That(t, q.close(), test.ShouldBeFalse)
+		test.That(t, time.Since(start), test.ShouldBeGreaterThanOrEqualTo, subscriberCloseTimeout)
+	})
+
+	t.Run("unsubscribing stuck subscribers doesn't block publishing", func(t *testing.T) {
+		rc := &rtspCamera{logger: logging.NewTestLogger(t), bufAndCBByID: map[rtppassthrough.SubscriptionID]bufAndCB{}}
+		sub, buf, err := rtppassthrough.NewSubscription(10)
+		test.That(t, err, test.ShouldBeNil)
+		buf.Start()
+		running, unblock := make(chan struct{}), make(chan struct{})
+		rc.bufAndCBByID[sub.ID] = bufAndCB{
+			cb: func(formatprocessor.Unit) {
+				close(running)
+				<-unblock
+			},
+			buf:   buf,
+			queue: newSubscriberQueue(nil, 10),
+			stats: newSubscriberStats(),
+		}
+		rc.publishPassthrough(&formatprocessor.H264{}, true)
+		<-running
+
+		unsubscribed := make(chan struct{})
+		go func() {
+			rc.unsubscribeAll()
+			close(unsubscribed)
+		}()
+		start := time.Now()
+		for rc.passthroughSubscribers() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		rc.publishPassthrough(&formatprocessor.H264{}, true)
+		test.That(t, time.Since(start), test.ShouldBeLessThan, subscriberCloseTimeout)
+		close(unblock)
+		<-unsubscribed
+	})
+
+	t.Run("validates the config", func(t *testing.T) {
+		test.That(t, (&PassthroughQueueConfig{DropPolicy: dropOldest}).Validate("path"), test.ShouldBeNil)
+		test.That(t, (&PassthroughQueueConfig{DropPolicy: "drop_all"}).Validate("path"), test.ShouldNotBeNil)
+		test.That(t, (&PassthroughQueueConfig{Size: -1}).Validate("path"), test.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,381 @@
+package viamrtsp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/resource"
+)
+
+// Reconfigure updates the camera without recreating it. Changes to the intrinsic & distortion
+// parameters, metrics_address, decode_workers, decode_priority, max_decode_fps, lazy_decode, max_frame_age_ms,
+// frame_history, rtp_passthrough_queue & rtp_passthrough_replay_gop are applied immediately and changing
+// stream_type rebuilds the camera. Any other change reconnects to the stream, keeping SubscribeRTP subscriptions as long
+// as the codec is unchanged & rtp_passthrough is still enabled, & enables rtp_passthrough again if it was disabled at
+// runtime, e.g. as the stream had B-frames.
+func (rc *rtspCamera) Reconfigure(_ context.Context, _ resource.Dependencies, conf resource.Config) error {
+	newConf, err := resource.NativeConfig[*Config](conf)
+	if err != nil {
+		return err
+	}
+
+	if newConf.StreamType != rc.conf.StreamType {
+		return resource.NewMustRebuildError(conf.ResourceName())
+	}
+
+	if newConf.MetricsAddress != rc.conf.MetricsAddress {
+		rc.stopMetricsServer()
+		if newConf.MetricsAddress != "" {
+			if err := rc.startMetricsServer(newConf.MetricsAddress); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !requiresReconnect(rc.conf, newConf) {
+		rc.applyLiveSettings(newConf)
+		rc.setCameraModel(newConf)
+		rc.conf = newConf
+		return nil
+	}
+
+	// stop the reconnect worker & close the connection so that neither use the settings while they change
+	rc.cancelFunc()
+	rc.activeBackgroundWorkers.Wait()
+	rc.closeConnection()
+	defer rc.startReconnectWorker()
+
+	oldConf := rc.conf
+	if err := rc.applyConfig(newConf); err != nil {
+		return err
+	}
+	rc.keepRotatedCredentials(oldConf, newConf)
+	// rtp_passthrough disabled at runtime, e.g. as the stream had B-frames, is tried again with the new stream
+	if rc.passthroughCtx().Err() != nil {
+		rc.resetPassthrough()
+	}
+	if newConf.RelayAddress != oldConf.RelayAddress || newConf.RelayUsername != oldConf.RelayUsername ||
+		newConf.RelayPassword != oldConf.RelayPassword {
+		rc.stopRelay()
+		if newConf.RelayAddress != "" {
+			if err := rc.startRelay(newConf); err != nil {
+				return err
+			}
+		}
+	}
+	rc.logger.Infof("reconfigured, reconnecting to %s", rc.redactedURL())
+	if err := rc.connect(rc.codecInfo); err != nil {
+		if !newConf.LazyConnect {
+			return err
+		}
+		rc.logger.Warnf("unable to reconnect, retrying in the background since lazy_connect is enabled, err: %s", err)
+	}
+	return nil
+}
+
+// resolveONVIFAddress returns the stream URI of the configured ONVIF profile.
+func (rc *rtspCamera) resolveONVIFAddress(conf *ONVIFConfig, username, password string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), onvifTimeout)
+	defer cancel()
+	address, err := conf.streamURI(ctx, username, password)
+	if err != nil {
+		return "", errors.Wrap(err, "resolving the rtsp address from onvif")
+	}
+	u, err := base.ParseURL(address)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid rtsp address '%s' resolved from onvif", address)
+	}
+	rc.logger.Infof("resolved rtsp address %s from onvif", u.CloneWithoutCredentials())
+	return address, nil
+}
+
+// resolveONVIFSubstream returns the address of the configured ONVIF substream profile, with the credentials of
+// the stream.
+func (rc *rtspCamera) resolveONVIFSubstream(conf *ONVIFConfig, username, password string) (*base.URL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), onvifTimeout)
+	defer cancel()
+	address, err := conf.substreamURI(ctx, username, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving the substream address from onvif")
+	}
+	u, err := base.ParseURL(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid substream address '%s' resolved from onvif", address)
+	}
+	rc.logger.Infof("resolved substream address %s from onvif, it is streamed while no rtp_passthrough subscriber is active",
+		u.CloneWithoutCredentials())
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	}
+	return u, nil
+}
+
+// resolveONVIFSnapshot returns the snapshot URI of the configured ONVIF profile.
+func (rc *rtspCamera) resolveONVIFSnapshot(conf *ONVIFConfig, username, password string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), onvifTimeout)
+	defer cancel()
+	address, err := conf.snapshotURI(ctx, username, password)
+	if err != nil {
+		return "", errors.Wrap(err, "resolving the snapshot address from onvif")
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid snapshot address '%s' resolved from onvif", address)
+	}
+	rc.logger.Infof("resolved snapshot address %s from onvif", u.Redacted())
+	return address, nil
+}
+
+// requiresReconnect returns true if the differences between the configs require reconnecting to the stream.
+func requiresReconnect(oldConf, newConf *Config) bool {
+	if oldConf == nil {
+		return true
+	}
+	o, n := *oldConf, *newConf
+	o.IntrinsicParams, n.IntrinsicParams = nil, nil
+	o.DistortionParams, n.DistortionParams = nil, nil
+	o.MetricsAddress, n.MetricsAddress = "", ""
+	o.DecodeWorkers, n.DecodeWorkers = 0, 0
+	o.DecodePriority, n.DecodePriority = 0, 0
+	o.MaxDecodeFPS, n.MaxDecodeFPS = 0, 0
+	o.LazyDecode, n.LazyDecode = false, false
+	o.MaxFrameAgeMs, n.MaxFrameAgeMs = 0, 0
+	o.FrameHistory, n.FrameHistory = 0, 0
+	o.PassthroughQueue, n.PassthroughQueue = nil, nil
+	o.ReplayGOP, n.ReplayGOP = false, false
+	return !reflect.DeepEqual(o, n)
+}
+
+// applyConfig sets the settings of the camera from newConf. It must not be called while connected.
+func (rc *rtspCamera) applyConfig(newConf *Config) error {
+	resolved, err := newConf.expandEnv()
+	if err != nil {
+		return err
+	}
+	username, password := resolved.Username, resolved.Password
+	if newConf.Credentials != nil {
+		if username, password, err = newConf.Credentials.lookup(); err != nil {
+			return err
+		}
+	}
+	var substream *base.URL
+	if newConf.ONVIF != nil {
+		rc.checkClock(newConf.ONVIF, username, password)
+		if address, err := rc.resolveONVIFAddress(newConf.ONVIF, username, password); err == nil {
+			resolved.Address = address
+		} else if resolved.Address != "" {
+			rc.logger.Warnf("unable to resolve the rtsp address from onvif, using rtsp_address, err: %s", err)
+		} else {
+			return err
+		}
+		if newConf.ONVIF.SubstreamProfile != "" {
+			if substream, err = rc.resolveONVIFSubstream(newConf.ONVIF, username, password); err != nil {
+				rc.logger.Warnf("unable to resolve the substream, only streaming the main stream, err: %s", err)
+			}
+		}
+	}
+	var addresses []*base.URL
+	for _, address := range append([]string{resolved.Address}, resolved.FallbackAddresses...) {
+		u, err := base.ParseURL(address)
+		if err != nil {
+			return err
+		}
+		if username != "" {
+			u.User = url.UserPassword(username, password)
+		}
+		addresses = append(addresses, u)
+	}
+	transport, err := parseTransport(newConf.Transport)
+	if err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if newConf.TLS != nil {
+		if tlsConfig, err = newConf.TLS.build(); err != nil {
+			return err
+		}
+	}
+
+	codecPreference, err := parseCodecPreference(newConf.CodecPreference)
+	if err != nil {
+		return err
+	}
+	scale, err := parseDecodeScale(newConf.DecodeScale)
+	if err != nil {
+		return err
+	}
+	orientation, err := newOrientation(newConf.RotateDegrees, newConf.Flip)
+	if err != nil {
+		return err
+	}
+	keepalive, err := newKeepalivePolicy(newConf.KeepaliveMethod, newConf.KeepaliveInterval)
+	if err != nil {
+		return err
+	}
+
+	rc.uMu.Lock()
+	rc.u = addresses[0]
+	rc.addresses = addresses
+	rc.substream = substream
+	rc.streamingSubstream = false
+	rc.substreamFailed = false
+	rc.uMu.Unlock()
+	rc.failedReconnects = 0
+	rc.reconnectPolicy = newReconnectPolicy(newConf)
+	rc.transport.Store(transport)
+	rc.httpTunnel = isHTTPTunnel(newConf.Transport)
+	rc.tlsConfig = tlsConfig
+	rc.timeouts = newRTSPTimeouts(newConf)
+	rc.udpReadBuffer = newConf.UDPReadBuffer
+	rc.binding = newLocalBinding(newConf)
+	rc.keepalive = keepalive
+	rc.idleTimeout = secondsToDuration(newConf.IdleTimeout)
+	rc.tokens.Store(nil)
+	if newConf.TokenAuth != nil {
+		rc.tokens.Store(newTokenSource(*newConf.TokenAuth))
+	}
+	rc.hardwareDecode = newConf.HardwareDecode
+	rc.applyLiveSettings(newConf)
+	rc.suppressCorrupted = newConf.SuppressCorrupted
+	rc.decodeFrames.Store(newConf.DecodeFrames == nil || *newConf.DecodeFrames)
+	rc.recordingConf.Store(newConf.Recording)
+	rc.onvif.Store(newConf.ONVIF)
+	rc.videoTrack = newConf.VideoTrack
+	rc.codecPreference = codecPreference
+	rc.snapshots.Store(nil)
+	if newConf.SnapshotFallback {
+		snapshots := newSnapshotSource(newConf)
+		snapshots.username, snapshots.password = username, password
+		if snapshots.url == "" {
+			if snapshots.url, err = rc.resolveONVIFSnapshot(newConf.ONVIF, username, password); err != nil {
+				rc.logger.Warnf("unable to resolve the snapshot uri from onvif, snapshot_fallback is disabled, err: %s", err)
+			}
+		}
+		if snapshots.url != "" {
+			rc.snapshots.Store(snapshots)
+		}
+	}
+	rc.depth = newConf.StreamType == depthStreamType
+	rc.gray = newConf.OutputFormat == gray8OutputFormat
+	rc.decodeScale = scale
+	rc.deinterlace = newConf.Deinterlace
+	rc.transcode.Store(newConf.Transcode)
+	// sinks detached by the detach-sink command are attached again with the new config
+	rc.sinksMu.Lock()
+	rc.detachedSinks = nil
+	rc.sinksMu.Unlock()
+	rc.orientation = orientation
+	rc.crop = newConf.Crop.rect()
+	rc.audio = newConf.Audio
+	if !rc.audio {
+		rc.audioMu.Lock()
+		rc.audioProps = nil
+		rc.audioMu.Unlock()
+	}
+	rc.metadataTrack = newConf.Metadata
+	rc.rtpPassthrough.Store(newConf.RTPPassthrough)
+	rc.setCameraModel(newConf)
+	rc.conf = newConf
+	return nil
+}
+
+// keepRotatedCredentials sets the credentials rotated with update-credentials on the addresses applyConfig resolved
+// from newConf, unless newConf changes the credentials, which then replace them.
+func (rc *rtspCamera) keepRotatedCredentials(oldConf, newConf *Config) {
+	rc.uMu.Lock()
+	defer rc.uMu.Unlock()
+	if rc.rotatedUser == nil {
+		return
+	}
+	if credentialsChanged(oldConf, newConf) {
+		rc.rotatedUser = nil
+		return
+	}
+	rc.setUserLocked(rc.rotatedUser)
+}
+
+// credentialsChanged returns whether newConf configures other credentials than oldConf, including those of its
+// addresses. Addresses which aren't URLs, e.g. as they reference environment variables, are compared as a whole.
+func credentialsChanged(oldConf, newConf *Config) bool {
+	userinfo := func(conf *Config) map[string]bool {
+		users := map[string]bool{}
+		for _, address := range append([]string{conf.Address}, conf.FallbackAddresses...) {
+			if u, err := url.Parse(address); err != nil {
+				users[address] = true
+			} else if u.User != nil {
+				users[u.User.String()] = true
+			}
+		}
+		return users
+	}
+	return oldConf.Username != newConf.Username || oldConf.Password != newConf.Password ||
+		!reflect.DeepEqual(oldConf.Credentials, newConf.Credentials) ||
+		!reflect.DeepEqual(userinfo(oldConf), userinfo(newConf))
+}
+
+// applyLiveSettings sets the settings of the camera from newConf which are applied without reconnecting.
+func (rc *rtspCamera) applyLiveSettings(newConf *Config) {
+	rc.decodeShare.update(newConf.DecodeWorkers, newConf.DecodePriority)
+	rc.decodeThrottle.setMaxFPS(newConf.MaxDecodeFPS)
+	rc.lazyDecode.Store(newConf.LazyDecode)
+	rc.maxFrameAge.Store(int64(time.Duration(newConf.MaxFrameAgeMs) * time.Millisecond))
+	rc.frames.resize(newConf.FrameHistory)
+	rc.passthroughQueue.Store(newConf.PassthroughQueue)
+	rc.replayGOP.Store(newConf.ReplayGOP)
+}
+
+// connect connects to the stream, trying each of the fallback addresses if connecting fails.
+func (rc *rtspCamera) connect(codecInfo videoCodec) error {
+	err := rc.reconnectClient(codecInfo)
+	// counted after connecting, which selects whether the substream is streamed
+	addresses := rc.failoverAddresses()
+	for i := 1; err != nil && i < addresses; i++ {
+		rc.logger.Warnf("unable to connect to %s, trying fallback address, err: %s", rc.redactedURL(), err)
+		rc.failover()
+		err = rc.reconnectClient(codecInfo)
+	}
+	return err
+}
+
+// startReconnectWorker starts the reconnect worker, which runs until cancelFunc is called.
+func (rc *rtspCamera) startReconnectWorker() {
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	rc.cancelFunc = cancel
+	rc.clientReconnectBackgroundWorker(cancelCtx, rc.codecInfo)
+}
+
+func (rc *rtspCamera) setCameraModel(newConf *Config) {
+	cameraModel := camera.NewPinholeModelWithBrownConradyDistortion(newConf.IntrinsicParams, newConf.DistortionParams)
+	rc.propsMu.Lock()
+	defer rc.propsMu.Unlock()
+	rc.cameraModel = cameraModel
+	rc.intrinsicsWarnedSize.Store(0)
+}
+
+// Properties returns the properties of the video source with the currently configured intrinsic & distortion parameters,
+// or a *NotReadyError while the camera isn't streaming. The intrinsics are rescaled to the resolution of the latest frame
+// if they were calibrated at another resolution.
+func (rc *rtspCamera) Properties(ctx context.Context) (camera.Properties, error) {
+	if err := rc.health.notReady(); err != nil {
+		return camera.Properties{}, err
+	}
+	props, err := rc.VideoSource.Properties(ctx)
+	if err != nil {
+		return camera.Properties{}, err
+	}
+	rc.propsMu.RLock()
+	props.IntrinsicParams = rc.cameraModel.PinholeCameraIntrinsics
+	props.DistortionParams = rc.cameraModel.Distortion
+	rc.propsMu.RUnlock()
+	if latest := rc.frames.latest(); latest != nil && props.IntrinsicParams != nil {
+		props.IntrinsicParams = rc.intrinsicsFor(latest.img.Bounds().Size())
+	}
+	props.SupportsPCD = rc.depth && props.IntrinsicParams != nil
+	return props, nil
+}
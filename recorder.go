@@ -0,0 +1,381 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/pion/rtp"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/utils"
+)
+
+const (
+	defaultSegmentDurationSec = 60
+	defaultRetentionHours     = 24
+	// defaultUploadPath is relative to the home directory.
+	defaultUploadPath = ".viam/video-upload"
+	// recorderQueueSize is the number of access units queued for writing before access units are dropped.
+	recorderQueueSize = 256
+	// segmentTimeFormat is the format of the UTC start time in segment file names.
+	segmentTimeFormat = "2006-01-02T15-04-05.000Z"
+)
+
+// RecordingConfig configures recording the stream to segmented MP4 files on disk.
+type RecordingConfig struct {
+	// Directory is where segments are written, it is created if it does not exist.
+	Directory string `json:"directory"`
+	// SegmentDurationSec is the minimum duration of a segment, segments end at the first keyframe after it.
+	SegmentDurationSec int `json:"segment_duration_sec,omitempty"`
+	// RetentionHours is how long segments are kept before they are deleted.
+	RetentionHours int `json:"retention_hours,omitempty"`
+	// UploadPath is where clips are saved by the save command, it should be synced by the data manager.
+	UploadPath string `json:"upload_path,omitempty"`
+}
+
+// Validate checks that the recording config is usable.
+func (c *RecordingConfig) Validate(path string) error {
+	if c.Directory == "" {
+		return fmt.Errorf("recording requires a directory for component at path '%s'", path)
+	}
+	if c.SegmentDurationSec < 0 || c.RetentionHours < 0 {
+		return fmt.Errorf("invalid recording config for component at path '%s': "+
+			"segment_duration_sec & retention_hours must not be negative", path)
+	}
+	return nil
+}
+
+func (c *RecordingConfig) segmentDuration() time.Duration {
+	if c.SegmentDurationSec == 0 {
+		return defaultSegmentDurationSec * time.Second
+	}
+	return time.Duration(c.SegmentDurationSec) * time.Second
+}
+
+func (c *RecordingConfig) uploadPath() (string, error) {
+	if c.UploadPath != "" {
+		return c.UploadPath, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, defaultUploadPath), nil
+}
+
+func (c *RecordingConfig) retention() time.Duration {
+	if c.RetentionHours == 0 {
+		return defaultRetentionHours * time.Hour
+	}
+	return time.Duration(c.RetentionHours) * time.Hour
+}
+
+// recordedAU is an access unit waiting to be written.
+type recordedAU struct {
+	au         [][]byte
+	pts        time.Duration
+	capturedAt time.Time
+	keyframe   bool
+}
+
+// newRecorderSink records the access units of the video track media, whose codec is codec & whose
+// parameter sets in the SDP are params.
+func (rc *rtspCamera) newRecorderSink(codec videoCodec, params [][]byte, media *description.Media) (*videoSink, error) {
+	conf := rc.recordingConf.Load()
+	if conf == nil {
+		return nil, errors.New("recording is not configured")
+	}
+	r, err := newRecorder(*conf, rc.Name().Name, codec, params, rc.logger)
+	if err != nil {
+		return nil, err
+	}
+	return &videoSink{
+		accessUnit: func(au [][]byte, pkt *rtp.Packet, keyframe bool) {
+			if pts, ok := rc.client.PacketPTS(media, pkt); ok {
+				r.write(au, pts, rc.packetTime(media, pkt), keyframe)
+			}
+		},
+		close: r.close,
+	}, nil
+}
+
+// dtsExtractor is implemented by the H264 & H265 DTS extractors.
+type dtsExtractor interface {
+	Extract(au [][]byte, pts time.Duration) (time.Duration, error)
+}
+
+// recorder remuxes the H264 or H265 access units of a connection into MP4 segments, named
+// <camera>_<UTC start time>.mp4. Access units are written by a background goroutine so that
+// disk writes don't delay receiving packets.
+type recorder struct {
+	conf   RecordingConfig
+	name   string
+	codec  videoCodec
+	logger logging.Logger
+	aus    chan recordedAU
+	done   chan struct{}
+
+	// the fields below are only used by the writing goroutine
+	params       map[int][]byte
+	segment      *mp4Muxer
+	segmentStart time.Time
+	baseDTS      time.Duration
+	firstAU      bool
+	dts          dtsExtractor
+	// paramsChanged is set when the SPS changed during the current segment, until the next segment
+	paramsChanged bool
+}
+
+// newRecorder starts recording. params are the parameter sets from the stream's SDP, if any.
+func newRecorder(conf RecordingConfig, name string, codec videoCodec, params [][]byte, logger logging.Logger) (*recorder, error) {
+	if codec != H264 && codec != H265 {
+		return nil, errors.Errorf("recording is not supported for codec %s", codec)
+	}
+	if err := os.MkdirAll(conf.Directory, 0o750); err != nil {
+		return nil, errors.Wrap(err, "creating recording directory")
+	}
+	r := &recorder{
+		conf:   conf,
+		name:   name,
+		codec:  codec,
+		logger: logger,
+		aus:    make(chan recordedAU, recorderQueueSize),
+		done:   make(chan struct{}),
+		params: map[int][]byte{},
+	}
+	r.updateParams(params)
+	utils.PanicCapturingGo(r.run)
+	return r, nil
+}
+
+// write queues au to be written, dropping it if the queue is full.
+func (r *recorder) write(au [][]byte, pts time.Duration, capturedAt time.Time, keyframe bool) {
+	// NALUs may point into buffers the RTP decoder reuses
+	cp := make([][]byte, len(au))
+	for i, nalu := range au {
+		cp[i] = append([]byte(nil), nalu...)
+	}
+	select {
+	case r.aus <- recordedAU{au: cp, pts: pts, capturedAt: capturedAt, keyframe: keyframe}:
+	default:
+		r.logger.Debug("recording queue is full, dropping access unit")
+	}
+}
+
+// close writes the queued access units & closes the current segment.
+func (r *recorder) close() {
+	close(r.aus)
+	<-r.done
+}
+
+func (r *recorder) run() {
+	defer close(r.done)
+	for au := range r.aus {
+		if err := r.writeAU(au); err != nil {
+			r.logger.Warnf("error recording %s, err: %s", r.name, err)
+		}
+	}
+	r.closeSegment()
+}
+
+func (r *recorder) writeAU(au recordedAU) error {
+	sps := r.params[r.spsType()]
+	r.updateParams(au.au)
+	// the parameters of a segment can't change, so a new SPS, e.g. for a new resolution, starts a new segment
+	if r.segment != nil && !bytes.Equal(sps, r.params[r.spsType()]) {
+		r.paramsChanged = true
+	}
+	if au.keyframe && (r.segment == nil || r.paramsChanged || au.capturedAt.Sub(r.segmentStart) >= r.conf.segmentDuration()) {
+		if err := r.startSegment(au.capturedAt); err != nil {
+			return err
+		}
+	}
+	if r.segment == nil {
+		// segments must start with a keyframe
+		return nil
+	}
+
+	nalus := au.au
+	if au.keyframe {
+		nalus = r.withParams(nalus)
+	}
+	dts, err := r.dts.Extract(nalus, au.pts)
+	if err != nil {
+		r.logger.Debugf("unable to extract DTS, dropping access unit: %s", err)
+		return nil
+	}
+	if r.firstAU {
+		r.baseDTS = dts
+		r.firstAU = false
+	}
+	annexB, err := h264.AnnexBMarshal(nalus)
+	if err != nil {
+		return err
+	}
+	return r.segment.writePacket(annexB, au.pts-r.baseDTS, dts-r.baseDTS, au.keyframe)
+}
+
+func (r *recorder) startSegment(start time.Time) error {
+	params := r.paramSets()
+	if params == nil {
+		return errors.New("no parameter sets received yet")
+	}
+	r.closeSegment()
+
+	var info *streamInfo
+	var dts dtsExtractor
+	if r.codec == H265 {
+		info = h265StreamInfo(r.params[int(h265.NALUType_SPS_NUT)])
+		dts = h265.NewDTSExtractor()
+	} else {
+		info = h264StreamInfo(r.params[int(h264.NALUTypeSPS)])
+		dts = h264.NewDTSExtractor()
+	}
+	if info == nil {
+		return errors.New("unable to parse SPS")
+	}
+	annexB, err := h264.AnnexBMarshal(params)
+	if err != nil {
+		return err
+	}
+	path := segmentPath(r.conf.Directory, r.name, start)
+	segment, err := newMP4Muxer(path, r.codec, annexB, info.width, info.height)
+	if err != nil {
+		return err
+	}
+	r.segment, r.segmentStart, r.firstAU, r.dts = segment, start, true, dts
+	r.paramsChanged = false
+	r.deleteExpiredSegments(time.Now())
+	return nil
+}
+
+func (r *recorder) closeSegment() {
+	if r.segment == nil {
+		return
+	}
+	if err := r.segment.close(); err != nil {
+		r.logger.Warnf("error closing recording segment, err: %s", err)
+	}
+	r.segment = nil
+}
+
+// naluType returns the type of nalu in the recorder's codec.
+func (r *recorder) naluType(nalu []byte) int {
+	if r.codec == H265 {
+		return int(h265.NALUType((nalu[0] >> 1) & 0b111111))
+	}
+	return int(h264.NALUType(nalu[0] & 0x1F))
+}
+
+func (r *recorder) spsType() int {
+	if r.codec == H265 {
+		return int(h265.NALUType_SPS_NUT)
+	}
+	return int(h264.NALUTypeSPS)
+}
+
+func (r *recorder) paramTypes() []int {
+	if r.codec == H265 {
+		return []int{int(h265.NALUType_VPS_NUT), int(h265.NALUType_SPS_NUT), int(h265.NALUType_PPS_NUT)}
+	}
+	return []int{int(h264.NALUTypeSPS), int(h264.NALUTypePPS)}
+}
+
+// updateParams stores the parameter sets contained in au, as cameras may change them in band.
+func (r *recorder) updateParams(au [][]byte) {
+	for _, nalu := range au {
+		if len(nalu) == 0 {
+			continue
+		}
+		typ := r.naluType(nalu)
+		for _, paramType := range r.paramTypes() {
+			if typ == paramType {
+				r.params[typ] = nalu
+			}
+		}
+	}
+}
+
+// paramSets returns the parameter sets in decoding order, or nil if any are missing.
+func (r *recorder) paramSets() [][]byte {
+	var params [][]byte
+	for _, typ := range r.paramTypes() {
+		param, ok := r.params[typ]
+		if !ok {
+			return nil
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// withParams prepends the parameter sets to a keyframe which was sent without them.
+func (r *recorder) withParams(au [][]byte) [][]byte {
+	for _, nalu := range au {
+		if len(nalu) > 0 && r.naluType(nalu) == r.paramTypes()[0] {
+			return au
+		}
+	}
+	return append(r.paramSets(), au...)
+}
+
+// deleteExpiredSegments deletes the segments of the camera which were last written before the retention period.
+func (r *recorder) deleteExpiredSegments(now time.Time) {
+	segments, err := listSegments(r.conf.Directory, r.name)
+	if err != nil {
+		r.logger.Warnf("unable to list recording segments, err: %s", err)
+		return
+	}
+	for _, s := range segments {
+		info, err := os.Stat(s.path)
+		if err != nil || now.Sub(info.ModTime()) < r.conf.retention() {
+			continue
+		}
+		if err := os.Remove(s.path); err != nil {
+			r.logger.Warnf("unable to delete expired recording segment, err: %s", err)
+		}
+	}
+}
+
+// segmentFile is a recorded segment on disk.
+type segmentFile struct {
+	path  string
+	start time.Time
+}
+
+func segmentPath(dir, name string, start time.Time) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_%s.mp4", name, start.UTC().Format(segmentTimeFormat)))
+}
+
+// listSegments returns the segments of the camera named name in dir, oldest first.
+func listSegments(dir, name string) ([]segmentFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []segmentFile
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), name+"_")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, ".mp4")
+		if !ok {
+			continue
+		}
+		start, err := time.Parse(segmentTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segmentFile{path: filepath.Join(dir, entry.Name()), start: start})
+	}
+	// ReadDir sorts by file name, which sorts segments by start time
+	return segments, nil
+}
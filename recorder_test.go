@@ -0,0 +1,82 @@
+package viamrtsp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestRecordingConfigDefaults(t *testing.T) {
+	conf := RecordingConfig{Directory: "/tmp"}
+	test.That(t, conf.segmentDuration(), test.ShouldEqual, time.Minute)
+	test.That(t, conf.retention(), test.ShouldEqual, 24*time.Hour)
+	conf = RecordingConfig{Directory: "/tmp", SegmentDurationSec: 10, RetentionHours: 2}
+	test.That(t, conf.segmentDuration(), test.ShouldEqual, 10*time.Second)
+	test.That(t, conf.retention(), test.ShouldEqual, 2*time.Hour)
+}
+
+func TestListSegments(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Minute + 500*time.Millisecond)
+	for _, path := range []string{
+		segmentPath(dir, "cam", second),
+		segmentPath(dir, "cam", first),
+		segmentPath(dir, "other", first),
+		filepath.Join(dir, "cam_notes.txt"),
+		filepath.Join(dir, "cam_invalid.mp4"),
+	} {
+		test.That(t, os.WriteFile(path, nil, 0o600), test.ShouldBeNil)
+	}
+
+	segments, err := listSegments(dir, "cam")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, segments, test.ShouldResemble, []segmentFile{
+		{path: filepath.Join(dir, "cam_2024-05-01T12-00-00.000Z.mp4"), start: first},
+		{path: filepath.Join(dir, "cam_2024-05-01T12-01-00.500Z.mp4"), start: second},
+	})
+
+	_, err = listSegments(filepath.Join(dir, "missing"), "cam")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestDeleteExpiredSegments(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	expired := segmentPath(dir, "cam", now.Add(-3*time.Hour))
+	kept := segmentPath(dir, "cam", now.Add(-time.Hour))
+	otherCamera := segmentPath(dir, "other", now.Add(-3*time.Hour))
+	for _, path := range []string{expired, kept, otherCamera} {
+		test.That(t, os.WriteFile(path, nil, 0o600), test.ShouldBeNil)
+	}
+	test.That(t, os.Chtimes(expired, now, now.Add(-3*time.Hour)), test.ShouldBeNil)
+	test.That(t, os.Chtimes(kept, now, now.Add(-time.Hour)), test.ShouldBeNil)
+	test.That(t, os.Chtimes(otherCamera, now, now.Add(-3*time.Hour)), test.ShouldBeNil)
+
+	r := &recorder{conf: RecordingConfig{Directory: dir, RetentionHours: 2}, name: "cam", logger: logging.NewTestLogger(t)}
+	r.deleteExpiredSegments(now)
+
+	_, err := os.Stat(expired)
+	test.That(t, os.IsNotExist(err), test.ShouldBeTrue)
+	_, err = os.Stat(kept)
+	test.That(t, err, test.ShouldBeNil)
+	_, err = os.Stat(otherCamera)
+	test.That(t, err, test.ShouldBeNil)
+}
+
+func TestRecorderParams(t *testing.T) {
+	sps := []byte{0x67, 0x01}
+	pps := []byte{0x68, 0x02}
+	idr := []byte{0x65, 0x03}
+	r := &recorder{codec: H264, params: map[int][]byte{}}
+	r.updateParams([][]byte{nil, pps})
+	test.That(t, r.paramSets(), test.ShouldBeNil)
+	r.updateParams([][]byte{sps, idr})
+	test.That(t, r.paramSets(), test.ShouldResemble, [][]byte{sps, pps})
+	test.That(t, r.withParams([][]byte{idr}), test.ShouldResemble, [][]byte{sps, pps, idr})
+	test.That(t, r.withParams([][]byte{sps, pps, idr}), test.ShouldResemble, [][]byte{sps, pps, idr})
+}
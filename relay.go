@@ -0,0 +1,234 @@
+package viamrtsp
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/auth"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
+)
+
+// relayServer republishes the stream of the camera on a local RTSP server, so that other consumers,
+// e.g. an NVR, can pull the stream from the robot without opening more connections to the camera.
+// Readers are served over TCP on any path.
+type relayServer struct {
+	logger logging.Logger
+	server *gortsplib.Server
+	// user & pass, if set, are the credentials readers must authenticate with
+	user, pass string
+	nonce      string
+
+	// mu guards stream & medias, which are replaced on every connection to the camera
+	mu     sync.RWMutex
+	stream *gortsplib.ServerStream
+	medias []*description.Media
+
+	// playing holds the sessions of the readers which are playing the stream
+	playingMu sync.Mutex
+	playing   map[*gortsplib.ServerSession]struct{}
+	// onPlay, if set, is called when a reader starts playing the stream
+	onPlay func()
+}
+
+// relayRealm is the realm readers of the relay authenticate in.
+const relayRealm = "viamrtsp"
+
+// newRelayServer starts a relay server on address. If user is set, readers must authenticate with user & pass.
+func newRelayServer(address, user, pass string, logger logging.Logger) (*relayServer, error) {
+	r, err := startRelayServer(&gortsplib.Server{RTSPAddress: address}, logger)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		nonce, err := auth.GenerateNonce()
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.user, r.pass, r.nonce = user, pass, nonce
+	}
+	return r, nil
+}
+
+// startRelayServer starts server, whose Handler is replaced by the returned relayServer.
+func startRelayServer(server *gortsplib.Server, logger logging.Logger) (*relayServer, error) {
+	r := &relayServer{logger: logger, server: server}
+	server.Handler = r
+	if err := server.Start(); err != nil {
+		return nil, errors.Wrapf(err, "unable to start relay server on %s", server.RTSPAddress)
+	}
+	return r, nil
+}
+
+// publish replaces the relayed stream with the medias of a new connection to the camera, disconnecting
+// the readers of the previous stream. If medias is empty readers are refused until the next publish.
+func (r *relayServer) publish(medias []*description.Media) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stream != nil {
+		r.stream.Close()
+		r.stream = nil
+	}
+	r.medias = medias
+	if len(medias) > 0 {
+		r.stream = gortsplib.NewServerStream(r.server, &description.Session{Medias: medias})
+	}
+}
+
+// writePacketRTP relays pkt to the readers of the stream.
+func (r *relayServer) writePacketRTP(media *description.Media, pkt *rtp.Packet) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// packets can be received before the stream of their connection is published
+	if r.stream == nil || !slices.Contains(r.medias, media) {
+		return
+	}
+	if err := r.stream.WritePacketRTP(media, pkt); err != nil {
+		r.logger.Debugf("error relaying packet err: %s", err)
+	}
+}
+
+func (r *relayServer) close() {
+	r.publish(nil)
+	r.server.Close()
+}
+
+func (r *relayServer) currentStream() *gortsplib.ServerStream {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.stream
+}
+
+// authenticate returns the response refusing req if the relay requires credentials & req doesn't have them,
+// or nil if req may read the stream.
+func (r *relayServer) authenticate(req *base.Request) *base.Response {
+	if r.user == "" {
+		return nil
+	}
+	if err := auth.Validate(req, r.user, r.pass, nil, nil, relayRealm, r.nonce); err != nil {
+		// clients first send requests without credentials to learn the authentication methods
+		if _, ok := req.Header["Authorization"]; ok {
+			r.logger.Debugf("relay reader failed to authenticate, err: %s", err)
+		}
+		return &base.Response{
+			StatusCode: base.StatusUnauthorized,
+			Header: base.Header{
+				"WWW-Authenticate": auth.GenerateWWWAuthenticate(nil, relayRealm, r.nonce),
+			},
+		}
+	}
+	return nil
+}
+
+// OnDescribe implements gortsplib.ServerHandlerOnDescribe.
+func (r *relayServer) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	if res := r.authenticate(ctx.Request); res != nil {
+		return res, nil, nil
+	}
+	stream := r.currentStream()
+	if stream == nil {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil, nil
+	}
+	return &base.Response{StatusCode: base.StatusOK}, stream, nil
+}
+
+// OnSetup implements gortsplib.ServerHandlerOnSetup.
+func (r *relayServer) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	if res := r.authenticate(ctx.Request); res != nil {
+		return res, nil, nil
+	}
+	stream := r.currentStream()
+	if stream == nil {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil, nil
+	}
+	return &base.Response{StatusCode: base.StatusOK}, stream, nil
+}
+
+// OnPlay implements gortsplib.ServerHandlerOnPlay.
+func (r *relayServer) OnPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+	r.playingMu.Lock()
+	if r.playing == nil {
+		r.playing = map[*gortsplib.ServerSession]struct{}{}
+	}
+	r.playing[ctx.Session] = struct{}{}
+	r.playingMu.Unlock()
+	if r.onPlay != nil {
+		r.onPlay()
+	}
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+// OnSessionClose implements gortsplib.ServerHandlerOnSessionClose.
+func (r *relayServer) OnSessionClose(ctx *gortsplib.ServerHandlerOnSessionCloseCtx) {
+	r.playingMu.Lock()
+	defer r.playingMu.Unlock()
+	delete(r.playing, ctx.Session)
+}
+
+// readers returns how many readers are playing the stream.
+func (r *relayServer) readers() int {
+	r.playingMu.Lock()
+	defer r.playingMu.Unlock()
+	return len(r.playing)
+}
+
+// onPacketRTP registers cb to receive the packets of media, counting the packets, computing their
+// reception statistics & relaying them if relay_address is set.
+func (rc *rtspCamera) onPacketRTP(media *description.Media, f format.Format, cb gortsplib.OnPacketRTPFunc) {
+	stats := newTrackStats(f.Codec(), f.ClockRate())
+	rc.rtcpStatsMu.Lock()
+	rc.rtcpStats = append(rc.rtcpStats, stats)
+	rc.rtcpStatsMu.Unlock()
+	rc.client.OnPacketRTCP(media, func(pkt rtcp.Packet) {
+		stats.processRTCP(pkt, time.Now())
+	})
+	counted := rc.metrics.countPackets(cb)
+	cb = func(pkt *rtp.Packet) {
+		stats.processRTP(pkt, time.Now())
+		counted(pkt)
+	}
+	relay := rc.relay
+	if relay == nil {
+		rc.client.OnPacketRTP(media, f, cb)
+		return
+	}
+	rc.relayMedias = append(rc.relayMedias, media)
+	rc.client.OnPacketRTP(media, f, func(pkt *rtp.Packet) {
+		relay.writePacketRTP(media, pkt)
+		cb(pkt)
+	})
+}
+
+// startRelay starts relaying the stream on the relay_address of conf. It must not be called while connected.
+func (rc *rtspCamera) startRelay(conf *Config) error {
+	relay, err := newRelayServer(conf.RelayAddress, conf.RelayUsername, conf.RelayPassword, rc.logger)
+	if err != nil {
+		return err
+	}
+	relay.onPlay = func() { rc.resumeStream() }
+	rc.relay = relay
+	if conf.RelayUsername == "" {
+		rc.logger.Warnf("relaying stream on rtsp://%s without authentication, set relay_username & relay_password "+
+			"unless the address is only reachable by trusted readers", conf.RelayAddress)
+	} else {
+		rc.logger.Infof("relaying stream on rtsp://%s", conf.RelayAddress)
+	}
+	return nil
+}
+
+// stopRelay stops the relay server if it is running. It must not be called while connected.
+func (rc *rtspCamera) stopRelay() {
+	if rc.relay == nil {
+		return
+	}
+	rc.relay.close()
+	rc.relay = nil
+}
@@ -0,0 +1,54 @@
+package viamrtsp
+
+import (
+	"sync"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/erh/viamrtsp/formatprocessor"
+)
+
+// passthroughGOP holds the passthrough units since the most recent keyframe, so that new
+// SubscribeRTP subscribers can be sent the keyframe, or the whole GOP, when they subscribe.
+type passthroughGOP struct {
+	mu    sync.Mutex
+	units []*formatprocessor.H264
+}
+
+// add buffers u, dropping the previous GOP if u contains an IDR. Units received before the
+// first keyframe, or after the GOP grew past maxBufferedAccessUnits, are not buffered.
+func (g *passthroughGOP) add(u *formatprocessor.H264) {
+	keyframe := h264.IDRPresent(u.AU)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if keyframe {
+		g.units = g.units[:0]
+	} else if len(g.units) == 0 || len(g.units) == maxBufferedAccessUnits {
+		return
+	}
+	// NALUs may point into buffers the RTP decoder reuses
+	cp := *u
+	cp.AU = make([][]byte, len(u.AU))
+	for i, nalu := range u.AU {
+		cp.AU[i] = append([]byte(nil), nalu...)
+	}
+	g.units = append(g.units, &cp)
+}
+
+// replay returns the buffered keyframe, followed by the rest of the GOP if full is true.
+func (g *passthroughGOP) replay(full bool) []*formatprocessor.H264 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.units) == 0 {
+		return nil
+	}
+	if !full {
+		return g.units[:1]
+	}
+	return append([]*formatprocessor.H264(nil), g.units...)
+}
+
+func (g *passthroughGOP) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.units = nil
+}
@@ -0,0 +1,36 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"github.com/erh/viamrtsp/formatprocessor"
+	"go.viam.com/test"
+)
+
+func TestPassthroughGOP(t *testing.T) {
+	idr := &formatprocessor.H264{AU: [][]byte{{0x67}, {0x68}, {0x65, 0x01}}}
+	nonIDR := &formatprocessor.H264{AU: [][]byte{{0x41, 0x02}}}
+
+	var g passthroughGOP
+	// units before the first keyframe are not buffered
+	g.add(nonIDR)
+	test.That(t, g.replay(true), test.ShouldBeNil)
+
+	g.add(idr)
+	g.add(nonIDR)
+	g.add(nonIDR)
+	test.That(t, g.replay(false), test.ShouldHaveLength, 1)
+	test.That(t, g.replay(false)[0].AU, test.ShouldResemble, idr.AU)
+	test.That(t, g.replay(true), test.ShouldHaveLength, 3)
+
+	// buffered NALUs don't share memory with the received units
+	nonIDR.AU[0][1] = 0x03
+	test.That(t, g.replay(true)[2].AU[0], test.ShouldResemble, []byte{0x41, 0x02})
+
+	// a keyframe starts a new GOP
+	g.add(idr)
+	test.That(t, g.replay(true), test.ShouldHaveLength, 1)
+
+	g.reset()
+	test.That(t, g.replay(false), test.ShouldBeNil)
+}
@@ -0,0 +1,154 @@
+package viamrtsp
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// trackStats computes the RFC 3550 reception statistics of an RTP track, i.e. the same interarrival
+// jitter & cumulative loss gortsplib reports to the camera in its receiver reports, and records the
+// camera's sender reports, so that users can tell network loss apart from decoder issues.
+type trackStats struct {
+	codec     string
+	clockRate int
+
+	mu          sync.Mutex
+	started     bool
+	baseSeq     uint16
+	maxSeq      uint16
+	cycles      uint32
+	received    uint64
+	lastArrival time.Time
+	lastTS      uint32
+	// jitter is the interarrival jitter in clock rate units
+	jitter float64
+
+	senderReports   uint64
+	lastSenderAt    time.Time
+	senderPackets   uint32
+	senderOctets    uint32
+	lastSenderNTPAt time.Time
+	// lastSenderRTPTime is the RTP timestamp which corresponds to lastSenderNTPAt in the camera's clock
+	lastSenderRTPTime uint32
+}
+
+func newTrackStats(codec string, clockRate int) *trackStats {
+	return &trackStats{codec: codec, clockRate: clockRate}
+}
+
+// processRTP updates the statistics with a packet received at now.
+func (s *trackStats) processRTP(pkt *rtp.Packet, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		s.started = true
+		s.baseSeq, s.maxSeq = pkt.SequenceNumber, pkt.SequenceNumber
+		s.received = 1
+		s.lastArrival, s.lastTS = now, pkt.Timestamp
+		return
+	}
+	s.received++
+	// sequence numbers after maxSeq, taking wrap around into account, advance it
+	if diff := int16(pkt.SequenceNumber - s.maxSeq); diff > 0 {
+		if pkt.SequenceNumber < s.maxSeq {
+			s.cycles += 1 << 16
+		}
+		s.maxSeq = pkt.SequenceNumber
+	}
+	if s.clockRate > 0 {
+		arrivalDiff := now.Sub(s.lastArrival).Seconds() * float64(s.clockRate)
+		d := math.Abs(arrivalDiff - float64(int32(pkt.Timestamp-s.lastTS)))
+		s.jitter += (d - s.jitter) / 16
+	}
+	s.lastArrival, s.lastTS = now, pkt.Timestamp
+}
+
+// processRTCP records the sender reports of the camera received at now.
+func (s *trackStats) processRTCP(pkt rtcp.Packet, now time.Time) {
+	sr, ok := pkt.(*rtcp.SenderReport)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.senderReports++
+	s.lastSenderAt = now
+	s.senderPackets = sr.PacketCount
+	s.senderOctets = sr.OctetCount
+	s.lastSenderNTPAt = ntpToTime(sr.NTPTime)
+	s.lastSenderRTPTime = sr.RTPTime
+}
+
+// snapshot returns the statistics, as returned by the get-rtcp-stats command.
+func (s *trackStats) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expected uint64
+	if s.started {
+		expected = uint64(s.cycles) + uint64(s.maxSeq) - uint64(s.baseSeq) + 1
+	}
+	var lost uint64
+	if expected > s.received {
+		lost = expected - s.received
+	}
+	resp := map[string]interface{}{
+		"codec":            s.codec,
+		"packets_received": s.received,
+		"packets_expected": expected,
+		"packets_lost":     lost,
+		"sender_reports":   s.senderReports,
+	}
+	if expected > 0 {
+		resp["fraction_lost"] = float64(lost) / float64(expected)
+	}
+	if s.clockRate > 0 {
+		resp["clock_rate"] = s.clockRate
+		resp["jitter_ms"] = s.jitter / float64(s.clockRate) * 1000
+	}
+	if s.senderReports > 0 {
+		resp["last_sender_report_at"] = s.lastSenderAt.Format(time.RFC3339Nano)
+		resp["last_sender_report_ntp_time"] = s.lastSenderNTPAt.Format(time.RFC3339Nano)
+		resp["last_sender_report_rtp_time"] = s.lastSenderRTPTime
+		resp["sender_packet_count"] = s.senderPackets
+		resp["sender_octet_count"] = s.senderOctets
+	}
+	return resp
+}
+
+// ntpToTime converts a 64 bit NTP timestamp, as sent in RTCP sender reports, to a time.
+func ntpToTime(v uint64) time.Time {
+	const ntpEpochOffset = 2208988800
+	secs := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xFFFFFFFF) * 1e9 >> 32)
+	return time.Unix(secs, nanos).UTC()
+}
+
+// getRTCPStats returns the reception statistics of every track of the current connection.
+func (rc *rtspCamera) getRTCPStats() (map[string]interface{}, error) {
+	rc.rtcpStatsMu.Lock()
+	defer rc.rtcpStatsMu.Unlock()
+	tracks := make([]interface{}, 0, len(rc.rtcpStats))
+	for _, stats := range rc.rtcpStats {
+		tracks = append(tracks, stats.snapshot())
+	}
+	return map[string]interface{}{"tracks": tracks}, nil
+}
+
+// logRTCPStats logs the reception statistics of the tracks of the connection being closed & clears them.
+func (rc *rtspCamera) logRTCPStats() {
+	rc.rtcpStatsMu.Lock()
+	defer rc.rtcpStatsMu.Unlock()
+	for _, stats := range rc.rtcpStats {
+		snapshot := stats.snapshot()
+		if snapshot["packets_received"] == uint64(0) {
+			continue
+		}
+		rc.logger.Infof("%s track reception stats: packets received: %d, lost: %d, jitter: %.1fms, sender reports: %d",
+			stats.codec, snapshot["packets_received"], snapshot["packets_lost"], snapshot["jitter_ms"], snapshot["sender_reports"])
+	}
+	rc.rtcpStats = nil
+}
@@ -0,0 +1,53 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"go.viam.com/test"
+)
+
+func TestTrackStats(t *testing.T) {
+	t.Run("loss across sequence number wrap around", func(t *testing.T) {
+		s := newTrackStats("H264", 90000)
+		now := time.Now()
+		for i, seq := range []uint16{65533, 65534, 0, 2, 3} {
+			s.processRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: uint32(i * 3000)}},
+				now.Add(time.Duration(i)*time.Second/30))
+		}
+		snapshot := s.snapshot()
+		test.That(t, snapshot["packets_received"], test.ShouldEqual, uint64(5))
+		test.That(t, snapshot["packets_expected"], test.ShouldEqual, uint64(7))
+		test.That(t, snapshot["packets_lost"], test.ShouldEqual, uint64(2))
+		test.That(t, snapshot["fraction_lost"], test.ShouldAlmostEqual, 2.0/7)
+		// packets arrived exactly as far apart as their timestamps
+		test.That(t, snapshot["jitter_ms"], test.ShouldAlmostEqual, 0, 1e-6)
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		s := newTrackStats("H264", 90000)
+		now := time.Now()
+		s.processRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: 1, Timestamp: 0}}, now)
+		// 10ms late, i.e. a difference of 900 clock rate units
+		s.processRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: 2, Timestamp: 3000}}, now.Add(time.Second/30+10*time.Millisecond))
+		test.That(t, s.snapshot()["jitter_ms"], test.ShouldAlmostEqual, 10.0/16, 1e-6)
+	})
+
+	t.Run("sender reports", func(t *testing.T) {
+		s := newTrackStats("H264", 90000)
+		test.That(t, s.snapshot()["last_sender_report_at"], test.ShouldBeNil)
+		now := time.Now()
+		s.processRTCP(&rtcp.ReceiverReport{}, now)
+		s.processRTCP(&rtcp.SenderReport{
+			NTPTime: (2208988800 + 1714768384) << 32, RTPTime: 123456, PacketCount: 42, OctetCount: 4200,
+		}, now)
+		snapshot := s.snapshot()
+		test.That(t, snapshot["sender_reports"], test.ShouldEqual, uint64(1))
+		test.That(t, snapshot["sender_packet_count"], test.ShouldEqual, uint32(42))
+		test.That(t, snapshot["last_sender_report_ntp_time"], test.ShouldEqual, "2024-05-03T20:33:04Z")
+		test.That(t, snapshot["last_sender_report_rtp_time"], test.ShouldEqual, uint32(123456))
+		test.That(t, snapshot["clock_rate"], test.ShouldEqual, 90000)
+	})
+}
@@ -4,9 +4,14 @@ package viamrtsp
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"image"
 	"image/jpeg"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,11 +20,19 @@ import (
 	"github.com/bluenviron/gortsplib/v4/pkg/base"
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpav1"
 	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
 	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph265"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmpeg4video"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpvp8"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpvp9"
 	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+	"github.com/bluenviron/mediacommon/pkg/codecs/av1"
 	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
 	"github.com/erh/viamrtsp/formatprocessor"
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/mediadevices/pkg/wave"
 	"github.com/pion/rtp"
 	"github.com/pkg/errors"
 	"go.viam.com/rdk/components/camera"
@@ -27,11 +40,16 @@ import (
 	"go.viam.com/rdk/gostream"
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/resource"
+	"go.viam.com/rdk/rimage"
 	"go.viam.com/rdk/rimage/transform"
 	rutils "go.viam.com/rdk/utils"
 	"go.viam.com/utils"
 )
 
+// failoverAfterFailedReconnects is how many reconnects to an address must fail in a row
+// before the next of the fallback_addresses is tried.
+const failoverAfterFailedReconnects = 3
+
 var (
 	family = resource.ModelNamespace("erh").WithFamily("viamrtsp")
 	// ModelAgnostic selects the best available codec.
@@ -42,10 +60,31 @@ var (
 	ModelH265 = family.WithModel("rtsp-h265")
 	// ModelMJPEG uses the mjpeg codec.
 	ModelMJPEG = family.WithModel("rtsp-mjpeg")
+	// ModelAV1 uses the av1 codec.
+	ModelAV1 = family.WithModel("rtsp-av1")
+	// ModelVP8 uses the vp8 codec.
+	ModelVP8 = family.WithModel("rtsp-vp8")
+	// ModelVP9 uses the vp9 codec.
+	ModelVP9 = family.WithModel("rtsp-vp9")
+	// ModelMPEG4 uses the mpeg4 part 2 codec.
+	ModelMPEG4 = family.WithModel("rtsp-mpeg4")
 	// Models is a slice containing the above available models.
-	Models = []resource.Model{ModelAgnostic, ModelH264, ModelH265, ModelMJPEG}
+	Models = []resource.Model{ModelAgnostic, ModelH264, ModelH265, ModelMJPEG, ModelAV1, ModelVP8, ModelVP9, ModelMPEG4}
 	// ErrH264PassthroughNotEnabled is an error indicating H264 passthrough is not enabled.
 	ErrH264PassthroughNotEnabled = errors.New("H264 passthrough is not enabled")
+	// ErrStaleFrame is returned instead of an image when the latest frame is older than max_frame_age_ms.
+	ErrStaleFrame = errors.New("latest frame is stale")
+	// ErrUnauthorized is the cause of connection errors when the camera rejects the configured credentials,
+	// or requires credentials which aren't configured.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrCodecUnsupported is the cause of connection errors when none of the stream's video tracks has a codec
+	// supported by the model.
+	ErrCodecUnsupported = errors.New("codec unsupported")
+	// ErrNoVideoTrack is the cause of connection errors when the stream has no video track, or none matching
+	// video_track.
+	ErrNoVideoTrack = errors.New("no video track")
+	// ErrConnectTimeout is the cause of connection errors when the camera didn't respond within the timeouts.
+	ErrConnectTimeout = errors.New("connect timeout")
 )
 
 func init() {
@@ -58,10 +97,81 @@ func init() {
 
 // Config are the config attributes for an RTSP camera model.
 type Config struct {
-	Address          string                             `json:"rtsp_address"`
-	RTPPassthrough   bool                               `json:"rtp_passthrough"`
-	IntrinsicParams  *transform.PinholeCameraIntrinsics `json:"intrinsic_parameters,omitempty"`
-	DistortionParams *transform.BrownConrady            `json:"distortion_parameters,omitempty"`
+	Address           string                             `json:"rtsp_address"`
+	FallbackAddresses []string                           `json:"fallback_addresses,omitempty"`
+	Username          string                             `json:"username,omitempty"`
+	Password          string                             `json:"password,omitempty"`
+	Credentials       *CredentialsConfig                 `json:"credentials,omitempty"`
+	RTPPassthrough    bool                               `json:"rtp_passthrough"`
+	ReplayGOP         bool                               `json:"rtp_passthrough_replay_gop,omitempty"`
+	PassthroughQueue  *PassthroughQueueConfig            `json:"rtp_passthrough_queue,omitempty"`
+	Transcode         *TranscodeConfig                   `json:"rtp_passthrough_transcode,omitempty"`
+	IntrinsicParams   *transform.PinholeCameraIntrinsics `json:"intrinsic_parameters,omitempty"`
+	DistortionParams  *transform.BrownConrady            `json:"distortion_parameters,omitempty"`
+	TokenAuth         *TokenAuthConfig                   `json:"token_auth,omitempty"`
+	Transport         string                             `json:"transport,omitempty"`
+	TLS               *TLSConfig                         `json:"tls,omitempty"`
+	ONVIF             *ONVIFConfig                       `json:"onvif,omitempty"`
+	HardwareDecode    string                             `json:"hardware_decode,omitempty"`
+	MaxDecodeFPS      float64                            `json:"max_decode_fps,omitempty"`
+	DecodeWorkers     int                                `json:"decode_workers,omitempty"`
+	DecodePriority    float64                            `json:"decode_priority,omitempty"`
+	LazyDecode        bool                               `json:"lazy_decode,omitempty"`
+	DecodeFrames      *bool                              `json:"decode_frames,omitempty"`
+	SuppressCorrupted bool                               `json:"suppress_corrupted_frames,omitempty"`
+	Audio             bool                               `json:"audio,omitempty"`
+	Metadata          bool                               `json:"metadata,omitempty"`
+	StreamType        string                             `json:"stream_type,omitempty"`
+	OutputFormat      string                             `json:"output_format,omitempty"`
+	DecodeScale       string                             `json:"decode_scale,omitempty"`
+	Deinterlace       bool                               `json:"deinterlace,omitempty"`
+	RotateDegrees     int                                `json:"rotate_degrees,omitempty"`
+	Flip              string                             `json:"flip,omitempty"`
+	Crop              *CropConfig                        `json:"crop,omitempty"`
+	MetricsAddress    string                             `json:"metrics_address,omitempty"`
+	LazyConnect       bool                               `json:"lazy_connect,omitempty"`
+	ReconnectInterval float64                            `json:"reconnect_interval,omitempty"`
+	MaxBackoff        float64                            `json:"max_backoff,omitempty"`
+	GiveUpAfter       float64                            `json:"give_up_after,omitempty"`
+	ReadTimeout       float64                            `json:"read_timeout,omitempty"`
+	WriteTimeout      float64                            `json:"write_timeout,omitempty"`
+	DialTimeout       float64                            `json:"dial_timeout,omitempty"`
+	UDPReadBuffer     int                                `json:"udp_read_buffer_bytes,omitempty"`
+	BindInterface     string                             `json:"bind_interface,omitempty"`
+	BindAddress       string                             `json:"bind_address,omitempty"`
+	KeepaliveMethod   string                             `json:"keepalive_method,omitempty"`
+	KeepaliveInterval float64                            `json:"keepalive_interval,omitempty"`
+	IdleTimeout       float64                            `json:"idle_timeout,omitempty"`
+	SnapshotFallback  bool                               `json:"snapshot_fallback,omitempty"`
+	SnapshotURL       string                             `json:"snapshot_url,omitempty"`
+	MaxFrameAgeMs     int                                `json:"max_frame_age_ms,omitempty"`
+	FrameHistory      int                                `json:"frame_history,omitempty"`
+	Recording         *RecordingConfig                   `json:"recording,omitempty"`
+	RelayAddress      string                             `json:"relay_address,omitempty"`
+	RelayUsername     string                             `json:"relay_username,omitempty"`
+	RelayPassword     string                             `json:"relay_password,omitempty"`
+	VideoTrack        *VideoTrackConfig                  `json:"video_track,omitempty"`
+	CodecPreference   []string                           `json:"codec_preference,omitempty"`
+}
+
+// parseTransport maps the transport config attribute to a gortsplib transport.
+// An empty transport returns nil so gortsplib tries UDP and falls back to TCP.
+func parseTransport(transport string) (*gortsplib.Transport, error) {
+	var t gortsplib.Transport
+	switch strings.ToLower(transport) {
+	case "":
+		return nil, nil
+	case "udp":
+		t = gortsplib.TransportUDP
+	case "udp-multicast":
+		t = gortsplib.TransportUDPMulticast
+	case "tcp", httpTunnelTransport:
+		t = gortsplib.TransportTCP
+	default:
+		return nil, fmt.Errorf("unsupported transport '%s', must be one of 'udp', 'udp-multicast', 'tcp' or '%s'",
+			transport, httpTunnelTransport)
+	}
+	return &t, nil
 }
 
 // CodecFormat contains a pointer to a format and the corresponding FFmpeg codec.
@@ -72,7 +182,20 @@ type codecFormat struct {
 
 // Validate checks to see if the attributes of the model are valid.
 func (conf *Config) Validate(path string) ([]string, error) {
-	_, err := base.ParseURL(conf.Address)
+	resolved, err := conf.expandEnv()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config for component at path '%s': %w", path, err)
+	}
+	if conf.ONVIF != nil {
+		if err := conf.ONVIF.Validate(path); err != nil {
+			return nil, err
+		}
+		// the address is resolved from the onvif profile, rtsp_address is only used if that fails
+		if resolved.Address == "" {
+			resolved.Address = "rtsp://"
+		}
+	}
+	u, err := base.ParseURL(resolved.Address)
 	if err != nil {
 		return nil, fmt.Errorf("invalid address '%s' for component at path '%s': %w", conf.Address, path, err)
 	}
@@ -86,6 +209,193 @@ func (conf *Config) Validate(path string) ([]string, error) {
 			return nil, fmt.Errorf("invalid distortion parameters for component at path '%s': %w", path, err)
 		}
 	}
+	if conf.TokenAuth != nil {
+		if err := conf.TokenAuth.Validate(path); err != nil {
+			return nil, err
+		}
+		if conf.TokenAuth.sendsAuthorization() && (resolved.Username != "" || u.User != nil || conf.Credentials != nil) {
+			return nil, fmt.Errorf("invalid token_auth for component at path '%s': the token can not be sent in the "+
+				"Authorization header of streams with credentials, set header or query_param", path)
+		}
+	}
+	if resolved.Password != "" && resolved.Username == "" {
+		return nil, fmt.Errorf("invalid credentials for component at path '%s': password requires a username", path)
+	}
+	if resolved.Username != "" && u.User != nil {
+		return nil, fmt.Errorf("invalid credentials for component at path '%s': "+
+			"username can not be set when rtsp_address already contains credentials", path)
+	}
+	if conf.Credentials != nil {
+		if err := conf.Credentials.Validate(path); err != nil {
+			return nil, err
+		}
+		if resolved.Username != "" {
+			return nil, fmt.Errorf("invalid credentials for component at path '%s': username can not be set with credentials", path)
+		}
+		if u.User != nil {
+			return nil, fmt.Errorf("invalid credentials for component at path '%s': "+
+				"credentials can not be set when rtsp_address already contains credentials", path)
+		}
+	}
+	for i, address := range resolved.FallbackAddresses {
+		fallback, err := base.ParseURL(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fallback address '%s' for component at path '%s': %w", conf.FallbackAddresses[i], path, err)
+		}
+		if fallback.Scheme != u.Scheme {
+			return nil, fmt.Errorf("invalid fallback address '%s' for component at path '%s': must use the same scheme as rtsp_address",
+				fallback.CloneWithoutCredentials(), path)
+		}
+		if (resolved.Username != "" || conf.Credentials != nil) && fallback.User != nil {
+			return nil, fmt.Errorf("invalid credentials for component at path '%s': "+
+				"username or credentials can not be set when fallback_addresses contain credentials", path)
+		}
+	}
+	transport, err := parseTransport(conf.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transport for component at path '%s': %w", path, err)
+	}
+	if u.Scheme == "rtsps" && transport != nil && *transport != gortsplib.TransportTCP {
+		return nil, fmt.Errorf("invalid transport '%s' for component at path '%s': rtsps only supports tcp", conf.Transport, path)
+	}
+	if u.Scheme == "rtsps" && isHTTPTunnel(conf.Transport) {
+		return nil, fmt.Errorf("invalid transport '%s' for component at path '%s': rtsps can not be tunneled through http",
+			conf.Transport, path)
+	}
+	if conf.HardwareDecode != "" && !slices.Contains(hardwareDecoders, conf.HardwareDecode) {
+		return nil, fmt.Errorf("invalid hardware_decode '%s' for component at path '%s': must be one of %v",
+			conf.HardwareDecode, path, hardwareDecoders)
+	}
+	if conf.MaxDecodeFPS < 0 {
+		return nil, fmt.Errorf("invalid max_decode_fps %v for component at path '%s': must not be negative", conf.MaxDecodeFPS, path)
+	}
+	if conf.DecodeWorkers < 0 {
+		return nil, fmt.Errorf("invalid decode_workers %d for component at path '%s': must not be negative", conf.DecodeWorkers, path)
+	}
+	if conf.DecodePriority < 0 {
+		return nil, fmt.Errorf("invalid decode_priority %v for component at path '%s': must not be negative", conf.DecodePriority, path)
+	}
+	if conf.StreamType != "" && conf.StreamType != colorStreamType && conf.StreamType != depthStreamType {
+		return nil, fmt.Errorf("invalid stream_type '%s' for component at path '%s': must be '%s' or '%s'",
+			conf.StreamType, path, colorStreamType, depthStreamType)
+	}
+	if conf.OutputFormat != "" && conf.OutputFormat != gray8OutputFormat {
+		return nil, fmt.Errorf("invalid output_format '%s' for component at path '%s': must be '%s'",
+			conf.OutputFormat, path, gray8OutputFormat)
+	}
+	if conf.OutputFormat != "" && conf.StreamType == depthStreamType {
+		return nil, fmt.Errorf("invalid output_format '%s' for component at path '%s': depth streams always output depth maps",
+			conf.OutputFormat, path)
+	}
+	if _, err := parseDecodeScale(conf.DecodeScale); err != nil {
+		return nil, fmt.Errorf("invalid decode_scale for component at path '%s': %w", path, err)
+	}
+	if conf.ReconnectInterval < 0 || conf.MaxBackoff < 0 || conf.GiveUpAfter < 0 {
+		return nil, fmt.Errorf("invalid reconnect policy for component at path '%s': "+
+			"reconnect_interval, max_backoff & give_up_after must not be negative", path)
+	}
+	if conf.MaxBackoff > 0 && conf.MaxBackoff < conf.ReconnectInterval {
+		return nil, fmt.Errorf("invalid max_backoff %v for component at path '%s': must not be less than reconnect_interval %v",
+			conf.MaxBackoff, path, conf.ReconnectInterval)
+	}
+	if conf.ReadTimeout < 0 || conf.WriteTimeout < 0 || conf.DialTimeout < 0 {
+		return nil, fmt.Errorf("invalid timeouts for component at path '%s': "+
+			"read_timeout, write_timeout & dial_timeout must not be negative", path)
+	}
+	if conf.UDPReadBuffer < 0 {
+		return nil, fmt.Errorf("invalid udp_read_buffer_bytes %d for component at path '%s': must not be negative",
+			conf.UDPReadBuffer, path)
+	}
+	if conf.BindInterface != "" && conf.BindAddress != "" {
+		return nil, fmt.Errorf("invalid bind_address for component at path '%s': bind_interface & bind_address can not both be set", path)
+	}
+	if conf.BindAddress != "" && net.ParseIP(conf.BindAddress) == nil {
+		return nil, fmt.Errorf("invalid bind_address '%s' for component at path '%s': must be an ip address", conf.BindAddress, path)
+	}
+	if _, err := newKeepalivePolicy(conf.KeepaliveMethod, conf.KeepaliveInterval); err != nil {
+		return nil, fmt.Errorf("invalid keepalive for component at path '%s': %w", path, err)
+	}
+	if conf.IdleTimeout < 0 {
+		return nil, fmt.Errorf("invalid idle_timeout %v for component at path '%s': must not be negative", conf.IdleTimeout, path)
+	}
+	if _, err := parseCodecPreference(conf.CodecPreference); err != nil {
+		return nil, fmt.Errorf("invalid codec_preference for component at path '%s': %w", path, err)
+	}
+	if _, err := newOrientation(conf.RotateDegrees, conf.Flip); err != nil {
+		return nil, fmt.Errorf("invalid orientation for component at path '%s': %w", path, err)
+	}
+	if conf.Crop != nil {
+		if err := conf.Crop.Validate(path); err != nil {
+			return nil, err
+		}
+	}
+	if conf.VideoTrack != nil {
+		if err := conf.VideoTrack.Validate(path); err != nil {
+			return nil, err
+		}
+	}
+	if conf.PassthroughQueue != nil {
+		if err := conf.PassthroughQueue.Validate(path); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Transcode != nil {
+		if !conf.RTPPassthrough {
+			return nil, fmt.Errorf("invalid rtp_passthrough_transcode for component at path '%s': requires rtp_passthrough", path)
+		}
+		if err := conf.Transcode.Validate(path); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Recording != nil {
+		if err := conf.Recording.Validate(path); err != nil {
+			return nil, err
+		}
+	}
+	if conf.MaxFrameAgeMs < 0 {
+		return nil, fmt.Errorf("invalid max_frame_age_ms %d for component at path '%s': must not be negative", conf.MaxFrameAgeMs, path)
+	}
+	if conf.FrameHistory < 0 || conf.FrameHistory > maxFrameHistory {
+		return nil, fmt.Errorf("invalid frame_history %d for component at path '%s': must be between 0 & %d",
+			conf.FrameHistory, path, maxFrameHistory)
+	}
+	if conf.SnapshotFallback && conf.SnapshotURL == "" && conf.ONVIF == nil {
+		return nil, fmt.Errorf("snapshot_fallback requires a snapshot_url or an onvif config for component at path '%s'", path)
+	}
+	if conf.SnapshotURL != "" {
+		snapshotURL, err := url.Parse(conf.SnapshotURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot_url for component at path '%s': %w", path, err)
+		}
+		if snapshotURL.Scheme != "http" && snapshotURL.Scheme != "https" {
+			return nil, fmt.Errorf("invalid snapshot_url '%s' for component at path '%s': scheme must be http or https",
+				conf.SnapshotURL, path)
+		}
+	}
+	if conf.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(conf.MetricsAddress); err != nil {
+			return nil, fmt.Errorf("invalid metrics_address '%s' for component at path '%s': %w", conf.MetricsAddress, path, err)
+		}
+	}
+	if conf.RelayAddress != "" {
+		if _, _, err := net.SplitHostPort(conf.RelayAddress); err != nil {
+			return nil, fmt.Errorf("invalid relay_address '%s' for component at path '%s': %w", conf.RelayAddress, path, err)
+		}
+	}
+	if (conf.RelayUsername == "") != (conf.RelayPassword == "") {
+		return nil, fmt.Errorf("relay_username & relay_password must be set together for component at path '%s'", path)
+	}
+	if conf.RelayUsername != "" && conf.RelayAddress == "" {
+		return nil, fmt.Errorf("relay_username requires relay_address for component at path '%s'", path)
+	}
+	if conf.TLS != nil {
+		if u.Scheme != "rtsps" {
+			return nil, fmt.Errorf("invalid tls config for component at path '%s': tls requires an rtsps:// rtsp_address", path)
+		}
+		if err := conf.TLS.Validate(path); err != nil {
+			return nil, err
+		}
+	}
 
 	return nil, nil
 }
@@ -95,113 +405,468 @@ type (
 	bufAndCB           struct {
 		cb  unitSubscriberFunc
 		buf *rtppassthrough.Buffer
+		// queue runs cb, buf is only used for the lifetime of the subscription
+		queue *subscriberQueue
+		stats *subscriberStats
 	}
 )
 
-// rtspCamera contains the rtsp client, and the reader function that fulfills the camera interface.
+// rtspCamera contains the rtsp client, and the video source that fulfills the camera interface.
 type rtspCamera struct {
-	model resource.Model
-	gostream.VideoReader
-	u *base.URL
-
-	client     *gortsplib.Client
-	rawDecoder *decoder
-
-	cancelCtx  context.Context
+	resource.Named
+	camera.VideoSource
+	model     resource.Model
+	codecInfo videoCodec
+
+	// conf is the config the camera was last built or reconfigured with
+	conf *Config
+	// propsMu guards cameraModel, which can be reconfigured at runtime
+	propsMu     sync.RWMutex
+	cameraModel transform.PinholeCameraModel
+	// intrinsicsWarnedSize is the last frame size, packed as width<<32 | height, warned about not matching the intrinsics
+	intrinsicsWarnedSize atomic.Int64
+
+	// uMu guards u, addresses & the substream. u is the address currently being streamed from, which is
+	// one of addresses, the rtsp_address followed by the fallback_addresses.
+	uMu       sync.RWMutex
+	u         *base.URL
+	addresses []*base.URL
+	// substream, if set, is the address of the onvif substream_profile, which is streamed instead of u while
+	// streamingSubstream is set. substreamFailed is set once failing over from it, until reconfigured.
+	substream          *base.URL
+	streamingSubstream bool
+	substreamFailed    bool
+	// rotatedUser, if set, are the credentials set by update-credentials, which are kept across Reconfigure
+	// unless the config changes the credentials
+	rotatedUser *url.Userinfo
+	// failedReconnects counts consecutive failed reconnects to u, it is only used by the reconnect worker
+	failedReconnects int
+	reconnectPolicy  reconnectPolicy
+
+	client *gortsplib.Client
+	// pipeline passes the video track of H264 & H265 connections on to their sinks, which detachedSinks are
+	// kept detached from across reconnects
+	pipeline      atomic.Pointer[videoPipeline]
+	sinksMu       sync.Mutex
+	detachedSinks map[sinkKind]bool
+	// videoTrack, if set, selects the video track to set up
+	videoTrack *VideoTrackConfig
+	// codecPreference, if set, is the order codecs are tried in by the codec agnostic model
+	codecPreference []videoCodec
+	// tokens, if set, fetches the auth tokens added to the RTSP requests
+	tokens atomic.Pointer[tokenSource]
+	// transport is the transport protocol to use, nil means gortsplib picks one
+	transport atomic.Pointer[gortsplib.Transport]
+	// tlsConfig is used for rtsps:// addresses, nil means the system defaults are used
+	tlsConfig *tls.Config
+	// timeouts of the RTSP client
+	timeouts rtspTimeouts
+	// udpReadBuffer is the size of the kernel receive buffers of UDP sockets, 0 keeps the gortsplib default
+	udpReadBuffer int
+	// binding binds the connections of the RTSP client to a local interface or address, nil means any
+	binding *localBinding
+	// keepalive is how the RTSP session is kept alive while streaming
+	keepalive keepalivePolicy
+	// httpTunnel tunnels the connection through HTTP, with the TCP transport
+	httpTunnel bool
+	// hardwareDecode is the hardware decoding backend for H264 & H265, empty means software decoding
+	hardwareDecode string
+	// decodeThrottle limits how many decoded H264 & H265 frames are converted per second, following max_decode_fps
+	decodeThrottle decodeThrottle
+	// decodeShare schedules decoding with the other cameras of the module, following decode_workers & decode_priority
+	decodeShare *decodeShare
+	// suppressCorrupted stops decoding H264 & H265 access units after packet loss until the next keyframe
+	suppressCorrupted bool
+	// lazyDecode buffers H264 & H265 access units instead of decoding them while no images are requested
+	lazyDecode atomic.Bool
+	// decodeFrames is false if frames are never decoded, for cameras only used with rtp_passthrough, relay_address or recording
+	decodeFrames atomic.Bool
+	// lastImageRequest is the unix nano time an image was last read
+	lastImageRequest atomic.Int64
+	// drainedGOP is closed once the GOP buffered while decoding was idle has been decoded, it is nil until
+	// a GOP was drained
+	drainedGOP atomic.Pointer[chan struct{}]
+	// idleTimeout, if set, is how long after the stream was last consumed it's paused
+	idleTimeout time.Duration
+	// lastConsumed is the unix nano time the stream was last consumed by an image request, a new subscriber or
+	// the resume command
+	lastConsumed atomic.Int64
+	// pauseRequested is set by the pause command until the stream is consumed or resumed
+	pauseRequested atomic.Bool
+	// paused is whether the session of the client is paused, it is only set by the reconnect worker
+	paused atomic.Bool
+
+	// maxFrameAge, if not zero, is the age after which frames are no longer served
+	maxFrameAge atomic.Int64
+	// recordingConf, if set, enables recording H264 & H265 streams with the recorder sink
+	recordingConf atomic.Pointer[RecordingConfig]
+	// onvif, if set, is the ONVIF device service of the camera, used to control its imaging settings
+	onvif atomic.Pointer[ONVIFConfig]
+
+	// relay, if set, republishes relayMedias, the medias of the current connection, on a local RTSP server
+	relay       *relayServer
+	relayMedias []*description.Media
+
+	// rtcpStats are the reception statistics of the tracks of the current connection
+	rtcpStatsMu sync.Mutex
+	rtcpStats   []*trackStats
+
+	// snapshots, if set, serves images while the stream is down
+	snapshots atomic.Pointer[snapshotSource]
+
+	// streamInfo & transportInUse describe the current connection for the get-stream-info command
+	streamInfo     atomic.Pointer[streamInfo]
+	transportInUse atomic.Pointer[gortsplib.Transport]
+
+	metrics streamMetrics
+	// metricsAddress is the metrics_address the metrics are served on, if any
+	metricsAddress string
+	// decodeErrors aggregates decode errors so that they're logged as a summary every minute
+	decodeErrors decodeErrorLog
+	// profile times the stages of the frame pipeline while profiling is started by the start-profiling command
+	profile stageProfiler
+	// health is whether the camera is streaming, which image & properties requests report when it isn't
+	health streamHealth
+	// sei holds the latest SEI messages of H264 & H265 streams
+	sei seiStore
+	// h264GOP holds the current GOP of H264 streams, which is served to clients which request MimeTypeH264
+	h264GOP atomic.Pointer[h264GOP]
+	// metadataTrack enables receiving the stream's ONVIF metadata or KLV track, whose latest data metadata holds
+	metadataTrack bool
+	metadata      metadataStore
+
+	// depth makes H264 & H265 frames decode to depth maps, it can not be changed by Reconfigure
+	depth bool
+	// gray makes H264 & H265 frames decode to grayscale images, from their luma
+	gray bool
+	// decodeScale downscales decoded H264 & H265 frames
+	decodeScale decodeScale
+	// deinterlace blends the fields of interlaced H264 & H265 frames
+	deinterlace bool
+	// transcode, if set, re-encodes H265 tracks to H264 for the rtp_passthrough subscribers
+	transcode atomic.Pointer[TranscodeConfig]
+	// orientation flips & rotates decoded H264 & H265 frames
+	orientation orientation
+	// crop is the region of decoded H264 & H265 frames which is stored, empty stores whole frames
+	crop image.Rectangle
+	// cropWarnedSize is the last frame size, packed as width<<32 | height, warned about not fitting crop
+	cropWarnedSize atomic.Int64
+
+	// audio enables receiving the stream's audio track, which is served by the rtsp-audio model
+	audio        bool
+	audioDecoder *aacDecoder
+	// audioMu guards audioProps & audioSubs
+	audioMu    sync.Mutex
+	audioProps *prop.Audio
+	audioSubs  map[chan wave.Audio]struct{}
+
+	// cancelFunc stops the reconnect worker
 	cancelFunc context.CancelFunc
+	// closeCtx is canceled by Close, so that in flight image requests & passthrough callbacks don't block closing
+	closeCtx    context.Context
+	closeCancel context.CancelFunc
+	// reconnectRequests wakes the reconnect worker up to reconnect immediately
+	reconnectRequests chan struct{}
+	// pauseRequests wakes the reconnect worker up to pause or resume the stream
+	pauseRequests chan struct{}
 
 	activeBackgroundWorkers sync.WaitGroup
 
-	latestFrame atomic.Pointer[image.Image]
+	// frames holds the latest frame, & the frames before it with frame_history
+	frames   frameHistory
+	frameSeq atomic.Uint64
+	// frameRate measures the frame rate of the video track of the current connection
+	frameRate frameRateMeter
+	// onFrame, if set, is called with every decoded frame
+	onFrame func(*frame)
 
 	logger logging.Logger
 
-	rtpPassthrough              bool
-	currentCodec                atomic.Int64
-	rtpPassthroughCtx           context.Context
-	rtpPassthroughCancelCauseFn context.CancelCauseFunc
+	rtpPassthrough atomic.Bool
+	currentCodec   atomic.Int64
+	// passthrough is canceled once rtp_passthrough is disabled at runtime, until a reconnecting Reconfigure
+	passthrough atomic.Pointer[passthroughState]
+	// bframesDisableRequested is set once the camera was asked through ONVIF to encode without B-frames
+	bframesDisableRequested atomic.Bool
 
 	subsMu       sync.RWMutex
 	bufAndCBByID map[rtppassthrough.SubscriptionID]bufAndCB
+	// passthroughGOP holds the passthrough units since the most recent IDR, which has the SPS & PPS prepended.
+	// The keyframe, or the whole GOP if replayGOP is set, is replayed to new subscribers so that their video
+	// starts without waiting for the next IDR
+	passthroughGOP passthroughGOP
+	replayGOP      atomic.Bool
+	// passthroughQueue configures the queues of new subscribers
+	passthroughQueue atomic.Pointer[PassthroughQueueConfig]
 }
 
-// Close closes the camera. It always returns nil, but because of Close() interface, it needs to return an error.
-func (rc *rtspCamera) Close(_ context.Context) error {
+// Close closes the camera.
+func (rc *rtspCamera) Close(ctx context.Context) error {
+	unregisterCamera(rc)
+	rc.closeCancel()
+	rc.stopMetricsServer()
 	rc.cancelFunc()
 	rc.unsubscribeAll()
 	rc.activeBackgroundWorkers.Wait()
 	rc.closeConnection()
-	return nil
+	rc.decodeShare.close()
+	rc.stopRelay()
+	return rc.VideoSource.Close(ctx)
 }
 
-// clientReconnectBackgroundWorker checks every 5 sec to see if the client is connected to the server, and reconnects if not.
-func (rc *rtspCamera) clientReconnectBackgroundWorker(codecInfo videoCodec) {
+// clientReconnectBackgroundWorker checks every reconnect_interval to see if the client is connected to the server,
+// and reconnects if not, backing off exponentially while reconnects fail. It stops retrying once reconnects
+// have failed for give_up_after, but always reconnects immediately when a reconnect is requested with requestReconnect.
+// It also pauses & resumes the stream, every reconnect_interval & when requested with requestPauseUpdate.
+func (rc *rtspCamera) clientReconnectBackgroundWorker(cancelCtx context.Context, codecInfo videoCodec) {
 	rc.activeBackgroundWorkers.Add(1)
+	policy := rc.reconnectPolicy
 	utils.ManagedGo(func() {
-		for utils.SelectContextOrWait(rc.cancelCtx, 5*time.Second) {
+		var failures int
+		var failingSince time.Time
+		var gaveUp bool
+		for {
 			badState := false
+			// after giving up the worker only wakes up for requested reconnects, as a nil channel never receives
+			var next <-chan time.Time
+			if !gaveUp {
+				next = time.After(policy.delay(failures))
+			}
+			select {
+			case <-cancelCtx.Done():
+				return
+			case <-rc.reconnectRequests:
+				rc.logger.Infof("reconnect requested for url: %s", rc.redactedURL())
+				badState = true
+			case <-rc.pauseRequests:
+				err := rc.updatePause()
+				if err == nil {
+					continue
+				}
+				rc.logger.Warnf("%s, trying to reconnect to %s", err, rc.redactedURL())
+				badState = true
+			case <-next:
+				if err := rc.updatePause(); err != nil {
+					rc.logger.Warnf("%s, trying to reconnect to %s", err, rc.redactedURL())
+					badState = true
+				} else if reason, ok := rc.streamSwitch(); ok && !rc.paused.Load() {
+					rc.logger.Info(reason)
+					badState = true
+				}
+			}
 
 			// use an OPTIONS request to see if the server is still responding to requests
-			if rc.client == nil {
-				badState = true
-			} else {
-				res, err := rc.client.Options(rc.u)
-				// Nick S:
-				// This error happens all the time on hardware we need to support & does not affect
-				// the performance of camera streaming. As a result, we ignore this error specifically
-				var errClientInvalidState liberrors.ErrClientInvalidState
-				if err != nil && !errors.As(err, &errClientInvalidState) {
-					rc.logger.Warnf("The rtsp client encountered an error, trying to reconnect to %s, err: %s", rc.u, err)
+			if !badState {
+				if rc.client == nil {
 					badState = true
-				} else if res != nil && res.StatusCode != base.StatusOK {
-					rc.logger.Warnf("The rtsp server responded with non-OK status url: %s, status_code: %d", rc.u, res.StatusCode)
+				} else if u, err := rc.requestURL(cancelCtx); err != nil {
+					rc.logger.Warnf("unable to get rtsp auth token, trying to reconnect to %s, err: %s", rc.redactedURL(), err)
 					badState = true
+				} else {
+					res, err := rc.client.Options(u)
+					// Nick S:
+					// This error happens all the time on hardware we need to support & does not affect
+					// the performance of camera streaming. As a result, we ignore this error specifically
+					var errClientInvalidState liberrors.ErrClientInvalidState
+					if err != nil && !errors.As(err, &errClientInvalidState) {
+						rc.logger.Warnf("The rtsp client encountered an error, trying to reconnect to %s, err: %s", rc.redactedURL(), err)
+						badState = true
+					} else if res != nil && res.StatusCode != base.StatusOK {
+						rc.logger.Warnf("The rtsp server responded with non-OK status url: %s, status_code: %d", rc.redactedURL(), res.StatusCode)
+						badState = true
+					}
 				}
 			}
 
 			if badState {
 				if err := rc.reconnectClient(codecInfo); err != nil {
 					rc.logger.Warnf("cannot reconnect to rtsp server err: %s", err.Error())
+					rc.metrics.reconnectFailures.Add(1)
+					rc.failedReconnects++
+					if rc.failedReconnects%failoverAfterFailedReconnects == 0 && rc.rediscoverONVIF() {
+						// the camera of the pinned onvif config moved, its new address is tried right away
+						rc.failedReconnects = 0
+						rc.requestReconnect()
+					} else if rc.failedReconnects >= failoverAfterFailedReconnects && rc.failoverAddresses() > 1 {
+						rc.failover()
+						rc.logger.Warnf("failed to reconnect %d times in a row, failing over to %s", rc.failedReconnects, rc.redactedURL())
+						rc.failedReconnects = 0
+					}
+					failures++
+					if failingSince.IsZero() {
+						failingSince = time.Now()
+					}
+					if !gaveUp && policy.giveUpAfter > 0 && time.Since(failingSince) >= policy.giveUpAfter {
+						gaveUp = true
+						rc.health.set(StreamGaveUp, err, rc.logger)
+						rc.logger.Errorf("giving up reconnecting to %s after failing for %s, reconfigure the camera "+
+							"or use the reconnect command to try again", rc.redactedURL(), policy.giveUpAfter)
+					}
 				} else {
-					rc.logger.Infof("reconnected to rtsp server url: %s", rc.u)
+					rc.metrics.reconnects.Add(1)
+					rc.failedReconnects = 0
+					failures, failingSince, gaveUp = 0, time.Time{}, false
+					rc.logger.Infof("reconnected to rtsp server url: %s", rc.redactedURL())
 				}
 			}
 		}
 	}, rc.activeBackgroundWorkers.Done)
 }
 
+// requestReconnect asks the reconnect worker to reconnect as soon as possible.
+// It does not block; if a reconnect is already pending this is a no-op.
+func (rc *rtspCamera) requestReconnect() {
+	select {
+	case rc.reconnectRequests <- struct{}{}:
+	default:
+	}
+}
+
+// getURL returns the current stream URL.
+func (rc *rtspCamera) getURL() *base.URL {
+	rc.uMu.RLock()
+	defer rc.uMu.RUnlock()
+	if rc.streamingSubstream {
+		return rc.substream
+	}
+	return rc.u
+}
+
+// redactedURL returns the stream URL without credentials, for logging.
+func (rc *rtspCamera) redactedURL() *base.URL {
+	return rc.getURL().CloneWithoutCredentials()
+}
+
+// setCredentials replaces the credentials of every address, which are used by subsequent reconnects.
+func (rc *rtspCamera) setCredentials(user *url.Userinfo) {
+	rc.uMu.Lock()
+	defer rc.uMu.Unlock()
+	rc.rotatedUser = user
+	rc.setUserLocked(user)
+}
+
+// setUserLocked replaces the credentials of every address. uMu must be locked.
+func (rc *rtspCamera) setUserLocked(user *url.Userinfo) {
+	for i, address := range rc.addresses {
+		u := address.Clone()
+		u.User = user
+		if address == rc.u {
+			rc.u = u
+		}
+		rc.addresses[i] = u
+	}
+	if rc.substream != nil {
+		rc.substream = rc.substream.Clone()
+		rc.substream.User = user
+	}
+}
+
+// failover makes the next address the one streamed from by subsequent reconnects, or the main stream if the
+// substream is streamed.
+func (rc *rtspCamera) failover() {
+	rc.uMu.Lock()
+	defer rc.uMu.Unlock()
+	if rc.streamingSubstream {
+		rc.streamingSubstream = false
+		rc.substreamFailed = true
+		return
+	}
+	next := (slices.Index(rc.addresses, rc.u) + 1) % len(rc.addresses)
+	rc.u = rc.addresses[next]
+}
+
 func (rc *rtspCamera) closeConnection() {
 	if rc.client != nil {
 		rc.client.Close()
 		rc.client = nil
 	}
 	rc.currentCodec.Store(0)
-	if rc.rawDecoder != nil {
-		rc.rawDecoder.close()
-		rc.rawDecoder = nil
+	rc.paused.Store(false)
+	rc.h264GOP.Store(nil)
+	rc.logRTCPStats()
+	rc.closePipeline()
+	if rc.audioDecoder != nil {
+		rc.audioDecoder.close()
+		rc.audioDecoder = nil
 	}
+	if rc.relay != nil {
+		rc.relay.publish(nil)
+	}
+	rc.relayMedias = nil
 }
 
 // reconnectClient reconnects the RTSP client to the streaming server by closing the old one and starting a new one.
-func (rc *rtspCamera) reconnectClient(codecInfo videoCodec) error {
+func (rc *rtspCamera) reconnectClient(codecInfo videoCodec) (err error) {
 	rc.logger.Warnf("reconnectClient called with codec: %s", codecInfo)
+	rc.health.connecting(rc.logger)
+	defer func() { rc.health.connected(err, rc.logger) }()
 
 	rc.closeConnection()
 
+	rc.selectStream()
+	baseURL := rc.getURL()
+	u, err := rc.requestURL(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "when fetching rtsp auth token")
+	}
+
+	// resolved before the client is created, as clients which weren't started can't be closed
+	var dial dialFunc
+	var listenPacket listenPacketFunc
+	if rc.binding != nil {
+		if dial, listenPacket, err = rc.binding.resolve(); err != nil {
+			return err
+		}
+	}
+
 	// replace the client with a new one, but close it if setup is not successful
-	rc.client = &gortsplib.Client{}
+	rc.client = &gortsplib.Client{Transport: rc.transport.Load(), TLSConfig: rc.tlsConfig, DialContext: dial, ListenPacket: listenPacket}
+	rc.timeouts.apply(rc.client)
+	var udpBuffers *udpReadBuffers
+	if rc.udpReadBuffer > 0 {
+		udpBuffers = &udpReadBuffers{size: rc.udpReadBuffer, listen: listenPacket}
+		rc.client.ListenPacket = udpBuffers.listenPacket
+	}
+	if rc.httpTunnel {
+		dial := rc.client.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		rc.client.DialContext = httpTunnelDialer(dial, (*url.URL)(u).RequestURI())
+	}
+	transport := initialTransport(rc.transport.Load(), baseURL.Scheme)
+	rc.transportInUse.Store(&transport)
+	auth := &authTracker{}
+	keepalive := newKeepaliveTracker(rc.keepalive)
+	tokens := rc.tokens.Load()
+	addTokenHeader := tokens != nil && tokens.conf.QueryParam == ""
+	rc.client.OnRequest = func(req *base.Request) {
+		if addTokenHeader {
+			tokens.addHeader(req)
+		}
+		auth.onRequest(req)
+		keepalive.onRequest(req)
+	}
+	rc.client.OnResponse = func(res *base.Response) {
+		auth.onResponse(res)
+		keepalive.onResponse(res)
+	}
 	rc.client.OnPacketLost = func(err error) {
+		rc.metrics.packetsLost(err)
 		rc.logger.Debugf("OnPacketLost: err: %s", err)
 	}
 	rc.client.OnTransportSwitch = func(err error) {
+		tcp := gortsplib.TransportTCP
+		rc.transportInUse.Store(&tcp)
 		rc.logger.Debugf("OnTransportSwitch: err: %s", err)
 	}
 	rc.client.OnDecodeError = func(err error) {
-		rc.logger.Debugf("OnDecodeError: err: %s", err)
+		rc.metrics.decodeErrors.Add(1)
+		rc.decodeErrors.record(decodeErrorRTP, err, time.Now())
 	}
 
-	if err := rc.client.Start(rc.u.Scheme, rc.u.Host); err != nil {
-		return errors.Wrapf(err, "when calling RTSP START on Scheme: %s, Host: %s", rc.u.Scheme, rc.u.Host)
+	if err := rc.client.Start(baseURL.Scheme, baseURL.Host); err != nil {
+		return errors.Wrapf(err, "when calling RTSP START on Scheme: %s, Host: %s", baseURL.Scheme, baseURL.Host)
 	}
 
 	var clientSuccessful bool
@@ -211,44 +876,65 @@ func (rc *rtspCamera) reconnectClient(codecInfo videoCodec) error {
 		}
 	}()
 
-	session, _, err := rc.client.Describe(rc.u)
+	// DESCRIBE is preceded by OPTIONS, either of which may require authentication
+	session, _, err := rc.client.Describe(u)
+	if err != nil {
+		return errors.Wrapf(auth.classify(err, u), "when calling RTSP DESCRIBE on %s", baseURL.CloneWithoutCredentials())
+	}
+	session, err = selectVideoTrack(session, rc.videoTrack)
 	if err != nil {
-		return errors.Wrapf(err, "when calling RTSP DESCRIBE on %s", rc.u)
+		return withCause(ErrNoVideoTrack, err)
 	}
 
+	candidates := []videoCodec{codecInfo}
 	if codecInfo == Agnostic {
-		codecInfo = getAvailableCodec(session)
+		candidates = orderCodecs(getAvailableCodecs(session), rc.codecPreference)
+		if n := countVideoMedias(session); n > 1 {
+			rc.logger.Infof("stream has %d video tracks, selecting the first supported codec out of %v, set video_track to select another",
+				n, candidates)
+		}
 	}
 
-	switch codecInfo {
-	case H264:
-		rc.logger.Info("setting up H264 decoder")
-		if err := rc.initH264(session); err != nil {
-			return err
+	initErr := errors.New("no supported video track found")
+	for _, candidate := range candidates {
+		if initErr = rc.initCodec(candidate, session); initErr == nil {
+			codecInfo = candidate
+			break
 		}
-	case H265:
-		rc.logger.Info("setting up H265 decoder")
-		if err := rc.initH265(session); err != nil {
-			return err
+		rc.logger.Warnf("unable to set up %s decoder: %s", candidate, initErr)
+		rc.closePipeline()
+	}
+	if initErr != nil {
+		rc.logger.Warn("tracks available")
+		for _, x := range session.Medias {
+			rc.logger.Warnf("\t %v", x)
 		}
-	case MJPEG:
-		rc.logger.Info("setting up MJPEG decoder")
-		if err := rc.initMJPEG(session); err != nil {
-			return err
+		if countVideoMedias(session) == 0 {
+			return withCause(ErrNoVideoTrack, initErr)
+		}
+		return withCause(ErrCodecUnsupported, initErr)
+	}
+
+	if rc.audio {
+		if err := rc.initAudio(session); err != nil {
+			rc.logger.Warnf("unable to set up audio, continuing without it: %s", err)
+		}
+	}
+	if rc.metadataTrack {
+		if err := rc.initMetadata(session); err != nil {
+			rc.logger.Warnf("unable to set up metadata, continuing without it: %s", err)
 		}
-	case Unknown:
-		return errors.New("codecInfo should not be Unknown after getting stream info")
-	case Agnostic:
-		return errors.New("codecInfo should not be Agnostic after getting stream info")
-	default:
-		return errors.Errorf("codec not supported %v", codecInfo)
 	}
 
+	rc.applyUDPReadBuffer(udpBuffers)
 	if _, err := rc.client.Play(nil); err != nil {
-		return err
+		return auth.classify(err, u)
 	}
 	clientSuccessful = true
 	rc.currentCodec.Store(int64(codecInfo))
+	if rc.relay != nil {
+		rc.relay.publish(rc.relayMedias)
+	}
 	// if after reconnecting we no longer support rtp_passthrough
 	// terminate all subscription
 	// otherwise, let any remaining subscriptions continue
@@ -259,13 +945,344 @@ func (rc *rtspCamera) reconnectClient(codecInfo videoCodec) error {
 	if err := rc.validateSupportsPassthrough(); err != nil {
 		rc.unsubscribeAll()
 	}
-	return nil
+	return nil
+}
+
+// initCodec sets up the decoder pipeline for codecInfo.
+func (rc *rtspCamera) initCodec(codecInfo videoCodec, session *description.Session) error {
+	switch codecInfo {
+	case H264:
+		rc.logger.Info("setting up H264 decoder")
+		return rc.initH264(session)
+	case H265:
+		rc.logger.Info("setting up H265 decoder")
+		return rc.initH265(session)
+	case MJPEG:
+		rc.logger.Info("setting up MJPEG decoder")
+		return rc.initMJPEG(session)
+	case AV1:
+		rc.logger.Info("setting up AV1 decoder")
+		return rc.initAV1(session)
+	case VP8:
+		rc.logger.Info("setting up VP8 decoder")
+		return rc.initVP8(session)
+	case VP9:
+		rc.logger.Info("setting up VP9 decoder")
+		return rc.initVP9(session)
+	case MPEG4:
+		rc.logger.Info("setting up MPEG4 decoder")
+		return rc.initMPEG4(session)
+	case Unknown:
+		return errors.New("codecInfo should not be Unknown after getting stream info")
+	case Agnostic:
+		return errors.New("codecInfo should not be Agnostic after getting stream info")
+	default:
+		return errors.Errorf("codec not supported %v", codecInfo)
+	}
+}
+
+// onVideoPacketRTP registers cb to receive the packets of the video track media, measuring its frame rate.
+func (rc *rtspCamera) onVideoPacketRTP(media *description.Media, f format.Format, cb gortsplib.OnPacketRTPFunc) {
+	rc.frameRate.reset(f.ClockRate())
+	rc.onPacketRTP(media, f, func(pkt *rtp.Packet) {
+		rc.frameRate.packet(pkt)
+		cb(pkt)
+	})
+}
+
+// initH264 sets up the sinks of the H264 track and the client to receive H264 packets.
+func (rc *rtspCamera) initH264(session *description.Session) (err error) {
+	// setup RTP/H264 -> H264 decoder
+	var f *format.H264
+
+	media := session.FindFormat(&f)
+	if media == nil {
+		rc.logger.Warn("tracks available")
+		for _, x := range session.Medias {
+			rc.logger.Warnf("\t %v", x)
+		}
+		return errors.New("h264 track not found")
+	}
+	rc.streamInfo.Store(h264StreamInfo(f.SPS))
+
+	// setup RTP/H264 -> H264 decoder
+	rtpDec, err := f.CreateDecoder()
+	if err != nil {
+		return errors.Wrap(err, "creating H264 RTP decoder")
+	}
+	if f.SPS == nil {
+		rc.logger.Warn("no initial SPS found in H264 format")
+	}
+	if f.PPS == nil {
+		rc.logger.Warn("no initial PPS found in H264 format")
+	}
+
+	pipeline := rc.startPipeline()
+	if rc.rtpPassthrough.Load() {
+		if err := rc.registerSink(pipeline, passthroughSink, func() (*videoSink, error) {
+			return rc.newH264PassthroughSink(f, media)
+		}); err != nil {
+			return err
+		}
+	}
+	if rc.recordingConf.Load() != nil {
+		if err := rc.registerSink(pipeline, recorderSink, func() (*videoSink, error) {
+			return rc.newRecorderSink(H264, [][]byte{f.SPS, f.PPS}, media)
+		}); err != nil {
+			rc.logger.Warnf("unable to start recording, continuing without it: %s", err)
+		}
+	}
+	if rc.decodeFrames.Load() {
+		if err := rc.registerSink(pipeline, decoderSink, func() (*videoSink, error) {
+			return rc.newH264DecoderSink(f, media)
+		}); err != nil {
+			return err
+		}
+	}
+
+	encoded := newH264GOP(f.SPS, f.PPS)
+	rc.h264GOP.Store(encoded)
+
+	_, err = rc.client.Setup(session.BaseURL, media, 0, 0)
+	if err != nil {
+		return errors.Wrapf(err, "when calling RTSP Setup on %s for H264", session.BaseURL.CloneWithoutCredentials())
+	}
+
+	rc.onVideoPacketRTP(media, f, func(pkt *rtp.Packet) {
+		pipeline.packet(pkt)
+		start := rc.profile.start()
+		au, err := rtpDec.Decode(pkt)
+		rc.profile.record(profileDepacketize, start)
+		if err != nil {
+			if !errors.Is(err, rtph264.ErrNonStartingPacketAndNoPrevious) && !errors.Is(err, rtph264.ErrMorePacketsNeeded) {
+				rc.decodeErrors.record(decodeErrorH264RTP, err, time.Now())
+			}
+			return
+		}
+		if msgs := parseSEI(H264, au); len(msgs) > 0 {
+			rc.sei.store(msgs, rc.packetTime(media, pkt))
+		}
+		keyframe := h264.IDRPresent(au)
+		encoded.add(au, rc.packetTime(media, pkt), keyframe)
+		pipeline.accessUnit(au, pkt, keyframe)
+	})
+
+	return nil
+}
+
+// newH264DecoderSink decodes the access units of the H264 track f & stores the frames.
+func (rc *rtspCamera) newH264DecoderSink(f *format.H264, media *description.Media) (*videoSink, error) {
+	d, err := newH264Decoder(rc.hardwareDecode, rc.logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating H264 raw decoder")
+	}
+	rc.configureDecoder(d)
+
+	// if SPS and PPS are present into the SDP, send them to the decoder
+	initialSPSAndPPS := [][]byte{}
+	if f.SPS != nil {
+		initialSPSAndPPS = append(initialSPSAndPPS, f.SPS)
+	}
+	if f.PPS != nil {
+		initialSPSAndPPS = append(initialSPSAndPPS, f.PPS)
+	}
+
+	var receivedFirstIDR bool
+	resolution := newResolutionWatcher(H264, f.SPS)
+	worker := rc.startDecodeWorker(d, func(d *decoder, au [][]byte, capturedAt time.Time) {
+		if info, changed := resolution.update(au); changed {
+			rc.onResolutionChange(d, info)
+		}
+		if !receivedFirstIDR && h264.IDRPresent(au) {
+			rc.logger.Debug("adding initial SPS & PPS")
+			receivedFirstIDR = true
+			au = append(initialSPSAndPPS, au...)
+		}
+
+		rc.storeH264Frame(d, au, capturedAt)
+	})
+	return rc.newDecoderSink(media, worker), nil
+}
+
+// newH264PassthroughSink publishes the packets of the H264 track f to the rtp_passthrough subscribers.
+func (rc *rtspCamera) newH264PassthroughSink(f *format.H264, media *description.Media) (*videoSink, error) {
+	// a keyframe of the previous connection may use different parameters
+	rc.passthroughGOP.reset()
+	fp, err := formatprocessor.New(1472, f, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create new h264 rtp formatprocessor")
+	}
+
+	var bframes bframeDetector
+	bframesDetected := false
+	publishToWebRTC := func(pkt *rtp.Packet) {
+		if rc.passthroughCtx().Err() != nil || bframesDetected {
+			return
+		}
+		pts, ok := rc.client.PacketPTS(media, pkt)
+		if !ok {
+			return
+		}
+		// units carry the wall clock capture time mapped from RTCP sender reports, so that consumers can
+		// synchronize cameras
+		u, err := fp.ProcessRTPPacket(pkt, rc.packetTime(media, pkt), pts, true)
+		if err != nil {
+			rc.logger.Debug(err.Error())
+			return
+		}
+		if tunit, ok := u.(*formatprocessor.H264); ok && tunit.AU != nil {
+			// units are received in decoding order, so a unit presented before the previous one is a B-frame
+			if bframes.detect(tunit.PTS) {
+				bframesDetected = true
+				rc.handleBFrames()
+				return
+			}
+			rc.passthroughGOP.add(tunit)
+		}
+		tunit, ok := u.(*formatprocessor.H264)
+		rc.publishPassthrough(u, ok && h264.IDRPresent(tunit.AU))
+	}
+	return &videoSink{packet: publishToWebRTC, close: rc.passthroughGOP.reset}, nil
+}
+
+// publishPassthrough publishes u, the newly received unit, to all rtp_passthrough subscribers. Subscribers
+// whose queue overflowed resume from a keyframe.
+func (rc *rtspCamera) publishPassthrough(u formatprocessor.Unit, keyframe bool) {
+	rc.subsMu.RLock()
+	defer rc.subsMu.RUnlock()
+	for _, bufAndCB := range rc.bufAndCBByID {
+		if dropped := bufAndCB.queue.publish(func() { bufAndCB.cb(u) }, keyframe); dropped > 0 {
+			bufAndCB.stats.dropped(dropped)
+			rc.metrics.subscriberDrops.Add(uint64(dropped))
+			rc.logger.Debugf("%d RTP passthrough units dropped as the subscriber's queue is full", dropped)
+		}
+	}
+}
+
+// initH265 sets up the sinks of the H265 track and the client to receive H265 packets.
+func (rc *rtspCamera) initH265(session *description.Session) (err error) {
+	transcode := rc.rtpPassthrough.Load() && rc.transcode.Load() != nil
+	if transcode {
+		rc.logger.Warn("transcoding the H265 RTSP track to H264 for rtp_passthrough, which decodes & re-encodes every frame " +
+			"& uses a lot of CPU")
+	} else if rc.rtpPassthrough.Load() {
+		rc.logger.Warn("rtp_passthrough is only supported for H264 codec, unless rtp_passthrough_transcode is set. " +
+			"rtp_passthrough features disabled due to H265 RTSP track")
+	}
+	var f *format.H265
+
+	media := session.FindFormat(&f)
+	if media == nil {
+		rc.logger.Warn("tracks available")
+		for _, x := range session.Medias {
+			rc.logger.Warnf("\t %v", x)
+		}
+		return errors.New("h265 track not found")
+	}
+	rc.streamInfo.Store(h265StreamInfo(f.SPS))
+
+	rtpDec, err := f.CreateDecoder()
+	if err != nil {
+		return errors.Wrap(err, "creating H265 RTP decoder")
+	}
+	if f.VPS == nil {
+		rc.logger.Warn("no VPS found in H265 format")
+	}
+	if f.SPS == nil {
+		rc.logger.Warn("no SPS found in H265 format")
+	}
+	if f.PPS == nil {
+		rc.logger.Warn("no PPS found in H265 format")
+	}
+
+	pipeline := rc.startPipeline()
+	if transcode {
+		if err := rc.registerSink(pipeline, passthroughSink, func() (*videoSink, error) {
+			return rc.newH265TranscodeSink(f, media)
+		}); err != nil {
+			// e.g. as FFmpeg was built without libx264, images are still decoded
+			rc.disablePassthrough(errors.Wrap(err, "unable to start transcoding"))
+		}
+	}
+	if rc.decodeFrames.Load() {
+		if err := rc.registerSink(pipeline, decoderSink, func() (*videoSink, error) {
+			return rc.newH265DecoderSink(f, media)
+		}); err != nil {
+			return err
+		}
+	}
+
+	_, err = rc.client.Setup(session.BaseURL, media, 0, 0)
+	if err != nil {
+		return errors.Wrapf(err, "when calling RTSP Setup on %s for H265", session.BaseURL.CloneWithoutCredentials())
+	}
+
+	if rc.recordingConf.Load() != nil {
+		if err := rc.registerSink(pipeline, recorderSink, func() (*videoSink, error) {
+			return rc.newRecorderSink(H265, [][]byte{f.VPS, f.SPS, f.PPS}, media)
+		}); err != nil {
+			rc.logger.Warnf("unable to start recording, continuing without it: %s", err)
+		}
+	}
+
+	rc.onVideoPacketRTP(media, f, func(pkt *rtp.Packet) {
+		pipeline.packet(pkt)
+		// Extract access units from RTP packets
+		start := rc.profile.start()
+		au, err := rtpDec.Decode(pkt)
+		rc.profile.record(profileDepacketize, start)
+		if err != nil {
+			if !errors.Is(err, rtph265.ErrNonStartingPacketAndNoPrevious) && !errors.Is(err, rtph265.ErrMorePacketsNeeded) {
+				rc.decodeErrors.record(decodeErrorH265RTP, err, time.Now())
+			}
+			return
+		}
+		if msgs := parseSEI(H265, au); len(msgs) > 0 {
+			rc.sei.store(msgs, rc.packetTime(media, pkt))
+		}
+		pipeline.accessUnit(au, pkt, h265.IsRandomAccess(au))
+	})
+
+	return nil
+}
+
+// newH265DecoderSink decodes the access units of the H265 track f & stores the frames.
+func (rc *rtspCamera) newH265DecoderSink(f *format.H265, media *description.Media) (*videoSink, error) {
+	d, err := newH265Decoder(rc.hardwareDecode, rc.logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating H265 raw decoder")
+	}
+	rc.configureDecoder(d)
+
+	// For H.265, handle VPS, SPS, and PPS
+	for _, params := range [][]byte{f.VPS, f.SPS, f.PPS} {
+		if params != nil {
+			//nolint:gosec
+			d.decode(params, 0)
+		}
+	}
+
+	resolution := newResolutionWatcher(H265, f.SPS)
+	worker := rc.startDecodeWorker(d, func(d *decoder, au [][]byte, capturedAt time.Time) {
+		if info, changed := resolution.update(au); changed {
+			rc.onResolutionChange(d, info)
+		}
+		for _, nalu := range au {
+			if err := rc.decodeAndStore(d, nalu, capturedAt); err != nil {
+				rc.decodeErrors.record(decodeErrorH265, err, time.Now())
+				return
+			}
+		}
+	})
+	return rc.newDecoderSink(media, worker), nil
 }
 
-// initH264 initializes the H264 decoder and sets up the client to receive H264 packets.
-func (rc *rtspCamera) initH264(session *description.Session) (err error) {
-	// setup RTP/H264 -> H264 decoder
-	var f *format.H264
+// initAV1 sets up the sinks of the AV1 track and the client to receive AV1 packets.
+func (rc *rtspCamera) initAV1(session *description.Session) (err error) {
+	if rc.rtpPassthrough.Load() {
+		rc.logger.Warn("rtp_passthrough is only supported for H264 codec. rtp_passthrough features disabled due to AV1 RTSP track")
+	}
+	var f *format.AV1
 
 	media := session.FindFormat(&f)
 	if media == nil {
@@ -273,187 +1290,360 @@ func (rc *rtspCamera) initH264(session *description.Session) (err error) {
 		for _, x := range session.Medias {
 			rc.logger.Warnf("\t %v", x)
 		}
-		return errors.New("h264 track not found")
+		return errors.New("av1 track not found")
 	}
 
-	// setup RTP/H264 -> H264 decoder
 	rtpDec, err := f.CreateDecoder()
 	if err != nil {
-		return errors.Wrap(err, "creating H264 RTP decoder")
+		return errors.Wrap(err, "creating AV1 RTP decoder")
 	}
-
-	// setup H264 -> raw frames decoder
-	rc.rawDecoder, err = newH264Decoder(rc.logger)
-	if err != nil {
-		return errors.Wrap(err, "creating H264 raw decoder")
+	if rc.recordingConf.Load() != nil {
+		rc.logger.Warn("recording is only supported for H264 & H265 streams, the AV1 stream is not recorded")
 	}
 
-	// if SPS and PPS are present into the SDP, send them to the decoder
-	initialSPSAndPPS := [][]byte{}
-	if f.SPS != nil {
-		initialSPSAndPPS = append(initialSPSAndPPS, f.SPS)
-	} else {
-		rc.logger.Warn("no initial SPS found in H264 format")
+	pipeline := rc.startPipeline()
+	if rc.decodeFrames.Load() {
+		if err := rc.registerSink(pipeline, decoderSink, func() (*videoSink, error) {
+			return rc.newAV1DecoderSink(media)
+		}); err != nil {
+			return err
+		}
 	}
-	if f.PPS != nil {
-		initialSPSAndPPS = append(initialSPSAndPPS, f.PPS)
-	} else {
-		rc.logger.Warn("no initial PPS found in H264 format")
+
+	_, err = rc.client.Setup(session.BaseURL, media, 0, 0)
+	if err != nil {
+		return errors.Wrapf(err, "when calling RTSP Setup on %s for AV1", session.BaseURL.CloneWithoutCredentials())
 	}
 
-	var receivedFirstIDR bool
-	storeImage := func(pkt *rtp.Packet) {
-		au, err := rtpDec.Decode(pkt)
+	rc.onVideoPacketRTP(media, f, func(pkt *rtp.Packet) {
+		pipeline.packet(pkt)
+		start := rc.profile.start()
+		tu, err := rtpDec.Decode(pkt)
+		rc.profile.record(profileDepacketize, start)
 		if err != nil {
-			if !errors.Is(err, rtph264.ErrNonStartingPacketAndNoPrevious) && !errors.Is(err, rtph264.ErrMorePacketsNeeded) {
-				rc.logger.Debugf("error decoding(1) h264 rstp stream %w", err)
+			if !errors.Is(err, rtpav1.ErrNonStartingPacketAndNoPrevious) && !errors.Is(err, rtpav1.ErrMorePacketsNeeded) {
+				rc.decodeErrors.record(decodeErrorAV1RTP, err, time.Now())
 			}
 			return
 		}
-
-		if !receivedFirstIDR && h264.IDRPresent(au) {
-			rc.logger.Debug("adding initial SPS & PPS")
-			receivedFirstIDR = true
-			au = append(initialSPSAndPPS, au...)
-		}
-
-		rc.storeH264Frame(au)
-	}
-
-	onPacketRTP := func(pkt *rtp.Packet) {
-		storeImage(pkt)
-	}
-
-	if rc.rtpPassthrough {
-		fp, err := formatprocessor.New(1472, f, true)
+		// keyframes carry the sequence header, the only source of the stream info as the SDP has none
+		keyframe, err := av1.ContainsKeyFrame(tu)
 		if err != nil {
-			return errors.Wrap(err, "unable to create new h264 rtp formatprocessor")
+			rc.decodeErrors.record(decodeErrorAV1RTP, err, time.Now())
+			return
 		}
-
-		publishToWebRTC := func(pkt *rtp.Packet) {
-			pts, ok := rc.client.PacketPTS(media, pkt)
-			if !ok {
-				return
-			}
-			ntp := time.Now()
-			u, err := fp.ProcessRTPPacket(pkt, ntp, pts, true)
-			if err != nil {
-				rc.logger.Debug(err.Error())
-				return
-			}
-			rc.subsMu.RLock()
-			defer rc.subsMu.RUnlock()
-			if len(rc.bufAndCBByID) == 0 {
-				return
-			}
-
-			// Publish the newly received packet Unit to all subscribers
-			for _, bufAndCB := range rc.bufAndCBByID {
-				if err := bufAndCB.buf.Publish(func() { bufAndCB.cb(u) }); err != nil {
-					rc.logger.Debug("RTP packet dropped due to %s", err.Error())
+		if keyframe && rc.streamInfo.Load() == nil {
+			for _, obu := range tu {
+				if info := av1StreamInfo(obu); info != nil {
+					rc.streamInfo.Store(info)
+					break
 				}
 			}
 		}
+		pipeline.accessUnit(tu, pkt, keyframe)
+	})
 
-		onPacketRTP = func(pkt *rtp.Packet) {
-			publishToWebRTC(pkt)
-			storeImage(pkt)
-		}
-	}
+	return nil
+}
 
-	_, err = rc.client.Setup(session.BaseURL, media, 0, 0)
+// newAV1DecoderSink decodes the temporal units of the AV1 track media & stores the frames.
+func (rc *rtspCamera) newAV1DecoderSink(media *description.Media) (*videoSink, error) {
+	d, err := newAV1Decoder(rc.hardwareDecode, rc.logger)
 	if err != nil {
-		return errors.Wrapf(err, "when calling RTSP Setup on %s for H264", session.BaseURL)
+		return nil, errors.Wrap(err, "creating AV1 raw decoder")
 	}
+	rc.configureDecoder(d)
 
-	rc.client.OnPacketRTP(media, f, onPacketRTP)
+	resolution := newResolutionWatcher(AV1, nil)
+	worker := rc.startDecodeWorker(d, func(d *decoder, tu [][]byte, capturedAt time.Time) {
+		if info, changed := resolution.update(tu); changed {
+			rc.onResolutionChange(d, info)
+		}
+		// libav decodes temporal units in the low overhead bitstream format, whose OBUs have size fields
+		bitstream, err := av1.BitstreamMarshal(tu)
+		if err != nil {
+			rc.decodeErrors.record(decodeErrorAV1, err, time.Now())
+			return
+		}
+		if err := rc.storeDecoded(d.decodePacket(bitstream, capturedAt.UnixNano())); err != nil {
+			rc.decodeErrors.record(decodeErrorAV1, err, time.Now())
+		}
+	})
+	return rc.newDecoderSink(media, worker), nil
+}
 
-	return nil
+// frameTrack describes a VP8, VP9 or MPEG-4 track, whose frames are depacketized & decoded whole.
+type frameTrack struct {
+	codec  videoCodec
+	media  *description.Media
+	format format.Format
+	// config, if set, is decoded before the first frame, e.g. the headers of MPEG-4 streams from the SDP
+	config []byte
+	// depacketize returns the frame completed by pkt, or nil if more packets are needed
+	depacketize func(pkt *rtp.Packet) ([]byte, error)
+	keyframe    func(frame []byte) bool
+	// streamInfo parses the stream info of config or keyframes, returning nil if they don't carry it
+	streamInfo            func(frame []byte) *streamInfo
+	newDecoder            func(hardwareDecode string, logger logging.Logger) (*decoder, error)
+	rtpError, decodeError string
 }
 
-// initH265 initializes the H265 decoder and sets up the client to receive H265 packets.
-func (rc *rtspCamera) initH265(session *description.Session) (err error) {
-	if rc.rtpPassthrough {
-		rc.logger.Warn("rtp_passthrough is only supported for H264 codec. rtp_passthrough features disabled due to H265 RTSP track")
+// initVP8 sets up the sinks of the VP8 track and the client to receive VP8 packets.
+func (rc *rtspCamera) initVP8(session *description.Session) error {
+	var f *format.VP8
+	media := session.FindFormat(&f)
+	if media == nil {
+		rc.logger.Warn("tracks available")
+		for _, x := range session.Medias {
+			rc.logger.Warnf("\t %v", x)
+		}
+		return errors.New("vp8 track not found")
 	}
-	var f *format.H265
+	rtpDec, err := f.CreateDecoder()
+	if err != nil {
+		return errors.Wrap(err, "creating VP8 RTP decoder")
+	}
+	return rc.initFrameTrack(session, frameTrack{
+		codec:  VP8,
+		media:  media,
+		format: f,
+		depacketize: func(pkt *rtp.Packet) ([]byte, error) {
+			frame, err := rtpDec.Decode(pkt)
+			if errors.Is(err, rtpvp8.ErrNonStartingPacketAndNoPrevious) || errors.Is(err, rtpvp8.ErrMorePacketsNeeded) {
+				return nil, nil
+			}
+			return frame, err
+		},
+		keyframe: func(frame []byte) bool {
+			// the lowest bit of the frame tag is 0 for keyframes
+			return len(frame) > 0 && frame[0]&0x01 == 0
+		},
+		streamInfo:  vp8StreamInfo,
+		newDecoder:  newVP8Decoder,
+		rtpError:    decodeErrorVP8RTP,
+		decodeError: decodeErrorVP8,
+	})
+}
 
+// initVP9 sets up the sinks of the VP9 track and the client to receive VP9 packets.
+func (rc *rtspCamera) initVP9(session *description.Session) error {
+	var f *format.VP9
 	media := session.FindFormat(&f)
 	if media == nil {
 		rc.logger.Warn("tracks available")
 		for _, x := range session.Medias {
 			rc.logger.Warnf("\t %v", x)
 		}
-		return errors.New("h265 track not found")
+		return errors.New("vp9 track not found")
 	}
-
 	rtpDec, err := f.CreateDecoder()
 	if err != nil {
-		return errors.Wrap(err, "creating H265 RTP decoder")
+		return errors.Wrap(err, "creating VP9 RTP decoder")
 	}
+	return rc.initFrameTrack(session, frameTrack{
+		codec:  VP9,
+		media:  media,
+		format: f,
+		depacketize: func(pkt *rtp.Packet) ([]byte, error) {
+			frame, err := rtpDec.Decode(pkt)
+			if errors.Is(err, rtpvp9.ErrNonStartingPacketAndNoPrevious) || errors.Is(err, rtpvp9.ErrMorePacketsNeeded) {
+				return nil, nil
+			}
+			return frame, err
+		},
+		keyframe: func(frame []byte) bool {
+			return vp9StreamInfo(frame) != nil
+		},
+		streamInfo:  vp9StreamInfo,
+		newDecoder:  newVP9Decoder,
+		rtpError:    decodeErrorVP9RTP,
+		decodeError: decodeErrorVP9,
+	})
+}
 
-	rc.rawDecoder, err = newH265Decoder(rc.logger)
+// initMPEG4 sets up the sinks of the MPEG-4 Part 2 track and the client to receive MP4V-ES packets.
+func (rc *rtspCamera) initMPEG4(session *description.Session) error {
+	var f *format.MPEG4Video
+	media := session.FindFormat(&f)
+	if media == nil {
+		rc.logger.Warn("tracks available")
+		for _, x := range session.Medias {
+			rc.logger.Warnf("\t %v", x)
+		}
+		return errors.New("mpeg4 track not found")
+	}
+	rtpDec, err := f.CreateDecoder()
 	if err != nil {
-		return errors.Wrap(err, "creating H265 raw decoder")
+		return errors.Wrap(err, "creating MPEG4 RTP decoder")
 	}
-
-	// For H.265, handle VPS, SPS, and PPS
-	if f.VPS != nil {
-		//nolint:gosec
-		rc.rawDecoder.decode(f.VPS)
-	} else {
-		rc.logger.Warn("no VPS found in H265 format")
+	if len(f.Config) == 0 {
+		rc.logger.Warn("no config found in MPEG4 format, frames can't be decoded until the stream repeats its headers")
 	}
+	return rc.initFrameTrack(session, frameTrack{
+		codec:  MPEG4,
+		media:  media,
+		format: f,
+		config: f.Config,
+		depacketize: func(pkt *rtp.Packet) ([]byte, error) {
+			frame, err := rtpDec.Decode(pkt)
+			if errors.Is(err, rtpmpeg4video.ErrMorePacketsNeeded) {
+				return nil, nil
+			}
+			return frame, err
+		},
+		keyframe:    mpeg4IsKeyframe,
+		streamInfo:  mpeg4StreamInfo,
+		newDecoder:  newMPEG4Decoder,
+		rtpError:    decodeErrorMPEG4RTP,
+		decodeError: decodeErrorMPEG4,
+	})
+}
 
-	if f.SPS != nil {
-		//nolint:gosec
-		rc.rawDecoder.decode(f.SPS)
-	} else {
-		rc.logger.Warn("no SPS found in H265 format")
+// initFrameTrack sets up the decoder sink of track and the client to receive its packets. The stream info is
+// updated from each keyframe, as VP8, VP9 & MPEG-4 streams may change their resolution on any keyframe,
+// which FFmpeg decodes without being reinitialized.
+func (rc *rtspCamera) initFrameTrack(session *description.Session, track frameTrack) error {
+	if rc.rtpPassthrough.Load() {
+		rc.logger.Warnf("rtp_passthrough is only supported for H264 codec. rtp_passthrough features disabled due to %s RTSP track",
+			track.codec)
 	}
+	if rc.recordingConf.Load() != nil {
+		rc.logger.Warnf("recording is only supported for H264 & H265 streams, the %s stream is not recorded", track.codec)
+	}
+	var info *streamInfo
+	if track.config != nil {
+		info = track.streamInfo(track.config)
+	}
+	rc.streamInfo.Store(info)
 
-	if f.PPS != nil {
-		//nolint:gosec
-		rc.rawDecoder.decode(f.PPS)
-	} else {
-		rc.logger.Warn("no PPS found in H265 format")
+	pipeline := rc.startPipeline()
+	if rc.decodeFrames.Load() {
+		if err := rc.registerSink(pipeline, decoderSink, func() (*videoSink, error) {
+			return rc.newFrameDecoderSink(track)
+		}); err != nil {
+			return err
+		}
 	}
 
-	_, err = rc.client.Setup(session.BaseURL, media, 0, 0)
-	if err != nil {
-		return errors.Wrapf(err, "when calling RTSP Setup on %s for H265", session.BaseURL)
+	if _, err := rc.client.Setup(session.BaseURL, track.media, 0, 0); err != nil {
+		return errors.Wrapf(err, "when calling RTSP Setup on %s for %s", session.BaseURL.CloneWithoutCredentials(), track.codec)
 	}
 
-	// On packet retreival, turn it into an image, and store it in shared memory
-	rc.client.OnPacketRTP(media, f, func(pkt *rtp.Packet) {
-		// Extract access units from RTP packets
-		au, err := rtpDec.Decode(pkt)
+	rc.onVideoPacketRTP(track.media, track.format, func(pkt *rtp.Packet) {
+		pipeline.packet(pkt)
+		start := rc.profile.start()
+		frame, err := track.depacketize(pkt)
+		rc.profile.record(profileDepacketize, start)
 		if err != nil {
-			if !errors.Is(err, rtph265.ErrNonStartingPacketAndNoPrevious) && !errors.Is(err, rtph265.ErrMorePacketsNeeded) {
-				rc.logger.Debugf("error decoding(1) h265 rstp stream %w", err)
-			}
+			rc.decodeErrors.record(track.rtpError, err, time.Now())
 			return
 		}
+		if frame == nil {
+			return
+		}
+		keyframe := track.keyframe(frame)
+		if keyframe {
+			info := track.streamInfo(frame)
+			if current := rc.streamInfo.Load(); info != nil && (current == nil || current.width != info.width || current.height != info.height) {
+				rc.streamInfo.Store(info)
+			}
+		}
+		pipeline.accessUnit([][]byte{frame}, pkt, keyframe)
+	})
 
-		for _, nalu := range au {
-			lastImage, err := rc.rawDecoder.decode(nalu)
-			if err != nil {
-				rc.logger.Debugf("error decoding(2) h265 rtsp stream err: %s", err.Error())
+	return nil
+}
+
+// newFrameDecoderSink decodes the frames of track & stores them.
+func (rc *rtspCamera) newFrameDecoderSink(track frameTrack) (*videoSink, error) {
+	d, err := track.newDecoder(rc.hardwareDecode, rc.logger)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating %s raw decoder", track.codec)
+	}
+	rc.configureDecoder(d)
+	if track.config != nil {
+		//nolint:errcheck
+		d.decodePacket(track.config, 0)
+	}
+
+	worker := rc.startDecodeWorker(d, func(d *decoder, au [][]byte, capturedAt time.Time) {
+		if err := rc.storeDecoded(d.decodePacket(au[0], capturedAt.UnixNano())); err != nil {
+			rc.decodeErrors.record(track.decodeError, err, time.Now())
+		}
+	})
+	return rc.newDecoderSink(track.media, worker), nil
+}
+
+// configureDecoder sets the output of d from the config.
+func (rc *rtspCamera) configureDecoder(d *decoder) {
+	d.depth = rc.depth
+	d.gray = rc.gray
+	d.scale = rc.decodeScale
+	d.orientation = rc.orientation
+	d.profile = &rc.profile
+	d.deinterlace = rc.deinterlace
+	d.throttle = &rc.decodeThrottle
+}
+
+// newDecoderSink passes the access units of the video track media on to worker. While lazy_decode is idle
+// access units are buffered instead, and frames which are corrupted by packet loss are skipped if
+// suppress_corrupted_frames is set.
+func (rc *rtspCamera) newDecoderSink(media *description.Media, worker *decodeWorker) *videoSink {
+	decodeAU := func(au [][]byte, capturedAt time.Time, keyframe bool) {
+		if !worker.submit(au, capturedAt, keyframe) {
+			rc.metrics.decodeQueueDrops.Add(1)
+		}
+	}
+	gop := newGOPBuffer()
+	guard := rc.newLossGuard()
+	return &videoSink{
+		packet: func(pkt *rtp.Packet) {
+			if guard != nil {
+				guard.packet(pkt)
+			}
+		},
+		accessUnit: func(au [][]byte, pkt *rtp.Packet, keyframe bool) {
+			if guard != nil && guard.suppress(keyframe) {
+				rc.metrics.framesSuppressed.Add(1)
 				return
 			}
 
-			if lastImage != nil {
-				rc.latestFrame.Store(&lastImage)
+			if rc.decodeIdle() {
+				gop.add(au, rc.packetTime(media, pkt), keyframe)
+				return
+			}
+			// buffered GOPs start with a keyframe & are decoded in one job, as they're longer than the queue
+			if buffered := gop.drain(); len(buffered) > 0 {
+				decoded := make(chan struct{})
+				rc.drainedGOP.Store(&decoded)
+				if !worker.submitGOP(buffered, func() { close(decoded) }) {
+					rc.metrics.decodeQueueDrops.Add(uint64(len(buffered)))
+					close(decoded)
+				}
 			}
-		}
-	})
 
-	return nil
+			decodeAU(au, rc.packetTime(media, pkt), keyframe)
+		},
+		close: worker.stop,
+	}
 }
 
 // initMJPEG initializes the MJPEG decoder and sets up the client to receive JPEG frames.
 func (rc *rtspCamera) initMJPEG(session *description.Session) error {
-	if rc.rtpPassthrough {
+	if rc.depth {
+		return errors.New("depth streams must use the H264 or H265 codec")
+	}
+	if rc.recordingConf.Load() != nil {
+		rc.logger.Warn("recording is only supported for H264 & H265 streams, the MJPEG stream is not recorded")
+	}
+	if rc.gray {
+		rc.logger.Warnf("output_format %s is only supported for H264 & H265 streams, MJPEG frames are served in color", gray8OutputFormat)
+	}
+	if rc.decodeScale != (decodeScale{}) || !rc.orientation.identity() || !rc.crop.Empty() || rc.deinterlace {
+		rc.logger.Warn("decode_scale, rotate_degrees, flip, crop & deinterlace are only supported for H264 & H265 streams, " +
+			"MJPEG frames are served as they are")
+	}
+	if rc.rtpPassthrough.Load() {
 		rc.logger.Warn("rtp_passthrough is only supported for H264 codec. rtp_passthrough features disabled due to MJPEG RTSP track")
 	}
 	var f *format.MJPEG
@@ -465,6 +1655,7 @@ func (rc *rtspCamera) initMJPEG(session *description.Session) error {
 		}
 		return errors.New("MJPEG track not found")
 	}
+	rc.streamInfo.Store(nil)
 
 	mjpegDecoder, err := f.CreateDecoder()
 	if err != nil {
@@ -473,11 +1664,17 @@ func (rc *rtspCamera) initMJPEG(session *description.Session) error {
 
 	_, err = rc.client.Setup(session.BaseURL, media, 0, 0)
 	if err != nil {
-		return errors.Wrapf(err, "when calling RTSP Setup on %s for MJPEG", session.BaseURL)
+		return errors.Wrapf(err, "when calling RTSP Setup on %s for MJPEG", session.BaseURL.CloneWithoutCredentials())
 	}
 
-	rc.client.OnPacketRTP(media, f, func(pkt *rtp.Packet) {
+	decodeFrames := rc.decodeFrames.Load()
+	rc.onVideoPacketRTP(media, f, func(pkt *rtp.Packet) {
+		if !decodeFrames || rc.sinkDetached(decoderSink) {
+			return
+		}
+		start := rc.profile.start()
 		frame, err := mjpegDecoder.Decode(pkt)
+		rc.profile.record(profileDepacketize, start)
 		if err != nil {
 			return
 		}
@@ -485,13 +1682,17 @@ func (rc *rtspCamera) initMJPEG(session *description.Session) error {
 			return
 		}
 
-		img, err := jpeg.Decode(bytes.NewReader(frame))
-		if err != nil {
-			rc.logger.Debugf("error converting MJPEG frame to image err: %s", err.Error())
+		// only the header is parsed here, the frame is decoded lazily so that requests for
+		// JPEG images are served the original bytes without being decoded & re-encoded
+		if _, err := jpeg.DecodeConfig(bytes.NewReader(frame)); err != nil {
+			rc.metrics.decodeErrors.Add(1)
+			rc.decodeErrors.record(decodeErrorMJPEG, err, time.Now())
 			return
 		}
 
-		rc.latestFrame.Store(&img)
+		start = rc.profile.start()
+		rc.storeFrame(rimage.NewLazyEncodedImage(frame, rutils.MimeTypeJPEG), rc.packetTime(media, pkt))
+		rc.profile.record(profileStore, start)
 	})
 
 	return nil
@@ -514,6 +1715,7 @@ func (rc *rtspCamera) SubscribeRTP(
 	if err != nil {
 		return rtppassthrough.NilSubscription, err
 	}
+	rc.resumeStream()
 	g := rutils.NewGuard(func() {
 		buf.Close()
 	})
@@ -529,8 +1731,7 @@ func (rc *rtspCamera) SubscribeRTP(
 		return rtppassthrough.NilSubscription, err
 	}
 
-	var firstReceived bool
-	var lastPTS time.Duration
+	stats := newSubscriberStats()
 	// OnPacketRTP will call this unitSubscriberFunc for all subscribers.
 	// unitSubscriberFunc will then convert the Unit into a slice of
 	// WebRTC compliant RTP packets & call packetsCB, which will
@@ -540,44 +1741,23 @@ func (rc *rtspCamera) SubscribeRTP(
 	// WebRTC compliant RTP packets.
 	// Inspired by https://github.com/bluenviron/mediamtx/blob/main/internal/servers/webrtc/session.go#L185
 	unitSubscriberFunc := func(u formatprocessor.Unit) {
-		if err := rc.rtpPassthroughCtx.Err(); err != nil {
-			return
-		}
-
-		tunit, ok := u.(*formatprocessor.H264)
-		if !ok {
-			err := errors.New("(*unit.H264) type conversion error")
-			rc.logger.Error(err.Error())
-			rc.rtpPassthroughCancelCauseFn(err)
-
-			// unsubscribeAll() needs to be run in another goroutine as it will call Close() on sub which
-			// will try to take a lock which has already been taken while unitSubscriberFunc is executing
-			rc.activeBackgroundWorkers.Add(1)
-			utils.ManagedGo(rc.unsubscribeAll, rc.activeBackgroundWorkers.Done)
-			return
-		}
-
-		// If we have no AUs we can't encode packets.
-		if tunit.AU == nil {
+		if err := rc.passthroughCtx().Err(); err != nil {
 			return
 		}
 
-		if !firstReceived {
-			firstReceived = true
-		} else if tunit.PTS < lastPTS {
-			err := errors.New("WebRTC doesn't support H264 streams with B-frames")
-			rc.logger.Error(err.Error())
-			rc.rtpPassthroughCancelCauseFn(err)
-
-			// unsubscribeAll() needs to be run in another goroutine as unsubscribeAll() will call Close() on sub which
-			// will try to take a lock which has already been taken while unitSubscriberFunc is executing
-			rc.activeBackgroundWorkers.Add(1)
-			utils.ManagedGo(rc.unsubscribeAll, rc.activeBackgroundWorkers.Done)
+		var pkts []*rtp.Packet
+		var err error
+		switch tunit := u.(type) {
+		case *formatprocessor.H264:
+			// If we have no AUs we can't encode packets.
+			if tunit.AU == nil {
+				return
+			}
+			pkts, err = encoder.Encode(tunit.AU)
+		default:
+			rc.disablePassthrough(errors.Errorf("%T type conversion error", u))
 			return
 		}
-		lastPTS = tunit.PTS
-
-		pkts, err := encoder.Encode(tunit.AU)
 		if err != nil {
 			// If there is an Encode error we just drop the packets.
 			return
@@ -589,33 +1769,127 @@ func (rc *rtspCamera) SubscribeRTP(
 		}
 
 		for _, pkt := range pkts {
-			pkt.Timestamp += tunit.RTPPackets[0].Timestamp
+			pkt.Timestamp += u.GetRTPPackets()[0].Timestamp
 		}
 
 		packetsCB(pkts)
+		stats.delivered(len(pkts))
 	}
 
+	// deferred before locking subsMu, so that it runs once the subscriber is added & subsMu is unlocked
+	defer rc.switchToMainStream()
 	rc.subsMu.Lock()
 	defer rc.subsMu.Unlock()
 
+	queue := newSubscriberQueue(rc.passthroughQueue.Load(), bufferSize)
 	rc.bufAndCBByID[sub.ID] = bufAndCB{
-		cb:  unitSubscriberFunc,
-		buf: buf,
+		cb:    unitSubscriberFunc,
+		buf:   buf,
+		queue: queue,
+		stats: stats,
 	}
 	buf.Start()
+	// replay the buffered units before any newer units are published, which requires subsMu
+	for _, u := range rc.passthroughGOP.replay(rc.replayGOP.Load()) {
+		u := u
+		if dropped := queue.publish(func() { unitSubscriberFunc(u) }, h264.IDRPresent(u.AU)); dropped > 0 {
+			stats.dropped(dropped)
+			rc.logger.Debug("unable to replay GOP to new subscriber as its queue is full")
+			break
+		}
+	}
 	g.Success()
 	return sub, nil
 }
 
+// passthroughState is the context of rtp_passthrough, which is canceled with the reason it was disabled.
+type passthroughState struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// passthroughCtx returns the context of rtp_passthrough, which is canceled once it's disabled.
+func (rc *rtspCamera) passthroughCtx() context.Context {
+	return rc.passthrough.Load().ctx
+}
+
+// resetPassthrough enables rtp_passthrough again after it was disabled at runtime, as the stream changed, & lets
+// the camera be asked to disable B-frames again.
+func (rc *rtspCamera) resetPassthrough() {
+	ctx, cancel := context.WithCancelCause(rc.closeCtx)
+	if old := rc.passthrough.Swap(&passthroughState{ctx: ctx, cancel: cancel}); old != nil {
+		old.cancel(nil)
+	}
+	rc.bframesDisableRequested.Store(false)
+}
+
+// disablePassthrough stops rtp_passthrough until a reconnecting Reconfigure because of err, e.g. as the stream
+// has B-frames, which WebRTC doesn't support. Images are still decoded.
+func (rc *rtspCamera) disablePassthrough(err error) {
+	state := rc.passthrough.Load()
+	if state.ctx.Err() != nil {
+		return
+	}
+	rc.logger.Errorf("disabling rtp_passthrough, images are still served: %s", err)
+	state.cancel(err)
+	rc.passthroughGOP.reset()
+
+	// unsubscribeAll() needs to be run in another goroutine as it waits for the subscribers' callbacks,
+	// which may be the caller
+	rc.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(rc.unsubscribeAll, rc.activeBackgroundWorkers.Done)
+}
+
+// handleBFrames disables rtp_passthrough as the stream has B-frames, unless the onvif disable_b_frames option is
+// set, in which case the camera is asked once to encode the profile with the Baseline H264 profile, which has no
+// B-frames, & the stream is reconnected. Passthrough is disabled if that fails, or if the new stream still has them.
+func (rc *rtspCamera) handleBFrames() {
+	bframesErr := errors.New("WebRTC doesn't support H264 streams with B-frames")
+	conf := rc.onvif.Load()
+	if conf == nil || !conf.DisableBFrames || !rc.bframesDisableRequested.CompareAndSwap(false, true) {
+		rc.disablePassthrough(bframesErr)
+		return
+	}
+	rc.passthroughGOP.reset()
+	rc.logger.Warn("the stream has B-frames, which WebRTC doesn't support, asking the camera for a Baseline H264 encode through ONVIF")
+	rc.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(func() {
+		ctx, cancel := context.WithTimeout(rc.closeCtx, onvifTimeout)
+		defer cancel()
+		cmd := map[string]interface{}{"profile": "Baseline"}
+		if _, err := rc.updateVideoEncoder(ctx, "disable_b_frames", cmd); err != nil {
+			rc.disablePassthrough(errors.Wrapf(bframesErr, "disabling B-frames through ONVIF failed: %s", err))
+			return
+		}
+		rc.requestReconnect()
+	}, rc.activeBackgroundWorkers.Done)
+}
+
+// bframeDetector detects B-frames from the presentation timestamps of units received in decoding order.
+type bframeDetector struct {
+	receivedFirst bool
+	lastPTS       time.Duration
+}
+
+// detect returns true if the unit presented at pts is presented before the previous one.
+func (d *bframeDetector) detect(pts time.Duration) bool {
+	if d.receivedFirst && pts < d.lastPTS {
+		return true
+	}
+	d.receivedFirst, d.lastPTS = true, pts
+	return false
+}
+
 // Unsubscribe deregisters the Subscription's callback.
 func (rc *rtspCamera) Unsubscribe(_ context.Context, id rtppassthrough.SubscriptionID) error {
 	rc.subsMu.Lock()
-	defer rc.subsMu.Unlock()
 	bufAndCB, ok := rc.bufAndCBByID[id]
+	delete(rc.bufAndCBByID, id)
+	rc.subsMu.Unlock()
 	if !ok {
 		return errors.New("id not found")
 	}
-	delete(rc.bufAndCBByID, id)
+	rc.closeQueue(bufAndCB.queue)
 	bufAndCB.buf.Close()
 	return nil
 }
@@ -626,81 +1900,163 @@ func newRTSPCamera(ctx context.Context, _ resource.Dependencies, conf resource.C
 		logger.Error(err.Error())
 		return nil, err
 	}
-	u, err := base.ParseURL(newConf.Address)
+	rc, err := newRTSPCameraFromConfig(ctx, conf.ResourceName(), conf.Model, newConf, nil, logger)
 	if err != nil {
-		logger.Error(err.Error())
 		return nil, err
 	}
-	rtpPassthroughCtx, rtpPassthroughCancelCauseFn := context.WithCancelCause(context.Background())
+	registerCamera(rc)
+	return rc, nil
+}
+
+// newRTSPCameraFromConfig creates an rtspCamera. If onFrame is not nil it is called with every decoded frame.
+func newRTSPCameraFromConfig(
+	ctx context.Context,
+	name resource.Name,
+	model resource.Model,
+	newConf *Config,
+	onFrame func(*frame),
+	logger logging.Logger,
+) (*rtspCamera, error) {
+	closeCtx, closeCancel := context.WithCancel(context.Background())
 	rc := &rtspCamera{
-		model:                       conf.Model,
-		u:                           u,
-		rtpPassthrough:              newConf.RTPPassthrough,
-		bufAndCBByID:                make(map[rtppassthrough.SubscriptionID]bufAndCB),
-		rtpPassthroughCtx:           rtpPassthroughCtx,
-		rtpPassthroughCancelCauseFn: rtpPassthroughCancelCauseFn,
-		logger:                      logger,
-	}
-	codecInfo, err := modelToCodec(conf.Model)
-	if err != nil {
+		Named:             name.AsNamed(),
+		model:             model,
+		reconnectRequests: make(chan struct{}, 1),
+		pauseRequests:     make(chan struct{}, 1),
+		bufAndCBByID:      make(map[rtppassthrough.SubscriptionID]bufAndCB),
+		closeCtx:          closeCtx,
+		closeCancel:       closeCancel,
+		onFrame:           onFrame,
+		decodeErrors:      decodeErrorLog{logger: logger},
+		decodeShare:       decodeSlots.newShare(),
+		logger:            logger,
+	}
+	rc.resetPassthrough()
+	var created bool
+	defer func() {
+		if !created {
+			rc.passthrough.Load().cancel(nil)
+			closeCancel()
+			rc.decodeShare.close()
+		}
+	}()
+	if err := rc.applyConfig(newConf); err != nil {
 		logger.Error(err.Error())
 		return nil, err
 	}
-
-	err = rc.reconnectClient(codecInfo)
+	codecInfo, err := modelToCodec(model)
 	if err != nil {
 		logger.Error(err.Error())
 		return nil, err
 	}
-	cancelCtx, cancel := context.WithCancel(context.Background())
-	reader := gostream.VideoReaderFunc(func(_ context.Context) (image.Image, func(), error) {
-		latest := rc.latestFrame.Load()
-		if latest == nil {
-			return nil, func() {}, errors.New("no frame yet")
+
+	if newConf.RelayAddress != "" {
+		if err := rc.startRelay(newConf); err != nil {
+			logger.Error(err.Error())
+			return nil, err
 		}
-		return *latest, func() {}, nil
-	})
-	rc.VideoReader = reader
-	rc.cancelCtx = cancelCtx
-	rc.cancelFunc = cancel
-	cameraModel := camera.NewPinholeModelWithBrownConradyDistortion(newConf.IntrinsicParams, newConf.DistortionParams)
-	rc.clientReconnectBackgroundWorker(codecInfo)
-	src, err := camera.NewVideoSourceFromReader(ctx, rc, &cameraModel, camera.ColorStream)
+	}
+	if err := rc.connect(codecInfo); err != nil {
+		if !newConf.LazyConnect {
+			rc.stopRelay()
+			logger.Error(err.Error())
+			return nil, err
+		}
+		logger.Warnf("unable to connect, retrying in the background since lazy_connect is enabled, err: %s", err)
+	}
+	reader := gostream.VideoReaderFunc(rc.readFrame)
+	streamType := camera.ColorStream
+	if rc.depth {
+		streamType = camera.DepthStream
+	}
+	src, err := camera.NewVideoSourceFromReader(ctx, reader, nil, streamType)
 	if err != nil {
+		rc.closeConnection()
+		rc.stopRelay()
 		logger.Error(err.Error())
 		return nil, err
 	}
+	if newConf.MetricsAddress != "" {
+		if err := rc.startMetricsServer(newConf.MetricsAddress); err != nil {
+			rc.closeConnection()
+			rc.stopRelay()
+			logger.Error(err.Error())
+			return nil, err
+		}
+	}
+	rc.VideoSource = src
+	rc.codecInfo = codecInfo
+	rc.startReconnectWorker()
+	utils.PanicCapturingGo(func() { rc.decodeErrors.run(closeCtx) })
+
+	created = true
+	return rc, nil
+}
 
-	return camera.FromVideoSource(conf.ResourceName(), src, logger), nil
+// requestURL returns the URL RTSP requests should be sent to, which includes
+// the auth token when token auth is configured to use a query parameter.
+func (rc *rtspCamera) requestURL(ctx context.Context) (*base.URL, error) {
+	u := rc.getURL()
+	tokens := rc.tokens.Load()
+	if tokens == nil {
+		return u, nil
+	}
+	token, err := tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.conf.QueryParam == "" {
+		return u, nil
+	}
+	return tokens.applyToURL(u, token), nil
 }
 
+// unsubscribeAll removes every passthrough subscriber. Their queues are closed once subsMu is unlocked, as closing
+// waits for stuck callbacks, which must not block publishing.
 func (rc *rtspCamera) unsubscribeAll() {
 	rc.subsMu.Lock()
-	defer rc.subsMu.Unlock()
-	for id, bufAndCB := range rc.bufAndCBByID {
-		delete(rc.bufAndCBByID, id)
+	subscribers := rc.bufAndCBByID
+	rc.bufAndCBByID = make(map[rtppassthrough.SubscriptionID]bufAndCB)
+	rc.subsMu.Unlock()
+	for _, bufAndCB := range subscribers {
+		rc.closeQueue(bufAndCB.queue)
 		bufAndCB.buf.Close()
 	}
 }
 
+// closeQueue closes the queue of a passthrough subscriber, abandoning it if its callback is stuck.
+func (rc *rtspCamera) closeQueue(q *subscriberQueue) {
+	if !q.close() {
+		rc.logger.Warnf("rtp_passthrough subscriber did not return within %s, abandoning it", subscriberCloseTimeout)
+	}
+}
+
 func (rc *rtspCamera) validateSupportsPassthrough() error {
-	if !rc.rtpPassthrough {
+	if !rc.rtpPassthrough.Load() {
 		return errors.New("rtp_passthrough not enabled in config")
 	}
-	modelSupportsPassthrough := rc.model == ModelAgnostic || rc.model == ModelH264
+	// H265 tracks are served to the subscribers transcoded to H264
+	transcode := rc.transcode.Load() != nil
+	modelSupportsPassthrough := rc.model == ModelAgnostic || rc.model == ModelH264 ||
+		(transcode && rc.model == ModelH265)
 	if !modelSupportsPassthrough {
 		return fmt.Errorf("model %s does not support rtp_passthrough", rc.model.Name)
 	}
 
 	currentCodec := videoCodec(rc.currentCodec.Load())
-	if currentCodec != H264 {
-		return fmt.Errorf("rtp_passthrough only supported for H264 codec, current codec is: %s", currentCodec)
+	if currentCodec != H264 && !(transcode && currentCodec == H265) {
+		return fmt.Errorf("rtp_passthrough only supported for H264 codec, or H265 with rtp_passthrough_transcode, "+
+			"current codec is: %s", currentCodec)
 	}
 
-	if err := context.Cause(rc.rtpPassthroughCtx); err != nil {
+	if err := context.Cause(rc.passthroughCtx()); err != nil {
 		return errors.Wrap(err, "rtp_passthrough was determined to not be supported at runtime due to")
 	}
 
+	if rc.sinkDetached(passthroughSink) {
+		return errors.New("the passthrough sink is detached")
+	}
+
 	return nil
 }
 
@@ -714,76 +2070,159 @@ func modelToCodec(model resource.Model) (videoCodec, error) {
 		return H265, nil
 	case ModelMJPEG:
 		return MJPEG, nil
+	case ModelAV1:
+		return AV1, nil
+	case ModelVP8:
+		return VP8, nil
+	case ModelVP9:
+		return VP9, nil
+	case ModelMPEG4:
+		return MPEG4, nil
 	default:
 		return Unknown, fmt.Errorf("model '%s' has unspecified codec handling", model.Name)
 	}
 }
 
-// getAvailableCodec determines the first supported codec from a session's SDP data
-// returning Unknown if none are found.
-func getAvailableCodec(session *description.Session) videoCodec {
+// parseCodecPreference parses the codec names of the codec_preference attribute.
+func parseCodecPreference(names []string) ([]videoCodec, error) {
+	var codecs []videoCodec
+	for _, name := range names {
+		var codec videoCodec
+		switch strings.ToLower(name) {
+		case "h264":
+			codec = H264
+		case "h265", "hevc":
+			codec = H265
+		case "mjpeg":
+			codec = MJPEG
+		case "av1":
+			codec = AV1
+		case "vp8":
+			codec = VP8
+		case "vp9":
+			codec = VP9
+		case "mpeg4":
+			codec = MPEG4
+		default:
+			return nil, fmt.Errorf("unsupported codec '%s', must be h264, h265, av1, vp8, vp9, mpeg4 or mjpeg", name)
+		}
+		if slices.Contains(codecs, codec) {
+			return nil, fmt.Errorf("codec '%s' is listed more than once", name)
+		}
+		codecs = append(codecs, codec)
+	}
+	return codecs, nil
+}
+
+// orderCodecs returns the available codecs in the order of preference, leaving out the codecs
+// which are not preferred. available is returned unchanged if preference is empty.
+func orderCodecs(available, preference []videoCodec) []videoCodec {
+	if len(preference) == 0 {
+		return available
+	}
+	var ordered []videoCodec
+	for _, codec := range preference {
+		if slices.Contains(available, codec) {
+			ordered = append(ordered, codec)
+		}
+	}
+	return ordered
+}
+
+// getAvailableCodecs returns the supported codecs found in a session's SDP data, in priority order.
+func getAvailableCodecs(session *description.Session) []videoCodec {
 	var h264 *format.H264
 	var h265 *format.H265
+	var av1 *format.AV1
+	var vp9 *format.VP9
+	var vp8 *format.VP8
+	var mpeg4 *format.MPEG4Video
 	var mjpeg *format.MJPEG
 
 	// List of formats/codecs in priority order
 	codecFormats := []codecFormat{
 		{&h264, H264},
 		{&h265, H265},
+		{&av1, AV1},
+		{&vp9, VP9},
+		{&vp8, VP8},
+		{&mpeg4, MPEG4},
 		{&mjpeg, MJPEG},
 	}
 
+	var codecs []videoCodec
 	for _, codecFormat := range codecFormats {
 		if session.FindFormat(codecFormat.formatPointer) != nil {
-			return codecFormat.codec
+			codecs = append(codecs, codecFormat.codec)
 		}
 	}
 
-	return Unknown
+	return codecs
 }
 
-func (rc *rtspCamera) storeH264Frame(au [][]byte) {
-	naluIndex := 0
-	for naluIndex < len(au) {
-		nalu := au[naluIndex]
-		if isCompactableH264(nalu) {
-			// if the NALU is a compactable type, compact it, feed it into the decoder & skip
-			// the NALUs that were compacted.
-			// We do this so that the libav functions the decoder uses under the hood don't log
-			// spam error messages (which happens when it is fed SPS or PPS without an IDR
-			nalu, nalusCompacted := rc.compactH264SPSAndPPSAndIDR(au[naluIndex:])
-			if err := rc.decodeAndStore(nalu); err != nil {
-				rc.logger.Debugf("error decoding(2) h264 rtsp stream  %s", err.Error())
-				return
-			}
-			naluIndex += nalusCompacted
-			continue
+// countVideoMedias returns the number of video tracks in a session's SDP data.
+func countVideoMedias(session *description.Session) int {
+	var n int
+	for _, media := range session.Medias {
+		if media.Type == description.MediaTypeVideo {
+			n++
 		}
+	}
+	return n
+}
 
-		// otherwise feed in each non compactable NALU into the decoder
-		if err := rc.decodeAndStore(nalu); err != nil {
-			rc.logger.Debugf("error decoding(2) h264 rtsp stream  %s", err.Error())
+func (rc *rtspCamera) storeH264Frame(d *decoder, au [][]byte, capturedAt time.Time) {
+	for _, nalu := range h264DecoderInputs(au) {
+		if err := rc.decodeAndStore(d, nalu, capturedAt); err != nil {
+			rc.decodeErrors.record(decodeErrorH264, err, time.Now())
 			return
 		}
-		naluIndex++
 	}
 }
 
-func (rc *rtspCamera) compactH264SPSAndPPSAndIDR(au [][]byte) ([]byte, int) {
+// h264DecoderInputs returns the NALUs of au to feed into the decoder one by one. Runs of SPS, PPS & IDR NALUs
+// are compacted into one input, so that the libav functions the decoder uses under the hood don't log spam
+// error messages, which happens when it is fed SPS or PPS without an IDR. Empty NALUs are skipped.
+func h264DecoderInputs(au [][]byte) [][]byte {
+	inputs := make([][]byte, 0, len(au))
+	for i := 0; i < len(au); {
+		nalu := au[i]
+		if len(nalu) == 0 {
+			i++
+			continue
+		}
+		if !isCompactableH264(nalu) {
+			inputs = append(inputs, nalu)
+			i++
+			continue
+		}
+		compacted, nalusCompacted := compactH264SPSAndPPSAndIDR(au[i:])
+		inputs = append(inputs, compacted)
+		i += nalusCompacted
+	}
+	return inputs
+}
+
+// compactH264SPSAndPPSAndIDR joins the SPS, PPS & IDR NALUs au starts with, returning the joined NALUs &
+// how many NALUs of au were joined.
+func compactH264SPSAndPPSAndIDR(au [][]byte) ([]byte, int) {
 	compactedNALU, numCompacted := []byte{}, 0
 	for _, nalu := range au {
-		if !isCompactableH264(nalu) {
+		if len(nalu) > 0 && !isCompactableH264(nalu) {
 			// return once we hit a non SPS, PPS or IDR message
 			return compactedNALU, numCompacted
 		}
-		// If this is the first iteration, don't add the start code
+		numCompacted++
+		if len(nalu) == 0 {
+			continue
+		}
+		// If this is the first NALU written, don't add the start code
 		// as the first nalu has not been written yet
 		if len(compactedNALU) > 0 {
 			startCode := H2645StartCode()
 			compactedNALU = append(compactedNALU, startCode...)
 		}
 		compactedNALU = append(compactedNALU, nalu...)
-		numCompacted++
 	}
 	return compactedNALU, numCompacted
 }
@@ -793,13 +2232,23 @@ func H2645StartCode() []byte {
 	return []uint8{0x00, 0x00, 0x00, 0x01}
 }
 
-func (rc *rtspCamera) decodeAndStore(nalu []byte) error {
-	image, err := rc.rawDecoder.decode(nalu)
+// decodeAndStore decodes nalu, which was captured at capturedAt, storing the frame the decoder outputs, if any.
+// The capture time is passed through the decoder as the timestamp, so that frames which were reordered,
+// e.g. in streams with B-frames, are stored with their own capture time.
+func (rc *rtspCamera) decodeAndStore(d *decoder, nalu []byte, capturedAt time.Time) error {
+	return rc.storeDecoded(d.decode(nalu, capturedAt.UnixNano()))
+}
+
+// storeDecoded stores img, the frame a decoder returned with its pts, if any.
+func (rc *rtspCamera) storeDecoded(img image.Image, pts int64, err error) error {
 	if err != nil {
+		rc.metrics.decodeErrors.Add(1)
 		return err
 	}
-	if image != nil {
-		rc.latestFrame.Store(&image)
+	if img != nil {
+		start := rc.profile.start()
+		rc.storeFrame(rc.cropFrame(img), time.Unix(0, pts))
+		rc.profile.record(profileStore, start)
 	}
 	return nil
 }
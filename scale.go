@@ -0,0 +1,60 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeScale downscales decoded H264 & H265 frames, either by a fraction or to a target size.
+// Its zero value keeps frames at their original size.
+type decodeScale struct {
+	// num & den scale both dimensions by num/den
+	num, den int
+	// width & height are the target size, 0 keeps the aspect ratio of the frame
+	width, height int
+}
+
+// parseDecodeScale parses the decode_scale config attribute, which is either a fraction
+// such as "1/2" or a target size such as "1920x1080", "1920x0" or "0x1080".
+func parseDecodeScale(s string) (decodeScale, error) {
+	if s == "" {
+		return decodeScale{}, nil
+	}
+	if num, den, ok := strings.Cut(s, "/"); ok {
+		n, errNum := strconv.Atoi(strings.TrimSpace(num))
+		d, errDen := strconv.Atoi(strings.TrimSpace(den))
+		if errNum != nil || errDen != nil || n <= 0 || d <= 0 || n > d {
+			return decodeScale{}, fmt.Errorf("fraction '%s' must be between 0 & 1, e.g. '1/2'", s)
+		}
+		return decodeScale{num: n, den: d}, nil
+	}
+	if width, height, ok := strings.Cut(strings.ToLower(s), "x"); ok {
+		w, errWidth := strconv.Atoi(strings.TrimSpace(width))
+		h, errHeight := strconv.Atoi(strings.TrimSpace(height))
+		if errWidth != nil || errHeight != nil || w < 0 || h < 0 || (w == 0 && h == 0) {
+			return decodeScale{}, fmt.Errorf("size '%s' must be WIDTHxHEIGHT, e.g. '1920x1080', where either may be 0 to keep the aspect ratio", s)
+		}
+		return decodeScale{width: w, height: h}, nil
+	}
+	return decodeScale{}, fmt.Errorf("'%s' must be a fraction such as '1/2' or a size such as '1920x1080'", s)
+}
+
+// size returns the size frames of width x height are scaled to. Frames are never upscaled.
+func (s decodeScale) size(width, height int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return width, height
+	}
+	w, h := width, height
+	switch {
+	case s.den > 0:
+		w, h = width*s.num/s.den, height*s.num/s.den
+	case s.width > 0 && s.height > 0:
+		w, h = s.width, s.height
+	case s.width > 0:
+		w, h = s.width, roundDiv(height*s.width, width)
+	case s.height > 0:
+		w, h = roundDiv(width*s.height, height), s.height
+	}
+	return min(max(w, 1), width), min(max(h, 1), height)
+}
@@ -0,0 +1,48 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestParseDecodeScale(t *testing.T) {
+	s, err := parseDecodeScale("")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, s, test.ShouldResemble, decodeScale{})
+	s, err = parseDecodeScale("1/2")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, s, test.ShouldResemble, decodeScale{num: 1, den: 2})
+	s, err = parseDecodeScale("1920X1080")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, s, test.ShouldResemble, decodeScale{width: 1920, height: 1080})
+	s, err = parseDecodeScale("0x720")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, s, test.ShouldResemble, decodeScale{height: 720})
+
+	for _, invalid := range []string{"2/1", "0/2", "1/0", "half", "0x0", "-1x720", "1920x"} {
+		_, err := parseDecodeScale(invalid)
+		test.That(t, err, test.ShouldNotBeNil)
+	}
+}
+
+func TestDecodeScaleSize(t *testing.T) {
+	for _, tc := range []struct {
+		scale         decodeScale
+		width, height int
+	}{
+		{decodeScale{}, 3840, 2160},
+		{decodeScale{num: 1, den: 2}, 1920, 1080},
+		{decodeScale{num: 1, den: 3}, 1280, 720},
+		{decodeScale{width: 1920, height: 1080}, 1920, 1080},
+		{decodeScale{width: 1280}, 1280, 720},
+		{decodeScale{height: 1080}, 1920, 1080},
+		// frames are never upscaled
+		{decodeScale{width: 7680, height: 4320}, 3840, 2160},
+		{decodeScale{width: 1920, height: 4320}, 1920, 2160},
+	} {
+		w, h := tc.scale.size(3840, 2160)
+		test.That(t, w, test.ShouldEqual, tc.width)
+		test.That(t, h, test.ShouldEqual, tc.height)
+	}
+}
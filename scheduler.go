@@ -0,0 +1,178 @@
+package viamrtsp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// decodeScheduler limits how many access units the cameras of the module decode at once to the largest
+// decode_workers of the cameras, so that many cameras sharing the process don't contend for the CPU. Free workers
+// go to the waiting camera which decoded the least, relative to its decode_priority, so that cameras with heavy
+// streams can't starve the others. It doesn't limit decoding if no camera sets decode_workers.
+type decodeScheduler struct {
+	mu     sync.Mutex
+	shares map[*decodeShare]struct{}
+	// busy is the number of access units being decoded
+	busy    int
+	waiting []*decodeTicket
+	// pass is the pass of the share which was last given a worker, which shares are moved up to when they're
+	// given a worker, so that cameras which were idle don't get the workers to themselves until they catch up
+	pass float64
+}
+
+// decodeSlots schedules the decoding of every camera of the module.
+var decodeSlots = newDecodeScheduler()
+
+func newDecodeScheduler() *decodeScheduler {
+	return &decodeScheduler{shares: map[*decodeShare]struct{}{}}
+}
+
+// decodeShare is the share of a camera of the workers of a decodeScheduler. A nil share decodes without
+// being scheduled.
+type decodeShare struct {
+	s *decodeScheduler
+	// workers is the decode_workers of the camera, 0 if unset
+	workers int
+	// priority is the decode_priority of the camera
+	priority float64
+	// pass is the time the camera spent decoding divided by its priority
+	pass float64
+}
+
+// decodeTicket is a share waiting for a worker, ready is closed once it's given one.
+type decodeTicket struct {
+	share *decodeShare
+	ready chan struct{}
+}
+
+// newShare adds a camera to the scheduler.
+func (s *decodeScheduler) newShare() *decodeShare {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share := &decodeShare{s: s, priority: 1, pass: s.pass}
+	s.shares[share] = struct{}{}
+	return share
+}
+
+// limit returns the number of workers, 0 if decoding isn't limited.
+func (s *decodeScheduler) limit() int {
+	var limit int
+	for share := range s.shares {
+		limit = max(limit, share.workers)
+	}
+	return limit
+}
+
+// grant gives the free workers to the waiting shares with the lowest pass, in the order they waited if equal.
+func (s *decodeScheduler) grant() {
+	limit := s.limit()
+	for len(s.waiting) > 0 && (limit == 0 || s.busy < limit) {
+		next := 0
+		for i, t := range s.waiting {
+			if t.share.pass < s.waiting[next].share.pass {
+				next = i
+			}
+		}
+		t := s.waiting[next]
+		s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+		s.start(t.share)
+		close(t.ready)
+	}
+}
+
+// start gives a worker to share.
+func (s *decodeScheduler) start(share *decodeShare) {
+	s.busy++
+	share.pass = max(share.pass, s.pass)
+	s.pass = share.pass
+}
+
+// update sets the decode_workers & decode_priority of the camera of the share.
+func (share *decodeShare) update(workers int, priority float64) {
+	if share == nil {
+		return
+	}
+	share.s.mu.Lock()
+	defer share.s.mu.Unlock()
+	share.workers = workers
+	share.priority = priority
+	if share.priority <= 0 {
+		share.priority = 1
+	}
+	share.s.grant()
+}
+
+// close removes the camera of the share from the scheduler, which no longer counts its decode_workers.
+func (share *decodeShare) close() {
+	if share == nil {
+		return
+	}
+	share.s.mu.Lock()
+	defer share.s.mu.Unlock()
+	delete(share.s.shares, share)
+	share.s.grant()
+}
+
+// acquire waits for a worker to decode an access unit, returning false if stop is closed first. Every successful
+// acquire must be followed by a release.
+func (share *decodeShare) acquire(stop <-chan struct{}) bool {
+	if share == nil {
+		return true
+	}
+	s := share.s
+	s.mu.Lock()
+	if limit := s.limit(); len(s.waiting) == 0 && (limit == 0 || s.busy < limit) {
+		s.start(share)
+		s.mu.Unlock()
+		return true
+	}
+	t := &decodeTicket{share: share, ready: make(chan struct{})}
+	s.waiting = append(s.waiting, t)
+	s.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		return true
+	case <-stop:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, waiting := range s.waiting {
+			if waiting == t {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				return false
+			}
+		}
+		// the worker was given to the share while stopping
+		s.busy--
+		s.grant()
+		return false
+	}
+}
+
+// release frees the worker of the share, which decoded for took.
+func (share *decodeShare) release(took time.Duration) {
+	if share == nil {
+		return
+	}
+	s := share.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share.pass += took.Seconds() / share.priority
+	s.busy--
+	s.grant()
+}
+
+// decodeSlot is the worker of an access unit being decoded, which is released once, either after decoding or
+// when decoding hangs, so that a hung decoder doesn't hold a worker of the other cameras.
+type decodeSlot struct {
+	share    *decodeShare
+	start    time.Time
+	released atomic.Bool
+}
+
+func (slot *decodeSlot) release() {
+	if slot.released.CompareAndSwap(false, true) {
+		slot.share.release(time.Since(slot.start))
+	}
+}
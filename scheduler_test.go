@@ -0,0 +1,134 @@
+package viamrtsp
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// waitForWaiting waits until n shares are waiting for a worker of s.
+func waitForWaiting(t *testing.T, s *decodeScheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		waiting := len(s.waiting)
+		s.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		test.That(t, time.Now().Before(deadline), test.ShouldBeTrue)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDecodeScheduler(t *testing.T) {
+	t.Run("doesn't limit decoding without decode_workers", func(t *testing.T) {
+		s := newDecodeScheduler()
+		a, b := s.newShare(), s.newShare()
+		for i := 0; i < 10; i++ {
+			test.That(t, a.acquire(nil), test.ShouldBeTrue)
+			test.That(t, b.acquire(nil), test.ShouldBeTrue)
+		}
+		test.That(t, s.busy, test.ShouldEqual, 20)
+	})
+
+	t.Run("limits decoding to the largest decode_workers", func(t *testing.T) {
+		s := newDecodeScheduler()
+		a, b := s.newShare(), s.newShare()
+		a.update(1, 1)
+		b.update(2, 1)
+		test.That(t, a.acquire(nil), test.ShouldBeTrue)
+		test.That(t, b.acquire(nil), test.ShouldBeTrue)
+
+		acquired := make(chan bool)
+		go func() { acquired <- a.acquire(nil) }()
+		waitForWaiting(t, s, 1)
+		b.release(time.Millisecond)
+		test.That(t, <-acquired, test.ShouldBeTrue)
+
+		// closing b lowers the limit to 1, so a is only given a worker once both others are released
+		b.close()
+		go func() { acquired <- a.acquire(nil) }()
+		waitForWaiting(t, s, 1)
+		a.release(time.Millisecond)
+		waitForWaiting(t, s, 1)
+		a.release(time.Millisecond)
+		test.That(t, <-acquired, test.ShouldBeTrue)
+	})
+
+	t.Run("gives workers to the share which decoded the least relative to its priority", func(t *testing.T) {
+		s := newDecodeScheduler()
+		heavy, light, holder := s.newShare(), s.newShare(), s.newShare()
+		holder.update(1, 1)
+		heavy.update(0, 1)
+		light.update(0, 4)
+
+		// heavy & light each decode for 1s, which counts 4 times less for light
+		test.That(t, heavy.acquire(nil), test.ShouldBeTrue)
+		heavy.release(time.Second)
+		test.That(t, light.acquire(nil), test.ShouldBeTrue)
+		light.release(time.Second)
+
+		test.That(t, holder.acquire(nil), test.ShouldBeTrue)
+		order := make(chan *decodeShare, 2)
+		go func() {
+			heavy.acquire(nil)
+			order <- heavy
+		}()
+		waitForWaiting(t, s, 1)
+		go func() {
+			light.acquire(nil)
+			order <- light
+		}()
+		waitForWaiting(t, s, 2)
+
+		holder.release(0)
+		test.That(t, <-order, test.ShouldEqual, light)
+		light.release(time.Second)
+		test.That(t, <-order, test.ShouldEqual, heavy)
+		heavy.release(0)
+	})
+
+	t.Run("stops waiting when stopped", func(t *testing.T) {
+		s := newDecodeScheduler()
+		a := s.newShare()
+		a.update(1, 1)
+		test.That(t, a.acquire(nil), test.ShouldBeTrue)
+		stop := make(chan struct{})
+		acquired := make(chan bool)
+		go func() { acquired <- a.acquire(stop) }()
+		waitForWaiting(t, s, 1)
+		close(stop)
+		test.That(t, <-acquired, test.ShouldBeFalse)
+		waitForWaiting(t, s, 0)
+		a.release(0)
+		test.That(t, s.busy, test.ShouldEqual, 0)
+	})
+
+	t.Run("hung decoders release their worker", func(t *testing.T) {
+		s := newDecodeScheduler()
+		share := s.newShare()
+		share.update(1, 1)
+		unblock := make(chan struct{})
+		started := make(chan struct{})
+		w := newDecodeWorker(&decoder{}, func(_ *decoder, _ [][]byte, _ time.Time) {
+			close(started)
+			<-unblock
+		}, share, func() {}, logging.NewTestLogger(t))
+		test.That(t, w.submit([][]byte{{0}}, time.Now(), true), test.ShouldBeTrue)
+		<-started
+		test.That(t, s.busy, test.ShouldEqual, 1)
+
+		w.releaseSlot()
+		test.That(t, s.busy, test.ShouldEqual, 0)
+		// releasing again, e.g. once the decoder returns, doesn't free another worker
+		w.releaseSlot()
+		test.That(t, s.busy, test.ShouldEqual, 0)
+		close(unblock)
+		w.stop()
+		test.That(t, s.busy, test.ShouldEqual, 0)
+	})
+}
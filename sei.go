@@ -0,0 +1,180 @@
+package viamrtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+)
+
+const (
+	seiTypeUserDataUnregistered = 5
+	// seiUUIDSize is the size of the UUID which identifies the format of user_data_unregistered payloads.
+	seiUUIDSize = 16
+)
+
+// seiTypeNames are the names of common SEI payload types of H264 & H265.
+var seiTypeNames = map[int]string{
+	0:                           "buffering_period",
+	1:                           "pic_timing",
+	4:                           "user_data_registered_itu_t_t35",
+	seiTypeUserDataUnregistered: "user_data_unregistered",
+	6:                           "recovery_point",
+	136:                         "time_code",
+	137:                         "mastering_display_colour_volume",
+	144:                         "content_light_level_info",
+}
+
+// seiMessage is an SEI message, which cameras use to embed e.g. wall clock timestamps or analytics in the stream.
+type seiMessage struct {
+	payloadType int
+	// uuid is the UUID of user_data_unregistered messages, whose payload is the data following it
+	uuid    string
+	payload []byte
+}
+
+// parseSEI returns the SEI messages of the SEI NAL units of au, an H264 or H265 access unit.
+func parseSEI(codec videoCodec, au [][]byte) []seiMessage {
+	var msgs []seiMessage
+	for _, nalu := range au {
+		headerSize := 1
+		if codec == H265 {
+			if len(nalu) < 2 {
+				continue
+			}
+			typ := h265.NALUType((nalu[0] >> 1) & 0b111111)
+			if typ != h265.NALUType_PREFIX_SEI_NUT && typ != h265.NALUType_SUFFIX_SEI_NUT {
+				continue
+			}
+			headerSize = 2
+		} else if len(nalu) == 0 || naluType(nalu) != h264.NALUTypeSEI {
+			continue
+		}
+		msgs = append(msgs, parseSEIMessages(h264.EmulationPreventionRemove(nalu[headerSize:]))...)
+	}
+	return msgs
+}
+
+// parseSEIMessages parses the sei_message()s of an SEI RBSP, ignoring a truncated message.
+func parseSEIMessages(rbsp []byte) []seiMessage {
+	var msgs []seiMessage
+	pos := 0
+	// readValue reads a payload type or size, which are coded as a run of 0xFF bytes followed by the remainder
+	readValue := func() (int, bool) {
+		v := 0
+		for pos < len(rbsp) {
+			b := rbsp[pos]
+			pos++
+			v += int(b)
+			if b != 0xFF {
+				return v, true
+			}
+		}
+		return 0, false
+	}
+	// the messages are followed by the rbsp_trailing_bits, a single 0x80 byte
+	for pos < len(rbsp) && !(pos == len(rbsp)-1 && rbsp[pos] == 0x80) {
+		payloadType, ok := readValue()
+		if !ok {
+			break
+		}
+		size, ok := readValue()
+		if !ok || pos+size > len(rbsp) {
+			break
+		}
+		msg := seiMessage{payloadType: payloadType, payload: append([]byte(nil), rbsp[pos:pos+size]...)}
+		pos += size
+		if payloadType == seiTypeUserDataUnregistered && len(msg.payload) >= seiUUIDSize {
+			msg.uuid = formatUUID(msg.payload[:seiUUIDSize])
+			msg.payload = msg.payload[seiUUIDSize:]
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// seiStore holds the latest SEI message of each payload type, & of each UUID for user_data_unregistered messages,
+// as cameras send some messages only occasionally.
+type seiStore struct {
+	mu       sync.Mutex
+	messages map[string]storedSEIMessage
+}
+
+type storedSEIMessage struct {
+	seiMessage
+	capturedAt time.Time
+}
+
+// store keeps msgs, which were received in an access unit captured at capturedAt.
+func (s *seiStore) store(msgs []seiMessage, capturedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages == nil {
+		s.messages = map[string]storedSEIMessage{}
+	}
+	for _, msg := range msgs {
+		s.messages[fmt.Sprintf("%d/%s", msg.payloadType, msg.uuid)] = storedSEIMessage{seiMessage: msg, capturedAt: capturedAt}
+	}
+}
+
+// snapshot returns the stored messages, ordered by payload type & UUID.
+func (s *seiStore) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	stored := make([]storedSEIMessage, 0, len(s.messages))
+	for _, msg := range s.messages {
+		stored = append(stored, msg)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(stored, func(i, j int) bool {
+		if stored[i].payloadType != stored[j].payloadType {
+			return stored[i].payloadType < stored[j].payloadType
+		}
+		return stored[i].uuid < stored[j].uuid
+	})
+	msgs := make([]interface{}, 0, len(stored))
+	for _, msg := range stored {
+		m := map[string]interface{}{
+			"type":        msg.payloadType,
+			"captured_at": msg.capturedAt.Format(time.RFC3339Nano),
+		}
+		if name, ok := seiTypeNames[msg.payloadType]; ok {
+			m["name"] = name
+		}
+		if msg.uuid != "" {
+			m["uuid"] = msg.uuid
+		}
+		// user data is often text, e.g. JSON, which is returned as is without its NUL terminator
+		if text := strings.TrimRight(string(msg.payload), "\x00"); msg.payloadType == seiTypeUserDataUnregistered && isText(text) {
+			m["text"] = text
+		} else {
+			m["payload"] = base64.StdEncoding.EncodeToString(msg.payload)
+		}
+		msgs = append(msgs, m)
+	}
+	return map[string]interface{}{"messages": msgs}
+}
+
+// isText returns true if s is printable UTF-8 text.
+func isText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
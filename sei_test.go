@@ -0,0 +1,69 @@
+package viamrtsp
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestParseSEI(t *testing.T) {
+	uuid := []byte{0xdc, 0x45, 0xe9, 0xbd, 0xe6, 0xd9, 0x48, 0xb7, 0x96, 0x2c, 0xd8, 0x20, 0xd9, 0x23, 0xee, 0xef}
+	text := `{"time":"2024-05-03T20:33:01Z"}`
+	userData := append(append([]byte{}, uuid...), append([]byte(text), 0)...)
+	// a pic_timing message whose payload needs an emulation prevention byte, followed by the user data
+	rbsp := append([]byte{1, 3, 0, 0, 1, seiTypeUserDataUnregistered, byte(len(userData))}, userData...)
+	rbsp = append(rbsp, 0x80)
+	h264SEI := h264NALU(6, rbsp)
+	test.That(t, h264SEI[1:6], test.ShouldResemble, []byte{1, 3, 0, 0, 3})
+
+	for _, tc := range []struct {
+		codec videoCodec
+		au    [][]byte
+	}{
+		{H264, [][]byte{h264SEI, {0x65, 0x88}}},
+		// PREFIX_SEI_NUT NAL units have a 2 byte header
+		{H265, [][]byte{{0x26, 0x01, 0xAF}, append([]byte{39 << 1, 0x01}, h264SEI[1:]...)}},
+	} {
+		t.Run(tc.codec.String(), func(t *testing.T) {
+			msgs := parseSEI(tc.codec, tc.au)
+			test.That(t, msgs, test.ShouldHaveLength, 2)
+			test.That(t, msgs[0].payloadType, test.ShouldEqual, 1)
+			test.That(t, msgs[0].payload, test.ShouldResemble, []byte{0, 0, 1})
+			test.That(t, msgs[1].payloadType, test.ShouldEqual, seiTypeUserDataUnregistered)
+			test.That(t, msgs[1].uuid, test.ShouldEqual, "dc45e9bd-e6d9-48b7-962c-d820d923eeef")
+			test.That(t, string(msgs[1].payload), test.ShouldEqual, text+"\x00")
+		})
+	}
+
+	// payload types & sizes of 255 or more are coded with 0xFF bytes
+	msgs := parseSEIMessages(append([]byte{0xFF, 0x02, 0xFF, 0x01}, make([]byte, 256)...))
+	test.That(t, msgs, test.ShouldHaveLength, 1)
+	test.That(t, msgs[0].payloadType, test.ShouldEqual, 257)
+	test.That(t, msgs[0].payload, test.ShouldHaveLength, 256)
+
+	// truncated messages are ignored
+	test.That(t, parseSEIMessages([]byte{5, 20, 1, 2}), test.ShouldBeEmpty)
+	test.That(t, parseSEI(H264, [][]byte{{0x65, 0x88}}), test.ShouldBeEmpty)
+
+	var store seiStore
+	capturedAt := time.Unix(1714768381, 0).UTC()
+	store.store(parseSEI(H264, [][]byte{h264SEI}), capturedAt)
+	store.store([]seiMessage{{payloadType: 1, payload: []byte{7}}}, capturedAt.Add(time.Second))
+	snapshot := store.snapshot()["messages"].([]interface{})
+	test.That(t, snapshot, test.ShouldHaveLength, 2)
+	test.That(t, snapshot[0], test.ShouldResemble, map[string]interface{}{
+		"type":        1,
+		"name":        "pic_timing",
+		"captured_at": "2024-05-03T20:33:02Z",
+		"payload":     base64.StdEncoding.EncodeToString([]byte{7}),
+	})
+	test.That(t, snapshot[1], test.ShouldResemble, map[string]interface{}{
+		"type":        seiTypeUserDataUnregistered,
+		"name":        "user_data_unregistered",
+		"uuid":        "dc45e9bd-e6d9-48b7-962c-d820d923eeef",
+		"captured_at": "2024-05-03T20:33:01Z",
+		"text":        text,
+	})
+}
@@ -0,0 +1,257 @@
+package viamrtsp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pkg/errors"
+)
+
+// sinkKind names one of the consumers of the video track of H264 & H265 connections.
+type sinkKind string
+
+const (
+	// decoderSink decodes frames, which are served as images.
+	decoderSink sinkKind = "decoder"
+	// passthroughSink publishes units to rtp_passthrough subscribers.
+	passthroughSink sinkKind = "passthrough"
+	// recorderSink writes the stream to recording segments.
+	recorderSink sinkKind = "recorder"
+)
+
+// sinkKinds are the kinds of sinks, in the order packets are passed on to them.
+var sinkKinds = []sinkKind{passthroughSink, recorderSink, decoderSink}
+
+func parseSinkKind(name string) (sinkKind, error) {
+	for _, kind := range sinkKinds {
+		if string(kind) == name {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("unknown sink '%s', must be one of %v", name, sinkKinds)
+}
+
+// videoSink consumes the video track of a connection. Either of packet & accessUnit may be nil.
+type videoSink struct {
+	// packet is called with each RTP packet of the track, before it's depacketized.
+	packet func(pkt *rtp.Packet)
+	// accessUnit is called with each access unit of the track, along with its last packet.
+	accessUnit func(au [][]byte, pkt *rtp.Packet, keyframe bool)
+	// close is called once the sink is detached or its connection is closed, after its last call.
+	close func()
+}
+
+// sinkFactory creates a sink for the video track of a connection.
+type sinkFactory func() (*videoSink, error)
+
+// videoPipeline passes the video track of an H264 or H265 connection on to its attached sinks. Each kind of sink
+// is created by a factory of the connection, so that sinks can be attached & detached while connected without
+// affecting each other, e.g. to stop decoding while rtp_passthrough subscribers keep receiving the stream.
+type videoPipeline struct {
+	// mu is held for reading while packets are passed on, so that sinks are never closed during a call
+	mu        sync.RWMutex
+	factories map[sinkKind]sinkFactory
+	sinks     map[sinkKind]*videoSink
+	closed    bool
+}
+
+func newVideoPipeline() *videoPipeline {
+	return &videoPipeline{factories: map[sinkKind]sinkFactory{}, sinks: map[sinkKind]*videoSink{}}
+}
+
+// register makes kind available for the connection, attaching it unless detached is true.
+func (p *videoPipeline) register(kind sinkKind, factory sinkFactory, detached bool) error {
+	p.mu.Lock()
+	p.factories[kind] = factory
+	p.mu.Unlock()
+	if detached {
+		return nil
+	}
+	return p.attach(kind)
+}
+
+// attach creates a sink of kind, unless one is already attached or the connection is closed.
+func (p *videoPipeline) attach(kind sinkKind) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	if _, ok := p.sinks[kind]; ok {
+		return nil
+	}
+	factory, ok := p.factories[kind]
+	if !ok {
+		return fmt.Errorf("the %s sink isn't available for this camera's config & codec", kind)
+	}
+	sink, err := factory()
+	if err != nil {
+		return errors.Wrapf(err, "creating %s sink", kind)
+	}
+	p.sinks[kind] = sink
+	return nil
+}
+
+// detach closes the sink of kind, if one is attached.
+func (p *videoPipeline) detach(kind sinkKind) {
+	p.mu.Lock()
+	sink, ok := p.sinks[kind]
+	delete(p.sinks, kind)
+	p.mu.Unlock()
+	if ok && sink.close != nil {
+		sink.close()
+	}
+}
+
+// attached returns the kinds of the attached sinks, sorted.
+func (p *videoPipeline) attached() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	kinds := make([]string, 0, len(p.sinks))
+	for kind := range p.sinks {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// isAttached reports whether a sink of kind is attached.
+func (p *videoPipeline) isAttached(kind sinkKind) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.sinks[kind]
+	return ok
+}
+
+// packet passes pkt on to the attached sinks.
+func (p *videoPipeline) packet(pkt *rtp.Packet) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, kind := range sinkKinds {
+		if sink, ok := p.sinks[kind]; ok && sink.packet != nil {
+			sink.packet(pkt)
+		}
+	}
+}
+
+// accessUnit passes au, whose last packet is pkt, on to the attached sinks.
+func (p *videoPipeline) accessUnit(au [][]byte, pkt *rtp.Packet, keyframe bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, kind := range sinkKinds {
+		if sink, ok := p.sinks[kind]; ok && sink.accessUnit != nil {
+			sink.accessUnit(au, pkt, keyframe)
+		}
+	}
+}
+
+// close closes every sink, after which sinks can't be attached.
+func (p *videoPipeline) close() {
+	p.mu.Lock()
+	sinks := p.sinks
+	p.sinks = map[sinkKind]*videoSink{}
+	p.closed = true
+	p.mu.Unlock()
+	for _, kind := range sinkKinds {
+		if sink, ok := sinks[kind]; ok && sink.close != nil {
+			sink.close()
+		}
+	}
+}
+
+// startPipeline sets the pipeline of the connection's video track.
+func (rc *rtspCamera) startPipeline() *videoPipeline {
+	p := newVideoPipeline()
+	rc.pipeline.Store(p)
+	return p
+}
+
+// closePipeline closes the sinks of the connection.
+func (rc *rtspCamera) closePipeline() {
+	if p := rc.pipeline.Swap(nil); p != nil {
+		p.close()
+	}
+}
+
+// sinkDetached reports whether kind was detached by the detach-sink command.
+func (rc *rtspCamera) sinkDetached(kind sinkKind) bool {
+	rc.sinksMu.Lock()
+	defer rc.sinksMu.Unlock()
+	return rc.detachedSinks[kind]
+}
+
+// registerSink makes kind available for the connection of p, attaching it unless it was detached.
+func (rc *rtspCamera) registerSink(p *videoPipeline, kind sinkKind, factory sinkFactory) error {
+	rc.sinksMu.Lock()
+	defer rc.sinksMu.Unlock()
+	return p.register(kind, factory, rc.detachedSinks[kind])
+}
+
+// attachSink attaches kind to the current connection, & to the following ones.
+func (rc *rtspCamera) attachSink(kind sinkKind) error {
+	rc.sinksMu.Lock()
+	defer rc.sinksMu.Unlock()
+	if p := rc.pipeline.Load(); p != nil {
+		if err := p.attach(kind); err != nil {
+			return err
+		}
+	}
+	delete(rc.detachedSinks, kind)
+	return nil
+}
+
+// detachSink detaches kind from the current connection, & from the following ones until it's attached again
+// or the camera is reconfigured. Detaching the passthrough sink ends the rtp_passthrough subscriptions.
+func (rc *rtspCamera) detachSink(kind sinkKind) {
+	rc.sinksMu.Lock()
+	if rc.detachedSinks == nil {
+		rc.detachedSinks = map[sinkKind]bool{}
+	}
+	rc.detachedSinks[kind] = true
+	if p := rc.pipeline.Load(); p != nil {
+		p.detach(kind)
+	}
+	rc.sinksMu.Unlock()
+	if kind == passthroughSink {
+		rc.unsubscribeAll()
+	}
+}
+
+// sinksInfo returns the attached sinks of the current connection & the detached ones.
+func (rc *rtspCamera) sinksInfo() map[string]interface{} {
+	rc.sinksMu.Lock()
+	defer rc.sinksMu.Unlock()
+	attached := []string{}
+	if p := rc.pipeline.Load(); p != nil {
+		attached = p.attached()
+	}
+	detached := []string{}
+	for _, kind := range sinkKinds {
+		if rc.detachedSinks[kind] {
+			detached = append(detached, string(kind))
+		}
+	}
+	return map[string]interface{}{"attached": attached, "detached": detached}
+}
+
+// sinkCommand attaches or detaches the sink named by the `sink` field of cmd, returning the sinks.
+func (rc *rtspCamera) sinkCommand(cmd map[string]interface{}, attach bool) (map[string]interface{}, error) {
+	name, ok := cmd["sink"].(string)
+	if !ok {
+		return nil, errors.Errorf("%s requires a string 'sink' field", cmd[commandKey])
+	}
+	kind, err := parseSinkKind(name)
+	if err != nil {
+		return nil, err
+	}
+	if attach {
+		if err := rc.attachSink(kind); err != nil {
+			return nil, err
+		}
+	} else {
+		rc.detachSink(kind)
+	}
+	return rc.sinksInfo(), nil
+}
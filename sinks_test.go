@@ -0,0 +1,119 @@
+package viamrtsp
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"go.viam.com/test"
+)
+
+// countingSink returns a factory of sinks which count the packets they receive & whether they're closed.
+func countingSink(packets, closed *int) sinkFactory {
+	return func() (*videoSink, error) {
+		return &videoSink{
+			packet: func(_ *rtp.Packet) { *packets++ },
+			close:  func() { *closed++ },
+		}, nil
+	}
+}
+
+func TestVideoPipeline(t *testing.T) {
+	p := newVideoPipeline()
+	var decoderPackets, decoderClosed, passthroughPackets, passthroughClosed int
+	test.That(t, p.register(decoderSink, countingSink(&decoderPackets, &decoderClosed), false), test.ShouldBeNil)
+	test.That(t, p.register(passthroughSink, countingSink(&passthroughPackets, &passthroughClosed), true), test.ShouldBeNil)
+	test.That(t, p.attached(), test.ShouldResemble, []string{"decoder"})
+
+	p.packet(&rtp.Packet{})
+	// sinks without an accessUnit func are skipped
+	p.accessUnit(nil, &rtp.Packet{}, true)
+	test.That(t, decoderPackets, test.ShouldEqual, 1)
+	test.That(t, passthroughPackets, test.ShouldEqual, 0)
+
+	test.That(t, p.attach(passthroughSink), test.ShouldBeNil)
+	// attaching an attached sink doesn't create another one
+	test.That(t, p.attach(passthroughSink), test.ShouldBeNil)
+	test.That(t, p.attached(), test.ShouldResemble, []string{"decoder", "passthrough"})
+	p.packet(&rtp.Packet{})
+	test.That(t, decoderPackets, test.ShouldEqual, 2)
+	test.That(t, passthroughPackets, test.ShouldEqual, 1)
+
+	p.detach(decoderSink)
+	p.detach(decoderSink)
+	test.That(t, decoderClosed, test.ShouldEqual, 1)
+	p.packet(&rtp.Packet{})
+	test.That(t, decoderPackets, test.ShouldEqual, 2)
+	test.That(t, passthroughPackets, test.ShouldEqual, 2)
+
+	err := p.attach(recorderSink)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "recorder sink isn't available")
+
+	failing := errors.New("no disk space")
+	test.That(t, p.register(recorderSink, func() (*videoSink, error) { return nil, failing }, false), test.ShouldBeError)
+	test.That(t, p.attached(), test.ShouldResemble, []string{"passthrough"})
+
+	p.close()
+	test.That(t, passthroughClosed, test.ShouldEqual, 1)
+	test.That(t, p.attached(), test.ShouldBeEmpty)
+	// sinks can't be attached once the connection is closed
+	test.That(t, p.attach(decoderSink), test.ShouldBeNil)
+	test.That(t, p.attached(), test.ShouldBeEmpty)
+	test.That(t, decoderClosed, test.ShouldEqual, 1)
+}
+
+func TestParseSinkKind(t *testing.T) {
+	for _, kind := range sinkKinds {
+		parsed, err := parseSinkKind(string(kind))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, parsed, test.ShouldEqual, kind)
+	}
+	_, err := parseSinkKind("encoder")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "unknown sink 'encoder'")
+}
+
+func TestSinkCommands(t *testing.T) {
+	rc := &rtspCamera{}
+	rc.decodeFrames.Store(true)
+	rc.storeFrame(image.NewRGBA(image.Rect(0, 0, 1, 1)), time.Now())
+	p := rc.startPipeline()
+	var packets, closed int
+	test.That(t, rc.registerSink(p, decoderSink, countingSink(&packets, &closed)), test.ShouldBeNil)
+
+	_, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": detachSinkCommand})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": detachSinkCommand, "sink": "encoder"})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	res, err := rc.DoCommand(context.Background(), map[string]interface{}{"command": detachSinkCommand, "sink": "decoder"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["attached"], test.ShouldResemble, []string{})
+	test.That(t, res["detached"], test.ShouldResemble, []string{"decoder"})
+	test.That(t, closed, test.ShouldEqual, 1)
+	_, _, err = rc.readFrame(context.Background())
+	test.That(t, err, test.ShouldBeError, errDecoderDetached)
+
+	// a detached sink stays detached on the following connections
+	rc.closePipeline()
+	p = rc.startPipeline()
+	test.That(t, rc.registerSink(p, decoderSink, countingSink(&packets, &closed)), test.ShouldBeNil)
+	test.That(t, p.attached(), test.ShouldBeEmpty)
+
+	res, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": attachSinkCommand, "sink": "decoder"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, res["attached"], test.ShouldResemble, []string{"decoder"})
+	test.That(t, res["detached"], test.ShouldResemble, []string{})
+	_, _, err = rc.readFrame(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+
+	// the recorder sink isn't available without a recording config
+	_, err = rc.DoCommand(context.Background(), map[string]interface{}{"command": attachSinkCommand, "sink": "recorder"})
+	test.That(t, err, test.ShouldNotBeNil)
+	rc.closePipeline()
+	test.That(t, closed, test.ShouldEqual, 2)
+}
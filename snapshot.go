@@ -0,0 +1,214 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // HTTP Digest authentication defaults to MD5
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/rimage"
+	rutils "go.viam.com/rdk/utils"
+)
+
+const (
+	// snapshotStaleAfter is how old the latest frame must be before the stream is considered down
+	// and images are served from the snapshot URL instead.
+	snapshotStaleAfter = 10 * time.Second
+	snapshotTimeout    = 5 * time.Second
+	// maxSnapshotSize bounds the memory used by a single snapshot.
+	maxSnapshotSize = 32 << 20
+)
+
+// snapshotSource fetches JPEG stills from a camera's HTTP snapshot URL.
+type snapshotSource struct {
+	url        string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+func newSnapshotSource(conf *Config) *snapshotSource {
+	return &snapshotSource{
+		url:        conf.SnapshotURL,
+		username:   conf.Username,
+		password:   conf.Password,
+		httpClient: &http.Client{Timeout: snapshotTimeout},
+	}
+}
+
+// fetch returns the current JPEG still of the camera, which is decoded lazily.
+func (s *snapshotSource) fetch(ctx context.Context) (image.Image, error) {
+	res, err := s.get(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized && s.username != "" {
+		// the first request is unauthenticated so that the password is only sent in the clear to cameras which
+		// don't offer Digest authentication
+		authorization, err := s.authorization(res)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if res, err = s.get(ctx, authorization); err != nil {
+			return nil, err
+		}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("snapshot url responded with status_code: %d", res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxSnapshotSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading snapshot")
+	}
+	if _, err := jpeg.DecodeConfig(bytes.NewReader(body)); err != nil {
+		return nil, errors.Wrap(err, "snapshot is not a JPEG")
+	}
+	return rimage.NewLazyEncodedImage(body, rutils.MimeTypeJPEG), nil
+}
+
+// get requests the snapshot URL with the Authorization header authorization, unless it's empty.
+func (s *snapshotSource) get(ctx context.Context, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating snapshot request")
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching snapshot")
+	}
+	return res, nil
+}
+
+// authorization returns the Authorization header answering the challenges of the unauthorized response res,
+// preferring Digest with SHA-256, then Digest with MD5, over Basic authentication.
+func (s *snapshotSource) authorization(res *http.Response) (string, error) {
+	var digest map[string]string
+	for _, challenge := range res.Header.Values("WWW-Authenticate") {
+		if !strings.EqualFold(authScheme(challenge), "Digest") {
+			continue
+		}
+		params := parseAuthParams(strings.TrimSpace(challenge)[len("Digest"):])
+		if digest == nil || strings.HasPrefix(strings.ToUpper(params["algorithm"]), "SHA-256") {
+			digest = params
+		}
+	}
+	if digest == nil {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(s.username+":"+s.password)), nil
+	}
+	return digestAuthorization(digest, http.MethodGet, res.Request.URL.RequestURI(), s.username, s.password)
+}
+
+// parseAuthParams parses the comma separated auth-params of a WWW-Authenticate challenge, e.g.
+// realm="camera", nonce="abc", into a map keyed by lowercase name.
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return params
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			s = s[min(i+1, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:end]))
+			s = s[end:]
+		}
+		params[name] = value.String()
+	}
+}
+
+// digestAuthorization returns the Authorization header of an RFC 7616 Digest response to the challenge params,
+// supporting the MD5 & SHA-256 algorithms, their -sess variants & the auth quality of protection.
+func digestAuthorization(params map[string]string, method, uri, username, password string) (string, error) {
+	algorithm := params["algorithm"]
+	var hash func(string) string
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		hash = func(s string) string {
+			//nolint:gosec
+			sum := md5.Sum([]byte(s))
+			return hex.EncodeToString(sum[:])
+		}
+	case "SHA-256":
+		hash = func(s string) string {
+			sum := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(sum[:])
+		}
+	default:
+		return "", errors.Errorf("snapshot url requires the unsupported digest algorithm %s", algorithm)
+	}
+
+	realm, nonce := params["realm"], params["nonce"]
+	cnonceBytes := make([]byte, 8)
+	//nolint:errcheck
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	ha1 := hash(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = hash(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := hash(method + ":" + uri)
+
+	var qop string
+	for _, offered := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(offered) == "auth" {
+			qop = "auth"
+		}
+	}
+	const nc = "00000001"
+	response := hash(ha1 + ":" + nonce + ":" + ha2)
+	if qop != "" {
+		response = hash(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		quote(username), quote(realm), quote(nonce), quote(uri), response)
+	if algorithm != "" {
+		authorization += ", algorithm=" + algorithm
+	}
+	if qop != "" {
+		authorization += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		authorization += fmt.Sprintf(`, opaque="%s"`, quote(opaque))
+	}
+	return authorization, nil
+}
+
+// frameIsStale reports whether images should be served from the snapshot URL because
+// no frame was decoded recently.
+func frameIsStale(f *frame, now time.Time) bool {
+	return f == nil || now.Sub(f.receivedAt) > snapshotStaleAfter
+}
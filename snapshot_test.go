@@ -0,0 +1,101 @@
+package viamrtsp
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec
+	"encoding/hex"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.viam.com/rdk/rimage"
+	"go.viam.com/test"
+)
+
+func TestSnapshotFallback(t *testing.T) {
+	var buf bytes.Buffer
+	test.That(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 2)), nil), test.ShouldBeNil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		//nolint:errcheck
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	t.Run("serves snapshots while the stream is down", func(t *testing.T) {
+		rc := &rtspCamera{}
+		rc.snapshots.Store(newSnapshotSource(&Config{SnapshotURL: srv.URL, Username: "admin", Password: "secret"}))
+		img, _, err := rc.readFrame(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		lazy, ok := img.(*rimage.LazyEncodedImage)
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, lazy.RawData(), test.ShouldResemble, buf.Bytes())
+		test.That(t, img.Bounds(), test.ShouldResemble, image.Rect(0, 0, 4, 2))
+
+		// recent frames are served instead of snapshots
+		frameImg := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		rc.storeFrame(frameImg, time.Now())
+		img, _, err = rc.readFrame(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img, test.ShouldEqual, frameImg)
+	})
+
+	t.Run("authenticates with digest", func(t *testing.T) {
+		md5Hex := func(s string) string {
+			//nolint:gosec
+			sum := md5.Sum([]byte(s))
+			return hex.EncodeToString(sum[:])
+		}
+		digestSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization := r.Header.Get("Authorization")
+			if !strings.HasPrefix(authorization, "Digest ") {
+				w.Header().Add("WWW-Authenticate", `Basic realm="Cam \"1\""`)
+				w.Header().Add("WWW-Authenticate", `Digest realm="Cam \"1\"", qop="auth,auth-int", nonce="abc", opaque="xyz"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			p := parseAuthParams(authorization[len("Digest"):])
+			ha1 := md5Hex(`admin:Cam "1":secret`)
+			ha2 := md5Hex("GET:" + r.URL.RequestURI())
+			if p["uri"] != r.URL.RequestURI() || p["opaque"] != "xyz" || p["qop"] != "auth" ||
+				p["response"] != md5Hex(ha1+":abc:"+p["nc"]+":"+p["cnonce"]+":auth:"+ha2) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			//nolint:errcheck
+			w.Write(buf.Bytes())
+		}))
+		defer digestSrv.Close()
+
+		s := newSnapshotSource(&Config{SnapshotURL: digestSrv.URL + "/snapshot.jpg?channel=1", Username: "admin", Password: "secret"})
+		img, err := s.fetch(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, img.Bounds(), test.ShouldResemble, image.Rect(0, 0, 4, 2))
+
+		s.password = "wrong"
+		_, err = s.fetch(context.Background())
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "status_code: 401")
+	})
+
+	t.Run("surfaces snapshot errors", func(t *testing.T) {
+		_, err := newSnapshotSource(&Config{SnapshotURL: srv.URL}).fetch(context.Background())
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "status_code: 401")
+	})
+
+	t.Run("frameIsStale", func(t *testing.T) {
+		now := time.Now()
+		test.That(t, frameIsStale(nil, now), test.ShouldBeTrue)
+		test.That(t, frameIsStale(&frame{receivedAt: now.Add(-time.Second)}, now), test.ShouldBeFalse)
+		test.That(t, frameIsStale(&frame{receivedAt: now.Add(-time.Minute)}, now), test.ShouldBeTrue)
+	})
+}
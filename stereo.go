@@ -0,0 +1,194 @@
+package viamrtsp
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/gostream"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+)
+
+const (
+	defaultStereoSyncTolerance = 20 * time.Millisecond
+	// stereoHistorySize is the number of recent frames kept per side to find a synchronized pair in.
+	stereoHistorySize = 30
+)
+
+// ModelStereo combines two RTSP streams into a synchronized stereo pair.
+var ModelStereo = family.WithModel("rtsp-stereo")
+
+func init() {
+	resource.RegisterComponent(camera.API, ModelStereo, resource.Registration[camera.Camera, *StereoConfig]{
+		Constructor: newStereoCamera,
+	})
+}
+
+// StereoConfig are the config attributes for the stereo pair model.
+type StereoConfig struct {
+	Left  Config `json:"left"`
+	Right Config `json:"right"`
+	// SyncToleranceMs is the maximum difference between the capture times of a left & right frame
+	// for them to be served as a pair.
+	SyncToleranceMs int `json:"sync_tolerance_ms,omitempty"`
+}
+
+// Validate checks to see if the attributes of the model are valid.
+func (conf *StereoConfig) Validate(path string) ([]string, error) {
+	if _, err := conf.Left.Validate(path + ".left"); err != nil {
+		return nil, err
+	}
+	if _, err := conf.Right.Validate(path + ".right"); err != nil {
+		return nil, err
+	}
+	if conf.SyncToleranceMs < 0 {
+		return nil, fmt.Errorf("invalid sync_tolerance_ms %d for component at path '%s': must not be negative", conf.SyncToleranceMs, path)
+	}
+	return nil, nil
+}
+
+// stereoCamera serves frames from two RTSP streams whose capture times, derived from
+// RTCP sender reports, are within the configured tolerance of each other.
+type stereoCamera struct {
+	resource.AlwaysRebuild
+	resource.Named
+	camera.VideoSource
+
+	left, right             *rtspCamera
+	leftFrames, rightFrames *frameHistory
+	tolerance               time.Duration
+	logger                  logging.Logger
+}
+
+func newStereoCamera(ctx context.Context, _ resource.Dependencies, conf resource.Config, logger logging.Logger) (camera.Camera, error) {
+	newConf, err := resource.NativeConfig[*StereoConfig](conf)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+	sc := &stereoCamera{
+		Named:       conf.ResourceName().AsNamed(),
+		leftFrames:  newFrameHistory(stereoHistorySize),
+		rightFrames: newFrameHistory(stereoHistorySize),
+		tolerance:   defaultStereoSyncTolerance,
+		logger:      logger,
+	}
+	if newConf.SyncToleranceMs > 0 {
+		sc.tolerance = time.Duration(newConf.SyncToleranceMs) * time.Millisecond
+	}
+
+	name := conf.ResourceName().Name
+	sc.left, err = newRTSPCameraFromConfig(
+		ctx, camera.Named(name+"-left"), ModelAgnostic, &newConf.Left, keepFrame(sc.leftFrames), logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "when connecting to left stream")
+	}
+	sc.right, err = newRTSPCameraFromConfig(
+		ctx, camera.Named(name+"-right"), ModelAgnostic, &newConf.Right, keepFrame(sc.rightFrames), logger)
+	if err != nil {
+		//nolint:errcheck
+		sc.left.Close(ctx)
+		return nil, errors.Wrap(err, "when connecting to right stream")
+	}
+
+	reader := gostream.VideoReaderFunc(func(_ context.Context) (image.Image, func(), error) {
+		l, r, err := sc.pair()
+		if err != nil {
+			return nil, func() {}, err
+		}
+		return sideBySide(l.img, r.img), func() {}, nil
+	})
+	src, err := camera.NewVideoSourceFromReader(ctx, reader, nil, camera.ColorStream)
+	if err != nil {
+		//nolint:errcheck
+		sc.left.Close(ctx)
+		//nolint:errcheck
+		sc.right.Close(ctx)
+		return nil, err
+	}
+	sc.VideoSource = src
+	return sc, nil
+}
+
+// keepFrame returns an onFrame callback which adds a copy of every frame to h.
+func keepFrame(h *frameHistory) func(*frame) {
+	return func(f *frame) {
+		h.add(&frame{img: cloneImage(f.img), seq: f.seq, receivedAt: f.receivedAt, capturedAt: f.capturedAt})
+	}
+}
+
+// Images returns the left & right images of the most recent synchronized pair.
+// The capture time of the pair is the midpoint of the capture times of its frames.
+func (sc *stereoCamera) Images(_ context.Context) ([]camera.NamedImage, resource.ResponseMetadata, error) {
+	l, r, err := sc.pair()
+	if err != nil {
+		return nil, resource.ResponseMetadata{}, err
+	}
+	return []camera.NamedImage{
+		{Image: l.img, SourceName: "left"},
+		{Image: r.img, SourceName: "right"},
+	}, resource.ResponseMetadata{
+		CapturedAt: l.capturedAt.Add(r.capturedAt.Sub(l.capturedAt) / 2),
+	}, nil
+}
+
+// DoCommand is not supported by the stereo camera.
+func (sc *stereoCamera) DoCommand(_ context.Context, _ map[string]interface{}) (map[string]interface{}, error) {
+	return nil, resource.ErrDoUnimplemented
+}
+
+// Close closes both streams.
+func (sc *stereoCamera) Close(ctx context.Context) error {
+	err := sc.VideoSource.Close(ctx)
+	if leftErr := sc.left.Close(ctx); err == nil {
+		err = leftErr
+	}
+	if rightErr := sc.right.Close(ctx); err == nil {
+		err = rightErr
+	}
+	return err
+}
+
+func (sc *stereoCamera) pair() (*frame, *frame, error) {
+	l, r, ok := closestPair(sc.leftFrames.snapshot(), sc.rightFrames.snapshot(), sc.tolerance)
+	if !ok {
+		return nil, nil, errors.Errorf("no left & right frames were captured within %s of each other", sc.tolerance)
+	}
+	return l, r, nil
+}
+
+// closestPair returns the newest left frame which has a right frame captured within tolerance of it,
+// along with the right frame closest to it in time.
+func closestPair(left, right []*frame, tolerance time.Duration) (*frame, *frame, bool) {
+	for i := len(left) - 1; i >= 0; i-- {
+		var best *frame
+		var bestDiff time.Duration
+		for _, r := range right {
+			diff := left[i].capturedAt.Sub(r.capturedAt)
+			if diff < 0 {
+				diff = -diff
+			}
+			if best == nil || diff < bestDiff {
+				best, bestDiff = r, diff
+			}
+		}
+		if best != nil && bestDiff <= tolerance {
+			return left[i], best, true
+		}
+	}
+	return nil, nil, false
+}
+
+// sideBySide draws left & right next to each other so the pair can be viewed as a single stream.
+func sideBySide(left, right image.Image) image.Image {
+	lb, rb := left.Bounds(), right.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, lb.Dx()+rb.Dx(), max(lb.Dy(), rb.Dy())))
+	draw.Draw(out, image.Rect(0, 0, lb.Dx(), lb.Dy()), left, lb.Min, draw.Src)
+	draw.Draw(out, image.Rect(lb.Dx(), 0, lb.Dx()+rb.Dx(), rb.Dy()), right, rb.Min, draw.Src)
+	return out
+}
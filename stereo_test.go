@@ -0,0 +1,60 @@
+package viamrtsp
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestClosestPair(t *testing.T) {
+	start := time.Now()
+	at := func(seq uint64, ms int) *frame {
+		return &frame{seq: seq, capturedAt: start.Add(time.Duration(ms) * time.Millisecond)}
+	}
+	left := []*frame{at(1, 0), at(2, 33), at(3, 66), at(4, 100)}
+	right := []*frame{at(1, 5), at(2, 40), at(3, 70)}
+
+	// the newest left frame has no right frame within tolerance, so the one before it is used
+	l, r, ok := closestPair(left, right, 10*time.Millisecond)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, l.seq, test.ShouldEqual, uint64(3))
+	test.That(t, r.seq, test.ShouldEqual, uint64(3))
+
+	// with a larger tolerance the newest left frame is paired with the closest right frame
+	l, r, ok = closestPair(left, right, 50*time.Millisecond)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, l.seq, test.ShouldEqual, uint64(4))
+	test.That(t, r.seq, test.ShouldEqual, uint64(3))
+
+	_, _, ok = closestPair(left, right, time.Millisecond)
+	test.That(t, ok, test.ShouldBeFalse)
+
+	_, _, ok = closestPair(left, nil, time.Second)
+	test.That(t, ok, test.ShouldBeFalse)
+}
+
+func TestSideBySide(t *testing.T) {
+	img := sideBySide(image.NewRGBA(image.Rect(0, 0, 4, 3)), image.NewRGBA(image.Rect(0, 0, 5, 2)))
+	test.That(t, img.Bounds(), test.ShouldResemble, image.Rect(0, 0, 9, 3))
+}
+
+func TestStereoConfig(t *testing.T) {
+	conf := &StereoConfig{
+		Left:  Config{Address: "rtsp://example.com:5000/left"},
+		Right: Config{Address: "rtsp://example.com:5000/right"},
+	}
+	_, err := conf.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+
+	conf.Right.Address = "http://example.com"
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "path.right")
+
+	conf.Right.Address = "rtsp://example.com:5000/right"
+	conf.SyncToleranceMs = -1
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+}
@@ -0,0 +1,209 @@
+package viamrtsp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/mediacommon/pkg/codecs/av1"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/pkg/codecs/vp9"
+)
+
+// streamInfo describes the video track of the current connection, as parsed from its SPS.
+type streamInfo struct {
+	profile string
+	level   string
+	width   int
+	height  int
+	fps     float64
+	// interlaced is set when pictures may be coded as fields: PAFF or MBAFF in H264, field sequences in H265
+	interlaced bool
+}
+
+var h264Profiles = map[uint8]string{
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4 Predictive",
+}
+
+var h265Profiles = map[uint8]string{
+	1: "Main",
+	2: "Main 10",
+	3: "Main Still Picture",
+	4: "Format Range Extensions",
+}
+
+var av1Profiles = map[uint8]string{
+	0: "Main",
+	1: "High",
+	2: "Professional",
+}
+
+// vpxProfiles names the version of VP8 & the profile of VP9 streams, which are both numbered from 0 to 3.
+var vpxProfiles = map[uint8]string{
+	0: "Profile 0",
+	1: "Profile 1",
+	2: "Profile 2",
+	3: "Profile 3",
+}
+
+// h264StreamInfo parses the stream info from an H264 SPS, returning nil if it can't be parsed.
+func h264StreamInfo(buf []byte) *streamInfo {
+	var sps h264.SPS
+	if buf == nil || sps.Unmarshal(buf) != nil {
+		return nil
+	}
+	return &streamInfo{
+		profile:    profileName(h264Profiles, sps.ProfileIdc),
+		level:      levelName(int(sps.LevelIdc), 10),
+		width:      sps.Width(),
+		height:     sps.Height(),
+		fps:        sps.FPS(),
+		interlaced: !sps.FrameMbsOnlyFlag,
+	}
+}
+
+// h265StreamInfo parses the stream info from an H265 SPS, returning nil if it can't be parsed.
+func h265StreamInfo(buf []byte) *streamInfo {
+	var sps h265.SPS
+	// chroma_format_idc is at most 3, the size of SPS with larger ones can't be computed
+	if buf == nil || sps.Unmarshal(buf) != nil || sps.ChromaFormatIdc > 3 {
+		return nil
+	}
+	return &streamInfo{
+		profile:    profileName(h265Profiles, sps.ProfileTierLevel.GeneralProfileIdc),
+		level:      levelName(int(sps.ProfileTierLevel.GeneralLevelIdc), 30),
+		width:      sps.Width(),
+		height:     sps.Height(),
+		fps:        sps.FPS(),
+		interlaced: sps.ProfileTierLevel.GeneralInterlacedSourceFlag || (sps.VUI != nil && sps.VUI.FieldSeqFlag),
+	}
+}
+
+// av1StreamInfo parses the stream info from an AV1 sequence header OBU, returning nil if buf is another OBU
+// or can't be parsed.
+// The frame rate of AV1 streams isn't parsed.
+func av1StreamInfo(buf []byte) *streamInfo {
+	var oh av1.OBUHeader
+	if oh.Unmarshal(buf) != nil || oh.Type != av1.OBUTypeSequenceHeader {
+		return nil
+	}
+	var sh av1.SequenceHeader
+	if sh.Unmarshal(buf) != nil {
+		return nil
+	}
+	info := &streamInfo{
+		profile: profileName(av1Profiles, sh.SeqProfile),
+		width:   sh.Width(),
+		height:  sh.Height(),
+	}
+	// seq_level_idx of the first operating point, which is the level 2 + idx / 4 . idx % 4
+	if len(sh.SeqLevelIdx) > 0 {
+		info.level = fmt.Sprintf("%d.%d", 2+sh.SeqLevelIdx[0]/4, sh.SeqLevelIdx[0]%4)
+	}
+	return info
+}
+
+// vp8StreamInfo parses the stream info from the header of a VP8 frame, returning nil if it isn't a keyframe,
+// the only frames which carry the resolution, or can't be parsed. VP8 has no levels & the frame rate isn't coded.
+func vp8StreamInfo(frame []byte) *streamInfo {
+	// a 3 byte frame tag, whose lowest bit is 0 for keyframes, followed by the start code & the dimensions
+	// of keyframes, each 14 bits little endian followed by 2 bits of scaling
+	if len(frame) < 10 || frame[0]&0x01 != 0 || frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		return nil
+	}
+	return &streamInfo{
+		profile: profileName(vpxProfiles, (frame[0]>>1)&0x07),
+		width:   int(binary.LittleEndian.Uint16(frame[6:8]) & 0x3fff),
+		height:  int(binary.LittleEndian.Uint16(frame[8:10]) & 0x3fff),
+	}
+}
+
+// vp9StreamInfo parses the stream info from the uncompressed header of a VP9 frame, returning nil if it isn't
+// a keyframe, the only frames which carry the resolution, or can't be parsed. The level & frame rate of VP9
+// streams aren't coded in their frames.
+func vp9StreamInfo(frame []byte) *streamInfo {
+	var h vp9.Header
+	if h.Unmarshal(frame) != nil || h.ShowExistingFrame || h.FrameType != vp9.FrameTypeKeyFrame || h.FrameSize == nil {
+		return nil
+	}
+	return &streamInfo{
+		profile: profileName(vpxProfiles, h.Profile),
+		width:   h.Width(),
+		height:  h.Height(),
+	}
+}
+
+func profileName(names map[uint8]string, idc uint8) string {
+	if name, ok := names[idc]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", idc)
+}
+
+// levelName formats a level_idc, which is the level multiplied by scale, e.g. 41 is level 4.1 in H264.
+func levelName(idc, scale int) string {
+	return strings.TrimSuffix(fmt.Sprintf("%d.%d", idc/scale, idc%scale*10/scale), ".0")
+}
+
+// initialTransport returns the transport a new client starts with, which changes to TCP
+// if the client switches transports.
+func initialTransport(configured *gortsplib.Transport, scheme string) gortsplib.Transport {
+	switch {
+	case scheme == "rtsps":
+		return gortsplib.TransportTCP
+	case configured != nil:
+		return *configured
+	default:
+		return gortsplib.TransportUDP
+	}
+}
+
+// getStreamInfo reports on the current connection for troubleshooting without debug logging.
+func (rc *rtspCamera) getStreamInfo() (map[string]interface{}, error) {
+	codec := videoCodec(rc.currentCodec.Load())
+	resp := map[string]interface{}{
+		"connected":            codec != Unknown,
+		"address":              rc.redactedURL().String(),
+		"codec":                codec.String(),
+		"rtp_packets_received": rc.metrics.rtpPacketsReceived.Load(),
+		"rtp_packets_lost":     rc.metrics.rtpPacketsLost.Load(),
+	}
+	if configured, streaming := rc.substreamState(); configured {
+		resp["substream"] = streaming
+	}
+	if state, since := rc.health.snapshot(); state != "" {
+		resp["state"] = string(state)
+		resp["state_since"] = since.Format(time.RFC3339Nano)
+	}
+	if transport := rc.transportInUse.Load(); transport != nil && codec != Unknown {
+		resp["transport"] = transport.String()
+	}
+	if info := rc.streamInfo.Load(); info != nil && codec != Unknown {
+		resp["profile"] = info.profile
+		// VP8 & VP9 streams have no level
+		if info.level != "" {
+			resp["level"] = info.level
+		}
+		resp["width"] = info.width
+		resp["height"] = info.height
+		resp["interlaced"] = info.interlaced
+		if info.fps > 0 {
+			resp["fps"] = info.fps
+		}
+	}
+	resp["sinks"] = rc.sinksInfo()
+	if latest := rc.frames.latest(); latest != nil {
+		resp["last_frame_received_at"] = latest.receivedAt.Format(time.RFC3339Nano)
+		resp["last_frame_captured_at"] = latest.capturedAt.Format(time.RFC3339Nano)
+	}
+	return resp, nil
+}
@@ -0,0 +1,73 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"go.viam.com/test"
+)
+
+func TestLevelName(t *testing.T) {
+	test.That(t, levelName(41, 10), test.ShouldEqual, "4.1")
+	test.That(t, levelName(40, 10), test.ShouldEqual, "4")
+	test.That(t, levelName(123, 30), test.ShouldEqual, "4.1")
+	test.That(t, levelName(150, 30), test.ShouldEqual, "5")
+	test.That(t, profileName(h265Profiles, 9), test.ShouldEqual, "unknown (9)")
+	test.That(t, h264StreamInfo(nil), test.ShouldBeNil)
+	test.That(t, h265StreamInfo([]byte{0x42}), test.ShouldBeNil)
+}
+
+func TestH264StreamInfoInterlaced(t *testing.T) {
+	// a 720x576 Main profile SPS of a stream coded with MBAFF
+	info := h264StreamInfo([]byte{0x67, 0x4d, 0x00, 0x1e, 0xda, 0x02, 0xd0, 0x93, 0x20})
+	test.That(t, info, test.ShouldNotBeNil)
+	test.That(t, info.profile, test.ShouldEqual, "Main")
+	test.That(t, info.width, test.ShouldEqual, 720)
+	test.That(t, info.height, test.ShouldEqual, 576)
+	test.That(t, info.interlaced, test.ShouldBeTrue)
+}
+
+func TestAV1StreamInfo(t *testing.T) {
+	// a 1920x804 sequence header OBU sent by Chrome over WebRTC
+	info := av1StreamInfo([]byte{8, 0, 0, 0, 66, 167, 191, 228, 96, 13, 0, 64})
+	test.That(t, info, test.ShouldNotBeNil)
+	test.That(t, info.profile, test.ShouldEqual, "Main")
+	test.That(t, info.level, test.ShouldEqual, "4.0")
+	test.That(t, info.width, test.ShouldEqual, 1920)
+	test.That(t, info.height, test.ShouldEqual, 804)
+	// a temporal delimiter OBU
+	test.That(t, av1StreamInfo([]byte{0x12, 0x00}), test.ShouldBeNil)
+	test.That(t, av1StreamInfo(nil), test.ShouldBeNil)
+}
+
+func TestVPXStreamInfo(t *testing.T) {
+	// the header of a 640x480 VP8 keyframe
+	info := vp8StreamInfo([]byte{0x50, 0x42, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01})
+	test.That(t, info, test.ShouldNotBeNil)
+	test.That(t, info.profile, test.ShouldEqual, "Profile 0")
+	test.That(t, info.width, test.ShouldEqual, 640)
+	test.That(t, info.height, test.ShouldEqual, 480)
+	// interframes don't carry the resolution
+	test.That(t, vp8StreamInfo([]byte{0x51, 0x42, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}), test.ShouldBeNil)
+	test.That(t, vp8StreamInfo(nil), test.ShouldBeNil)
+
+	// the header of a 1920x804 VP9 keyframe sent by Chrome over WebRTC
+	info = vp9StreamInfo([]byte{
+		0x82, 0x49, 0x83, 0x42, 0x00, 0x77, 0xf0, 0x32,
+		0x34, 0x30, 0x38, 0x24, 0x1c, 0x19, 0x40, 0x18,
+		0x03, 0x40, 0x5f, 0xb4,
+	})
+	test.That(t, info, test.ShouldNotBeNil)
+	test.That(t, info.profile, test.ShouldEqual, "Profile 0")
+	test.That(t, info.width, test.ShouldEqual, 1920)
+	test.That(t, info.height, test.ShouldEqual, 804)
+	// an interframe
+	test.That(t, vp9StreamInfo([]byte{0x86, 0x00, 0x40, 0x92}), test.ShouldBeNil)
+}
+
+func TestInitialTransport(t *testing.T) {
+	udp := gortsplib.TransportUDPMulticast
+	test.That(t, initialTransport(nil, "rtsp"), test.ShouldEqual, gortsplib.TransportUDP)
+	test.That(t, initialTransport(&udp, "rtsp"), test.ShouldEqual, gortsplib.TransportUDPMulticast)
+	test.That(t, initialTransport(nil, "rtsps"), test.ShouldEqual, gortsplib.TransportTCP)
+}
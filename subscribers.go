@@ -0,0 +1,64 @@
+package viamrtsp
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"go.viam.com/rdk/components/camera/rtppassthrough"
+)
+
+// subscriberStats counts what was sent to an rtp_passthrough subscriber, to debug stalled viewers.
+type subscriberStats struct {
+	createdAt time.Time
+	// unitsDelivered & packetsDelivered count the access units, & the WebRTC packets they were encoded to,
+	// which were passed to the subscriber's callback
+	unitsDelivered   atomic.Uint64
+	packetsDelivered atomic.Uint64
+	// unitsDropped counts the access units dropped as the subscriber's queue was full
+	unitsDropped atomic.Uint64
+}
+
+func newSubscriberStats() *subscriberStats {
+	return &subscriberStats{createdAt: time.Now()}
+}
+
+func (s *subscriberStats) delivered(packets int) {
+	s.unitsDelivered.Add(1)
+	s.packetsDelivered.Add(uint64(packets))
+}
+
+func (s *subscriberStats) dropped(units int) {
+	s.unitsDropped.Add(uint64(units))
+}
+
+// listSubscribers returns the stats of every rtp_passthrough subscriber, oldest first.
+func (rc *rtspCamera) listSubscribers() (map[string]interface{}, error) {
+	type subscriber struct {
+		id     rtppassthrough.SubscriptionID
+		stats  *subscriberStats
+		queued int
+	}
+	rc.subsMu.RLock()
+	subs := make([]subscriber, 0, len(rc.bufAndCBByID))
+	for id, bufAndCB := range rc.bufAndCBByID {
+		subs = append(subs, subscriber{id: id, stats: bufAndCB.stats, queued: bufAndCB.queue.len()})
+	}
+	rc.subsMu.RUnlock()
+
+	slices.SortFunc(subs, func(a, b subscriber) int {
+		return a.stats.createdAt.Compare(b.stats.createdAt)
+	})
+	out := make([]interface{}, 0, len(subs))
+	for _, sub := range subs {
+		out = append(out, map[string]interface{}{
+			"id":                sub.id.String(),
+			"created_at":        sub.stats.createdAt.Format(time.RFC3339Nano),
+			"units_delivered":   sub.stats.unitsDelivered.Load(),
+			"packets_delivered": sub.stats.packetsDelivered.Load(),
+			"units_dropped":     sub.stats.unitsDropped.Load(),
+			"queued_units":      sub.queued,
+		})
+	}
+	return map[string]interface{}{"subscribers": out}, nil
+}
@@ -0,0 +1,65 @@
+package viamrtsp
+
+// passthroughSubscribers returns the number of active rtp_passthrough subscribers.
+func (rc *rtspCamera) passthroughSubscribers() int {
+	rc.subsMu.RLock()
+	defer rc.subsMu.RUnlock()
+	return len(rc.bufAndCBByID)
+}
+
+// wantsSubstream reports whether the substream should be streamed, which it is while no rtp_passthrough subscriber
+// is active, unless streaming it failed. It must be called with uMu held.
+func (rc *rtspCamera) wantsSubstream(subscribers int) bool {
+	return rc.substream != nil && !rc.substreamFailed && subscribers == 0
+}
+
+// selectStream selects the substream or the main stream for the next connection. It's called before connecting.
+func (rc *rtspCamera) selectStream() {
+	subscribers := rc.passthroughSubscribers()
+	rc.uMu.Lock()
+	defer rc.uMu.Unlock()
+	rc.streamingSubstream = rc.wantsSubstream(subscribers)
+}
+
+// streamSwitch reports whether the demand for the main stream changed since the stream was selected, describing
+// the switch for the log, in which case the stream is switched by reconnecting.
+func (rc *rtspCamera) streamSwitch() (string, bool) {
+	subscribers := rc.passthroughSubscribers()
+	rc.uMu.RLock()
+	defer rc.uMu.RUnlock()
+	if rc.substream == nil || rc.wantsSubstream(subscribers) == rc.streamingSubstream {
+		return "", false
+	}
+	if rc.streamingSubstream {
+		return "an rtp_passthrough subscriber is active, switching from the substream to the main stream", true
+	}
+	return "no rtp_passthrough subscriber is active, switching from the main stream to the substream", true
+}
+
+// switchToMainStream switches to the main stream as soon as possible once an rtp_passthrough subscriber subscribed.
+// Switching back to the substream once the subscribers unsubscribed is left to the reconnect worker, so that
+// viewers which resubscribe right away don't make the camera reconnect twice.
+func (rc *rtspCamera) switchToMainStream() {
+	if reason, ok := rc.streamSwitch(); ok && rc.passthroughSubscribers() > 0 {
+		rc.logger.Info(reason)
+		rc.requestReconnect()
+	}
+}
+
+// failoverAddresses returns the number of addresses which can be streamed from, counting the substream while
+// it's streamed, as failing over from it switches to the main stream.
+func (rc *rtspCamera) failoverAddresses() int {
+	rc.uMu.RLock()
+	defer rc.uMu.RUnlock()
+	if rc.streamingSubstream {
+		return len(rc.addresses) + 1
+	}
+	return len(rc.addresses)
+}
+
+// substreamState reports whether a substream is configured & whether it's streamed, for get-stream-info.
+func (rc *rtspCamera) substreamState() (bool, bool) {
+	rc.uMu.RLock()
+	defer rc.uMu.RUnlock()
+	return rc.substream != nil, rc.streamingSubstream
+}
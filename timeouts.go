@@ -0,0 +1,56 @@
+package viamrtsp
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/pkg/errors"
+)
+
+// rtspTimeouts are the timeouts of the RTSP client, zero values use the gortsplib defaults.
+type rtspTimeouts struct {
+	read  time.Duration
+	write time.Duration
+	dial  time.Duration
+}
+
+func newRTSPTimeouts(conf *Config) rtspTimeouts {
+	return rtspTimeouts{
+		read:  secondsToDuration(conf.ReadTimeout),
+		write: secondsToDuration(conf.WriteTimeout),
+		dial:  secondsToDuration(conf.DialTimeout),
+	}
+}
+
+// apply sets the timeouts of client, which must not be started yet.
+func (t rtspTimeouts) apply(client *gortsplib.Client) {
+	client.ReadTimeout = t.read
+	client.WriteTimeout = t.write
+	if t.dial > 0 {
+		dial := client.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		client.DialContext = withDialTimeout(dial, t.dial)
+	}
+}
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// withDialTimeout bounds dial by timeout. gortsplib bounds dials by the read timeout, so that deadline
+// is replaced, while cancelation, i.e. closing the client, still aborts the dial.
+func withDialTimeout(dial dialFunc, timeout time.Duration) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		stop := context.AfterFunc(ctx, func() {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				cancel()
+			}
+		})
+		defer stop()
+		return dial(dialCtx, network, address)
+	}
+}
@@ -0,0 +1,53 @@
+package viamrtsp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"go.viam.com/test"
+)
+
+func TestWithDialTimeout(t *testing.T) {
+	// blockingDial waits until its context is done & reports when that happened
+	blockingDial := func(done chan<- time.Duration) dialFunc {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			start := time.Now()
+			<-ctx.Done()
+			done <- time.Since(start)
+			return nil, ctx.Err()
+		}
+	}
+
+	t.Run("replaces the parent deadline", func(t *testing.T) {
+		done := make(chan time.Duration, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := withDialTimeout(blockingDial(done), 200*time.Millisecond)(ctx, "tcp", "camera:554")
+		test.That(t, err, test.ShouldEqual, context.DeadlineExceeded)
+		test.That(t, <-done, test.ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+	})
+
+	t.Run("cancelation aborts the dial", func(t *testing.T) {
+		done := make(chan time.Duration, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		_, err := withDialTimeout(blockingDial(done), time.Minute)(ctx, "tcp", "camera:554")
+		test.That(t, err, test.ShouldEqual, context.Canceled)
+		test.That(t, <-done, test.ShouldBeLessThan, time.Minute)
+	})
+}
+
+func TestRTSPTimeoutsApply(t *testing.T) {
+	client := &gortsplib.Client{}
+	newRTSPTimeouts(&Config{}).apply(client)
+	test.That(t, client.ReadTimeout, test.ShouldEqual, time.Duration(0))
+	test.That(t, client.DialContext, test.ShouldBeNil)
+
+	newRTSPTimeouts(&Config{ReadTimeout: 30, WriteTimeout: 15, DialTimeout: 20}).apply(client)
+	test.That(t, client.ReadTimeout, test.ShouldEqual, 30*time.Second)
+	test.That(t, client.WriteTimeout, test.ShouldEqual, 15*time.Second)
+	test.That(t, client.DialContext, test.ShouldNotBeNil)
+}
@@ -0,0 +1,61 @@
+package viamrtsp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig configures the TLS connection used for rtsps:// addresses, for cameras
+// behind TLS terminating proxies or with self signed certificates.
+type TLSConfig struct {
+	// CACert is the path to a PEM encoded CA certificate used to verify the server, in addition to the system roots.
+	CACert string `json:"ca_cert,omitempty"`
+	// ClientCert & ClientKey are the paths to a PEM encoded certificate & key used for mutual TLS.
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+	// InsecureSkipVerify disables verification of the server's certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// Validate checks that the TLS config is usable.
+func (c *TLSConfig) Validate(path string) error {
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return fmt.Errorf("invalid tls config for component at path '%s': client_cert and client_key must be set together", path)
+	}
+	if _, err := c.build(); err != nil {
+		return fmt.Errorf("invalid tls config for component at path '%s': %w", path, err)
+	}
+	return nil
+}
+
+// build loads the configured certificates into a crypto/tls config.
+func (c *TLSConfig) build() (*tls.Config, error) {
+	//nolint:gosec
+	conf := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CACert != "" {
+		pem, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading ca_cert")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in ca_cert '%s'", c.CACert)
+		}
+		conf.RootCAs = pool
+	}
+	if c.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client_cert and client_key")
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
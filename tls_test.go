@@ -0,0 +1,86 @@
+package viamrtsp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+// writeSelfSignedCert writes a self signed certificate & its key to dir, returning their paths.
+func writeSelfSignedCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.That(t, err, test.ShouldBeNil)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "camera.local"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	test.That(t, err, test.ShouldBeNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	test.That(t, err, test.ShouldBeNil)
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	test.That(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600), test.ShouldBeNil)
+	test.That(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600), test.ShouldBeNil)
+	return certPath, keyPath
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeSelfSignedCert(t, dir)
+
+	t.Run("build", func(t *testing.T) {
+		conf := &TLSConfig{CACert: certPath, ClientCert: certPath, ClientKey: keyPath, InsecureSkipVerify: true}
+		test.That(t, conf.Validate("path"), test.ShouldBeNil)
+		tlsConf, err := conf.build()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, tlsConf.InsecureSkipVerify, test.ShouldBeTrue)
+		test.That(t, tlsConf.RootCAs, test.ShouldNotBeNil)
+		test.That(t, tlsConf.Certificates, test.ShouldHaveLength, 1)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := (&TLSConfig{ClientCert: certPath}).Validate("path")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "must be set together")
+
+		err = (&TLSConfig{CACert: filepath.Join(dir, "missing.pem")}).Validate("path")
+		test.That(t, err, test.ShouldNotBeNil)
+
+		err = (&TLSConfig{CACert: keyPath}).Validate("path")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "no certificates found")
+	})
+
+	t.Run("rtsp config", func(t *testing.T) {
+		conf := &Config{Address: "rtsps://example.com:322/stream", TLS: &TLSConfig{CACert: certPath}}
+		_, err := conf.Validate("path")
+		test.That(t, err, test.ShouldBeNil)
+
+		conf.Transport = "udp"
+		_, err = conf.Validate("path")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "rtsps only supports tcp")
+
+		conf = &Config{Address: "rtsp://example.com:554/stream", TLS: &TLSConfig{InsecureSkipVerify: true}}
+		_, err = conf.Validate("path")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "requires an rtsps://")
+	})
+}
@@ -0,0 +1,199 @@
+package viamrtsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTokenRefreshInterval = 50 * time.Minute
+	// tokenRefreshMargin is how long before a token expires that we fetch a new one.
+	tokenRefreshMargin = time.Minute
+	tokenFetchTimeout  = 10 * time.Second
+)
+
+// TokenAuthConfig configures a rotating access token that is fetched from an HTTP endpoint
+// and attached to every RTSP request, for cloud brokered streams with short lived credentials.
+type TokenAuthConfig struct {
+	// TokenURL is the HTTP(S) endpoint that returns the token.
+	TokenURL string `json:"token_url"`
+	// RequestHeaders are sent with each request to TokenURL (e.g. an API key for the broker).
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	// QueryParam, if set, appends the token to the RTSP URL as this query string parameter.
+	// Otherwise the token is sent as an `Authorization: Bearer <token>` header, or in Header.
+	QueryParam string `json:"query_param,omitempty"`
+	// Header, if set, is the header the bare token is sent in instead of Authorization, which
+	// streams with credentials use for basic or digest authentication.
+	Header string `json:"header,omitempty"`
+	// RefreshIntervalSec is used when the token endpoint does not return an expiry.
+	RefreshIntervalSec int `json:"refresh_interval_sec,omitempty"`
+}
+
+// Validate checks that the token auth config is usable.
+func (c *TokenAuthConfig) Validate(path string) error {
+	u, err := url.Parse(c.TokenURL)
+	if err != nil {
+		return fmt.Errorf("invalid token_url for component at path '%s': %w", path, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid token_url '%s' for component at path '%s': scheme must be http or https", c.TokenURL, path)
+	}
+	if c.RefreshIntervalSec < 0 {
+		return fmt.Errorf("invalid token_auth refresh_interval_sec for component at path '%s': must not be negative", path)
+	}
+	if c.QueryParam != "" && c.Header != "" {
+		return fmt.Errorf("invalid token_auth for component at path '%s': only one of query_param or header can be set", path)
+	}
+	return nil
+}
+
+// sendsAuthorization returns whether the token is sent in the Authorization header.
+func (c *TokenAuthConfig) sendsAuthorization() bool {
+	return c.QueryParam == "" && (c.Header == "" || strings.EqualFold(c.Header, "Authorization"))
+}
+
+// tokenResponse is the JSON body the token endpoint may return.
+// Endpoints that return a bare token as plain text are also supported.
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// tokenSource fetches and caches a token, refreshing it shortly before it expires.
+type tokenSource struct {
+	conf       TokenAuthConfig
+	httpClient *http.Client
+	now        func() time.Time
+
+	// fetchMu serializes fetches, so that mu is never held while fetching
+	fetchMu sync.Mutex
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newTokenSource(conf TokenAuthConfig) *tokenSource {
+	return &tokenSource{
+		conf:       conf,
+		httpClient: &http.Client{Timeout: tokenFetchTimeout},
+		now:        time.Now,
+	}
+}
+
+// Token returns the cached token, fetching a new one if it is missing or about to expire.
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	if token, ok := ts.fresh(); ok {
+		return token, nil
+	}
+	ts.fetchMu.Lock()
+	defer ts.fetchMu.Unlock()
+	// another caller may have fetched it while this one waited
+	if token, ok := ts.fresh(); ok {
+		return token, nil
+	}
+
+	token, ttl, err := ts.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.token = token
+	ts.expires = ts.now().Add(ttl)
+	return ts.token, nil
+}
+
+// fresh returns the cached token & whether it's not about to expire.
+func (ts *tokenSource) fresh() (string, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.token, ts.token != "" && ts.now().Add(tokenRefreshMargin).Before(ts.expires)
+}
+
+// addHeader is a gortsplib OnRequest callback which adds the cached token to an RTSP request. It never fetches,
+// as OnRequest must not block; the token is fetched before connecting & refreshed by the reconnect worker's
+// OPTIONS requests. An existing header, e.g. the Authorization of digest authentication, is never replaced.
+func (ts *tokenSource) addHeader(req *base.Request) {
+	ts.mu.Lock()
+	token := ts.token
+	ts.mu.Unlock()
+	if token == "" {
+		return
+	}
+	name, value := "Authorization", "Bearer "+token
+	if ts.conf.Header != "" && !strings.EqualFold(ts.conf.Header, "Authorization") {
+		name, value = ts.conf.Header, token
+	}
+	if _, ok := req.Header[name]; ok {
+		return
+	}
+	req.Header[name] = base.HeaderValue{value}
+}
+
+func (ts *tokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.conf.TokenURL, nil)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "creating token request")
+	}
+	for k, v := range ts.conf.RequestHeaders {
+		req.Header.Set(k, v)
+	}
+	res, err := ts.httpClient.Do(req)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "fetching token")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", 0, errors.Errorf("token endpoint responded with status_code: %d", res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if err != nil {
+		return "", 0, errors.Wrap(err, "reading token response")
+	}
+
+	ttl := defaultTokenRefreshInterval
+	if ts.conf.RefreshIntervalSec > 0 {
+		ttl = time.Duration(ts.conf.RefreshIntervalSec) * time.Second
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		// not JSON, treat the whole body as the token
+		token := strings.TrimSpace(string(body))
+		if token == "" {
+			return "", 0, errors.New("token endpoint returned an empty body")
+		}
+		return token, ttl, nil
+	}
+	token := tr.Token
+	if token == "" {
+		token = tr.AccessToken
+	}
+	if token == "" {
+		return "", 0, errors.New("token endpoint response did not contain a token")
+	}
+	if tr.ExpiresIn > 0 {
+		ttl = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	return token, ttl, nil
+}
+
+// applyToURL returns a copy of u with the token set as a query parameter.
+func (ts *tokenSource) applyToURL(u *base.URL, token string) *base.URL {
+	ret := u.Clone()
+	q := (*url.URL)(ret).Query()
+	q.Set(ts.conf.QueryParam, token)
+	ret.RawQuery = q.Encode()
+	return ret
+}
@@ -0,0 +1,99 @@
+package viamrtsp
+
+import (
+	"fmt"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pkg/errors"
+)
+
+// VideoTrackConfig selects one of the video tracks of a stream which has several, e.g. the main
+// & sub streams of a multi-profile camera. Index takes precedence over the resolution.
+type VideoTrackConfig struct {
+	// Index is the position of the track among the video tracks of the SDP, starting at 0.
+	Index *int `json:"index,omitempty"`
+	// Width & Height select the H264 or H265 track with this resolution.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// Validate checks that the video track config selects a track.
+func (c *VideoTrackConfig) Validate(path string) error {
+	if c.Index != nil {
+		if *c.Index < 0 {
+			return fmt.Errorf("invalid video_track index %d for component at path '%s': must not be negative", *c.Index, path)
+		}
+		return nil
+	}
+	if c.Width <= 0 || c.Height <= 0 {
+		return fmt.Errorf("video_track for component at path '%s' requires an index or a positive width & height", path)
+	}
+	return nil
+}
+
+// selectVideoTrack returns session without the video tracks other than the one selected by conf,
+// so that the selected track is the one set up. The session is returned unchanged if conf is nil.
+func selectVideoTrack(session *description.Session, conf *VideoTrackConfig) (*description.Session, error) {
+	if conf == nil {
+		return session, nil
+	}
+	var videoMedias []*description.Media
+	for _, media := range session.Medias {
+		if media.Type == description.MediaTypeVideo {
+			videoMedias = append(videoMedias, media)
+		}
+	}
+
+	var selected *description.Media
+	if conf.Index != nil {
+		if *conf.Index >= len(videoMedias) {
+			return nil, errors.Errorf("video_track index %d not found, the stream has %d video tracks", *conf.Index, len(videoMedias))
+		}
+		selected = videoMedias[*conf.Index]
+	} else {
+		var resolutions []string
+		for _, media := range videoMedias {
+			info := mediaStreamInfo(media)
+			if info == nil {
+				continue
+			}
+			if info.width == conf.Width && info.height == conf.Height {
+				selected = media
+				break
+			}
+			resolutions = append(resolutions, fmt.Sprintf("%dx%d", info.width, info.height))
+		}
+		if selected == nil {
+			return nil, errors.Errorf("no video track with resolution %dx%d found, available resolutions: %v",
+				conf.Width, conf.Height, resolutions)
+		}
+	}
+
+	filtered := *session
+	filtered.Medias = nil
+	for _, media := range session.Medias {
+		if media.Type != description.MediaTypeVideo || media == selected {
+			filtered.Medias = append(filtered.Medias, media)
+		}
+	}
+	return &filtered, nil
+}
+
+// mediaStreamInfo returns the stream info of the first H264 or H265 format of media whose SPS is
+// in the SDP, or nil if there is none.
+func mediaStreamInfo(media *description.Media) *streamInfo {
+	for _, f := range media.Formats {
+		var info *streamInfo
+		switch f := f.(type) {
+		case *format.H264:
+			info = h264StreamInfo(f.SPS)
+		case *format.H265:
+			info = h265StreamInfo(f.SPS)
+		}
+		if info != nil {
+			return info
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"go.viam.com/test"
+)
+
+func TestSelectVideoTrack(t *testing.T) {
+	// SPS of a 1280x720 stream
+	sps720p := []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+		0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+		0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+		0xcb,
+	}
+	// SPS of a 352x288 stream
+	spsCIF := []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	main := &description.Media{
+		Type:    description.MediaTypeVideo,
+		Formats: []format.Format{&format.H264{PayloadTyp: 96, SPS: sps720p, PacketizationMode: 1}},
+	}
+	sub := &description.Media{
+		Type:    description.MediaTypeVideo,
+		Formats: []format.Format{&format.H264{PayloadTyp: 97, SPS: spsCIF, PacketizationMode: 1}},
+	}
+	audio := &description.Media{
+		Type:    description.MediaTypeAudio,
+		Formats: []format.Format{&format.G711{PayloadTyp: 0, MULaw: true, SampleRate: 8000, ChannelCount: 1}},
+	}
+	session := &description.Session{Medias: []*description.Media{main, audio, sub}}
+	index := func(i int) *int { return &i }
+
+	selected, err := selectVideoTrack(session, nil)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, selected, test.ShouldEqual, session)
+
+	selected, err = selectVideoTrack(session, &VideoTrackConfig{Index: index(1)})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, selected.Medias, test.ShouldResemble, []*description.Media{audio, sub})
+	// the session is not modified
+	test.That(t, session.Medias, test.ShouldHaveLength, 3)
+
+	selected, err = selectVideoTrack(session, &VideoTrackConfig{Width: 1280, Height: 720})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, selected.Medias, test.ShouldResemble, []*description.Media{main, audio})
+
+	_, err = selectVideoTrack(session, &VideoTrackConfig{Index: index(2)})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "the stream has 2 video tracks")
+
+	_, err = selectVideoTrack(session, &VideoTrackConfig{Width: 640, Height: 480})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "[1280x720 352x288]")
+}
+
+func TestVideoTrackConfigValidate(t *testing.T) {
+	index := -1
+	test.That(t, (&VideoTrackConfig{Index: &index}).Validate("path"), test.ShouldNotBeNil)
+	index = 0
+	test.That(t, (&VideoTrackConfig{Index: &index}).Validate("path"), test.ShouldBeNil)
+	test.That(t, (&VideoTrackConfig{Width: 640, Height: 480}).Validate("path"), test.ShouldBeNil)
+	test.That(t, (&VideoTrackConfig{Width: 640}).Validate("path"), test.ShouldNotBeNil)
+}
@@ -0,0 +1,313 @@
+package viamrtsp
+
+/*
+#cgo pkg-config: libavcodec libavutil libswscale
+#include <libavcodec/avcodec.h>
+#include <libavutil/opt.h>
+#include <libswscale/swscale.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"slices"
+	"time"
+	"unsafe"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/erh/viamrtsp/formatprocessor"
+	"github.com/pion/rtp"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
+)
+
+const (
+	defaultTranscodeBitrateKbps = 2000
+	defaultTranscodePreset      = "veryfast"
+	// transcodeMaxGOP is the most frames between the keyframes of the H264 stream, which are otherwise
+	// encoded whenever the H265 stream has a keyframe.
+	transcodeMaxGOP = 300
+)
+
+// x264Presets are the supported values of the transcode preset, from the fastest to the most efficient.
+var x264Presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+
+// transcodeTimeBase is the time base frames are encoded in, which is the RTP clock rate of H264.
+var transcodeTimeBase = C.AVRational{num: 1, den: 90000}
+
+// TranscodeConfig configures transcoding H265 streams to H264, so that they can be served to rtp_passthrough
+// subscribers, which only support H264.
+type TranscodeConfig struct {
+	// BitrateKbps is the average bitrate of the H264 stream, defaulting to 2000 kbps.
+	BitrateKbps int `json:"bitrate_kbps,omitempty"`
+	// Preset is an x264 preset, which trades CPU usage for quality at the bitrate, defaulting to veryfast.
+	Preset string `json:"preset,omitempty"`
+}
+
+// Validate checks that the transcode config is usable.
+func (c *TranscodeConfig) Validate(path string) error {
+	if c.BitrateKbps < 0 {
+		return fmt.Errorf("invalid transcode bitrate_kbps %d for component at path '%s': must not be negative", c.BitrateKbps, path)
+	}
+	if c.Preset != "" && !slices.Contains(x264Presets, c.Preset) {
+		return fmt.Errorf("invalid transcode preset '%s' for component at path '%s': must be one of %v", c.Preset, path, x264Presets)
+	}
+	return nil
+}
+
+func (c *TranscodeConfig) bitrate() int {
+	if c.BitrateKbps == 0 {
+		return defaultTranscodeBitrateKbps * 1000
+	}
+	return c.BitrateKbps * 1000
+}
+
+func (c *TranscodeConfig) preset() string {
+	if c.Preset == "" {
+		return defaultTranscodePreset
+	}
+	return c.Preset
+}
+
+// h264Encoder encodes decoded frames to H264 with libx264, tuned for latency & without B-frames, which
+// WebRTC doesn't support. The encoder is opened for the size of the first frame & reopened if it changes.
+type h264Encoder struct {
+	logger  logging.Logger
+	bitrate int
+	preset  string
+	codec   *C.AVCodec
+	ctx     *C.AVCodecContext
+	// frame holds the decoded frames converted to YUV 4:2:0, the input format of the encoder
+	frame  *C.AVFrame
+	swsCtx *C.struct_SwsContext
+	packet *C.AVPacket
+}
+
+// newH264Encoder returns an encoder using the bitrate & preset of conf.
+func newH264Encoder(conf *TranscodeConfig, logger logging.Logger) (*h264Encoder, error) {
+	name := C.CString("libx264")
+	defer C.free(unsafe.Pointer(name))
+	codec := C.avcodec_find_encoder_by_name(name)
+	if codec == nil {
+		return nil, errors.New("encoder libx264 not found, FFmpeg must be built with --enable-libx264 to transcode")
+	}
+	e := &h264Encoder{logger: logger, bitrate: conf.bitrate(), preset: conf.preset(), codec: codec}
+	e.packet = C.av_packet_alloc()
+	if e.packet == nil {
+		return nil, errors.New("av_packet_alloc() failed")
+	}
+	return e, nil
+}
+
+// open opens the encoder for frames of width & height.
+func (e *h264Encoder) open(width, height C.int) error {
+	e.closeCodec()
+	e.ctx = C.avcodec_alloc_context3(e.codec)
+	if e.ctx == nil {
+		return errors.New("avcodec_alloc_context3() failed")
+	}
+	e.ctx.width = width
+	e.ctx.height = height
+	e.ctx.pix_fmt = C.AV_PIX_FMT_YUV420P
+	e.ctx.time_base = transcodeTimeBase
+	e.ctx.bit_rate = C.int64_t(e.bitrate)
+	e.ctx.gop_size = transcodeMaxGOP
+	e.ctx.max_b_frames = 0
+	// zerolatency disables B-frames & lookahead, forced-idr makes the forced keyframes IDRs, which new subscribers start at
+	for _, option := range [][2]string{{"preset", e.preset}, {"tune", "zerolatency"}, {"forced-idr", "1"}} {
+		if err := e.setOption(option[0], option[1]); err != nil {
+			return err
+		}
+	}
+	if res := C.avcodec_open2(e.ctx, e.codec, nil); res < 0 {
+		return errors.Errorf("avcodec_open2() failed: %s", avError(res))
+	}
+
+	e.frame = C.av_frame_alloc()
+	if e.frame == nil {
+		return errors.New("av_frame_alloc() failed")
+	}
+	e.frame.format = C.AV_PIX_FMT_YUV420P
+	e.frame.width = width
+	e.frame.height = height
+	if res := C.av_frame_get_buffer(e.frame, 0); res < 0 {
+		return errors.Errorf("av_frame_get_buffer() failed: %s", avError(res))
+	}
+	e.logger.Infof("transcoding to %dx%d H264 at %d kbps with the %s preset", width, height, e.bitrate/1000, e.preset)
+	return nil
+}
+
+func (e *h264Encoder) setOption(key, value string) error {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	if res := C.av_opt_set(e.ctx.priv_data, cKey, cValue, 0); res < 0 {
+		return errors.Errorf("setting libx264 option %s to %s failed: %s", key, value, avError(res))
+	}
+	return nil
+}
+
+// encode encodes src, whose presentation timestamp is pts in transcodeTimeBase, forcing a keyframe if keyframe
+// is set. It returns the Annex-B access unit of the frame & whether it is a keyframe, or nil if the encoder
+// buffered the frame.
+func (e *h264Encoder) encode(src *C.AVFrame, pts int64, keyframe bool) ([]byte, bool, error) {
+	if e.ctx == nil || e.ctx.width != src.width || e.ctx.height != src.height {
+		if err := e.open(src.width, src.height); err != nil {
+			e.closeCodec()
+			return nil, false, err
+		}
+	}
+	// the encoder may still reference the previous frame
+	if res := C.av_frame_make_writable(e.frame); res < 0 {
+		return nil, false, errors.Errorf("av_frame_make_writable() failed: %s", avError(res))
+	}
+	e.swsCtx = C.sws_getCachedContext(e.swsCtx, src.width, src.height, (int32)(src.format),
+		e.frame.width, e.frame.height, (int32)(e.frame.format), C.SWS_BILINEAR, nil, nil, nil)
+	if e.swsCtx == nil {
+		return nil, false, errors.New("sws_getCachedContext() failed")
+	}
+	if res := C.sws_scale(e.swsCtx, frameData(src), frameLineSize(src), 0, src.height,
+		frameData(e.frame), frameLineSize(e.frame)); res < 0 {
+		return nil, false, errors.New("sws_scale() failed")
+	}
+	e.frame.pts = C.int64_t(pts)
+	e.frame.pict_type = C.AV_PICTURE_TYPE_NONE
+	if keyframe {
+		e.frame.pict_type = C.AV_PICTURE_TYPE_I
+	}
+
+	if res := C.avcodec_send_frame(e.ctx, e.frame); res < 0 {
+		return nil, false, errors.Errorf("avcodec_send_frame() failed: %s", avError(res))
+	}
+	var au []byte
+	var encodedKeyframe bool
+	for C.avcodec_receive_packet(e.ctx, e.packet) >= 0 {
+		au = append(au, C.GoBytes(unsafe.Pointer(e.packet.data), e.packet.size)...)
+		encodedKeyframe = encodedKeyframe || e.packet.flags&C.AV_PKT_FLAG_KEY != 0
+		C.av_packet_unref(e.packet)
+	}
+	return au, encodedKeyframe, nil
+}
+
+func (e *h264Encoder) closeCodec() {
+	if e.ctx != nil {
+		C.avcodec_free_context(&e.ctx)
+	}
+	if e.frame != nil {
+		C.av_frame_free(&e.frame)
+	}
+	if e.swsCtx != nil {
+		C.sws_freeContext(e.swsCtx)
+		e.swsCtx = nil
+	}
+}
+
+// close closes the encoder.
+func (e *h264Encoder) close() {
+	e.closeCodec()
+	if e.packet != nil {
+		C.av_packet_free(&e.packet)
+	}
+}
+
+// newH265TranscodeSink decodes the access units of the H265 track f & re-encodes them to H264 for the
+// rtp_passthrough subscribers. The track is decoded separately from the decoder sink, so that the
+// subscribers receive every frame regardless of lazy_decode, max_decode_fps or the image requests.
+func (rc *rtspCamera) newH265TranscodeSink(f *format.H265, media *description.Media) (*videoSink, error) {
+	rc.passthroughGOP.reset()
+	fp, err := formatprocessor.New(1472, &format.H264{PayloadTyp: 96, PacketizationMode: 1}, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create new h264 rtp formatprocessor")
+	}
+	d, err := newH265Decoder(rc.hardwareDecode, rc.logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating H265 raw decoder")
+	}
+	d.encoder, err = newH264Encoder(rc.transcode.Load(), rc.logger)
+	if err != nil {
+		d.close()
+		return nil, err
+	}
+	for _, params := range [][]byte{f.VPS, f.SPS, f.PPS} {
+		if params != nil {
+			//nolint:gosec
+			d.decode(params, 0)
+		}
+	}
+
+	resolution := newResolutionWatcher(H265, f.SPS)
+	var firstPTS int64
+	var started bool
+	worker := rc.startDecodeWorker(d, func(d *decoder, au [][]byte, capturedAt time.Time) {
+		if rc.passthroughCtx().Err() != nil {
+			return
+		}
+		if _, changed := resolution.update(au); changed {
+			if err := d.reset(); err != nil {
+				rc.logger.Warnf("unable to reinitialize the transcode decoder, reconnecting, err: %s", err)
+				rc.requestReconnect()
+				return
+			}
+		}
+		bitstream, err := h264.AnnexBMarshal(au)
+		if err != nil {
+			rc.decodeErrors.record(decodeErrorH265, err, time.Now())
+			return
+		}
+		frame, pts, err := d.decodeFrame(bitstream, capturedAt.UnixNano())
+		if err != nil {
+			rc.decodeErrors.record(decodeErrorH265, err, time.Now())
+			return
+		}
+		if frame == nil {
+			return
+		}
+		if !started {
+			firstPTS, started = pts, true
+		}
+		elapsed := time.Duration(pts - firstPTS)
+		// the H264 stream has keyframes wherever the H265 stream has them. Frames transferred from hardware
+		// may not keep the flags of the decoded frame.
+		forceKeyframe := d.srcFrame.flags&C.AV_FRAME_FLAG_KEY != 0
+		// the elapsed time in transcodeTimeBase
+		encoded, keyframe, err := d.encoder.encode(frame, elapsed.Microseconds()*9/100, forceKeyframe)
+		if err != nil {
+			rc.decodeErrors.record(decodeErrorTranscode, err, time.Now())
+			return
+		}
+		if encoded == nil {
+			return
+		}
+		h264AU, err := h264.AnnexBUnmarshal(encoded)
+		if err != nil {
+			rc.decodeErrors.record(decodeErrorTranscode, err, time.Now())
+			return
+		}
+		u := &formatprocessor.H264{Base: formatprocessor.Base{NTP: time.Unix(0, pts), PTS: elapsed}, AU: h264AU}
+		if err := fp.ProcessUnit(u); err != nil {
+			rc.decodeErrors.record(decodeErrorTranscode, err, time.Now())
+			return
+		}
+		rc.passthroughGOP.add(u)
+		rc.publishPassthrough(u, keyframe)
+	})
+
+	return &videoSink{
+		accessUnit: func(au [][]byte, pkt *rtp.Packet, keyframe bool) {
+			if rc.passthroughCtx().Err() != nil {
+				return
+			}
+			if !worker.submit(au, rc.packetTime(media, pkt), keyframe) {
+				rc.metrics.decodeQueueDrops.Add(1)
+			}
+		},
+		close: func() {
+			worker.stop()
+			rc.passthroughGOP.reset()
+		},
+	}, nil
+}
@@ -0,0 +1,18 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestTranscodeConfig(t *testing.T) {
+	conf := &TranscodeConfig{}
+	test.That(t, conf.bitrate(), test.ShouldEqual, 2000000)
+	test.That(t, conf.preset(), test.ShouldEqual, "veryfast")
+
+	conf = &TranscodeConfig{BitrateKbps: 500, Preset: "ultrafast"}
+	test.That(t, conf.Validate("path"), test.ShouldBeNil)
+	test.That(t, conf.bitrate(), test.ShouldEqual, 500000)
+	test.That(t, conf.preset(), test.ShouldEqual, "ultrafast")
+}
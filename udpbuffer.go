@@ -0,0 +1,106 @@
+package viamrtsp
+
+import (
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// udpReadBuffers resizes the kernel receive buffers of the UDP sockets the RTSP client reads packets from.
+// gortsplib sets them to 512KiB as it opens them, which high bitrate streams overflow, dropping packets,
+// so they're recorded as they're opened & resized once the tracks are set up.
+type udpReadBuffers struct {
+	size int
+	// listen opens the sockets, nil means net.ListenPacket
+	listen listenPacketFunc
+
+	mu    sync.Mutex
+	conns []*net.UDPConn
+}
+
+// listenPacket is the ListenPacket of the RTSP client, recording the UDP sockets it opens.
+func (b *udpReadBuffers) listenPacket(network, address string) (net.PacketConn, error) {
+	listen := b.listen
+	if listen == nil {
+		listen = net.ListenPacket
+	}
+	pc, err := listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if conn, ok := pc.(*net.UDPConn); ok {
+		b.mu.Lock()
+		b.conns = append(b.conns, conn)
+		b.mu.Unlock()
+	}
+	return pc, nil
+}
+
+// apply resizes the receive buffers of the sockets opened since the last call, skipping those which were
+// closed already. It returns the effective size of the buffers, which the kernel may cap, or 0 if no socket
+// was resized, e.g. with the TCP transport.
+func (b *udpReadBuffers) apply() (int, error) {
+	b.mu.Lock()
+	conns := b.conns
+	b.conns = nil
+	b.mu.Unlock()
+
+	var effective int
+	for _, conn := range conns {
+		if err := conn.SetReadBuffer(b.size); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				continue
+			}
+			return 0, errors.Wrap(err, "setting udp read buffer")
+		}
+		size, err := readBufferSize(conn)
+		if err != nil {
+			return 0, err
+		}
+		if effective == 0 || size < effective {
+			effective = size
+		}
+	}
+	return effective, nil
+}
+
+// readBufferSize returns the size of the kernel receive buffer of conn. Linux reports double the size
+// which was set, to account for its bookkeeping overhead.
+func readBufferSize(conn *net.UDPConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, errors.Wrap(err, "getting udp socket")
+	}
+	var size int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		size, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	}); err != nil {
+		return 0, errors.Wrap(err, "getting udp socket")
+	}
+	if sockErr != nil {
+		return 0, errors.Wrap(sockErr, "getting udp read buffer size")
+	}
+	return size, nil
+}
+
+// applyUDPReadBuffer resizes the receive buffers of the UDP sockets recorded by buffers, once the client set up
+// its tracks, logging their effective size so that a cap of the kernel can be spotted. A nil buffers does nothing.
+func (rc *rtspCamera) applyUDPReadBuffer(buffers *udpReadBuffers) {
+	if buffers == nil {
+		return
+	}
+	effective, err := buffers.apply()
+	switch {
+	case err != nil:
+		rc.logger.Warnf("unable to set udp_read_buffer_bytes to %d: %s", buffers.size, err)
+	case effective == 0:
+	case effective < buffers.size:
+		rc.logger.Warnf("the kernel capped the udp read buffer at %d bytes rather than the %d bytes of udp_read_buffer_bytes, "+
+			"raise its maximum, e.g. net.core.rmem_max on linux", effective, buffers.size)
+	default:
+		rc.logger.Infof("udp read buffer set to %d bytes", effective)
+	}
+}
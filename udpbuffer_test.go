@@ -0,0 +1,29 @@
+package viamrtsp
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestUDPReadBuffers(t *testing.T) {
+	buffers := &udpReadBuffers{size: 1 << 20}
+
+	// nothing to resize, e.g. with the TCP transport
+	effective, err := buffers.apply()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, effective, test.ShouldEqual, 0)
+
+	rtp, err := buffers.listenPacket("udp", "127.0.0.1:0")
+	test.That(t, err, test.ShouldBeNil)
+	defer rtp.Close()
+	rtcp, err := buffers.listenPacket("udp", "127.0.0.1:0")
+	test.That(t, err, test.ShouldBeNil)
+	// closed sockets, e.g. of ports which were taken, are skipped
+	test.That(t, rtcp.Close(), test.ShouldBeNil)
+
+	effective, err = buffers.apply()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, effective, test.ShouldBeGreaterThan, 0)
+	test.That(t, buffers.conns, test.ShouldBeEmpty)
+}
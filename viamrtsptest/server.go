@@ -0,0 +1,182 @@
+// Package viamrtsptest provides an RTSP server which publishes canned H264, H265 & MJPEG streams, so that
+// RTSP clients, e.g. the viamrtsp cameras, can be tested without real cameras.
+package viamrtsptest
+
+import (
+	"image"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/rtptime"
+	"github.com/pkg/errors"
+)
+
+// Codec is the codec of a canned stream.
+type Codec string
+
+// The codecs of the canned streams.
+const (
+	H264  Codec = "h264"
+	H265  Codec = "h265"
+	MJPEG Codec = "mjpeg"
+)
+
+// FrameInterval is how often a Server publishes a frame.
+const FrameInterval = 200 * time.Millisecond
+
+// Server is an RTSP server which publishes a canned stream on any path, repeating the same keyframe
+// every FrameInterval, so that the images decoded from it are deterministic. Readers are served over TCP.
+type Server struct {
+	fixture *fixture
+	media   *description.Media
+	server  *gortsplib.Server
+	addr    string
+	rtpTime *rtptime.Encoder
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	stream *gortsplib.ServerStream
+	plays  int
+}
+
+// NewServer starts a Server publishing a stream of codec on address, e.g. "127.0.0.1:0" to listen on
+// any free port.
+func NewServer(codec Codec, address string) (*Server, error) {
+	f, err := newFixture(codec)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		fixture: f,
+		media:   &description.Media{Type: description.MediaTypeVideo, Formats: []format.Format{f.forma}},
+		rtpTime: &rtptime.Encoder{ClockRate: f.forma.ClockRate()},
+		done:    make(chan struct{}),
+	}
+	if err := s.rtpTime.Initialize(); err != nil {
+		return nil, err
+	}
+	s.server = &gortsplib.Server{
+		Handler:     s,
+		RTSPAddress: address,
+		Listen: func(network, address string) (net.Listener, error) {
+			l, err := net.Listen(network, address)
+			if err != nil {
+				return nil, err
+			}
+			s.addr = l.Addr().String()
+			return l, nil
+		},
+	}
+	if err := s.server.Start(); err != nil {
+		return nil, errors.Wrapf(err, "unable to start test server on %s", address)
+	}
+	s.wg.Add(1)
+	go s.publish()
+	return s, nil
+}
+
+// URL returns the URL of the stream.
+func (s *Server) URL() string {
+	return "rtsp://" + s.addr + "/stream"
+}
+
+// Address returns the address the server listens on.
+func (s *Server) Address() string {
+	return s.addr
+}
+
+// Size returns the size of the frames decoded from the stream.
+func (s *Server) Size() image.Point {
+	return s.fixture.size
+}
+
+// Plays returns how many times the stream was played, e.g. to check that a client reconnected.
+func (s *Server) Plays() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.plays
+}
+
+// Disconnect disconnects every reader of the stream, e.g. to test reconnects. The stream can be played again afterwards.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream != nil {
+		s.stream.Close()
+		s.stream = nil
+	}
+}
+
+// Close disconnects every reader & stops the server.
+func (s *Server) Close() {
+	close(s.done)
+	s.wg.Wait()
+	s.Disconnect()
+	s.server.Close()
+	//nolint:errcheck
+	s.server.Wait()
+}
+
+// publish writes a frame to the readers of the stream every FrameInterval until the server is closed.
+func (s *Server) publish() {
+	defer s.wg.Done()
+	start := time.Now()
+	ticker := time.NewTicker(FrameInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		pkts, err := s.fixture.encode()
+		if err != nil {
+			// the same keyframe is encoded every time, so this doesn't happen unless its first encode failed too
+			continue
+		}
+		ts := s.rtpTime.Encode(time.Since(start))
+		s.mu.Lock()
+		if s.stream != nil {
+			for _, pkt := range pkts {
+				pkt.Timestamp = ts
+				//nolint:errcheck
+				s.stream.WritePacketRTP(s.media, pkt)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// currentStream returns the stream, which is created by the first DESCRIBE request after it was disconnected.
+func (s *Server) currentStream() *gortsplib.ServerStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream == nil {
+		s.stream = gortsplib.NewServerStream(s.server, &description.Session{Medias: []*description.Media{s.media}})
+	}
+	return s.stream
+}
+
+// OnDescribe implements gortsplib.ServerHandlerOnDescribe.
+func (s *Server) OnDescribe(_ *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	return &base.Response{StatusCode: base.StatusOK}, s.currentStream(), nil
+}
+
+// OnSetup implements gortsplib.ServerHandlerOnSetup.
+func (s *Server) OnSetup(_ *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	return &base.Response{StatusCode: base.StatusOK}, s.currentStream(), nil
+}
+
+// OnPlay implements gortsplib.ServerHandlerOnPlay.
+func (s *Server) OnPlay(_ *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.plays++
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
@@ -0,0 +1,77 @@
+package viamrtsptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"go.viam.com/test"
+)
+
+func TestServer(t *testing.T) {
+	_, err := NewServer("vp8", "127.0.0.1:0")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	for _, codec := range []Codec{H264, H265, MJPEG} {
+		t.Run(string(codec), func(t *testing.T) {
+			s, err := NewServer(codec, "127.0.0.1:0")
+			test.That(t, err, test.ShouldBeNil)
+			defer s.Close()
+			test.That(t, s.Size().X, test.ShouldEqual, 480)
+
+			u, err := base.ParseURL(s.URL())
+			test.That(t, err, test.ShouldBeNil)
+			transport := gortsplib.TransportTCP
+			c := &gortsplib.Client{Transport: &transport}
+			test.That(t, c.Start(u.Scheme, u.Host), test.ShouldBeNil)
+			defer c.Close()
+			desc, _, err := c.Describe(u)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, desc.Medias, test.ShouldHaveLength, 1)
+			test.That(t, desc.Medias[0].Formats[0].Codec(), test.ShouldEqual, s.fixture.forma.Codec())
+			test.That(t, c.SetupAll(desc.BaseURL, desc.Medias), test.ShouldBeNil)
+			received := make(chan *rtp.Packet, 1)
+			c.OnPacketRTPAny(func(_ *description.Media, _ format.Format, pkt *rtp.Packet) {
+				select {
+				case received <- pkt:
+				default:
+				}
+			})
+			_, err = c.Play(nil)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, s.Plays(), test.ShouldEqual, 1)
+
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for packets")
+			}
+
+			// readers are disconnected
+			s.Disconnect()
+			errCh := make(chan error, 1)
+			go func() { errCh <- c.Wait() }()
+			select {
+			case err := <-errCh:
+				test.That(t, err, test.ShouldNotBeNil)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for reader to be disconnected")
+			}
+		})
+	}
+}
+
+func TestAccessUnit(t *testing.T) {
+	for _, codec := range []Codec{H264, H265} {
+		au, err := AccessUnit(codec)
+		test.That(t, err, test.ShouldBeNil)
+		// the parameter sets are followed by the keyframe
+		test.That(t, len(au), test.ShouldBeGreaterThanOrEqualTo, 3)
+	}
+	_, err := AccessUnit(MJPEG)
+	test.That(t, err, test.ShouldNotBeNil)
+}
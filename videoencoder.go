@@ -0,0 +1,303 @@
+package viamrtsp
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// xmlNode is an XML element decoded without a schema, so that a configuration read from the camera can be changed
+// & sent back with the elements this package doesn't know about, which ONVIF set requests must include.
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []*xmlNode `xml:",any"`
+}
+
+// find returns the descendant of n at the path of local element names, or nil.
+func (n *xmlNode) find(path ...string) *xmlNode {
+	if len(path) == 0 {
+		return n
+	}
+	for _, child := range n.Nodes {
+		if child.XMLName.Local == path[0] {
+			return child.find(path[1:]...)
+		}
+	}
+	return nil
+}
+
+// text returns the trimmed text of the descendant of n at path, or "".
+func (n *xmlNode) text(path ...string) string {
+	if node := n.find(path...); node != nil {
+		return strings.TrimSpace(node.Text)
+	}
+	return ""
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) setAttr(name, value string) {
+	for i, a := range n.Attrs {
+		if a.Name.Local == name {
+			n.Attrs[i].Value = value
+			return
+		}
+	}
+	n.Attrs = append(n.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+}
+
+// marshal returns n as the element name of namespace, dropping the namespace declarations of the response it was
+// decoded from, as the encoder declares the namespace of each element.
+func (n *xmlNode) marshal(namespace, name string) (string, error) {
+	var strip func(n *xmlNode)
+	strip = func(n *xmlNode) {
+		attrs := n.Attrs[:0]
+		for _, a := range n.Attrs {
+			if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+				attrs = append(attrs, a)
+			}
+		}
+		n.Attrs = attrs
+		if len(n.Nodes) > 0 {
+			n.Text = ""
+		}
+		for _, child := range n.Nodes {
+			strip(child)
+		}
+	}
+	strip(n)
+	n.XMLName = xml.Name{Space: namespace, Local: name}
+	data, err := xml.Marshal(n)
+	return string(data), err
+}
+
+// videoEncoder is the video encoder configuration of an ONVIF profile. Media configurations have the GOP length &
+// H264 profile in the H264 element, Media2 configurations in the GovLength & Profile attributes.
+type videoEncoder struct {
+	config *xmlNode
+	media2 bool
+}
+
+// toMap returns the configuration as the response of the get-video-encoder command.
+func (e videoEncoder) toMap() map[string]interface{} {
+	m := map[string]interface{}{"token": e.config.attr("token")}
+	for _, field := range videoEncoderFields {
+		value := e.config.text(field.path...)
+		if attr, ok := videoEncoder2Attrs[field.key]; ok && e.media2 {
+			value = e.config.attr(attr)
+		}
+		if value == "" {
+			continue
+		}
+		if field.key == "encoding" || field.key == "profile" {
+			m[field.key] = value
+		} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+			m[field.key] = f
+		}
+	}
+	return m
+}
+
+// videoEncoderFields are the fields of the set-video-encoder command & the path of their elements in Media
+// configurations. encoding & profile are strings, the others integers.
+var videoEncoderFields = []struct {
+	key  string
+	path []string
+}{
+	{"encoding", []string{"Encoding"}},
+	{"width", []string{"Resolution", "Width"}},
+	{"height", []string{"Resolution", "Height"}},
+	{"quality", []string{"Quality"}},
+	{"frame_rate", []string{"RateControl", "FrameRateLimit"}},
+	{"bitrate_kbps", []string{"RateControl", "BitrateLimit"}},
+	{"gov_length", []string{"H264", "GovLength"}},
+	{"profile", []string{"H264", "H264Profile"}},
+}
+
+// videoEncoder2Attrs are the attributes of the fields which Media2 configurations have as attributes.
+var videoEncoder2Attrs = map[string]string{"gov_length": "GovLength", "profile": "Profile"}
+
+// parseVideoEncoderFields returns the values of the fields of the set-video-encoder command cmd by key.
+func parseVideoEncoderFields(cmd map[string]interface{}) (map[string]string, error) {
+	fields := map[string]string{}
+	for _, field := range videoEncoderFields {
+		raw, ok := cmd[field.key]
+		if !ok {
+			continue
+		}
+		if field.key == "encoding" || field.key == "profile" {
+			s, ok := raw.(string)
+			if !ok || s == "" {
+				return nil, errors.Errorf("%s must be a string", field.key)
+			}
+			fields[field.key] = s
+			continue
+		}
+		f, ok := raw.(float64)
+		if !ok || f < 0 || f != float64(int(f)) {
+			return nil, errors.Errorf("%s must be a non-negative integer", field.key)
+		}
+		fields[field.key] = strconv.Itoa(int(f))
+	}
+	return fields, nil
+}
+
+// update sets the fields of the configuration. Fields whose element the camera doesn't report can't be set, since
+// their position in the configuration is unknown.
+func (e videoEncoder) update(fields map[string]string) error {
+	for _, field := range videoEncoderFields {
+		value, ok := fields[field.key]
+		if !ok {
+			continue
+		}
+		if attr, ok := videoEncoder2Attrs[field.key]; ok && e.media2 {
+			e.config.setAttr(attr, value)
+			continue
+		}
+		node := e.config.find(field.path...)
+		if node == nil {
+			return errors.Errorf("the video encoder configuration of the camera has no %s", strings.Join(field.path, ">"))
+		}
+		node.Text = value
+	}
+	return nil
+}
+
+// videoEncoderService returns a client of the camera's ONVIF media service, its address, whether it's Media2 & the
+// configured profile.
+func (rc *rtspCamera) videoEncoderService(
+	ctx context.Context, command string,
+) (*onvifClient, string, bool, onvifProfile, error) {
+	conf, client, err := rc.deviceClient(command)
+	if err != nil {
+		return nil, "", false, onvifProfile{}, err
+	}
+	mediaURL, media2, profile, err := client.mediaProfile(ctx, client.services(ctx), conf.Profile)
+	if err != nil {
+		return nil, "", false, onvifProfile{}, err
+	}
+	return client, mediaURL, media2, profile, nil
+}
+
+// videoEncoder returns the video encoder configuration of profile, which Media2 services return by profile & Media
+// services by the token of the profile's configuration.
+func (c *onvifClient) videoEncoder(
+	ctx context.Context, mediaURL string, media2 bool, profile onvifProfile,
+) (videoEncoder, error) {
+	var res struct {
+		Config  *xmlNode `xml:"Body>GetVideoEncoderConfigurationResponse>Configuration"`
+		Configs *xmlNode `xml:"Body>GetVideoEncoderConfigurationsResponse>Configurations"`
+	}
+	var body string
+	if media2 {
+		body = `<GetVideoEncoderConfigurations xmlns="` + onvifMedia2Namespace + `"><ProfileToken>` + xmlEscape(profile.Token) +
+			`</ProfileToken></GetVideoEncoderConfigurations>`
+	} else {
+		if profile.VideoEncoder.Token == "" {
+			return videoEncoder{}, errors.Errorf("ONVIF profile '%s' has no video encoder", profile.Token)
+		}
+		body = `<GetVideoEncoderConfiguration xmlns="` + onvifMediaNamespace + `"><ConfigurationToken>` +
+			xmlEscape(profile.VideoEncoder.Token) + `</ConfigurationToken></GetVideoEncoderConfiguration>`
+	}
+	if err := c.call(ctx, mediaURL, body, &res); err != nil {
+		return videoEncoder{}, errors.Wrapf(err, "getting the video encoder of ONVIF profile '%s'", profile.Token)
+	}
+	config := res.Config
+	if media2 {
+		config = res.Configs
+	}
+	if config == nil {
+		return videoEncoder{}, errors.Errorf("ONVIF profile '%s' has no video encoder", profile.Token)
+	}
+	return videoEncoder{config: config, media2: media2}, nil
+}
+
+// setVideoEncoder sends the configuration back to the camera, persisting it on Media services which ask whether to.
+func (c *onvifClient) setVideoEncoder(ctx context.Context, mediaURL string, e videoEncoder) error {
+	namespace, persistence := onvifMediaNamespace, `<ForcePersistence>true</ForcePersistence>`
+	if e.media2 {
+		namespace, persistence = onvifMedia2Namespace, ""
+	}
+	config, err := e.config.marshal(namespace, "Configuration")
+	if err != nil {
+		return err
+	}
+	body := `<SetVideoEncoderConfiguration xmlns="` + namespace + `">` + config + persistence + `</SetVideoEncoderConfiguration>`
+	var res struct{}
+	if err := c.call(ctx, mediaURL, body, &res); err != nil {
+		return errors.Wrap(err, "setting the ONVIF video encoder")
+	}
+	return nil
+}
+
+// getVideoEncoder returns the encoding, resolution, quality, frame rate, bitrate, GOP length & H264 profile of the
+// video encoder of the configured ONVIF profile.
+func (rc *rtspCamera) getVideoEncoder(ctx context.Context) (map[string]interface{}, error) {
+	client, mediaURL, media2, profile, err := rc.videoEncoderService(ctx, getVideoEncoderCommand)
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := client.videoEncoder(ctx, mediaURL, media2, profile)
+	if err != nil {
+		return nil, err
+	}
+	return encoder.toMap(), nil
+}
+
+// setVideoEncoder changes the video encoder settings of the fields of cmd, keeping the others, & returns the
+// settings once they're changed. The stream is reconnected, as cameras restart it with the new settings.
+func (rc *rtspCamera) setVideoEncoder(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	settings, err := rc.updateVideoEncoder(ctx, setVideoEncoderCommand, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if !rc.closed() {
+		rc.requestReconnect()
+	}
+	return settings, nil
+}
+
+// updateVideoEncoder changes the video encoder settings of the fields of cmd on the camera for command, & returns the
+// settings once they're changed.
+func (rc *rtspCamera) updateVideoEncoder(
+	ctx context.Context, command string, cmd map[string]interface{},
+) (map[string]interface{}, error) {
+	fields, err := parseVideoEncoderFields(cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, command)
+	}
+	if len(fields) == 0 {
+		return nil, errors.Errorf("%s requires at least one of encoding, width, height, quality, frame_rate, bitrate_kbps, "+
+			"gov_length & profile", command)
+	}
+	client, mediaURL, media2, profile, err := rc.videoEncoderService(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := client.videoEncoder(ctx, mediaURL, media2, profile)
+	if err != nil {
+		return nil, err
+	}
+	if err := encoder.update(fields); err != nil {
+		return nil, errors.Wrap(err, command)
+	}
+	if err := client.setVideoEncoder(ctx, mediaURL, encoder); err != nil {
+		return nil, err
+	}
+	if encoder, err = client.videoEncoder(ctx, mediaURL, media2, profile); err != nil {
+		return nil, err
+	}
+	return encoder.toMap(), nil
+}